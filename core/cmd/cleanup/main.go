@@ -1,5 +1,11 @@
 package main
 
+import "time"
+
+// multipartExpiry is how long a resumable upload can be resumed before its
+// parts are discarded
+const multipartExpiry = 7 * 24 * time.Hour
+
 func main() {
 	run()
 }
@@ -2,8 +2,88 @@
 
 package main
 
-import "log"
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
 
+	"github.com/joho/godotenv"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+	"github.com/simonlewi/levelmix/pkg/storage/local"
+	"github.com/simonlewi/levelmix/pkg/storage/localfs"
+)
+
+// consentRetention is how long cookie consent records are kept, per the
+// cookie policy
+const consentRetention = 2 * 365 * 24 * time.Hour
+
+// run deletes stored files older than RETENTION_DAYS, which S3 lifecycle
+// rules do for the hosted service, stale multipart uploads and expired
+// consent records
 func run() {
-	log.Fatal("Built without enterprise features. Rebuild with: go build -tags ee")
+	_, b, _, _ := runtime.Caller(0)
+	projectRoot := filepath.Join(filepath.Dir(b), "../../..")
+
+	// Load environment variables
+	envPath := filepath.Join(projectRoot, ".env")
+	if _, err := os.Stat(envPath); err == nil {
+		if err := godotenv.Load(envPath); err != nil {
+			log.Printf("Error loading .env file to cleanup: %v", err)
+		} else {
+			log.Println(".env file loaded successfully to cleanup")
+		}
+	} else {
+		log.Println("No .env file found, using environment variables from system/Docker")
+	}
+
+	log.Println("Starting cleanup job...")
+
+	ctx := context.Background()
+
+	// Get retention period from environment (default: 30 days)
+	retentionDays := 30
+	if days := os.Getenv("RETENTION_DAYS"); days != "" {
+		if parsed, err := strconv.Atoi(days); err == nil {
+			retentionDays = parsed
+		}
+	}
+
+	factory := local.NewFactory()
+
+	audioStorage, err := factory.CreateAudioStorage()
+	if err != nil {
+		log.Fatalf("Failed to create audio storage: %v", err)
+	}
+
+	metadataStorage, err := factory.CreateMetadataStorage()
+	if err != nil {
+		log.Fatalf("Failed to create metadata storage: %v", err)
+	}
+
+	if files, ok := audioStorage.(*localfs.Storage); ok {
+		cutoff := time.Now().AddDate(0, 0, -retentionDays)
+		log.Printf("Deleting files older than %d days", retentionDays)
+		deleted, err := files.DeleteOlderThan(ctx, cutoff)
+		if err != nil {
+			log.Printf("File cleanup failed: %v", err)
+		}
+		log.Printf("Deleted %d files", deleted)
+	}
+
+	aborted, err := storage.AbortStaleMultipartUploads(ctx, audioStorage, time.Now().Add(-multipartExpiry))
+	if err != nil {
+		log.Printf("Multipart upload cleanup failed: %v", err)
+	}
+	log.Printf("Aborted %d stale multipart uploads", aborted)
+
+	if err := metadataStorage.DeleteOldConsents(ctx, time.Now().Add(-consentRetention)); err != nil {
+		log.Printf("Consent cleanup failed: %v", err)
+	}
+
+	log.Println("Cleanup job completed")
 }
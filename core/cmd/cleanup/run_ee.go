@@ -9,10 +9,13 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/simonlewi/levelmix-enterprise/cleanup"
-	"github.com/simonlewi/levelmix-enterprise/storage"
+	ee_storage "github.com/simonlewi/levelmix-enterprise/storage"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
 )
 
 func run() {
@@ -44,7 +47,7 @@ func run() {
 	}
 
 	// Initialize storage factory
-	factory := storage.NewFactory()
+	factory := ee_storage.NewFactory()
 
 	// Get S3 storage
 	audioStorage, err := factory.CreateAudioStorage()
@@ -59,7 +62,7 @@ func run() {
 	}
 
 	// Configure S3 lifecycle rules (AWS handles cleanup automatically)
-	if s3Storage, ok := audioStorage.(*storage.S3Storage); ok {
+	if s3Storage, ok := audioStorage.(*ee_storage.S3Storage); ok {
 		cleaner := cleanup.NewS3Cleaner(s3Storage.GetClient(), s3Storage.GetBucket())
 
 		log.Printf("Configuring S3 lifecycle rules for %d day retention", retentionDays)
@@ -70,6 +73,13 @@ func run() {
 		}
 	}
 
+	// Abort resumable uploads that were never completed
+	aborted, err := storage.AbortStaleMultipartUploads(ctx, audioStorage, time.Now().Add(-multipartExpiry))
+	if err != nil {
+		log.Printf("Multipart upload cleanup failed: %v", err)
+	}
+	log.Printf("Aborted %d stale multipart uploads", aborted)
+
 	// Run consent cleanup (2 years retention per cookie policy)
 	consentCleaner := cleanup.NewConsentCleaner(metadataStorage)
 	if err := consentCleaner.CleanupOldConsents(ctx, 2); err != nil {
@@ -0,0 +1,69 @@
+// Command migrate applies the SQLite schema migrations, or lists them with
+// -status.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/joho/godotenv"
+	"github.com/simonlewi/levelmix/pkg/storage/sqlite"
+)
+
+func main() {
+	_, b, _, _ := runtime.Caller(0)
+	projectRoot := filepath.Join(filepath.Dir(b), "../../..")
+
+	envPath := filepath.Join(projectRoot, ".env")
+	if _, err := os.Stat(envPath); err == nil {
+		if err := godotenv.Load(envPath); err != nil {
+			log.Printf("Error loading .env file: %v", err)
+		}
+	}
+
+	dbPath := flag.String("db", os.Getenv("SQLITE_PATH"), "path to the SQLite database (default $SQLITE_PATH)")
+	status := flag.Bool("status", false, "list migrations and whether they are applied, without applying any")
+	flag.Parse()
+
+	if *dbPath == "" {
+		log.Fatal("No database given: set SQLITE_PATH or pass -db")
+	}
+
+	store, err := sqlite.NewStorage(*dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+
+	if *status {
+		migrations, err := store.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		for _, m := range migrations {
+			if m.AppliedAt != nil {
+				log.Printf("%04d %s: applied %s", m.Version, m.Name, m.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				log.Printf("%04d %s: pending", m.Version, m.Name)
+			}
+		}
+		return
+	}
+
+	applied, err := store.Migrate(ctx)
+	for _, m := range applied {
+		log.Printf("Applied migration %04d %s", m.Version, m.Name)
+	}
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+	if len(applied) == 0 {
+		log.Println("Database is up to date")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// configureTrustedProxies sets up proxy trust configuration based on environment
+func configureTrustedProxies(r *gin.Engine) {
+	trustedProxies := os.Getenv("TRUSTED_PROXIES")
+
+	if trustedProxies == "" {
+		// No proxies configured - direct deployment
+		log.Println("No trusted proxies configured - disabling proxy trust")
+		r.SetTrustedProxies(nil)
+		return
+	}
+
+	// Parse comma-separated proxy IPs/CIDRs
+	proxies := strings.Split(trustedProxies, ",")
+	for i, proxy := range proxies {
+		proxies[i] = strings.TrimSpace(proxy)
+	}
+
+	log.Printf("Configuring trusted proxies for Traefik: %v", proxies)
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		log.Printf("Warning: Failed to set trusted proxies: %v", err)
+		r.SetTrustedProxies(nil)
+		return
+	}
+
+	r.ForwardedByClientIP = true
+
+	//Configure additional middleware for Traefik headers
+	r.Use(func(c *gin.Context) {
+		if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+			if proto == "https" {
+				c.Request.TLS = &tls.ConnectionState{}
+			}
+		}
+
+		c.Next()
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/simonlewi/levelmix/core/internal/audio"
+	"github.com/simonlewi/levelmix/core/internal/handlers"
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// coreHandlers are the handlers both editions serve
+type coreHandlers struct {
+	upload    *handlers.UploadHandler
+	tus       *handlers.TusHandler
+	download  *handlers.DownloadHandler
+	about     *handlers.AboutHandler
+	pricing   *handlers.PricingHandler
+	start     *handlers.StartHandler
+	howToUse  *handlers.HowToUseHandler
+	dashboard *handlers.DashboardHandler
+	account   *handlers.AccountHandler
+	health    *handlers.HealthHandler
+	cookie    *handlers.CookieHandler
+	admin     *handlers.AdminHandler
+}
+
+func newCoreHandlers(audioStorage storage.AudioStorage, metadataStorage storage.MetadataStorage, qm *audio.QueueManager) *coreHandlers {
+	uploadHandler := handlers.NewUploadHandler(audioStorage, metadataStorage, qm, os.Getenv("REDIS_URL"))
+	return &coreHandlers{
+		upload:    uploadHandler,
+		tus:       handlers.NewTusHandler(uploadHandler),
+		download:  handlers.NewDownloadHandler(audioStorage, metadataStorage),
+		about:     handlers.NewAboutHandler(),
+		pricing:   handlers.NewPricingHandler(),
+		start:     handlers.NewStartHandler(),
+		howToUse:  handlers.NewHowToUseHandler(),
+		dashboard: handlers.NewDashboardHandler(metadataStorage),
+		account:   handlers.NewAccountHandler(metadataStorage, audioStorage),
+		health:    handlers.NewHealthHandler(metadataStorage, os.Getenv("REDIS_URL")),
+		cookie:    handlers.NewCookieHandler(metadataStorage),
+		admin:     handlers.NewAdminHandler(uploadHandler, qm),
+	}
+}
+
+// authRoutes are the edition's authentication middleware and its login,
+// password recovery and email verification handlers
+type authRoutes struct {
+	templateContext gin.HandlerFunc
+	requireAuth     gin.HandlerFunc
+
+	showLogin      gin.HandlerFunc
+	handleLogin    gin.HandlerFunc
+	showRegister   gin.HandlerFunc
+	handleRegister gin.HandlerFunc
+	logout         gin.HandlerFunc
+
+	showForgotPassword   gin.HandlerFunc
+	handleForgotPassword gin.HandlerFunc
+	showResetPassword    gin.HandlerFunc
+	handleResetPassword  gin.HandlerFunc
+
+	showVerify        gin.HandlerFunc
+	showRejectConfirm gin.HandlerFunc
+	handleReject      gin.HandlerFunc
+}
+
+// registerRoutes serves the routes both editions have and returns the groups
+// of public pages and of pages requiring login, for the edition's own routes
+func registerRoutes(r *gin.Engine, projectRoot string, h *coreHandlers, a authRoutes) (public, protected *gin.RouterGroup) {
+	// Static files
+	r.Static("/static", filepath.Join(projectRoot, "core", "static"))
+
+	// LLM SEO — serve llms.txt for AI crawlers (Perplexity, ChatGPT Browse, Claude web).
+	// Public, no auth: same exposure as the static files above.
+	r.GET("/llms.txt", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.File(filepath.Join(projectRoot, "core", "static", "llms.txt"))
+	})
+
+	// Health check - must be before any middleware for Docker/Traefik
+	r.GET("/health", h.health.HealthCheck)
+
+	// API endpoints without middleware
+	r.POST("/login", a.handleLogin)
+	r.POST("/register", a.handleRegister)
+	r.POST("/forgot-password", a.handleForgotPassword)
+	r.POST("/reset-password", a.handleResetPassword)
+	r.POST("/verify-email/reject", a.handleReject)
+	r.GET("/status/:id", h.upload.GetStatus)
+	r.GET("/status/:id/stream", h.upload.StreamStatus)
+	r.POST("/cancel/:id", h.upload.CancelJob)
+	r.POST("/retry/:id", h.upload.RetryJob)
+	r.GET("/download/:id", h.download.HandleDownload)
+
+	// tus capability discovery, which browsers send as a preflight without
+	// credentials
+	r.OPTIONS("/api/tus", h.tus.Options)
+	r.OPTIONS("/api/tus/:id", h.tus.Options)
+
+	// Public routes with template context
+	public = r.Group("/")
+	public.Use(handlers.TemplateContext(GitCommit))
+	public.Use(a.templateContext)
+	{
+		public.GET("/login", a.showLogin)
+		public.GET("/register", a.showRegister)
+		public.GET("/logout", a.logout)
+		public.GET("/forgot-password", a.showForgotPassword)
+		public.GET("/reset-password", a.showResetPassword)
+		public.GET("/verify-email", a.showVerify)
+		public.GET("/verify-email/reject", a.showRejectConfirm)
+		public.GET("/results/:id", h.download.ShowResults)
+		public.GET("/privacy", h.cookie.ShowPrivacyPolicy)
+		public.GET("/cookies", h.cookie.ShowCookiePolicy)
+		public.GET("/terms-of-service", h.cookie.ShowTermsOfService)
+		public.POST("/api/cookie-consent", h.cookie.HandleCookieConsent)
+
+		public.GET("/", func(c *gin.Context) {
+			c.HTML(http.StatusOK, "home.html", handlers.GetTemplateData(c, gin.H{
+				"CurrentPage": "home",
+			}))
+		})
+		public.GET("/about", h.about.ShowAbout)
+		public.GET("/pricing", h.pricing.ShowPricing)
+		public.GET("/start", h.start.ShowStart)
+		public.GET("/how-to-use", h.howToUse.ShowHowToUse)
+	}
+
+	// Protected routes requiring authentication
+	protected = r.Group("/")
+	protected.Use(handlers.TemplateContext(GitCommit))
+	protected.Use(a.templateContext)
+	protected.Use(a.requireAuth)
+	{
+		protected.GET("/upload", func(c *gin.Context) {
+			templateData := handlers.GetTemplateData(c, gin.H{
+				"CurrentPage": "upload",
+				"PageTitle":   "Upload",
+			})
+
+			if user, exists := c.Get("user"); exists {
+				templateData["user"] = user
+				if u, ok := user.(*storage.User); ok {
+					templateData["UploadLimits"] = handlers.UploadLimitsText(u.SubscriptionTier)
+				}
+			}
+			templateData["OffPeakText"] = h.upload.OffPeakText()
+
+			c.HTML(http.StatusOK, "upload.html", templateData)
+		})
+		protected.GET("/api/presigned-upload", h.upload.GetPresignedUploadURL)
+		protected.POST("/api/confirm-upload", h.upload.ConfirmUpload)
+		protected.POST("/api/multipart-upload", h.upload.StartMultipartUpload)
+		protected.GET("/api/multipart-upload/part-url", h.upload.GetMultipartPartURL)
+		protected.GET("/api/multipart-upload/parts", h.upload.ListMultipartParts)
+		protected.POST("/api/multipart-upload/complete", h.upload.CompleteMultipartUpload)
+		protected.POST("/api/multipart-upload/abort", h.upload.AbortMultipartUpload)
+		protected.POST("/api/tus", h.tus.Create)
+		protected.HEAD("/api/tus/:id", h.tus.Head)
+		protected.PATCH("/api/tus/:id", h.tus.Patch)
+		protected.DELETE("/api/tus/:id", h.tus.Terminate)
+		protected.POST("/upload", h.upload.HandleUpload)
+		protected.POST("/reprocess/:id", h.upload.ReprocessJob)
+
+		protected.GET("/dashboard", h.dashboard.ShowDashboard)
+		protected.GET("/account/delete", h.account.ShowDeleteConfirmation)
+		protected.POST("/account/delete", h.account.HandleDeleteAccount)
+		protected.GET("/account/change-email", h.account.ShowChangeEmail)
+		protected.POST("/account/change-email", h.account.HandleChangeEmail)
+		protected.POST("/account/update-name", h.account.UpdateName)
+		protected.POST("/account/marketing-consent", h.account.HandleMarketingConsent)
+		protected.GET("/account/change-password", h.account.ShowChangePassword)
+		protected.POST("/account/change-password", h.account.HandleChangePassword)
+		protected.GET("/api/consent/:userID", h.cookie.GetLatestConsent)
+		protected.GET("/api/consent/:userID/history", h.cookie.GetUserConsentHistory)
+		protected.DELETE("/api/consent/:userID", h.cookie.DeleteUserConsentData)
+
+		// Operator pages, limited to ADMIN_EMAILS
+		admin := protected.Group("/admin")
+		admin.Use(handlers.RequireAdmin())
+		{
+			admin.GET("/queue/archived", h.admin.ShowArchivedTasks)
+			admin.POST("/queue/archived/requeue", h.admin.RequeueArchivedTasks)
+		}
+	}
+
+	return public, protected
+}
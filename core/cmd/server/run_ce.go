@@ -2,8 +2,168 @@
 
 package main
 
-import "log"
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+
+	"github.com/simonlewi/levelmix/core/internal/audio"
+	"github.com/simonlewi/levelmix/core/internal/auth"
+	"github.com/simonlewi/levelmix/pkg/email"
+	"github.com/simonlewi/levelmix/pkg/storage/local"
+	"github.com/simonlewi/levelmix/pkg/storage/localfs"
+)
+
+// run starts the self-hosted server: files on local disk, metadata in
+// SQLite, email and password login, and no payments. Tier limits come from
+// the TIER_* variables and new accounts get DEFAULT_TIER.
 func run() {
-	log.Fatal("Built without enterprise features. Rebuild with: go build -tags ee")
+	_, b, _, _ := runtime.Caller(0)
+	projectRoot := filepath.Join(filepath.Dir(b), "../../..")
+
+	// Load environment variables
+	envPath := filepath.Join(projectRoot, ".env")
+	if _, err := os.Stat(envPath); err == nil {
+		if err := godotenv.Load(envPath); err != nil {
+			log.Printf("Error loading .env file to server: %v", err)
+		} else {
+			log.Println(".env file loaded successfully to server")
+		}
+	} else {
+		log.Println("No .env file found, using environment variables from system/Docker")
+	}
+
+	// Initialize storage
+	factory := local.NewFactory()
+
+	audioStorage, err := factory.CreateAudioStorage()
+	if err != nil {
+		log.Fatal("Failed to create audio storage:", err)
+	}
+
+	metadataStorage, err := factory.CreateMetadataStorage()
+	if err != nil {
+		log.Fatal("Failed to create metadata storage:", err)
+	}
+
+	emailService := newEmailService()
+
+	// Initialize queue
+	qm := audio.NewQueueManager(os.Getenv("REDIS_URL"))
+	defer qm.Shutdown()
+
+	// Initialize handlers
+	h := newCoreHandlers(audioStorage, metadataStorage, qm)
+
+	// Initialize auth
+	sessions := auth.NewSessions()
+	authMiddleware := auth.NewMiddleware(metadataStorage, sessions)
+	authHandler := auth.NewHandler(metadataStorage, sessions, emailService)
+	passwordRecoveryHandler := auth.NewPasswordRecoveryHandler(metadataStorage, sessions, emailService)
+	verificationHandler := auth.NewVerificationHandler(metadataStorage)
+
+	// Set up graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	r := gin.Default()
+	r.MaxMultipartMemory = 64 << 20 // 64 MB in-memory buffer
+
+	// Configure trusted proxies based on environment
+	configureTrustedProxies(r)
+
+	// Load templates
+	loadTemplates(r, projectRoot)
+
+	registerRoutes(r, projectRoot, h, authRoutes{
+		templateContext:      authMiddleware.TemplateContext(),
+		requireAuth:          authMiddleware.RequireAuth(),
+		showLogin:            authHandler.ShowLogin,
+		handleLogin:          authHandler.HandleLogin,
+		showRegister:         authHandler.ShowRegister,
+		handleRegister:       authHandler.HandleRegister,
+		logout:               authHandler.HandleLogout,
+		showForgotPassword:   passwordRecoveryHandler.ShowForgotPassword,
+		handleForgotPassword: passwordRecoveryHandler.HandleForgotPassword,
+		showResetPassword:    passwordRecoveryHandler.ShowResetPassword,
+		handleResetPassword:  passwordRecoveryHandler.HandleResetPassword,
+		showVerify:           verificationHandler.ShowVerify,
+		showRejectConfirm:    verificationHandler.ShowRejectConfirm,
+		handleReject:         verificationHandler.HandleReject,
+	})
+
+	// Signed file URLs handed out as "presigned" URLs by local storage
+	if files, ok := audioStorage.(*localfs.Storage); ok {
+		r.GET("/files/*key", files.Handler())
+		r.HEAD("/files/*key", files.Handler())
+		r.PUT("/files/*key", files.Handler())
+	}
+
+	// Start server
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	srv := &http.Server{
+		Addr:              ":" + port,
+		Handler:           r,
+		ReadTimeout:       15 * time.Minute, // Allow large file uploads
+		WriteTimeout:      15 * time.Minute, // Allow large file downloads
+		ReadHeaderTimeout: 30 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    1 << 20, // 1 MB
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("listen: %s\n", err)
+		}
+	}()
+	log.Printf("Server listening on :%s", port)
+
+	<-quit
+	log.Println("Shutting down server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Fatal("Server forced to shutdown:", err)
+	}
+
+	log.Println("Server exiting")
+}
+
+// newEmailService picks the email service from EMAIL_SERVICE: "smtp",
+// "resend", or "mock" (the default), which logs emails instead of sending
+// them
+func newEmailService() email.EmailService {
+	var service email.EmailService
+	var err error
+
+	switch os.Getenv("EMAIL_SERVICE") {
+	case "smtp":
+		service, err = email.NewSMTPService()
+	case "resend":
+		service, err = email.NewResendService()
+	default:
+		log.Println("Using mock email service (emails will be logged)")
+		return email.NewMockEmailService()
+	}
+
+	if err != nil {
+		log.Printf("Failed to initialize email service, falling back to mock: %v", err)
+		return email.NewMockEmailService()
+	}
+	log.Println("Email service initialized successfully")
+	return service
 }
@@ -4,14 +4,12 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
-	"strings"
 	"syscall"
 	"time"
 
@@ -110,19 +108,10 @@ func run() {
 	defer qm.Shutdown()
 
 	// Initialize handlers
-	uploadHandler := handlers.NewUploadHandler(audioStorage, metadataStorage, qm, os.Getenv("REDIS_URL"))
-	downloadHandler := handlers.NewDownloadHandler(audioStorage, metadataStorage)
-	aboutHandler := handlers.NewAboutHandler()
-	pricingHandler := handlers.NewPricingHandler()
-	startHandler := handlers.NewStartHandler()
-	howToUseHandler := handlers.NewHowToUseHandler()
+	h := newCoreHandlers(audioStorage, metadataStorage, qm)
 	paymentSuccessHandler := handlers.NewPaymentSuccessHandler()
-	dashboardHandler := handlers.NewDashboardHandler(metadataStorage)
-	accountHandler := handlers.NewAccountHandler(metadataStorage, audioStorage)
 	passwordRecoveryHandler := ee_auth.NewPasswordRecoveryHandler(metadataStorage, emailService)
 	verificationHandler := ee_auth.NewVerificationHandler(metadataStorage)
-	healthHandler := handlers.NewHealthHandler(metadataStorage, os.Getenv("REDIS_URL"))
-	cookieHandler := handlers.NewCookieHandler(metadataStorage)
 
 	// Initialize auth
 	authMiddleware := ee_auth.NewMiddleware(metadataStorage)
@@ -147,160 +136,31 @@ func run() {
 	configureTrustedProxies(r)
 
 	// Load templates
-	baseTemplate := filepath.Join(projectRoot, "core", "templates", "base.html")
-	homeTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "home.html")
-	uploadTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "upload.html")
-	resultsTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "results.html")
-	aboutTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "about.html")
-	pricingTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "pricing.html")
-	paymentSuccessTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "payment-success.html")
-	loginTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "login.html")
-	registerTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "register.html")
-	dashboardTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "dashboard.html")
-	deleteAccountTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "delete-account.html")
-	forgotPasswordTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "forgot-password.html")
-	resetPasswordTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "reset-password.html")
-	changeEmailTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "change-email.html")
-	changePasswordTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "change-password.html")
-	privacyPolicyTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "privacy-policy.html")
-	cookiePolicyTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "cookie-policy.html")
-	termsOfServiceTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "terms-of-service.html")
-	howToUseTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "how-to-use.html")
-	startTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "start.html")
-	verifyEmailTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "verify-email.html")
-	verifyEmailRejectTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "verify-email-reject.html")
-	verifyEmailRejectedTemplate := filepath.Join(projectRoot, "core", "templates", "pages", "verify-email-rejected.html")
-
-	r.LoadHTMLFiles(
-		baseTemplate,
-		homeTemplate,
-		uploadTemplate,
-		resultsTemplate,
-		aboutTemplate,
-		pricingTemplate,
-		howToUseTemplate,
-		paymentSuccessTemplate,
-		loginTemplate,
-		registerTemplate,
-		dashboardTemplate,
-		deleteAccountTemplate,
-		forgotPasswordTemplate,
-		resetPasswordTemplate,
-		changeEmailTemplate,
-		changePasswordTemplate,
-		privacyPolicyTemplate,
-		cookiePolicyTemplate,
-		termsOfServiceTemplate,
-		startTemplate,
-		verifyEmailTemplate,
-		verifyEmailRejectTemplate,
-		verifyEmailRejectedTemplate,
-	)
-
-	// Static files
-	r.Static("/static", filepath.Join(projectRoot, "core", "static"))
-
-	// LLM SEO — serve llms.txt for AI crawlers (Perplexity, ChatGPT Browse, Claude web).
-	// Public, no auth: same exposure as the static files above.
-	r.GET("/llms.txt", func(c *gin.Context) {
-		c.Header("Content-Type", "text/plain; charset=utf-8")
-		c.File(filepath.Join(projectRoot, "core", "static", "llms.txt"))
+	loadTemplates(r, projectRoot)
+
+	public, protected := registerRoutes(r, projectRoot, h, authRoutes{
+		templateContext:      authMiddleware.TemplateContext(),
+		requireAuth:          authMiddleware.RequireAuth(),
+		showLogin:            authHandler.ShowLogin,
+		handleLogin:          authHandler.HandleLogin,
+		showRegister:         authHandler.ShowRegister,
+		handleRegister:       authHandler.HandleRegister,
+		logout:               authHandler.HandleLogout,
+		showForgotPassword:   passwordRecoveryHandler.ShowForgotPassword,
+		handleForgotPassword: passwordRecoveryHandler.HandleForgotPassword,
+		showResetPassword:    passwordRecoveryHandler.ShowResetPassword,
+		handleResetPassword:  passwordRecoveryHandler.HandleResetPassword,
+		showVerify:           verificationHandler.ShowVerify,
+		showRejectConfirm:    verificationHandler.ShowRejectConfirm,
+		handleReject:         verificationHandler.HandleReject,
 	})
+	public.GET("/payment/success", paymentSuccessHandler.ShowPaymentSuccess)
 
-	// Health check - must be before any middleware for Docker/Traefik
-	r.GET("/health", healthHandler.HealthCheck)
-
-	// API endpoints without middleware
-	r.POST("/login", authHandler.HandleLogin)
-	r.POST("/register", authHandler.HandleRegister)
-	r.POST("/forgot-password", passwordRecoveryHandler.HandleForgotPassword)
-	r.POST("/reset-password", passwordRecoveryHandler.HandleResetPassword)
-	r.POST("/verify-email/reject", verificationHandler.HandleReject)
-	r.GET("/status/:id", uploadHandler.GetStatus)
-	r.POST("/cancel/:id", uploadHandler.CancelJob)
-	r.POST("/retry/:id", uploadHandler.RetryJob)
-	r.GET("/download/:id", downloadHandler.HandleDownload)
-
-	// Public routes with template context
-	public := r.Group("/")
-	public.Use(handlers.TemplateContext(GitCommit))
-	public.Use(authMiddleware.TemplateContext())
-	{
-		public.GET("/login", authHandler.ShowLogin)
-		public.GET("/register", authHandler.ShowRegister)
-		public.GET("/logout", authHandler.HandleLogout)
-		public.GET("/forgot-password", passwordRecoveryHandler.ShowForgotPassword)
-		public.GET("/reset-password", passwordRecoveryHandler.ShowResetPassword)
-		public.GET("/verify-email", verificationHandler.ShowVerify)
-		public.GET("/verify-email/reject", verificationHandler.ShowRejectConfirm)
-		public.GET("/results/:id", downloadHandler.ShowResults)
-		public.GET("/privacy", cookieHandler.ShowPrivacyPolicy)
-		public.GET("/cookies", cookieHandler.ShowCookiePolicy)
-		public.GET("/terms-of-service", cookieHandler.ShowTermsOfService)
-		public.POST("/api/cookie-consent", cookieHandler.HandleCookieConsent)
-	}
-
-	// Public routes (no authentication required)
-	publicOpen := r.Group("/")
-	publicOpen.Use(handlers.TemplateContext(GitCommit))
-	publicOpen.Use(authMiddleware.TemplateContext())
-	{
-		publicOpen.GET("/", func(c *gin.Context) {
-			c.HTML(http.StatusOK, "home.html", handlers.GetTemplateData(c, gin.H{
-				"CurrentPage": "home",
-			}))
-		})
-
-		publicOpen.GET("/about", aboutHandler.ShowAbout)
-		publicOpen.GET("/pricing", pricingHandler.ShowPricing)
-		publicOpen.GET("/start", startHandler.ShowStart)
-		publicOpen.GET("/payment/success", paymentSuccessHandler.ShowPaymentSuccess)
-		publicOpen.GET("/how-to-use", howToUseHandler.ShowHowToUse)
-
-	}
-
-	// Protected routes requiring authentication
-	protected := r.Group("/")
-	protected.Use(handlers.TemplateContext(GitCommit))
-	protected.Use(authMiddleware.TemplateContext())
-	protected.Use(authMiddleware.RequireAuth())
-	{
-		// Upload routes - now require authentication
-		protected.GET("/upload", func(c *gin.Context) {
-			templateData := handlers.GetTemplateData(c, gin.H{
-				"CurrentPage": "upload",
-				"PageTitle":   "Upload",
-			})
-
-			if user, exists := c.Get("user"); exists {
-				templateData["user"] = user
-			}
-
-			c.HTML(http.StatusOK, "upload.html", templateData)
-		})
-		protected.GET("/api/presigned-upload", uploadHandler.GetPresignedUploadURL)
-		protected.POST("/api/confirm-upload", uploadHandler.ConfirmUpload)
-		protected.POST("/upload", uploadHandler.HandleUpload)
-
-		protected.GET("/dashboard", dashboardHandler.ShowDashboard)
-		protected.GET("/account/delete", accountHandler.ShowDeleteConfirmation)
-		protected.POST("/account/delete", accountHandler.HandleDeleteAccount)
-		protected.GET("/account/change-email", accountHandler.ShowChangeEmail)
-		protected.POST("/account/change-email", accountHandler.HandleChangeEmail)
-		protected.POST("/account/update-name", accountHandler.UpdateName)
-		protected.POST("/account/marketing-consent", accountHandler.HandleMarketingConsent)
-		protected.GET("/account/change-password", accountHandler.ShowChangePassword)
-		protected.POST("/account/change-password", accountHandler.HandleChangePassword)
-		protected.GET("/api/consent/:userID", cookieHandler.GetLatestConsent)
-		protected.GET("/api/consent/:userID/history", cookieHandler.GetUserConsentHistory)
-		protected.DELETE("/api/consent/:userID", cookieHandler.DeleteUserConsentData)
-
-		// Payment endpoints (require authentication)
-		if paymentHandlers != nil {
-			protected.POST("/api/v1/payment/checkout", paymentHandlers.GinHandleCreateCheckoutSession)
-			protected.GET("/api/v1/payment/subscription", paymentHandlers.GinHandleGetSubscriptionStatus)
-			protected.POST("/api/v1/payment/portal", paymentHandlers.GinHandleCreatePortalSession)
-		}
+	// Payment endpoints (require authentication)
+	if paymentHandlers != nil {
+		protected.POST("/api/v1/payment/checkout", paymentHandlers.GinHandleCreateCheckoutSession)
+		protected.GET("/api/v1/payment/subscription", paymentHandlers.GinHandleGetSubscriptionStatus)
+		protected.POST("/api/v1/payment/portal", paymentHandlers.GinHandleCreatePortalSession)
 	}
 
 	// Webhook endpoints (no authentication - verified by webhook signature)
@@ -348,41 +208,3 @@ func run() {
 
 	log.Println("Server exiting")
 }
-
-// configureTrustedProxies sets up proxy trust configuration based on environment
-func configureTrustedProxies(r *gin.Engine) {
-	trustedProxies := os.Getenv("TRUSTED_PROXIES")
-
-	if trustedProxies == "" {
-		// No proxies configured - direct deployment
-		log.Println("No trusted proxies configured - disabling proxy trust")
-		r.SetTrustedProxies(nil)
-		return
-	}
-
-	// Parse comma-separated proxy IPs/CIDRs
-	proxies := strings.Split(trustedProxies, ",")
-	for i, proxy := range proxies {
-		proxies[i] = strings.TrimSpace(proxy)
-	}
-
-	log.Printf("Configuring trusted proxies for Traefik: %v", proxies)
-	if err := r.SetTrustedProxies(proxies); err != nil {
-		log.Printf("Warning: Failed to set trusted proxies: %v", err)
-		r.SetTrustedProxies(nil)
-		return
-	}
-
-	r.ForwardedByClientIP = true
-
-	//Configure additional middleware for Traefik headers
-	r.Use(func(c *gin.Context) {
-		if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
-			if proto == "https" {
-				c.Request.TLS = &tls.ConnectionState{}
-			}
-		}
-
-		c.Next()
-	})
-}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pageTemplates are the pages under core/templates/pages
+var pageTemplates = []string{
+	"home.html",
+	"upload.html",
+	"results.html",
+	"about.html",
+	"pricing.html",
+	"payment-success.html",
+	"login.html",
+	"register.html",
+	"dashboard.html",
+	"delete-account.html",
+	"forgot-password.html",
+	"reset-password.html",
+	"change-email.html",
+	"change-password.html",
+	"privacy-policy.html",
+	"cookie-policy.html",
+	"terms-of-service.html",
+	"how-to-use.html",
+	"start.html",
+	"verify-email.html",
+	"verify-email-reject.html",
+	"verify-email-rejected.html",
+	"admin-queue.html",
+}
+
+// loadTemplates loads base.html and every page template
+func loadTemplates(r *gin.Engine, projectRoot string) {
+	templatesDir := filepath.Join(projectRoot, "core", "templates")
+
+	files := []string{filepath.Join(templatesDir, "base.html")}
+	for _, page := range pageTemplates {
+		files = append(files, filepath.Join(templatesDir, "pages", page))
+	}
+	r.LoadHTMLFiles(files...)
+}
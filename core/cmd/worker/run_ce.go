@@ -2,8 +2,72 @@
 
 package main
 
-import "log"
+import (
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
 
+	"github.com/joho/godotenv"
+
+	"github.com/simonlewi/levelmix/core/internal/audio"
+	"github.com/simonlewi/levelmix/pkg/storage/local"
+)
+
+// run starts the self-hosted worker on the same local storage as the server.
+// There are no payments, so no trial reminder tasks to handle.
 func run() {
-	log.Fatal("Built without enterprise features. Rebuild with: go build -tags ee")
+	_, b, _, _ := runtime.Caller(0)
+	projectRoot := filepath.Join(filepath.Dir(b), "../../..")
+
+	// Load environment variables
+	envPath := filepath.Join(projectRoot, ".env")
+	if _, err := os.Stat(envPath); err == nil {
+		if err := godotenv.Load(envPath); err != nil {
+			log.Printf("Error loading .env file to worker: %v", err)
+		} else {
+			log.Println(".env file loaded successfully to worker")
+		}
+	} else {
+		log.Println("No .env file found, using environment variables from system/Docker")
+	}
+
+	// Clean up any orphaned temp files from previous runs
+	cleanupTempFiles()
+
+	// Initialize storage
+	factory := local.NewFactory()
+
+	audioStorage, err := factory.CreateAudioStorage()
+	if err != nil {
+		log.Fatal("Failed to create audio storage:", err)
+	}
+
+	metadataStorage, err := factory.CreateMetadataStorage()
+	if err != nil {
+		log.Fatal("Failed to create metadata storage:", err)
+	}
+
+	processor := audio.NewProcessor(audioStorage, metadataStorage, os.Getenv("REDIS_URL"))
+
+	// Start worker
+	srv, mux := audio.NewWorker(os.Getenv("REDIS_URL"), processor, nil)
+
+	// Graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	log.Println("Worker starting...")
+	go func() {
+		if err := audio.StartWorker(srv, mux); err != nil {
+			log.Printf("Worker error: %v", err)
+			quit <- syscall.SIGTERM
+		}
+	}()
+
+	<-quit
+	log.Println("Shutting down worker...")
+	srv.Shutdown()
 }
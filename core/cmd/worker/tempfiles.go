@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cleanupTempFiles removes any orphaned temp files from previous runs
+func cleanupTempFiles() {
+	tempDir := "/tmp/levelmix"
+
+	// Ensure the directory exists
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		log.Printf("[WARN] Failed to create temp directory for cleanup: %v", err)
+		return
+	}
+
+	files, err := os.ReadDir(tempDir)
+	if err != nil {
+		log.Printf("[WARN] Failed to read temp directory for cleanup: %v", err)
+		return
+	}
+
+	cleaned := 0
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), "levelmix_") {
+			filePath := filepath.Join(tempDir, file.Name())
+			if err := os.Remove(filePath); err != nil {
+				log.Printf("[WARN] Failed to remove temp file %s: %v", filePath, err)
+			} else {
+				cleaned++
+			}
+		}
+	}
+
+	if cleaned > 0 {
+		log.Printf("[INFO] Cleaned up %d orphaned temp files", cleaned)
+	}
+}
@@ -3,11 +3,13 @@ package audio
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,12 +28,13 @@ func SetDebugMode(enabled bool) {
 }
 
 // AnalyzeLoudness performs the first pass to measure audio loudness with timeout
-func AnalyzeLoudness(inputFile string) (*LoudnessInfo, error) {
-	return AnalyzeLoudnessWithTimeout(inputFile, 15*time.Minute)
+func AnalyzeLoudness(ctx context.Context, inputFile string) (*LoudnessInfo, error) {
+	return AnalyzeLoudnessWithTimeout(ctx, inputFile, 15*time.Minute)
 }
 
-// AnalyzeLoudnessWithTimeout performs loudness analysis with configurable timeout
-func AnalyzeLoudnessWithTimeout(inputFile string, timeout time.Duration) (info *LoudnessInfo, err error) {
+// AnalyzeLoudnessWithTimeout performs loudness analysis with configurable timeout.
+// ffmpeg is killed when ctx ends and a *CancelledError is returned.
+func AnalyzeLoudnessWithTimeout(ctx context.Context, inputFile string, timeout time.Duration) (info *LoudnessInfo, err error) {
 	// Panic recovery
 	defer func() {
 		if r := recover(); r != nil {
@@ -41,26 +44,25 @@ func AnalyzeLoudnessWithTimeout(inputFile string, timeout time.Duration) (info *
 	}()
 
 	// Acquire semaphore to limit concurrent FFmpeg processes
-	select {
-	case ffmpegSemaphore <- struct{}{}:
-		defer func() { <-ffmpegSemaphore }()
-	case <-time.After(30 * time.Second):
-		return nil, fmt.Errorf("timeout waiting for FFmpeg slot")
+	if err := acquireFFmpegSlot(ctx, "loudness analysis"); err != nil {
+		return nil, err
 	}
+	defer releaseFFmpegSlot()
 
 	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
 		return nil, fmt.Errorf("input file does not exist: %s", inputFile)
 	}
 
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx,
+	cmd := commandContext(ctx,
 		"ffmpeg",
 		"-i", inputFile,
 		"-af", "loudnorm=print_format=json:I=-16:TP=-1.5:LRA=11",
 		"-f", "null", "-")
+	attachProgress(ctx, cmd, StageAnalyzing, progressDuration(ctx))
 
 	// Get pipes for streaming output
 	stderr, err := cmd.StderrPipe()
@@ -98,16 +100,16 @@ func AnalyzeLoudnessWithTimeout(inputFile string, timeout time.Duration) (info *
 	// Wait for command to complete
 	cmdErr := cmd.Wait()
 
-	// Check if context timed out
-	if ctx.Err() == context.DeadlineExceeded {
-		return nil, fmt.Errorf("ffmpeg analysis timed out after %v", timeout)
+	// Check if the context was cancelled or timed out
+	if err := contextError(ctx, "loudness analysis"); err != nil {
+		return nil, err
 	}
 
 	// Get output
 	select {
 	case output := <-outputChan:
 		if cmdErr != nil {
-			return nil, fmt.Errorf("ffmpeg analysis failed: %w", cmdErr)
+			return nil, newFFmpegError("ffmpeg analysis", cmdErr, output)
 		}
 		result, err := parseLoudnormOutput(output)
 		if err != nil {
@@ -125,12 +127,18 @@ func AnalyzeLoudnessWithTimeout(inputFile string, timeout time.Duration) (info *
 	}
 }
 
+// ProbeDuration returns the length in seconds of the audio at input, a local
+// path or a URL that ffprobe can read
+func ProbeDuration(ctx context.Context, input string) (float64, error) {
+	return getDuration(ctx, input)
+}
+
 // getDuration gets the duration of an audio file using ffprobe with timeout
-func getDuration(inputFile string) (float64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func getDuration(ctx context.Context, inputFile string) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx,
+	cmd := commandContext(ctx,
 		"ffprobe",
 		"-v", "error",
 		"-show_entries", "format=duration",
@@ -139,10 +147,15 @@ func getDuration(inputFile string) (float64, error) {
 
 	output, err := cmd.Output()
 	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			return 0, fmt.Errorf("ffprobe timed out")
+		if err := contextError(ctx, "duration probe"); err != nil {
+			return 0, err
+		}
+		var stderr []byte
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			stderr = exitErr.Stderr
 		}
-		return 0, fmt.Errorf("failed to get file duration: %w", err)
+		return 0, newFFmpegError("duration probe", err, stderr)
 	}
 
 	var duration float64
@@ -152,204 +165,233 @@ func getDuration(inputFile string) (float64, error) {
 	return duration, nil
 }
 
-// AnalyzeLoudnessAdaptiveSample performs adaptive sampling based on file duration
-// Note: For DJ mixes with high dynamic range, full analysis (Precise mode) is recommended
-func AnalyzeLoudnessAdaptiveSample(inputFile string) (*LoudnessInfo, error) {
-	// Check disk space before processing
-	if err := checkDiskSpace(); err != nil {
-		return nil, fmt.Errorf("insufficient disk space: %w", err)
+// AnalyzeLoudnessAdaptiveSample estimates loudness from content-aware samples.
+// A cheap energy scan picks windows covering the file's loudness distribution;
+// when the estimate's confidence interval is too wide (or sampling is not
+// worthwhile for the file) the whole file is analyzed instead.
+func AnalyzeLoudnessAdaptiveSample(ctx context.Context, inputFile string) (*LoudnessInfo, error) {
+	info, err := estimateLoudnessFromSamples(ctx, inputFile)
+	if errors.Is(err, ErrSamplingInsufficient) {
+		log.Printf("[INFO] %v, using full analysis", err)
+		return AnalyzeLoudness(ctx, inputFile)
 	}
+	return info, err
+}
 
-	duration, err := getDuration(inputFile)
-	if err != nil {
-		return nil, err
-	}
+// performMultiSampleAnalysis measures the sample windows concurrently within the
+// FFmpeg concurrency budget. Windows are split into one batch per free FFmpeg
+// slot and each batch is measured by a single ffmpeg process, so the file is
+// opened once per slot rather than once per sample. Too many failed samples
+// cancel the remaining batches. Results are indexed like windows; failed
+// samples are nil.
+func performMultiSampleAnalysis(ctx context.Context, inputFile string, windows []segment) ([]*LoudnessInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
 
-	// Adaptive sampling strategy based on duration
-	var sampleLength float64
-	var samplePoints []float64
+	batches := splitBatches(windows, cap(ffmpegSemaphore))
+	results := make([]*LoudnessInfo, len(windows))
+	maxFailures := len(windows) / 2 // Allow up to 50% failure rate
 
-	switch {
-	case duration <= 60: // Short files (<1 min)
-		// Analyze the whole file for short clips
-		return AnalyzeLoudness(inputFile)
+	var mu sync.Mutex
+	var failedSamples, doneSamples int
+	var wg sync.WaitGroup
+
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch []segment) {
+			defer wg.Done()
+
+			select {
+			case ffmpegSemaphore <- struct{}{}:
+				defer func() { <-ffmpegSemaphore }()
+			case <-ctx.Done():
+				return
+			}
 
-	case duration <= 180: // Medium files (1-3 min)
-		sampleLength = 20.0
-		samplePoints = []float64{0.15, 0.50, 0.85}
+			infos, err := analyzeSampleBatch(ctx, inputFile, batch)
+			if err != nil && debugMode {
+				log.Printf("[DEBUG] Sample batch at %.1fs failed: %v", batch[0].Start, err)
+			}
 
-	case duration <= 600: // Long files (3-10 min)
-		sampleLength = 30.0
-		samplePoints = []float64{0.10, 0.30, 0.50, 0.70, 0.90}
+			mu.Lock()
+			defer mu.Unlock()
 
-	default: // Very long files (>10 min)
-		sampleLength = 30.0
-		samplePoints = []float64{0.10, 0.25, 0.40, 0.55, 0.70, 0.80, 0.90}
-	}
+			// Sample windows are the second half of the fast analysis stage,
+			// after the energy scan
+			doneSamples += len(batch)
+			reportProgress(ctx, StageAnalyzing, 0.5+0.5*float64(doneSamples)/float64(len(windows)))
 
-	// Calculate total sample coverage
-	totalSampleTime := sampleLength * float64(len(samplePoints))
-	coveragePercent := (totalSampleTime / duration) * 100
+			for i, w := range batch {
+				if infos[i] == nil {
+					failedSamples++
+					continue
+				}
+				results[w.Index] = infos[i]
+				if debugMode {
+					log.Printf("[DEBUG] Sample %d: %.1f LUFS at position %.1fs", w.Index, infos[i].InputI, w.Start)
+				}
+			}
 
-	// If we're sampling more than 60% of the file, just analyze the whole thing
-	if coveragePercent > 60 {
-		return AnalyzeLoudness(inputFile)
+			// Early exit: stop the remaining batches
+			if failedSamples > maxFailures {
+				cancel()
+			}
+		}(batch)
 	}
 
-	if debugMode {
-		log.Printf("[DEBUG] Adaptive analysis: %d samples from %.1fs file (%.1f%% coverage)",
-			len(samplePoints), duration, coveragePercent)
+	wg.Wait()
+
+	if failedSamples > maxFailures {
+		return nil, fmt.Errorf("too many failed samples (%d/%d)", failedSamples, len(windows))
+	}
+	if err := contextError(ctx, "sample analysis"); err != nil {
+		return nil, err
 	}
 
-	return performMultiSampleAnalysis(inputFile, samplePoints, sampleLength, duration)
+	return results, nil
 }
 
-// performMultiSampleAnalysis executes the sampling strategy with proper LUFS math
-func performMultiSampleAnalysis(inputFile string, samplePoints []float64, sampleLength, duration float64) (*LoudnessInfo, error) {
-	var energyValues []float64
-	var validSamples int
-	var failedSamples int
-	var maxPeak float64 = -math.MaxFloat64
-	var totalLRA float64
-	var minLUFS float64 = math.MaxFloat64
-	var maxLUFS float64 = -math.MaxFloat64
+// splitBatches distributes windows round-robin over at most n batches so each
+// batch covers positions spread across the file
+func splitBatches(windows []segment, n int) [][]segment {
+	if n < 1 {
+		n = 1
+	}
+	if n > len(windows) {
+		n = len(windows)
+	}
 
-	var mu sync.Mutex
-	maxFailures := len(samplePoints) / 2 // Allow up to 50% failure rate
+	batches := make([][]segment, n)
+	for i, w := range windows {
+		batches[i%n] = append(batches[i%n], w)
+	}
+	return batches
+}
 
-	for i, p := range samplePoints {
-		startTime := duration * p
+// analyzeSampleBatch measures several windows in one ffmpeg process: each window
+// is a separately seeked input with its own loudnorm instance. It returns one
+// entry per window, nil for windows that could not be measured.
+func analyzeSampleBatch(ctx context.Context, inputFile string, batch []segment) ([]*LoudnessInfo, error) {
+	var args []string
+	var graph []string
+	for i, w := range batch {
+		args = append(args,
+			"-ss", fmt.Sprintf("%.2f", w.Start),
+			"-t", fmt.Sprintf("%.2f", w.End-w.Start),
+			"-i", inputFile)
+		graph = append(graph, fmt.Sprintf("[%d:a]loudnorm=print_format=json:I=-16:TP=-1.5:LRA=11[s%d]", i, i))
+	}
+	args = append(args, "-filter_complex", strings.Join(graph, ";"))
+	for i := range batch {
+		args = append(args, "-map", fmt.Sprintf("[s%d]", i), "-f", "null", "-")
+	}
 
-		// Ensure we don't go past the end
-		if startTime+sampleLength > duration {
-			startTime = duration - sampleLength
-			if startTime < 0 {
-				startTime = 0
-				sampleLength = duration
-			}
-		}
+	cmd := commandContext(ctx, "ffmpeg", args...)
+	output, err := cmd.CombinedOutput()
 
-		// Acquire semaphore for each sample
-		select {
-		case ffmpegSemaphore <- struct{}{}:
-			// Acquired
-		case <-time.After(30 * time.Second):
-			failedSamples++
-			if failedSamples > maxFailures {
-				return nil, fmt.Errorf("too many failed samples (%d/%d)", failedSamples, len(samplePoints))
-			}
-			continue
+	// loudnorm prints its summary on teardown, so parse whatever was reported
+	// even when the process exits with an error
+	infos := parseLoudnormBatchOutput(output, len(batch))
+	if err != nil {
+		if err := contextError(ctx, "sample batch"); err != nil {
+			return infos, err
 		}
+		return infos, err
+	}
+	return infos, nil
+}
 
-		// Process sample with timeout
-		func() {
-			defer func() { <-ffmpegSemaphore }() // Release semaphore
-
-			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-			defer cancel()
-
-			cmd := exec.CommandContext(ctx,
-				"ffmpeg",
-				"-ss", fmt.Sprintf("%.2f", startTime),
-				"-t", fmt.Sprintf("%.2f", sampleLength),
-				"-i", inputFile,
-				"-af", "loudnorm=print_format=json:I=-16:TP=-1.5:LRA=11",
-				"-f", "null", "-")
-
-			output, err := cmd.CombinedOutput()
-			if err != nil {
-				mu.Lock()
-				failedSamples++
-				mu.Unlock()
-
-				if ctx.Err() == context.DeadlineExceeded {
-					log.Printf("[WARN] Sample %d timed out at position %.1fs", i, startTime)
-				} else if debugMode {
-					log.Printf("[DEBUG] Sample %d failed at position %.1fs: %v", i, startTime, err)
-				}
-
-				// Early failure detection
-				if failedSamples > maxFailures {
-					return
-				}
-				return
-			}
-
-			info, err := parseLoudnormOutput(output)
-			if err != nil {
-				mu.Lock()
-				failedSamples++
-				mu.Unlock()
-				if debugMode {
-					log.Printf("[DEBUG] Sample %d: failed to parse output: %v", i, err)
-				}
-				return
-			}
-
-			mu.Lock()
-			defer mu.Unlock()
-
-			// Track variance for quality check
-			if info.InputI < minLUFS {
-				minLUFS = info.InputI
-			}
-			if info.InputI > maxLUFS {
-				maxLUFS = info.InputI
-			}
+// parseLoudnormBatchOutput splits the output of several loudnorm instances by
+// their "[Parsed_loudnorm_N @ 0x...]" log prefix and parses each summary
+func parseLoudnormBatchOutput(output []byte, n int) []*LoudnessInfo {
+	infos := make([]*LoudnessInfo, n)
+	const marker = "[Parsed_loudnorm_"
 
-			// Convert LUFS to linear energy for proper averaging
-			energy := math.Pow(10, (info.InputI+0.691)/10)
-			energyValues = append(energyValues, energy)
+	sections := strings.Split(string(output), marker)
+	for _, section := range sections[1:] {
+		end := strings.IndexAny(section, " @]")
+		if end <= 0 {
+			continue
+		}
+		idx, err := strconv.Atoi(section[:end])
+		if err != nil || idx < 0 || idx >= n || infos[idx] != nil {
+			continue
+		}
+		if info, err := parseLoudnormOutput([]byte(section)); err == nil {
+			infos[idx] = info
+		}
+	}
+	return infos
+}
 
-			// Track peak values
-			if info.InputTP > maxPeak {
-				maxPeak = info.InputTP
-			}
+// lufsToEnergy converts integrated loudness to linear mean-square energy
+func lufsToEnergy(lufs float64) float64 {
+	return math.Pow(10, (lufs+0.691)/10)
+}
 
-			totalLRA += info.InputLRA
-			validSamples++
+// energyToLUFS converts linear mean-square energy back to LUFS
+func energyToLUFS(energy float64) float64 {
+	return 10*math.Log10(energy) - 0.691
+}
 
-			if debugMode {
-				log.Printf("[DEBUG] Sample %d: %.1f LUFS at position %.1fs", i, info.InputI, startTime)
-			}
-		}()
+// loudnessMeasurement is one partial measurement and the seconds of audio it covers
+type loudnessMeasurement struct {
+	info   *LoudnessInfo
+	weight float64
+}
 
-		// Check for early exit
-		if failedSamples > maxFailures {
-			return nil, fmt.Errorf("too many failed samples (%d/%d)", failedSamples, len(samplePoints))
+// mergeLoudnessMeasurements combines partial measurements in the energy domain,
+// weighting each by the duration it covers. The true peak is the maximum peak;
+// LRA is the weighted mean, widened to the spread between the measurements so
+// level changes across parts (breakdowns, quiet sections) are not averaged away.
+func mergeLoudnessMeasurements(measurements []loudnessMeasurement) (*LoudnessInfo, error) {
+	var totalEnergy, totalWeight, totalLRA float64
+	maxPeak := -math.MaxFloat64
+	levels := make([]float64, 0, len(measurements))
+
+	for _, m := range measurements {
+		if m.info == nil || m.weight <= 0 {
+			continue
+		}
+		totalEnergy += lufsToEnergy(m.info.InputI) * m.weight
+		totalLRA += m.info.InputLRA * m.weight
+		totalWeight += m.weight
+		if m.info.InputTP > maxPeak {
+			maxPeak = m.info.InputTP
 		}
+		levels = append(levels, m.info.InputI)
 	}
 
-	if validSamples == 0 {
-		return nil, fmt.Errorf("failed to get any valid loudness samples")
+	if totalWeight == 0 {
+		return nil, fmt.Errorf("no valid loudness measurements to merge")
 	}
 
-	// Check variance - if too high, warn but continue
-	variance := maxLUFS - minLUFS
-	if variance > 6.0 {
-		log.Printf("[WARN] High loudness variance: %.1f LU (may indicate inconsistent mix)", variance)
-	}
+	integrated := energyToLUFS(totalEnergy / totalWeight)
+	lra := totalLRA / totalWeight
 
-	// Calculate the weighted average in energy domain, then convert back to LUFS
-	var totalEnergy float64
-	for _, energy := range energyValues {
-		totalEnergy += energy
+	// EBU R128 LRA is the 10th-95th percentile spread of short-term loudness
+	sort.Float64s(levels)
+	if spread := percentile(levels, 0.95) - percentile(levels, 0.10); spread > lra {
+		lra = spread
 	}
-	avgEnergy := totalEnergy / float64(len(energyValues))
-	avgLUFS := 10*math.Log10(avgEnergy) - 0.691
-	avgLRA := totalLRA / float64(validSamples)
-
-	log.Printf("[INFO] Multi-sample analysis: %.1f LUFS, Peak: %.1f dB (%d/%d samples)",
-		avgLUFS, maxPeak, validSamples, len(samplePoints))
 
 	return &LoudnessInfo{
-		InputI:      avgLUFS,
+		InputI:      integrated,
 		InputTP:     maxPeak,
-		InputLRA:    avgLRA,
-		InputThresh: avgLUFS - 10,
+		InputLRA:    lra,
+		InputThresh: integrated - 10,
 	}, nil
 }
 
+// percentile returns the p-quantile (0-1) of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Round(p * float64(len(sorted)-1)))
+	return sorted[idx]
+}
+
 // parseLoudnormOutput parses FFmpeg loudnorm JSON output
 func parseLoudnormOutput(output []byte) (*LoudnessInfo, error) {
 	outputStr := string(output)
@@ -32,3 +32,33 @@ func TestParseLoudnormOutput(t *testing.T) {
 		t.Errorf("Expected InputThresh to be -27.61, got %f", info.InputThresh)
 	}
 }
+
+func TestParseLoudnormBatchOutput(t *testing.T) {
+	sample := `
+[Parsed_loudnorm_1 @ 0x7f8e1d] 
+{
+    "input_i" : "-9.20",
+    "input_tp" : "-0.40",
+    "input_lra" : "5.10",
+    "input_thresh" : "-19.30"
+}
+[Parsed_loudnorm_0 @ 0x7f8e1c] 
+{
+    "input_i" : "-16.54",
+    "input_tp" : "-2.29",
+    "input_lra" : "7.80",
+    "input_thresh" : "-27.61"
+}
+`
+	infos := parseLoudnormBatchOutput([]byte(sample), 3)
+
+	if infos[0] == nil || infos[0].InputI != -16.54 {
+		t.Errorf("Expected sample 0 InputI to be -16.54, got %+v", infos[0])
+	}
+	if infos[1] == nil || infos[1].InputI != -9.20 {
+		t.Errorf("Expected sample 1 InputI to be -9.20, got %+v", infos[1])
+	}
+	if infos[2] != nil {
+		t.Errorf("Expected sample 2 to be missing, got %+v", infos[2])
+	}
+}
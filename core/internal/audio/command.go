@@ -0,0 +1,73 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ErrJobCancelled is the cancellation cause used when a user cancels a job
+var ErrJobCancelled = errors.New("job cancelled by user")
+
+// CancelledError reports that a pipeline stage stopped because its context
+// ended. It unwraps to the context's cause: context.Canceled,
+// context.DeadlineExceeded or ErrJobCancelled.
+type CancelledError struct {
+	Stage string
+	Cause error
+}
+
+func (e *CancelledError) Error() string {
+	if errors.Is(e.Cause, context.DeadlineExceeded) {
+		return fmt.Sprintf("%s timed out", e.Stage)
+	}
+	return fmt.Sprintf("%s cancelled: %v", e.Stage, e.Cause)
+}
+
+func (e *CancelledError) Unwrap() error {
+	return e.Cause
+}
+
+// IsCancelled reports whether err was caused by a cancelled or expired context
+func IsCancelled(err error) bool {
+	var cancelled *CancelledError
+	return errors.As(err, &cancelled)
+}
+
+// contextError returns a *CancelledError for stage if ctx has ended, or nil
+func contextError(ctx context.Context, stage string) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return &CancelledError{Stage: stage, Cause: context.Cause(ctx)}
+}
+
+// commandContext builds an ffmpeg/ffprobe command bound to ctx. The child runs
+// in its own process group and the whole group is killed when ctx ends, so
+// helpers ffmpeg spawns are not orphaned. WaitDelay stops Wait from blocking on
+// output pipes held open by anything that survives the kill.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
+
+// acquireFFmpegSlot waits for a free FFmpeg slot, giving up when ctx ends or
+// after 30 seconds. Release the slot with releaseFFmpegSlot.
+func acquireFFmpegSlot(ctx context.Context, stage string) error {
+	select {
+	case ffmpegSemaphore <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx, stage)
+	case <-time.After(30 * time.Second):
+		return fmt.Errorf("timeout waiting for FFmpeg slot")
+	}
+}
+
+func releaseFFmpegSlot() {
+	<-ffmpegSemaphore
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package audio
+
+import "os/exec"
+
+// setProcessGroup is a no-op where process groups are not available; the
+// default cancellation still kills the direct child
+func setProcessGroup(cmd *exec.Cmd) {}
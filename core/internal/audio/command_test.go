@@ -0,0 +1,177 @@
+//go:build linux
+
+package audio
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// installFakeFFmpeg puts ffmpeg and ffprobe stand-ins first on PATH. The fake
+// ffmpeg records its own PID and that of a child it spawns, then blocks, so a
+// test can check that cancellation kills the whole process tree.
+func installFakeFFmpeg(t *testing.T) string {
+	t.Helper()
+
+	binDir := t.TempDir()
+	pidFile := filepath.Join(binDir, "pids")
+
+	scripts := map[string]string{
+		"ffmpeg":  "#!/bin/sh\necho $$ >> \"$LEVELMIX_FAKE_PIDS\"\nsleep 60 &\necho $! >> \"$LEVELMIX_FAKE_PIDS\"\nwait\n",
+		"ffprobe": "#!/bin/sh\necho 600\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(binDir, name), []byte(script), 0755); err != nil {
+			t.Fatalf("Failed to write fake %s: %v", name, err)
+		}
+	}
+
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("LEVELMIX_FAKE_PIDS", pidFile)
+	return pidFile
+}
+
+// readPIDs returns the PIDs recorded by the fake ffmpeg so far
+func readPIDs(pidFile string) []int {
+	data, _ := os.ReadFile(pidFile)
+	var pids []int
+	for _, line := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(line); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// processAlive reports whether pid is running; zombies count as exited
+func processAlive(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	// Format: "pid (comm) state ..."
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestCancellationKillsFFmpeg(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input.wav")
+	if err := os.WriteFile(input, []byte("not really audio"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	output := filepath.Join(t.TempDir(), "output.wav")
+
+	testCases := []struct {
+		name      string
+		processes int
+		run       func(ctx context.Context) error
+	}{
+		{
+			name:      "AnalyzeLoudness",
+			processes: 1,
+			run: func(ctx context.Context) error {
+				_, err := AnalyzeLoudness(ctx, input)
+				return err
+			},
+		},
+		{
+			name:      "DetectSilence",
+			processes: 1,
+			run: func(ctx context.Context) error {
+				_, err := DetectSilence(ctx, input)
+				return err
+			},
+		},
+		{
+			name:      "NormalizeLoudness",
+			processes: 1,
+			run: func(ctx context.Context) error {
+				info := &LoudnessInfo{InputI: -20, InputTP: -3, InputLRA: 5}
+				return NormalizeLoudness(ctx, input, output, -14, info, OutputOptions{}, nil, false)
+			},
+		},
+		{
+			name:      "AnalyzeLoudnessSegmented",
+			processes: 3,
+			run: func(ctx context.Context) error {
+				_, err := AnalyzeLoudnessSegmented(ctx, input, planSegments(0, 900, 300))
+				return err
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pidFile := installFakeFFmpeg(t)
+
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+
+			done := make(chan error, 1)
+			go func() { done <- tc.run(ctx) }()
+
+			// Wait until every ffmpeg and its child are running
+			deadline := time.Now().Add(5 * time.Second)
+			for len(readPIDs(pidFile)) < 2*tc.processes {
+				if time.Now().After(deadline) {
+					t.Fatalf("Fake ffmpeg did not start (pids: %v)", readPIDs(pidFile))
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			start := time.Now()
+			cancel(ErrJobCancelled)
+
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("Call did not return after cancellation")
+			}
+
+			// Returning promptly means no surviving child held the output pipes open
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("Call took %v to return after cancellation", elapsed)
+			}
+			if !IsCancelled(err) {
+				t.Errorf("Expected a *CancelledError, got %v", err)
+			}
+			if !errors.Is(err, ErrJobCancelled) {
+				t.Errorf("Expected error to carry the cancellation cause, got %v", err)
+			}
+
+			for _, pid := range readPIDs(pidFile) {
+				deadline := time.Now().Add(2 * time.Second)
+				for processAlive(pid) && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+				if processAlive(pid) {
+					t.Errorf("Process %d is still running after cancellation", pid)
+				}
+			}
+		})
+	}
+}
+
+func TestDeadlineReturnsCancelledError(t *testing.T) {
+	installFakeFFmpeg(t)
+
+	input := filepath.Join(t.TempDir(), "input.wav")
+	if err := os.WriteFile(input, []byte("not really audio"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	_, err := AnalyzeLoudnessWithTimeout(context.Background(), input, 200*time.Millisecond)
+	if !IsCancelled(err) {
+		t.Fatalf("Expected a *CancelledError, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
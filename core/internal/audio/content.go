@@ -0,0 +1,112 @@
+package audio
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// ReusableJob finds a completed job of the user's that rendered the content
+// with the given hash with the same options and whose output still exists,
+// and the file it belongs to
+func ReusableJob(ctx context.Context, s storage.AudioStorage, m storage.MetadataStorage, userID, hash string, spec storage.JobSpec) (*storage.ProcessingJob, *storage.AudioFile) {
+	files, err := m.GetAudioFilesByHash(ctx, hash)
+	if err != nil {
+		log.Printf("[WARN] Failed to look up files with hash %s: %v", hash, err)
+		return nil, nil
+	}
+
+	for _, file := range files {
+		if file.UserID == nil || *file.UserID != userID {
+			continue
+		}
+		jobs, err := m.GetJobsByFileID(ctx, file.ID)
+		if err != nil {
+			log.Printf("[WARN] Failed to get jobs of file %s: %v", file.ID, err)
+			continue
+		}
+		for _, job := range jobs {
+			if job.Status != storage.JobCompleted || job.OutputS3Key == "" || job.Spec == nil || !SameOutput(*job.Spec, spec) {
+				continue
+			}
+			if _, err := s.GetObjectInfo(ctx, job.OutputS3Key); err != nil {
+				continue
+			}
+			return job, file
+		}
+	}
+	return nil, nil
+}
+
+// ReuseOutput gives job a copy of the output ReusableJob finds for the
+// content with the given hash and spec on another of the user's files, and
+// fills in job's output and completion time; saving job is left to the
+// caller. The output is copied so deleting the earlier file doesn't take it
+// along. It returns the earlier job and its file, or nil if there is nothing
+// to reuse or copying failed, and the file should be processed.
+func ReuseOutput(ctx context.Context, s storage.AudioStorage, m storage.MetadataStorage, job *storage.ProcessingJob, hash string, spec storage.JobSpec) (*storage.ProcessingJob, *storage.AudioFile) {
+	earlier, earlierFile := ReusableJob(ctx, s, m, job.UserID, hash, spec)
+	if earlier == nil || earlierFile.ID == job.AudioFileID {
+		return nil, nil
+	}
+
+	outputKey := s.GetJobProcessedKey(job.AudioFileID, job.ID, earlier.OutputFormat)
+	if err := s.Copy(ctx, earlier.OutputS3Key, outputKey); err != nil {
+		log.Printf("[WARN] Failed to copy output of job %s for job %s, processing instead: %v", earlier.ID, job.ID, err)
+		return nil, nil
+	}
+
+	now := time.Now()
+	job.OutputFormat = earlier.OutputFormat
+	job.OutputS3Key = outputKey
+	job.ParentJobID = &earlier.ID
+	job.CompletedAt = &now
+	return earlier, earlierFile
+}
+
+// claimContent takes hash, the SHA-256 of an original that was confirmed
+// without hashing it as computed while downloading it, checks it against the
+// digest the client sent, and moves the original to its content key so
+// identical uploads share one object. If the user already has output for
+// the same content and options, the job is completed with a copy of it and
+// claimContent reports true.
+func (p *Processor) claimContent(ctx context.Context, job *storage.ProcessingJob, audioFile *storage.AudioFile, task ProcessTask, hash string) (bool, error) {
+	if task.SHA256 != "" && task.SHA256 != hash {
+		return false, failure(FailureInvalidInput, fmt.Errorf("original has SHA-256 %s, client sent %s", hash, task.SHA256))
+	}
+
+	if err := storage.StoreOriginal(ctx, p.audioStorage, p.metadataStorage, audioFile, hash, func() error {
+		return p.metadataStorage.UpdateAudioFileHash(ctx, audioFile.ID, hash)
+	}); err != nil {
+		return false, failure(FailureStorage, fmt.Errorf("failed to store original by hash: %w", err))
+	}
+
+	earlier, earlierFile := ReuseOutput(ctx, p.audioStorage, p.metadataStorage, job, hash, task.Spec())
+	if earlier == nil {
+		return false, nil
+	}
+	if err := storage.TransitionJob(ctx, p.metadataStorage, job, storage.JobCompleted, "reused output of job "+earlier.ID); err != nil {
+		p.audioStorage.Delete(ctx, job.OutputS3Key)
+		return false, fmt.Errorf("failed to complete job %s with reused output: %w", job.ID, err)
+	}
+
+	if earlierFile.DurationSeconds != nil && audioFile.DurationSeconds == nil {
+		if err := p.metadataStorage.UpdateAudioFileDuration(ctx, audioFile.ID, *earlierFile.DurationSeconds); err != nil {
+			log.Printf("[WARN] Failed to update audio file duration in DB: %v", err)
+		}
+	}
+	if err := p.metadataStorage.UpdateStatus(ctx, audioFile.ID, storage.StatusCompleted); err != nil {
+		log.Printf("[WARN] Failed to update file status: %v", err)
+	}
+	p.updateProgress(ctx, task.FileID, 100, storage.StatusCompleted)
+
+	// Nothing was processed, so nothing is charged
+	p.releaseUsage(ctx, job.ID)
+
+	log.Printf("[INFO] File %s has the same content and options as file %s, reused output of job %s",
+		audioFile.ID, earlierFile.ID, earlier.ID)
+	return true, nil
+}
@@ -0,0 +1,177 @@
+package audio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+	"github.com/simonlewi/levelmix/pkg/storage/localfs"
+	"github.com/simonlewi/levelmix/pkg/storage/sqlite"
+)
+
+// newContentProcessor is a Processor backed by SQLite and local file storage
+func newContentProcessor(t *testing.T) *Processor {
+	t.Helper()
+	metadata, err := sqlite.NewStorage(filepath.Join(t.TempDir(), "levelmix.db"))
+	if err != nil {
+		t.Fatalf("sqlite.NewStorage: %v", err)
+	}
+	t.Cleanup(func() { metadata.Close() })
+	if _, err := metadata.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	files, err := localfs.NewStorage(t.TempDir(), "http://localhost/files", []byte("test-secret"))
+	if err != nil {
+		t.Fatalf("localfs.NewStorage: %v", err)
+	}
+	return &Processor{audioStorage: files, metadataStorage: metadata}
+}
+
+// queueUnhashed stores data as the original of a new, unhashed file of user
+// and starts a processing job for it, both named after name
+func queueUnhashed(t *testing.T, p *Processor, userID, name, data string) (*storage.AudioFile, *storage.ProcessingJob, ProcessTask) {
+	t.Helper()
+	ctx := context.Background()
+
+	file := &storage.AudioFile{
+		ID:               "file-" + name,
+		UserID:           &userID,
+		OriginalFilename: "mix.wav",
+		FileSize:         int64(len(data)),
+		Format:           "wav",
+		Status:           storage.StatusQueued,
+	}
+	if err := p.audioStorage.Upload(ctx, file.ID, strings.NewReader(data), file.Format); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if err := p.metadataStorage.CreateAudioFile(ctx, file); err != nil {
+		t.Fatalf("CreateAudioFile: %v", err)
+	}
+
+	task := ProcessTask{JobID: "job-" + name, FileID: file.ID, UserID: userID, TargetLUFS: -14, ProcessingMode: ModeFast}
+	spec := task.Spec()
+	job := &storage.ProcessingJob{ID: task.JobID, AudioFileID: file.ID, UserID: userID, Status: storage.JobQueued, Spec: &spec}
+	if err := p.metadataStorage.CreateJob(ctx, job); err != nil {
+		t.Fatalf("CreateJob: %v", err)
+	}
+	if err := p.startJob(ctx, job); err != nil {
+		t.Fatalf("startJob: %v", err)
+	}
+	return file, job, task
+}
+
+func createContentUser(t *testing.T, p *Processor, id string) {
+	t.Helper()
+	user := &storage.User{ID: id, Email: id + "@example.com", AuthProvider: "email", SubscriptionTier: 1}
+	if err := p.metadataStorage.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+}
+
+func TestClaimContentReusesEarlierOutput(t *testing.T) {
+	p := newContentProcessor(t)
+	ctx := context.Background()
+	createContentUser(t, p, "user")
+	data := "the same audio"
+	sum := sha256.Sum256([]byte(data))
+	hash := hex.EncodeToString(sum[:])
+
+	// The first upload is hashed, moved to its content key and processed
+	first, firstJob, task := queueUnhashed(t, p, "user", "first", data)
+	task.SHA256 = hash
+	if reused, err := p.claimContent(ctx, firstJob, first, task, hash); err != nil || reused {
+		t.Fatalf("claimContent of the first upload = %v, %v; want false, nil", reused, err)
+	}
+	if got, err := p.metadataStorage.GetAudioFile(ctx, first.ID); err != nil || got.ContentHash != hash {
+		t.Fatalf("first file has ContentHash %q, %v; want %q", got.ContentHash, err, hash)
+	}
+	if _, err := p.audioStorage.GetObjectInfo(ctx, p.audioStorage.GetContentKey(hash, "wav")); err != nil {
+		t.Fatalf("original not at its content key: %v", err)
+	}
+	if err := p.audioStorage.UploadProcessed(ctx, first.ID, firstJob.ID, strings.NewReader("rendered"), "wav"); err != nil {
+		t.Fatalf("UploadProcessed: %v", err)
+	}
+	firstJob.OutputFormat = "wav"
+	firstJob.OutputS3Key = p.audioStorage.GetJobProcessedKey(first.ID, firstJob.ID, "wav")
+	if err := storage.TransitionJob(ctx, p.metadataStorage, firstJob, storage.JobCompleted, "processing finished"); err != nil {
+		t.Fatalf("TransitionJob: %v", err)
+	}
+
+	// The same content with the same options completes with a copy
+	second, secondJob, task := queueUnhashed(t, p, "user", "second", data)
+	if reused, err := p.claimContent(ctx, secondJob, second, task, hash); err != nil || !reused {
+		t.Fatalf("claimContent of the second upload = %v, %v; want true, nil", reused, err)
+	}
+	got, err := p.metadataStorage.GetJob(ctx, secondJob.ID)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if got.Status != storage.JobCompleted || got.ParentJobID == nil || *got.ParentJobID != firstJob.ID || got.OutputS3Key == firstJob.OutputS3Key {
+		t.Fatalf("expected a completed job with a copy of %s's output, got %+v", firstJob.ID, got)
+	}
+	r, err := p.audioStorage.Download(ctx, got.OutputS3Key)
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	defer r.Close()
+	if b, _ := io.ReadAll(r); string(b) != "rendered" {
+		t.Errorf("reused output = %q, want %q", b, "rendered")
+	}
+}
+
+func TestClaimContentRejectsChecksumMismatch(t *testing.T) {
+	p := newContentProcessor(t)
+	ctx := context.Background()
+	createContentUser(t, p, "user")
+
+	file, job, task := queueUnhashed(t, p, "user", "upload", "what was uploaded")
+	uploaded := sha256.Sum256([]byte("what was uploaded"))
+	sent := sha256.Sum256([]byte("what the client hashed"))
+	task.SHA256 = hex.EncodeToString(sent[:])
+
+	_, err := p.claimContent(ctx, job, file, task, hex.EncodeToString(uploaded[:]))
+	if ClassifyFailure(err) != FailureInvalidInput {
+		t.Fatalf("claimContent = %v, want an invalid input failure", err)
+	}
+	if got, err := p.metadataStorage.GetAudioFile(ctx, file.ID); err != nil || got.ContentHash != "" {
+		t.Errorf("mismatched file has ContentHash %q, %v; want none", got.ContentHash, err)
+	}
+}
+
+func TestDownloadHashesUnhashedOriginal(t *testing.T) {
+	p := newContentProcessor(t)
+	ctx := context.Background()
+	createContentUser(t, p, "user")
+	data := "downloaded once"
+	sum := sha256.Sum256([]byte(data))
+
+	file, _, _ := queueUnhashed(t, p, "user", "download", data)
+	path, hash, err := p.downloadFileForProcessing(ctx, file)
+	if err != nil {
+		t.Fatalf("downloadFileForProcessing: %v", err)
+	}
+	defer os.Remove(path)
+	if want := hex.EncodeToString(sum[:]); hash != want {
+		t.Errorf("hash = %q, want %q", hash, want)
+	}
+
+	// Files already hashed aren't hashed again
+	file.ContentHash = hash
+	if err := p.audioStorage.Copy(ctx, p.audioStorage.GetUploadKey(file.ID, file.Format), p.audioStorage.GetContentKey(hash, file.Format)); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	path, hash, err = p.downloadFileForProcessing(ctx, file)
+	if err != nil {
+		t.Fatalf("downloadFileForProcessing of a hashed file: %v", err)
+	}
+	defer os.Remove(path)
+	if hash != "" {
+		t.Errorf("hash of a hashed file = %q, want none", hash)
+	}
+}
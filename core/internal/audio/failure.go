@@ -0,0 +1,143 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FailureCategory groups processing failures by cause. It decides whether a
+// failed task is worth retrying and which message the user sees.
+type FailureCategory string
+
+const (
+	FailureInvalidInput FailureCategory = "invalid_input" // Corrupt or undecodable audio
+	FailureUnsupported  FailureCategory = "unsupported"   // Codec or container ffmpeg can't read
+	FailureValidation   FailureCategory = "validation"    // Task options that can never succeed
+	FailureStorage      FailureCategory = "storage"       // Download or upload problems
+	FailureTimeout      FailureCategory = "timeout"       // Deadline hit while processing
+	FailureInternal     FailureCategory = "internal"      // Anything else
+)
+
+// Permanent reports whether retrying the same task would fail the same way
+func (c FailureCategory) Permanent() bool {
+	switch c {
+	case FailureInvalidInput, FailureUnsupported, FailureValidation:
+		return true
+	}
+	return false
+}
+
+// UserMessage is the explanation stored on a failed job
+func (c FailureCategory) UserMessage() string {
+	switch c {
+	case FailureInvalidInput:
+		return "We couldn't read this file. It may be damaged or not an audio file. Try exporting it again and re-uploading."
+	case FailureUnsupported:
+		return "This file uses an audio format or codec we don't support. Try converting it to WAV, FLAC or MP3."
+	case FailureValidation:
+		return "The processing settings for this file are invalid. Please upload it again with different settings."
+	case FailureStorage:
+		return "We had trouble transferring your file. Please try again in a few minutes."
+	case FailureTimeout:
+		return "Processing took longer than allowed. Please try again, or use fast mode for very long files."
+	default:
+		return "Something went wrong while processing your file. Please try again."
+	}
+}
+
+// ProcessingError attaches a failure category to an error
+type ProcessingError struct {
+	Category FailureCategory
+	Err      error
+}
+
+func (e *ProcessingError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProcessingError) Unwrap() error {
+	return e.Err
+}
+
+// failure wraps err with a category
+func failure(category FailureCategory, err error) error {
+	return &ProcessingError{Category: category, Err: err}
+}
+
+// FFmpegError is a non-zero exit from ffmpeg or ffprobe, keeping the end of
+// its diagnostic output so the cause can be classified
+type FFmpegError struct {
+	Stage  string
+	Err    error
+	Output string
+}
+
+// Keep enough output for the final error lines
+const ffmpegErrorOutputLimit = 4096
+
+func newFFmpegError(stage string, err error, output []byte) *FFmpegError {
+	if len(output) > ffmpegErrorOutputLimit {
+		output = output[len(output)-ffmpegErrorOutputLimit:]
+	}
+	return &FFmpegError{Stage: stage, Err: err, Output: string(output)}
+}
+
+func (e *FFmpegError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Stage, e.Err)
+}
+
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+// ffmpeg messages that mean the input itself is at fault
+var (
+	unsupportedInputMessages = []string{
+		"unknown decoder",
+		"decoder not found",
+		"could not find codec parameters",
+		"not currently supported",
+		"unsupported codec",
+		"no decoder for",
+	}
+	invalidInputMessages = []string{
+		"invalid data found when processing input",
+		"moov atom not found",
+		"does not contain any stream",
+		"invalid audio stream",
+		"format not recognized",
+		"error while decoding",
+	}
+)
+
+// ClassifyFailure returns the category of an error from the processing pipeline
+func ClassifyFailure(err error) FailureCategory {
+	var pe *ProcessingError
+	if errors.As(err, &pe) {
+		return pe.Category
+	}
+
+	var ce *CancelledError
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ce) && !errors.Is(err, ErrJobCancelled)) {
+		return FailureTimeout
+	}
+
+	var fe *FFmpegError
+	if errors.As(err, &fe) {
+		output := strings.ToLower(fe.Output)
+		for _, msg := range unsupportedInputMessages {
+			if strings.Contains(output, msg) {
+				return FailureUnsupported
+			}
+		}
+		for _, msg := range invalidInputMessages {
+			if strings.Contains(output, msg) {
+				return FailureInvalidInput
+			}
+		}
+	}
+
+	return FailureInternal
+}
@@ -0,0 +1,61 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hibiken/asynq"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected FailureCategory
+	}{
+		{
+			name:     "Corrupt input",
+			err:      fmt.Errorf("audio processing failed: %w", newFFmpegError("normalization", errors.New("exit status 1"), []byte("input.mp3: Invalid data found when processing input\n"))),
+			expected: FailureInvalidInput,
+		},
+		{
+			name:     "Unsupported codec",
+			err:      newFFmpegError("ffmpeg analysis", errors.New("exit status 1"), []byte("[aac @ 0x1] Could not find codec parameters for stream 0\n")),
+			expected: FailureUnsupported,
+		},
+		{
+			name:     "Unrecognised ffmpeg failure",
+			err:      newFFmpegError("normalization", errors.New("signal: killed"), []byte("Conversion failed!\n")),
+			expected: FailureInternal,
+		},
+		{
+			name:     "Storage",
+			err:      failure(FailureStorage, fmt.Errorf("failed to download file: %w", errors.New("connection reset"))),
+			expected: FailureStorage,
+		},
+		{
+			name:     "Task deadline",
+			err:      &CancelledError{Stage: "normalization", Cause: context.DeadlineExceeded},
+			expected: FailureTimeout,
+		},
+		{
+			name:     "Validation",
+			err:      fmt.Errorf("%w: %w", failure(FailureValidation, errors.New("job ID is required")), asynq.SkipRetry),
+			expected: FailureValidation,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ClassifyFailure(tc.err); got != tc.expected {
+				t.Errorf("ClassifyFailure() = %s, expected %s", got, tc.expected)
+			}
+		})
+	}
+
+	if !FailureInvalidInput.Permanent() || FailureStorage.Permanent() || FailureTimeout.Permanent() {
+		t.Error("Input failures should be permanent and storage or timeout failures transient")
+	}
+}
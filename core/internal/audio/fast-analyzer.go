@@ -1,6 +1,7 @@
 package audio
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -8,7 +9,7 @@ import (
 
 // ProcessAudioWithMode processes audio using the specified mode
 // This is the main entry point that routes to appropriate analysis method
-func ProcessAudioWithMode(inputFile, outputFile string, targetLUFS float64, options OutputOptions, mode ProcessingMode, silenceInfo *SilenceInfo, noiseReduction bool) error {
+func ProcessAudioWithMode(ctx context.Context, inputFile, outputFile string, targetLUFS float64, options OutputOptions, mode ProcessingMode, silenceInfo *SilenceInfo, noiseReduction bool) error {
 	var loudnessInfo *LoudnessInfo
 	var err error
 
@@ -18,7 +19,7 @@ func ProcessAudioWithMode(inputFile, outputFile string, targetLUFS float64, opti
 		// Best for: podcasts, radio content, shorter mixes
 		// Note: For DJ mixes with dramatic dynamics, Precise mode is recommended
 		log.Printf("[INFO] Fast mode: Using adaptive sampling analysis")
-		loudnessInfo, err = AnalyzeLoudnessAdaptiveSample(inputFile)
+		loudnessInfo, err = AnalyzeLoudnessAdaptiveSample(ctx, inputFile)
 		if err != nil {
 			return fmt.Errorf("adaptive analysis failed: %w", err)
 		}
@@ -28,7 +29,7 @@ func ProcessAudioWithMode(inputFile, outputFile string, targetLUFS float64, opti
 		// Best for: DJ mixes, live sets, content with high dynamic range
 		// Takes longer but captures true dynamics for optimal normalization
 		log.Printf("[INFO] Precise mode: Using full file analysis")
-		loudnessInfo, err = AnalyzeLoudness(inputFile)
+		loudnessInfo, err = AnalyzeLoudness(ctx, inputFile)
 		if err != nil {
 			return fmt.Errorf("full analysis failed: %w", err)
 		}
@@ -43,7 +44,7 @@ func ProcessAudioWithMode(inputFile, outputFile string, targetLUFS float64, opti
 
 	// Normalize using dynamics-aware single-pass processing
 	// No segment cutting - preserves original audio structure perfectly
-	return NormalizeLoudness(inputFile, outputFile, targetLUFS, loudnessInfo, options, silenceInfo, noiseReduction)
+	return NormalizeLoudness(ctx, inputFile, outputFile, targetLUFS, loudnessInfo, options, silenceInfo, noiseReduction)
 }
 
 // ValidateProcessingMode checks if the processing mode is valid and returns the canonical form
@@ -1,5 +1,11 @@
 package audio
 
+import (
+	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
 type ProcessingMode string
 
 const (
@@ -13,6 +19,9 @@ type LoudnessInfo struct {
 	InputLRA      float64
 	InputThresh   float64
 	InputLoudness float64
+	// Confidence95 is the 95% confidence half-width (LU) of a sampled
+	// estimate; zero when the whole file was measured
+	Confidence95 float64
 }
 
 type ProcessTask struct {
@@ -25,11 +34,60 @@ type ProcessTask struct {
 	FastMode       bool           `json:"fast_mode"` // deprecated, used for backward compatibility
 	ProcessingMode ProcessingMode `json:"processing_mode"`
 	NoiseReduction bool           `json:"noise_reduction"`
+	SampleRate     int            `json:"sample_rate,omitempty"`   // 0 keeps DefaultSampleRate
+	ProcessAfter   *time.Time     `json:"process_after,omitempty"` // Deferred: don't start before this time
+	OffPeak        bool           `json:"off_peak,omitempty"`      // Runs in the off-peak window at a discount
+
+	DurationSeconds int    `json:"duration_seconds,omitempty"` // Length of the file, 0 if not measured when queued
+	SHA256          string `json:"sha256,omitempty"`           // The client's digest of the upload, checked when it is hashed
+}
+
+// Spec returns the options of the task for storing with its job
+func (t ProcessTask) Spec() storage.JobSpec {
+	return storage.JobSpec{
+		TargetLUFS:     t.TargetLUFS,
+		Preset:         t.Preset,
+		ProcessingMode: string(t.ProcessingMode),
+		NoiseReduction: t.NoiseReduction,
+		SampleRate:     t.SampleRate,
+		IsPremium:      t.IsPremium,
+		ProcessAfter:   t.ProcessAfter,
+		OffPeak:        t.OffPeak,
+	}
+}
+
+// SameOutput reports whether jobs with specs a and b render the same output
+// from the same original. When they run doesn't matter.
+func SameOutput(a, b storage.JobSpec) bool {
+	return a.TargetLUFS == b.TargetLUFS &&
+		a.Preset == b.Preset &&
+		a.ProcessingMode == b.ProcessingMode &&
+		a.NoiseReduction == b.NoiseReduction &&
+		a.SampleRate == b.SampleRate &&
+		a.IsPremium == b.IsPremium
+}
+
+// TaskFromSpec rebuilds the task a job was submitted with
+func TaskFromSpec(job *storage.ProcessingJob, spec storage.JobSpec) ProcessTask {
+	return ProcessTask{
+		JobID:          job.ID,
+		FileID:         job.AudioFileID,
+		UserID:         job.UserID,
+		TargetLUFS:     spec.TargetLUFS,
+		Preset:         spec.Preset,
+		IsPremium:      spec.IsPremium,
+		ProcessingMode: ProcessingMode(spec.ProcessingMode),
+		NoiseReduction: spec.NoiseReduction,
+		SampleRate:     spec.SampleRate,
+		ProcessAfter:   spec.ProcessAfter,
+		OffPeak:        spec.OffPeak,
+	}
 }
 
 type OutputOptions struct {
 	Codec        string
 	Bitrate      string
+	SampleRate   int // Target output rate in Hz; 0 uses DefaultSampleRate
 	ExtraOptions []string
 }
 
@@ -42,6 +100,12 @@ const (
 	BroadcastLUFS = -23.0 // Radio, TV (EBU R128)
 )
 
+// Output sample rates
+const (
+	DefaultSampleRate   = 44100 // CD / streaming delivery
+	BroadcastSampleRate = 48000 // Broadcast and video delivery (EBU, ATSC)
+)
+
 // Safety limits
 const (
 	MaxLUFS = -2.0
@@ -53,6 +117,7 @@ const (
 	QueueFast          = "fast"
 	QueuePremium       = "premium"
 	QueueStandard      = "standard"
+	QueueOffPeak       = "offpeak"       // deferred and off-peak jobs, lowest processing weight
 	QueueNotifications = "notifications" // dedicated queue for email/background tasks
 )
 
@@ -1,20 +1,62 @@
 package audio
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"math"
-	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
 )
 
-func NormalizeLoudness(inputFile, outputFile string, targetLUFS float64, info *LoudnessInfo, options OutputOptions, silenceInfo *SilenceInfo, noiseReduction bool) error {
+// NormalizeLoudness renders the normalized output. ffmpeg is killed when ctx
+// ends and a *CancelledError is returned.
+func NormalizeLoudness(ctx context.Context, inputFile, outputFile string, targetLUFS float64, info *LoudnessInfo, options OutputOptions, silenceInfo *SilenceInfo, noiseReduction bool) error {
 	numThreads := runtime.NumCPU()
 
+	filters, err := buildNormalizationFilters(targetLUFS, info, options, silenceInfo, noiseReduction)
+	if err != nil {
+		return err
+	}
+
+	filterChain := strings.Join(filters, ",")
+
+	args := buildFFmpegArgs(inputFile, outputFile, filterChain, numThreads, options)
+
+	log.Printf("[INFO] Processing audio (dynamics preserved)...")
+
+	// Output covers only the content when silence is trimmed
+	expected := progressDuration(ctx)
+	if silenceInfo != nil && silenceInfo.NeedsTrimming() {
+		expected = silenceInfo.ContentDuration()
+	}
+
+	var output bytes.Buffer
+	cmd := commandContext(ctx, "ffmpeg", args...)
+	attachProgress(ctx, cmd, StageNormalizing, expected)
+	cmd.Stderr = &output
+	err = cmd.Run()
+
+	if err := contextError(ctx, "normalization"); err != nil {
+		return err
+	}
+	if err != nil {
+		log.Printf("[ERROR] FFmpeg error: %v", err)
+		log.Printf("[ERROR] FFmpeg output: %s", output.String())
+		return newFFmpegError("normalization", err, output.Bytes())
+	}
+
+	log.Printf("[INFO] Normalization complete")
+	return nil
+}
+
+// buildNormalizationFilters builds the trim, noise reduction, resample, gain and
+// limiter chain shared by single-pass and segmented rendering
+func buildNormalizationFilters(targetLUFS float64, info *LoudnessInfo, options OutputOptions, silenceInfo *SilenceInfo, noiseReduction bool) ([]string, error) {
 	if targetLUFS < MinLUFS || targetLUFS > MaxLUFS {
-		return fmt.Errorf("target LUFS %.1f is outside valid range (%.1f to %.1f)", targetLUFS, MinLUFS, MaxLUFS)
+		return nil, fmt.Errorf("target LUFS %.1f is outside valid range (%.1f to %.1f)", targetLUFS, MinLUFS, MaxLUFS)
 	}
 
 	log.Printf("[INFO] Input: %.1f LUFS (LRA: %.1f, Peak: %.1f dB)", info.InputI, info.InputLRA, info.InputTP)
@@ -65,6 +107,12 @@ func NormalizeLoudness(inputFile, outputFile string, targetLUFS float64, info *L
 		log.Printf("[INFO] Noise reduction enabled (afftdn)")
 	}
 
+	// Resample ahead of gain + limiter so the limiter runs at the delivery rate
+	// and its ceiling still holds in the encoded output
+	sampleRate := outputSampleRate(options)
+	filters = append(filters, resampleFilter(sampleRate))
+	log.Printf("[INFO] Resampling to %d Hz (soxr)", sampleRate)
+
 	// Add normalization chain: gain + limiter (at DJ level)
 	if predictedPeak > -1.0 {
 		// Peaks will hit 0dB - limit and apply -1dB headroom
@@ -85,23 +133,7 @@ func NormalizeLoudness(inputFile, outputFile string, targetLUFS float64, info *L
 		log.Printf("[INFO] Reducing output by %.1f dB to reach final target", -volumeReduction)
 	}
 
-	filterChain := strings.Join(filters, ",")
-
-	args := buildFFmpegArgs(inputFile, outputFile, filterChain, numThreads, options)
-
-	log.Printf("[INFO] Processing audio (dynamics preserved)...")
-
-	cmd := exec.Command("ffmpeg", args...)
-	output, err := cmd.CombinedOutput()
-
-	if err != nil {
-		log.Printf("[ERROR] FFmpeg error: %v", err)
-		log.Printf("[ERROR] FFmpeg output: %s", string(output))
-		return fmt.Errorf("normalization failed: %w", err)
-	}
-
-	log.Printf("[INFO] Normalization complete")
-	return nil
+	return filters, nil
 }
 
 func calculateDynamicsAwareTarget(targetLUFS float64, info *LoudnessInfo) (float64, string) {
@@ -154,6 +186,11 @@ func buildFFmpegArgs(inputFile, outputFile, filterChain string, numThreads int,
 		"-max_muxing_queue_size", "9999",
 	}
 
+	return appendEncoderArgs(args, outputFile, options)
+}
+
+// appendEncoderArgs adds codec, bitrate, sample rate and output path arguments
+func appendEncoderArgs(args []string, outputFile string, options OutputOptions) []string {
 	outputExt := strings.ToLower(filepath.Ext(outputFile))
 
 	if options.Codec != "" {
@@ -177,7 +214,9 @@ func buildFFmpegArgs(inputFile, outputFile, filterChain string, numThreads int,
 		args = append(args, "-b:a", "320k")
 	}
 
-	args = append(args, "-ar", "44100")
+	// Matches the aresample stage in the chain, so no second resampler
+	// runs after the limiter
+	args = append(args, "-ar", fmt.Sprintf("%d", outputSampleRate(options)))
 
 	if len(options.ExtraOptions) > 0 {
 		args = append(args, options.ExtraOptions...)
@@ -187,6 +226,35 @@ func buildFFmpegArgs(inputFile, outputFile, filterChain string, numThreads int,
 	return args
 }
 
+// resampleFilter returns a high-quality SoX resampler stage for the target rate
+func resampleFilter(sampleRate int) string {
+	return fmt.Sprintf("aresample=%d:resampler=soxr:precision=28:cheby=1", sampleRate)
+}
+
+// outputSampleRate returns the requested output rate, or DefaultSampleRate if unset
+func outputSampleRate(options OutputOptions) int {
+	if options.SampleRate > 0 {
+		return options.SampleRate
+	}
+	return DefaultSampleRate
+}
+
+// ValidateSampleRate checks that the rate is supported for the output format.
+// MP3 tops out at 48kHz; lossless outputs also allow the high-resolution rates.
+func ValidateSampleRate(sampleRate int, outputFormat string) error {
+	switch sampleRate {
+	case 0, 44100, 48000:
+		return nil
+	case 88200, 96000:
+		if strings.ToLower(outputFormat) == "mp3" {
+			return fmt.Errorf("sample rate %d Hz is not supported for MP3 output (max 48000 Hz)", sampleRate)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported sample rate: %d Hz. Use 44100, 48000, 88200 or 96000", sampleRate)
+	}
+}
+
 func ValidateLUFS(lufs float64) error {
 	if lufs < MinLUFS || lufs > MaxLUFS {
 		return fmt.Errorf("LUFS value %.1f is outside valid range (%.1f to %.1f)", lufs, MinLUFS, MaxLUFS)
@@ -1,6 +1,7 @@
 package audio
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -43,14 +44,14 @@ func TestNormalizeLoudness(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			info, err := AnalyzeLoudness(tc.inputFile)
+			info, err := AnalyzeLoudness(context.Background(), tc.inputFile)
 			if err != nil {
 				t.Fatalf("Failed to analyze input file: %v", err)
 			}
 
 			outputFile := filepath.Join(tmpDir, "output.wav")
 
-			err = NormalizeLoudness(tc.inputFile, outputFile, tc.targetLUFS, info, tc.options, &SilenceInfo{}, false)
+			err = NormalizeLoudness(context.Background(), tc.inputFile, outputFile, tc.targetLUFS, info, tc.options, &SilenceInfo{}, false)
 			if (err != nil) != tc.wantError {
 				t.Errorf("NormalizeLoudness() error = %v, wantError %v", err, tc.wantError)
 				return
@@ -65,7 +66,7 @@ func TestNormalizeLoudness(t *testing.T) {
 				return
 			}
 
-			outputInfo, err := AnalyzeLoudness(outputFile)
+			outputInfo, err := AnalyzeLoudness(context.Background(), outputFile)
 			if err != nil {
 				t.Fatalf("Failed to analyze output file: %v", err)
 			}
@@ -83,3 +84,27 @@ func abs(x float64) float64 {
 	}
 	return x
 }
+
+func TestValidateSampleRate(t *testing.T) {
+	testCases := []struct {
+		name       string
+		sampleRate int
+		format     string
+		wantError  bool
+	}{
+		{"Default", 0, "mp3", false},
+		{"Broadcast MP3", 48000, "mp3", false},
+		{"Hi-res WAV", 96000, "wav", false},
+		{"Hi-res MP3", 96000, "mp3", true},
+		{"Unsupported rate", 22050, "wav", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateSampleRate(tc.sampleRate, tc.format)
+			if (err != nil) != tc.wantError {
+				t.Errorf("ValidateSampleRate(%d, %s) error = %v, wantError %v", tc.sampleRate, tc.format, err, tc.wantError)
+			}
+		})
+	}
+}
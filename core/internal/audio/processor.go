@@ -2,7 +2,10 @@ package audio
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -24,6 +27,7 @@ type Processor struct {
 	audioStorage    storage.AudioStorage
 	metadataStorage storage.MetadataStorage
 	redisClient     *redis.Client
+	scheduling      SchedulingConfig
 	mu              sync.Mutex
 }
 
@@ -67,10 +71,17 @@ func NewProcessor(audioStorage storage.AudioStorage, metadataStorage storage.Met
 		audioStorage:    audioStorage,
 		metadataStorage: metadataStorage,
 		redisClient:     redisClient,
+		scheduling:      LoadSchedulingConfig(),
 	}
 }
 
 func (p *Processor) updateProgress(ctx context.Context, jobID string, progress int, status string) {
+	p.updateProgressETA(ctx, jobID, progress, status, 0)
+}
+
+// updateProgressETA is updateProgress with an estimate of the time left; zero
+// means no estimate
+func (p *Processor) updateProgressETA(ctx context.Context, jobID string, progress int, status string, eta time.Duration) {
 	if p.redisClient == nil {
 		return
 	}
@@ -78,18 +89,7 @@ func (p *Processor) updateProgress(ctx context.Context, jobID string, progress i
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	key := fmt.Sprintf("progress:%s", jobID)
-	data := map[string]interface{}{
-		"progress":   progress,
-		"status":     status,
-		"updated_at": time.Now().Unix(),
-	}
-
-	pipe := p.redisClient.Pipeline()
-	pipe.HMSet(ctx, key, data)
-	pipe.Expire(ctx, key, 30*time.Minute)
-
-	if _, err := pipe.Exec(ctx); err != nil {
+	if err := PublishProgress(ctx, p.redisClient, jobID, progress, status, eta); err != nil {
 		// Only log if debug mode - progress updates failing isn't critical
 		if debugMode {
 			log.Printf("[DEBUG] Progress update failed for job %s: %v", jobID, err)
@@ -105,7 +105,7 @@ func (p *Processor) setSilenceTrimmed(ctx context.Context, jobID string, trimmed
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	key := fmt.Sprintf("progress:%s", jobID)
+	key := ProgressKey(jobID)
 	if trimmed {
 		p.redisClient.HSet(ctx, key, "silence_trimmed", "true")
 	}
@@ -120,11 +120,6 @@ func (p *Processor) HandleAudioProcess(ctx context.Context, t *asynq.Task) (err
 		}
 	}()
 
-	// Set overall timeout for the entire job (handles up to 5GB files / ~8 hours of audio)
-	jobTimeout := 60 * time.Minute
-	ctx, cancel := context.WithTimeout(ctx, jobTimeout)
-	defer cancel()
-
 	var task ProcessTask
 	if err := json.Unmarshal(t.Payload(), &task); err != nil {
 		return fmt.Errorf("failed to unmarshal task: %w", err)
@@ -142,15 +137,40 @@ func (p *Processor) HandleAudioProcess(ctx context.Context, t *asynq.Task) (err
 		task.ProcessingMode = ModePrecise
 	}
 
+	// Overall timeout for the entire job, scaled to the file's length
+	ctx, cancel := context.WithTimeout(ctx, jobTimeout(task.DurationSeconds, task.ProcessingMode))
+	defer cancel()
+
 	if err := p.validateTask(task); err != nil {
-		return fmt.Errorf("task validation failed: %w", err)
+		err = failure(FailureValidation, fmt.Errorf("task validation failed: %w", err))
+		if job, getErr := p.metadataStorage.GetJob(ctx, task.JobID); getErr == nil {
+			return p.failJob(ctx, job, task.FileID, err)
+		}
+		return fmt.Errorf("%w: %w", err, asynq.SkipRetry)
 	}
 
 	job, err := p.metadataStorage.GetJob(ctx, task.JobID)
+	if errors.Is(err, storage.ErrNotFound) {
+		// Deleted along with its file or account; retrying won't bring it back
+		log.Printf("[INFO] Job %s no longer exists, dropping task", task.JobID)
+		p.releaseUsage(ctx, task.JobID)
+		return fmt.Errorf("%w: %w", err, asynq.SkipRetry)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to retrieve job %s: %w", task.JobID, err)
 	}
 
+	// Wait our turn if the user already has their tier's share of workers.
+	// The task is requeued without counting as a failed attempt.
+	releaseSlot, err := p.acquireUserSlot(ctx, task.UserID, job.ID)
+	if err != nil {
+		if errors.Is(err, ErrUserAtCapacity) {
+			log.Printf("[INFO] %v", err)
+		}
+		return err
+	}
+	defer releaseSlot()
+
 	// Track cleanup operations
 	var cleanupFiles []string
 	defer func() {
@@ -163,45 +183,72 @@ func (p *Processor) HandleAudioProcess(ctx context.Context, t *asynq.Task) (err
 		}
 	}()
 
-	p.updateProgress(ctx, task.FileID, 1, "processing")
+	p.updateProgress(ctx, task.FileID, 1, storage.StatusProcessing)
 
-	now := time.Now()
-	job.Status = "processing"
-	job.StartedAt = &now
-	if err := p.metadataStorage.UpdateJob(ctx, job); err != nil {
-		// Non-critical, continue processing
-		if debugMode {
-			log.Printf("[DEBUG] Failed to update job status: %v", err)
+	// Every stage runs under processingCtx, and cancelling it kills any running
+	// ffmpeg. A user cancellation is recorded as the cause so it can be told
+	// apart from the task deadline or a worker shutdown.
+	processingCtx, cancelProcessing := context.WithCancelCause(ctx)
+	defer cancelProcessing(nil)
+	go p.watchCancellation(processingCtx, task.FileID, cancelProcessing)
+
+	if err := p.startJob(ctx, job); err != nil {
+		if errors.Is(err, storage.ErrInvalidTransition) || errors.Is(err, storage.ErrStatusConflict) {
+			log.Printf("[INFO] Job %s not started: %v", job.ID, err)
+			return fmt.Errorf("job %s not started: %v: %w", job.ID, err, asynq.SkipRetry)
 		}
+		return fmt.Errorf("failed to start job %s: %w", job.ID, err)
 	}
 
 	// Get audio file info
 	audioFile, err := p.getAudioFileWithTimeout(ctx, task.FileID)
 	if err != nil {
-		return p.failJob(ctx, job, task.FileID, fmt.Errorf("failed to get audio file info: %w", err))
+		return p.failJob(ctx, job, task.FileID, failure(FailureStorage, fmt.Errorf("failed to get audio file info: %w", err)))
 	}
 
-	// Download file
-	p.updateProgress(ctx, task.FileID, 5, "downloading")
-	inputFile, err := p.downloadFileForProcessing(ctx, task.FileID, audioFile.Format)
-	if err != nil {
-		return p.failJob(ctx, job, task.FileID, fmt.Errorf("failed to download file: %w", err))
-	}
-	cleanupFiles = append(cleanupFiles, inputFile)
+	// Multi-hour files are streamed from storage and processed in chunks
+	// instead of being downloaded whole
+	inputFile, segmented := p.segmentedSource(processingCtx, audioFile, jobTimeout(task.DurationSeconds, task.ProcessingMode))
+	if segmented {
+		log.Printf("[INFO] Long file (%.1f minutes): using segmented streaming pipeline", float64(*audioFile.DurationSeconds)/60)
+	} else {
+		// Download file
+		p.updateProgress(ctx, task.FileID, 5, "downloading")
+		var hash string
+		inputFile, hash, err = p.downloadFileForProcessing(processingCtx, audioFile)
+		if err != nil {
+			return p.stopJob(ctx, processingCtx, job, task.FileID, failure(FailureStorage, fmt.Errorf("failed to download file: %w", err)))
+		}
+		cleanupFiles = append(cleanupFiles, inputFile)
 
-	// Verify downloaded file
-	if info, err := os.Stat(inputFile); err != nil || info.Size() == 0 {
-		return p.failJob(ctx, job, task.FileID, fmt.Errorf("downloaded file is invalid or empty"))
-	}
+		// Verify downloaded file
+		if info, err := os.Stat(inputFile); err != nil || info.Size() == 0 {
+			return p.failJob(ctx, job, task.FileID, failure(FailureInvalidInput, fmt.Errorf("downloaded file is invalid or empty")))
+		}
 
-	if debugMode {
-		if info, _ := os.Stat(inputFile); info != nil {
-			log.Printf("[DEBUG] Input file ready: %s (%.2f MB)", inputFile, float64(info.Size())/(1024*1024))
+		// Uploads confirmed without hashing them were hashed as they were
+		// downloaded, so confirming doesn't read the whole file; one the
+		// user already has output for completes with a copy of it. Streamed
+		// files are never read whole, so they stay at their upload key.
+		if audioFile.ContentHash == "" {
+			if reused, err := p.claimContent(processingCtx, job, audioFile, task, hash); err != nil {
+				return p.stopJob(ctx, processingCtx, job, task.FileID, err)
+			} else if reused {
+				return nil
+			}
+		}
+
+		if debugMode {
+			if info, _ := os.Stat(inputFile); info != nil {
+				log.Printf("[DEBUG] Input file ready: %s (%.2f MB)", inputFile, float64(info.Size())/(1024*1024))
+			}
 		}
 	}
 
 	var silenceInfo *SilenceInfo
-	if si, err := DetectSilence(inputFile); err != nil {
+	if si, err := DetectSilence(processingCtx, inputFile); IsCancelled(err) {
+		return p.stopJob(ctx, processingCtx, job, task.FileID, err)
+	} else if err != nil {
 		log.Printf("[WARN] Silence detection failed, continuing without trim: %v", err)
 	} else {
 		silenceInfo = si
@@ -212,9 +259,22 @@ func (p *Processor) HandleAudioProcess(ctx context.Context, t *asynq.Task) (err
 		}
 	}
 
+	// The segmented pipeline needs the exact length; silence detection has
+	// just measured it
+	var sourceDuration float64
+	if segmented {
+		if silenceInfo != nil && silenceInfo.TotalDuration > 0 {
+			sourceDuration = silenceInfo.TotalDuration
+		} else if sourceDuration, err = getDuration(processingCtx, inputFile); err != nil {
+			return p.stopJob(ctx, processingCtx, job, task.FileID, fmt.Errorf("failed to get audio duration: %w", err))
+		}
+	}
+
 	if audioFile.DurationSeconds == nil {
-		duration, err := getDuration(inputFile)
-		if err != nil {
+		duration, err := getDuration(processingCtx, inputFile)
+		if IsCancelled(err) {
+			return p.stopJob(ctx, processingCtx, job, task.FileID, err)
+		} else if err != nil {
 			log.Printf("[WARN] Failed to get audio duration for %s: %v", task.FileID, err)
 		} else {
 			durationInt := int(duration)
@@ -228,62 +288,38 @@ func (p *Processor) HandleAudioProcess(ctx context.Context, t *asynq.Task) (err
 	}
 
 	// Determine output format and options
-	outputFormat := p.determineOutputFormat(task.IsPremium, audioFile.Format)
+	outputFormat := OutputFormat(task.IsPremium, audioFile.Format)
 	outputFile := p.getOutputFilePath(task.FileID, task.JobID, outputFormat)
 	cleanupFiles = append(cleanupFiles, outputFile)
 	outputOptions := p.getOutputOptions(task.IsPremium, audioFile.Format)
+	outputOptions.SampleRate = task.SampleRate
 
 	// Update progress based on mode
-	var statusMsg string
-	switch task.ProcessingMode {
-	case ModeFast:
-		statusMsg = "fast_analyzing"
-	case ModePrecise:
-		statusMsg = "precise_analyzing"
-	default:
-		statusMsg = "analyzing"
-	}
-	p.updateProgress(ctx, task.FileID, 15, statusMsg)
-
-	// Process with timeout monitoring
-	processingCtx, processingCancel := context.WithTimeout(ctx, 20*time.Minute)
-	defer processingCancel()
+	tracker := p.newJobProgress(ctx, task.FileID, task.ProcessingMode)
+	p.updateProgress(ctx, task.FileID, progressAnalysisStart, tracker.statuses[StageAnalyzing])
 
-	processDone := make(chan error, 1)
-	go func() {
-		processDone <- ProcessAudioWithMode(inputFile, outputFile, task.TargetLUFS, outputOptions, task.ProcessingMode, silenceInfo, task.NoiseReduction)
-	}()
+	// ffmpeg reports its position against the input duration
+	progressDuration := sourceDuration
+	if !segmented && audioFile.DurationSeconds != nil {
+		progressDuration = float64(*audioFile.DurationSeconds)
+	}
 
-	// Update progress during normalization phase
-	progressTicker := time.NewTicker(2 * time.Second)
-	defer progressTicker.Stop()
+	// Process with a timeout scaled to the file's length; ffmpeg is killed
+	// when the deadline passes
+	renderCtx, renderCancel := context.WithTimeout(processingCtx, renderTimeout(progressDuration, task.ProcessingMode))
+	defer renderCancel()
+	renderCtx = WithProgress(renderCtx, progressDuration, tracker.report)
 
-	currentProgress := 15
-	for {
-		select {
-		case err := <-processDone:
-			if err != nil {
-				return p.failJob(ctx, job, task.FileID, fmt.Errorf("audio processing failed: %w", err))
-			}
-			// Processing complete, break out of loop
-			goto ProcessingComplete
-		case <-processingCtx.Done():
-			return p.failJob(ctx, job, task.FileID, fmt.Errorf("processing timed out after 20 minutes"))
-		case <-progressTicker.C:
-			// Check for cancellation
-			if p.isCancelled(ctx, task.FileID) {
-				return p.cancelJob(ctx, job, task.FileID)
-			}
-			// Incrementally update progress from 15% to 80% during processing
-			if currentProgress < 80 {
-				currentProgress += 1
-				p.updateProgress(ctx, task.FileID, currentProgress, "normalizing")
-			}
-		}
+	if segmented {
+		err = ProcessAudioSegmented(renderCtx, inputFile, outputFile, task.TargetLUFS, outputOptions, task.ProcessingMode, silenceInfo, task.NoiseReduction, sourceDuration)
+	} else {
+		err = ProcessAudioWithMode(renderCtx, inputFile, outputFile, task.TargetLUFS, outputOptions, task.ProcessingMode, silenceInfo, task.NoiseReduction)
+	}
+	if err != nil {
+		return p.stopJob(ctx, processingCtx, job, task.FileID, fmt.Errorf("audio processing failed: %w", err))
 	}
 
-ProcessingComplete:
-	p.updateProgress(ctx, task.FileID, 85, "normalizing")
+	p.updateProgress(ctx, task.FileID, progressRenderEnd, "normalizing")
 
 	// Verify output file
 	if info, err := os.Stat(outputFile); err != nil || info.Size() == 0 {
@@ -293,37 +329,44 @@ ProcessingComplete:
 	p.updateProgress(ctx, task.FileID, 90, "uploading")
 
 	// Upload with timeout (handles up to 5GB files)
-	uploadCtx, uploadCancel := context.WithTimeout(ctx, 10*time.Minute)
+	uploadCtx, uploadCancel := context.WithTimeout(processingCtx, 10*time.Minute)
 	defer uploadCancel()
 
-	if err := p.uploadProcessedFile(uploadCtx, task.FileID, outputFile, outputFormat); err != nil {
-		return p.failJob(ctx, job, task.FileID, fmt.Errorf("failed to upload processed file: %w", err))
+	if err := p.uploadProcessedFile(uploadCtx, task.FileID, task.JobID, outputFile, outputFormat); err != nil {
+		return p.stopJob(ctx, processingCtx, job, task.FileID, failure(FailureStorage, fmt.Errorf("failed to upload processed file: %w", err)))
 	}
 
 	// Mark as completed
 	job.OutputFormat = outputFormat
+	job.OutputS3Key = p.audioStorage.GetJobProcessedKey(task.FileID, task.JobID, outputFormat)
 	completedNow := time.Now()
-	job.Status = "completed"
 	job.CompletedAt = &completedNow
 
-	if err := p.metadataStorage.UpdateJob(ctx, job); err != nil {
-		// Non-critical error
+	if err := storage.TransitionJob(ctx, p.metadataStorage, job, storage.JobCompleted, "processing finished"); err != nil {
+		// Cancelled by the user while the upload was finishing
+		if errors.Is(err, storage.ErrStatusConflict) {
+			log.Printf("[INFO] Job %s changed status before completing, discarding result", job.ID)
+			if err := p.audioStorage.Delete(ctx, job.OutputS3Key); err != nil {
+				log.Printf("[WARN] Failed to delete discarded output %s: %v", job.OutputS3Key, err)
+			}
+			return asynq.SkipRetry
+		}
 		if debugMode {
 			log.Printf("[DEBUG] Failed to update job to completed: %v", err)
 		}
 	}
 
-	p.updateProgress(ctx, task.FileID, 100, "completed")
+	p.updateProgress(ctx, task.FileID, 100, storage.StatusCompleted)
 
-	if err := p.metadataStorage.UpdateStatus(ctx, task.FileID, "completed"); err != nil {
+	if err := p.metadataStorage.UpdateStatus(ctx, task.FileID, storage.StatusCompleted); err != nil {
 		if debugMode {
 			log.Printf("[DEBUG] Failed to update file status: %v", err)
 		}
 	}
 
-	// Update user stats if applicable
+	// Record usage if applicable
 	if task.UserID != "" {
-		p.updateUserStats(ctx, task.UserID, job)
+		p.recordUsage(ctx, task.UserID, job, silenceInfo)
 	}
 
 	// Log success with timing
@@ -333,27 +376,192 @@ ProcessingComplete:
 	return nil
 }
 
+// failJob records a failed attempt. Transient failures put the job back to
+// queued while asynq has retries left; permanent ones fail it at once and
+// skip the remaining retries. The job keeps a user-facing message for its
+// failure category; the full error goes to the log and the task.
 func (p *Processor) failJob(ctx context.Context, job *storage.ProcessingJob, fileID string, err error) error {
-	log.Printf("[ERROR] Job %s failed: %v", job.ID, err)
+	category := ClassifyFailure(err)
+	log.Printf("[ERROR] Job %s failed (%s): %v", job.ID, category, err)
 
-	errMsg := err.Error()
-	job.Status = "failed"
-	job.ErrorMessage = &errMsg
-
-	updateCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	// The job context may already have expired (e.g. task deadline)
+	updateCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
 	defer cancel()
 
-	if updateErr := p.metadataStorage.UpdateJob(updateCtx, job); updateErr != nil {
+	retry, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	if !category.Permanent() && retry < maxRetry && !errors.Is(err, asynq.SkipRetry) {
+		if updateErr := storage.TransitionJob(updateCtx, p.metadataStorage, job, storage.JobQueued,
+			fmt.Sprintf("attempt %d failed (%s), will retry", retry+1, category)); updateErr != nil && debugMode {
+			log.Printf("[DEBUG] Failed to requeue job after failed attempt: %v", updateErr)
+		}
+		p.updateProgress(updateCtx, fileID, 0, storage.StatusQueued)
+		return err
+	}
+
+	errMsg := category.UserMessage()
+	errCategory := string(category)
+	job.ErrorMessage = &errMsg
+	job.ErrorCategory = &errCategory
+
+	if updateErr := storage.TransitionJob(updateCtx, p.metadataStorage, job, storage.JobFailed, fmt.Sprintf("%s failure", category)); updateErr != nil {
 		if debugMode {
 			log.Printf("[DEBUG] Failed to update job status to failed: %v", updateErr)
 		}
 	}
 
-	p.updateProgress(updateCtx, fileID, 0, "failed")
+	p.updateProgress(updateCtx, fileID, 0, storage.StatusFailed)
+	p.releaseUsage(updateCtx, job.ID)
 
+	if category.Permanent() && !errors.Is(err, asynq.SkipRetry) {
+		return fmt.Errorf("%w: %w", err, asynq.SkipRetry)
+	}
 	return err
 }
 
+// startJob moves a job to processing. A job left in processing by a worker
+// that died mid-run is first put back to queued, so its history shows the
+// interruption. Jobs that were cancelled or finished in the meantime are
+// not started.
+func (p *Processor) startJob(ctx context.Context, job *storage.ProcessingJob) error {
+	if job.Status == storage.JobProcessing {
+		log.Printf("[WARN] Job %s was left processing by an interrupted worker, restarting", job.ID)
+		if err := storage.TransitionJob(ctx, p.metadataStorage, job, storage.JobQueued, "recovered after worker interruption"); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	job.StartedAt = &now
+	return storage.TransitionJob(ctx, p.metadataStorage, job, storage.JobProcessing, "picked up by worker")
+}
+
+// Overall progress bounds of the analysis and render stages
+const (
+	progressAnalysisStart = 15
+	progressRenderEnd     = 85
+)
+
+// progressRange is the part of the overall progress bar a stage fills
+type progressRange struct {
+	start, end int
+}
+
+// jobProgress maps ffmpeg stage progress onto the job's overall progress,
+// keeps it monotonic, throttles Redis writes and estimates the time left
+type jobProgress struct {
+	p        *Processor
+	ctx      context.Context
+	fileID   string
+	ranges   map[string]progressRange
+	statuses map[string]string
+	started  time.Time
+
+	mu        sync.Mutex
+	progress  int
+	lastWrite time.Time
+}
+
+func (p *Processor) newJobProgress(ctx context.Context, fileID string, mode ProcessingMode) *jobProgress {
+	// Sampled analysis is a small part of a fast job; full analysis decodes
+	// the whole file, which takes about as long as rendering it
+	analysisEnd, analyzingStatus := 50, "precise_analyzing"
+	if mode == ModeFast {
+		analysisEnd, analyzingStatus = 30, "fast_analyzing"
+	}
+
+	return &jobProgress{
+		p:      p,
+		ctx:    ctx,
+		fileID: fileID,
+		ranges: map[string]progressRange{
+			StageAnalyzing:   {start: progressAnalysisStart, end: analysisEnd},
+			StageNormalizing: {start: analysisEnd, end: progressRenderEnd},
+		},
+		statuses: map[string]string{
+			StageAnalyzing:   analyzingStatus,
+			StageNormalizing: "normalizing",
+		},
+		started: time.Now(),
+	}
+}
+
+func (j *jobProgress) report(stage string, fraction float64) {
+	r, ok := j.ranges[stage]
+	if !ok {
+		return
+	}
+	progress := r.start + int(fraction*float64(r.end-r.start))
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// Never go backwards, and write at most once a second
+	if progress <= j.progress || time.Since(j.lastWrite) < time.Second {
+		return
+	}
+	j.progress = progress
+	j.lastWrite = time.Now()
+
+	j.p.updateProgressETA(j.ctx, j.fileID, progress, j.statuses[stage], j.eta(progress))
+}
+
+// eta extrapolates the time left in the analysis and render stages from the
+// rate of progress so far
+func (j *jobProgress) eta(progress int) time.Duration {
+	done := progress - progressAnalysisStart
+	if done <= 0 {
+		return 0
+	}
+	remaining := progressRenderEnd - progress
+	return time.Since(j.started) * time.Duration(remaining) / time.Duration(done)
+}
+
+// stopJob records why processing stopped. A user cancellation marks the job
+// cancelled; a worker shutdown leaves it for asynq to requeue; anything else,
+// including the task deadline, fails the job.
+func (p *Processor) stopJob(ctx, processingCtx context.Context, job *storage.ProcessingJob, fileID string, err error) error {
+	switch {
+	case errors.Is(context.Cause(processingCtx), ErrJobCancelled):
+		return p.cancelJob(ctx, job, fileID)
+	case errors.Is(ctx.Err(), context.Canceled):
+		log.Printf("[WARN] Job %s interrupted by worker shutdown: %v", job.ID, err)
+
+		updateCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if updateErr := storage.TransitionJob(updateCtx, p.metadataStorage, job, storage.JobQueued, "worker shutdown"); updateErr != nil && debugMode {
+			log.Printf("[DEBUG] Failed to requeue interrupted job: %v", updateErr)
+		}
+		return err
+	default:
+		return p.failJob(ctx, job, fileID, err)
+	}
+}
+
+// watchCancellation polls for a user cancellation and cancels the job's
+// processing context with ErrJobCancelled, which kills any running ffmpeg
+func (p *Processor) watchCancellation(ctx context.Context, fileID string, cancel context.CancelCauseFunc) {
+	if p.redisClient == nil {
+		return
+	}
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if p.isCancelled(ctx, fileID) {
+				log.Printf("[INFO] Cancellation requested for %s, stopping processing", fileID)
+				cancel(ErrJobCancelled)
+				return
+			}
+		}
+	}
+}
+
 func (p *Processor) isCancelled(ctx context.Context, fileID string) bool {
 	if p.redisClient == nil {
 		return false
@@ -372,35 +580,39 @@ func (p *Processor) cancelJob(ctx context.Context, job *storage.ProcessingJob, f
 	log.Printf("[INFO] Job %s cancelled by user", job.ID)
 
 	cancelMsg := "Job cancelled by user"
-	job.Status = "cancelled"
 	job.ErrorMessage = &cancelMsg
 	now := time.Now()
 	job.CompletedAt = &now
 
-	updateCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	updateCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
 	defer cancel()
 
-	if updateErr := p.metadataStorage.UpdateJob(updateCtx, job); updateErr != nil {
+	// The cancel endpoint normally records the transition itself before
+	// signalling the worker, in which case the status has already moved on
+	updateErr := storage.TransitionJob(updateCtx, p.metadataStorage, job, storage.JobCancelled, "cancelled by user")
+	if updateErr != nil && !errors.Is(updateErr, storage.ErrStatusConflict) {
 		if debugMode {
 			log.Printf("[DEBUG] Failed to update job status to cancelled: %v", updateErr)
 		}
 	}
 
-	p.updateProgress(updateCtx, fileID, 0, "cancelled")
+	p.updateProgress(updateCtx, fileID, 0, storage.StatusCancelled)
+	p.releaseUsage(updateCtx, job.ID)
 
-	if err := p.metadataStorage.UpdateStatus(updateCtx, fileID, "cancelled"); err != nil {
+	if err := p.metadataStorage.UpdateStatus(updateCtx, fileID, storage.StatusCancelled); err != nil {
 		if debugMode {
 			log.Printf("[DEBUG] Failed to update file status to cancelled: %v", err)
 		}
 	}
 
-	// Clean up uploaded file from S3
+	// Clean up uploaded file from S3, unless an earlier job for the file
+	// completed and it is kept for re-processing, or another file shares it
 	audioFile, err := p.metadataStorage.GetAudioFile(updateCtx, fileID)
-	if err == nil {
-		uploadKey := p.audioStorage.GetUploadKey(fileID, audioFile.Format)
-		if err := p.audioStorage.Delete(updateCtx, uploadKey); err != nil {
+	if err == nil && !storage.HasCompletedJob(updateCtx, p.metadataStorage, fileID) {
+		uploadKey := storage.OriginalKey(p.audioStorage, audioFile)
+		if deleted, err := storage.DeleteOriginal(updateCtx, p.audioStorage, p.metadataStorage, audioFile); err != nil {
 			log.Printf("[WARN] Failed to delete cancelled file from S3: %v", err)
-		} else {
+		} else if deleted {
 			log.Printf("[INFO] Deleted cancelled file %s from S3", uploadKey)
 		}
 	}
@@ -416,58 +628,73 @@ func (p *Processor) getAudioFileWithTimeout(ctx context.Context, fileID string)
 	return p.metadataStorage.GetAudioFile(ctx, fileID)
 }
 
-func (p *Processor) updateUserStats(ctx context.Context, userID string, job *storage.ProcessingJob) {
+// recordUsage charges the job's processing time in the usage ledger, which
+// releases the time reserved when it was queued. Silence trimmed from the
+// ends isn't charged. It is charged once per job even if completion is
+// reported again after a retry.
+func (p *Processor) recordUsage(ctx context.Context, userID string, job *storage.ProcessingJob, silence *SilenceInfo) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	stats, err := p.metadataStorage.GetUserStats(ctx, userID)
-	if err != nil {
-		stats = &storage.UserUploadStats{
-			UserID:                     userID,
-			ProcessingTimeThisMonth:    0,
-			MonthResetAt:               getMonthStart(time.Now()),
-			TotalUploads:               0,
-			TotalProcessingTimeSeconds: 0,
-			UploadsThisWeek:            0,
-			WeekResetAt:                time.Now(),
-		}
-	}
-
 	// Get the audio file to access duration
 	audioFile, err := p.metadataStorage.GetAudioFile(ctx, job.AudioFileID)
 	if err != nil {
-		log.Printf("[ERROR] Failed to get audio file for stats update: %v", err)
+		log.Printf("[ERROR] Failed to get audio file for usage of job %s: %v", job.ID, err)
 		return
 	}
 
 	// Use audio file duration instead of job execution time
-	if audioFile.DurationSeconds != nil && *audioFile.DurationSeconds > 0 {
-		audioDuration := *audioFile.DurationSeconds
-
-		// Check for monthly reset before updating
-		currentMonthStart := getMonthStart(time.Now())
-		if stats.MonthResetAt.Before(currentMonthStart) {
-			log.Printf("[INFO] Monthly reset during stats update for user %s", userID)
-			stats.ProcessingTimeThisMonth = 0
-			stats.MonthResetAt = currentMonthStart
-		}
+	if audioFile.DurationSeconds == nil || *audioFile.DurationSeconds <= 0 {
+		log.Printf("[WARN] Audio file %s has no duration, keeping what job %s reserved as its usage", job.AudioFileID, job.ID)
+		return
+	}
+	audioDuration := *audioFile.DurationSeconds
+	if content := int(silence.ContentDuration()); silence.NeedsTrimming() && content > 0 && content < audioDuration {
+		audioDuration = content
+	}
 
-		// Update both total and monthly processing time with audio duration
-		stats.TotalProcessingTimeSeconds += audioDuration
-		stats.ProcessingTimeThisMonth += audioDuration
+	tier := 1
+	if user, err := p.metadataStorage.GetUser(ctx, userID); err == nil {
+		tier = user.SubscriptionTier
+	} else {
+		log.Printf("[WARN] Failed to look up tier of user %s for usage, recording free tier: %v", userID, err)
+	}
 
-		log.Printf("[INFO] Updated stats for user %s: +%ds audio processed (Monthly: %ds, Total: %ds)",
-			userID, audioDuration, stats.ProcessingTimeThisMonth, stats.TotalProcessingTimeSeconds)
+	// Off-peak jobs use less of the monthly allowance
+	offPeak := job.Spec != nil && job.Spec.OffPeak
+	charged := p.scheduling.ChargedSeconds(audioDuration, offPeak)
 
-		if err := p.metadataStorage.UpdateUserStats(ctx, stats); err != nil {
-			log.Printf("[ERROR] Failed to update user stats: %v", err)
+	entry := &storage.UsageEntry{
+		UserID:       userID,
+		JobID:        job.ID,
+		Seconds:      charged,
+		AudioSeconds: audioDuration,
+		Tier:         tier,
+	}
+	if err := p.metadataStorage.RecordUsage(ctx, entry); err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			log.Printf("[INFO] Usage of job %s already recorded", job.ID)
+			return
 		}
-	} else {
-		log.Printf("[WARN] Audio file %s has no duration, cannot update stats", job.AudioFileID)
+		log.Printf("[ERROR] Failed to record usage of job %s: %v", job.ID, err)
+		return
 	}
+
+	log.Printf("[INFO] Recorded usage for user %s: %ds audio processed, %ds charged to %s (off-peak: %t)",
+		userID, audioDuration, charged, entry.Period, offPeak)
 }
 
-func (p *Processor) determineOutputFormat(isPremium bool, inputFormat string) string {
+// releaseUsage gives back the processing time a job reserved when it was
+// queued, once it won't be charged
+func (p *Processor) releaseUsage(ctx context.Context, jobID string) {
+	if err := p.metadataStorage.ReleaseUsage(ctx, jobID); err != nil {
+		log.Printf("[ERROR] Failed to release usage reserved by job %s: %v", jobID, err)
+	}
+}
+
+// OutputFormat is the format a job's output is rendered in: MP3 for free
+// users, the input's format for premium ones
+func OutputFormat(isPremium bool, inputFormat string) string {
 	if !isPremium {
 		return "mp3"
 	}
@@ -484,17 +711,23 @@ func (p *Processor) validateTask(task ProcessTask) error {
 	if task.TargetLUFS < -50 || task.TargetLUFS > 0 {
 		return fmt.Errorf("target LUFS must be between -50 and 0, got %f", task.TargetLUFS)
 	}
+	if task.SampleRate < 0 {
+		return fmt.Errorf("sample rate must be positive, got %d", task.SampleRate)
+	}
 	return nil
 }
 
-func (p *Processor) downloadFileForProcessing(ctx context.Context, fileID, format string) (string, error) {
+// downloadFileForProcessing downloads the original to a temp file. For files
+// without a content hash it also returns the original's hex SHA-256, taken
+// from what was downloaded rather than by reading the original again.
+func (p *Processor) downloadFileForProcessing(ctx context.Context, audioFile *storage.AudioFile) (string, string, error) {
 	// Check disk space first
 	if err := checkDiskSpace(); err != nil {
-		return "", fmt.Errorf("disk space check failed: %w", err)
+		return "", "", fmt.Errorf("disk space check failed: %w", err)
 	}
 
 	ext := ".mp3"
-	switch strings.ToLower(format) {
+	switch strings.ToLower(audioFile.Format) {
 	case "wav":
 		ext = ".wav"
 	case "flac":
@@ -503,7 +736,7 @@ func (p *Processor) downloadFileForProcessing(ctx context.Context, fileID, forma
 
 	tempFile, err := os.CreateTemp("/tmp/levelmix", "levelmix_input_*"+ext)
 	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
+		return "", "", fmt.Errorf("failed to create temp file: %w", err)
 	}
 	tempFileName := tempFile.Name()
 	tempFile.Close()
@@ -512,8 +745,7 @@ func (p *Processor) downloadFileForProcessing(ctx context.Context, fileID, forma
 	downloadCtx, cancel := context.WithTimeout(ctx, 15*time.Minute)
 	defer cancel()
 
-	// Get the correct key with extension
-	uploadKey := p.audioStorage.GetUploadKey(fileID, format)
+	uploadKey := storage.OriginalKey(p.audioStorage, audioFile)
 
 	// Try optimized multipart download with timeout
 	err = p.audioStorage.DownloadToFile(downloadCtx, uploadKey, tempFileName)
@@ -523,7 +755,13 @@ func (p *Processor) downloadFileForProcessing(ctx context.Context, fileID, forma
 			if debugMode {
 				log.Printf("[DEBUG] Downloaded %s (%.2f MB)", uploadKey, float64(info.Size())/(1024*1024))
 			}
-			return tempFileName, nil
+			// Parts arrive out of order, so the file is hashed once complete
+			hash, err := downloadHash(audioFile, tempFileName)
+			if err != nil {
+				os.Remove(tempFileName)
+				return "", "", err
+			}
+			return tempFileName, hash, nil
 		}
 	}
 	// Only log fallback in debug mode
@@ -535,32 +773,78 @@ func (p *Processor) downloadFileForProcessing(ctx context.Context, fileID, forma
 	reader, err := p.audioStorage.Download(downloadCtx, uploadKey)
 	if err != nil {
 		os.Remove(tempFileName)
-		return "", fmt.Errorf("failed to download from S3: %w", err)
+		return "", "", fmt.Errorf("failed to download from S3: %w", err)
 	}
 	defer reader.Close()
 
 	outFile, err := os.Create(tempFileName)
 	if err != nil {
 		os.Remove(tempFileName)
-		return "", fmt.Errorf("failed to create output file: %w", err)
+		return "", "", fmt.Errorf("failed to create output file: %w", err)
 	}
 	defer outFile.Close()
 
-	written, err := io.Copy(outFile, reader)
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(outFile, hash), reader)
 	if err != nil {
 		os.Remove(tempFileName)
-		return "", fmt.Errorf("failed to copy file content: %w", err)
+		return "", "", fmt.Errorf("failed to copy file content: %w", err)
 	}
 
 	if written == 0 {
 		os.Remove(tempFileName)
-		return "", fmt.Errorf("downloaded file is empty")
+		return "", "", fmt.Errorf("downloaded file is empty")
+	}
+
+	if audioFile.ContentHash != "" {
+		return tempFileName, "", nil
+	}
+	return tempFileName, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// downloadHash returns the hex SHA-256 of path, the downloaded original of
+// audioFile, if audioFile has no content hash yet
+func downloadHash(audioFile *storage.AudioFile, path string) (string, error) {
+	if audioFile.ContentHash != "" {
+		return "", nil
 	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open downloaded file: %w", err)
+	}
+	defer f.Close()
 
-	return tempFileName, nil
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to hash downloaded file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-func (p *Processor) uploadProcessedFile(ctx context.Context, fileID, filePath, outputFormat string) error {
+// segmentedSource returns a presigned URL for files long enough to use the
+// segmented pipeline, going by the duration measured when the file was
+// queued; files never measured are downloaded. ffmpeg reads the URL with
+// range requests, so only the chunks currently being worked on are fetched.
+// The URL stays valid for lifetime, which must cover the whole job.
+func (p *Processor) segmentedSource(ctx context.Context, audioFile *storage.AudioFile, lifetime time.Duration) (string, bool) {
+	if audioFile.DurationSeconds == nil || float64(*audioFile.DurationSeconds) < SegmentedMinDuration {
+		return "", false
+	}
+	uploadKey := storage.OriginalKey(p.audioStorage, audioFile)
+
+	// Silence detection, analysis and rendering all read the URL, until the
+	// end of rendering
+	sourceURL, err := p.audioStorage.GetPresignedURL(ctx, uploadKey, lifetime, audioFile.Format)
+	if err != nil {
+		if debugMode {
+			log.Printf("[DEBUG] No presigned source for %s, downloading instead: %v", audioFile.ID, err)
+		}
+		return "", false
+	}
+	return sourceURL, true
+}
+
+func (p *Processor) uploadProcessedFile(ctx context.Context, fileID, jobID, filePath, outputFormat string) error {
 	// Verify file exists and has content before upload
 	info, err := os.Stat(filePath)
 	if err != nil {
@@ -580,7 +864,7 @@ func (p *Processor) uploadProcessedFile(ctx context.Context, fileID, filePath, o
 	uploadCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancel()
 
-	err = p.audioStorage.UploadProcessed(uploadCtx, fileID, file, outputFormat)
+	err = p.audioStorage.UploadProcessed(uploadCtx, fileID, jobID, file, outputFormat)
 	if err != nil {
 		return fmt.Errorf("upload failed: %w", err)
 	}
@@ -589,14 +873,13 @@ func (p *Processor) uploadProcessedFile(ctx context.Context, fileID, filePath, o
 }
 
 func (p *Processor) getOutputOptions(isPremium bool, inputFormat string) OutputOptions {
-	outputFormat := p.determineOutputFormat(isPremium, inputFormat)
+	outputFormat := OutputFormat(isPremium, inputFormat)
 
 	switch strings.ToLower(outputFormat) {
 	case "wav":
 		return OutputOptions{
 			Codec: "pcm_s16le",
 			ExtraOptions: []string{
-				"-ar", "44100",
 				"-ac", "2",
 			},
 		}
@@ -653,26 +936,12 @@ func NewWorker(redisAddr string, processor *Processor, trialHandler TrialReminde
 		maxConcurrency = 2
 	}
 
-	standardPriority := maxConcurrency * 2 / 10
-	if standardPriority == 0 {
-		standardPriority = 1
-	}
-
-	premiumPriority := maxConcurrency * 3 / 10
-	if premiumPriority == 0 {
-		premiumPriority = 1
-	}
-
-	fastPriority := maxConcurrency * 5 / 10
-	if fastPriority < 2 {
-		fastPriority = 2
-	}
-
-	log.Printf("[INFO] Worker initialized (concurrency: %d, queues: fast=%d, premium=%d, standard=%d)",
+	weights := processor.scheduling.QueueWeights
+	log.Printf("[INFO] Worker initialized (concurrency: %d, queue weights: fast=%d, premium=%d, standard=%d)",
 		maxConcurrency,
-		fastPriority,
-		premiumPriority,
-		standardPriority)
+		weights[QueueFast],
+		weights[QueuePremium],
+		weights[QueueStandard])
 
 	srv := asynq.NewServer(
 		asynq.RedisClientOpt{
@@ -684,28 +953,45 @@ func NewWorker(redisAddr string, processor *Processor, trialHandler TrialReminde
 		},
 		asynq.Config{
 			Concurrency: maxConcurrency,
-			Queues: map[string]int{
-				QueueFast:          maxConcurrency * 5 / 10, // 50% for fast processing
-				QueuePremium:       maxConcurrency * 3 / 10, // 30% for premium
-				QueueStandard:      standardPriority,        // 20% for standard
-				QueueNotifications: 1,                       // lowest weight; never competes with audio
+			// Weighted rather than strict priority, so no queue can starve
+			// the others; per-user limits are applied by the processor
+			Queues:         weights,
+			StrictPriority: false,
+
+			// Waiting for a user's job slot is not a failed attempt
+			IsFailure: func(err error) bool {
+				return !errors.Is(err, ErrUserAtCapacity)
 			},
-			StrictPriority: true, // Fast -> Premium -> Standard
 
-			// Only log actual errors, not retries
+			// Log failures that end in the archive (dead-letter queue); the
+			// processor already logged each attempt
 			ErrorHandler: asynq.ErrorHandlerFunc(func(ctx context.Context, task *asynq.Task, err error) {
+				// Waiting for a slot is requeued however often it happens
+				if errors.Is(err, ErrUserAtCapacity) {
+					return
+				}
 				retried, _ := asynq.GetRetryCount(ctx)
 				maxRetry, _ := asynq.GetMaxRetry(ctx)
-
-				// Only log if it's the final failure
-				if retried >= maxRetry {
-					log.Printf("[ERROR] Task permanently failed - Type: %s, Error: %v",
-						task.Type(), err)
+				taskID, _ := asynq.GetTaskID(ctx)
+
+				if errors.Is(err, asynq.SkipRetry) {
+					log.Printf("[ERROR] Task %s archived without retry (%s) - Type: %s, Error: %v",
+						taskID, ClassifyFailure(err), task.Type(), err)
+				} else if retried >= maxRetry {
+					log.Printf("[ERROR] Task %s archived after %d retries (%s) - Type: %s, Error: %v",
+						taskID, retried, ClassifyFailure(err), task.Type(), err)
 				}
 			}),
 
-			// Exponential backoff for retries
+			// Linear backoff for retries; timeouts usually mean the worker
+			// is overloaded, so they wait longer
 			RetryDelayFunc: func(n int, e error, t *asynq.Task) time.Duration {
+				if errors.Is(e, ErrUserAtCapacity) {
+					return userSlotRetryDelay
+				}
+				if ClassifyFailure(e) == FailureTimeout {
+					return time.Duration(n+1) * 2 * time.Minute
+				}
 				return time.Duration(n) * 30 * time.Second
 			},
 		},
@@ -749,7 +1035,3 @@ func StartWorker(srv *asynq.Server, mux *asynq.ServeMux) error {
 
 	return nil
 }
-
-func getMonthStart(t time.Time) time.Time {
-	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
-}
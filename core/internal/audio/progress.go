@@ -0,0 +1,180 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Pipeline stages reported to a ProgressFunc
+const (
+	StageAnalyzing   = "analyzing"
+	StageNormalizing = "normalizing"
+)
+
+// ProgressFunc receives the completed fraction (0-1) of a pipeline stage.
+// It may be called from several goroutines.
+type ProgressFunc func(stage string, fraction float64)
+
+type progressKey struct{}
+
+type progressReporter struct {
+	duration float64 // Input duration in seconds
+	report   ProgressFunc
+}
+
+// WithProgress returns a context whose ffmpeg stages report progress to fn.
+// duration is the input length in seconds that ffmpeg's out_time is measured against.
+func WithProgress(ctx context.Context, duration float64, fn ProgressFunc) context.Context {
+	if fn == nil || duration <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, progressKey{}, &progressReporter{duration: duration, report: fn})
+}
+
+// reportProgress reports a stage fraction to the reporter in ctx, if any
+func reportProgress(ctx context.Context, stage string, fraction float64) {
+	if r, ok := ctx.Value(progressKey{}).(*progressReporter); ok {
+		r.report(stage, clampFraction(fraction))
+	}
+}
+
+// progressDuration returns the input duration known to the reporter in ctx
+func progressDuration(ctx context.Context) float64 {
+	if r, ok := ctx.Value(progressKey{}).(*progressReporter); ok {
+		return r.duration
+	}
+	return 0
+}
+
+// attachProgress makes cmd emit machine-readable progress on stdout and reports
+// out_time against expected seconds of output. It does nothing if ctx has no
+// reporter or stdout is already in use.
+func attachProgress(ctx context.Context, cmd *exec.Cmd, stage string, expected float64) {
+	if _, ok := ctx.Value(progressKey{}).(*progressReporter); !ok || expected <= 0 || cmd.Stdout != nil {
+		return
+	}
+
+	// Global options go straight after the program name
+	args := append([]string{cmd.Args[0], "-progress", "pipe:1", "-nostats"}, cmd.Args[1:]...)
+	cmd.Args = args
+	cmd.Stdout = &progressWriter{
+		report: func(seconds float64) {
+			reportProgress(ctx, stage, seconds/expected)
+		},
+	}
+}
+
+// progressWriter parses ffmpeg "-progress" key=value output
+type progressWriter struct {
+	mu      sync.Mutex
+	pending []byte
+	report  func(seconds float64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(w.pending[:i]))
+		w.pending = w.pending[i+1:]
+
+		if seconds, ok := parseProgressLine(line); ok {
+			w.report(seconds)
+		}
+	}
+	return len(p), nil
+}
+
+// parseProgressLine returns the output position in seconds from an out_time_us
+// or out_time_ms line. Despite its name, out_time_ms is also in microseconds.
+func parseProgressLine(line string) (float64, bool) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok || (key != "out_time_us" && key != "out_time_ms") {
+		return 0, false
+	}
+	us, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || us < 0 {
+		return 0, false
+	}
+	return float64(us) / 1e6, true
+}
+
+func clampFraction(f float64) float64 {
+	if f < 0 {
+		return 0
+	}
+	if f > 1 {
+		return 1
+	}
+	return f
+}
+
+// ProgressEvent is published on ProgressChannel for every progress update. Seq
+// increases with each update and is stored in the progress hash, so stream
+// clients can tell which updates they have already seen.
+type ProgressEvent struct {
+	Seq        int64  `json:"seq"`
+	Status     string `json:"status"`
+	Progress   int    `json:"progress"`
+	ETASeconds int    `json:"etaSeconds,omitempty"`
+}
+
+// ProgressKey is the Redis hash holding a file's latest progress
+func ProgressKey(fileID string) string {
+	return fmt.Sprintf("progress:%s", fileID)
+}
+
+// ProgressChannel is the Redis pub/sub channel a file's progress updates are published on
+func ProgressChannel(fileID string) string {
+	return fmt.Sprintf("progress-events:%s", fileID)
+}
+
+// PublishProgress writes a progress update to the file's progress hash and
+// publishes it to stream subscribers. The sequence number and state are
+// written atomically, so a snapshot never pairs a new seq with old state.
+func PublishProgress(ctx context.Context, rdb *redis.Client, fileID string, progress int, status string, eta time.Duration) error {
+	key := ProgressKey(fileID)
+	etaSeconds := int(eta.Round(time.Second).Seconds())
+
+	var seq *redis.IntCmd
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		seq = pipe.HIncrBy(ctx, key, "seq", 1)
+		pipe.HSet(ctx, key, map[string]interface{}{
+			"progress":    progress,
+			"status":      status,
+			"eta_seconds": etaSeconds,
+			"updated_at":  time.Now().Unix(),
+		})
+		pipe.Expire(ctx, key, 30*time.Minute)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(ProgressEvent{
+		Seq:        seq.Val(),
+		Status:     status,
+		Progress:   progress,
+		ETASeconds: etaSeconds,
+	})
+	if err != nil {
+		return err
+	}
+	return rdb.Publish(ctx, ProgressChannel(fileID), payload).Err()
+}
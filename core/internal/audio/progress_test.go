@@ -0,0 +1,35 @@
+package audio
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProgressWriter(t *testing.T) {
+	var reported []float64
+	ctx := WithProgress(context.Background(), 100, func(stage string, fraction float64) {
+		if stage != StageNormalizing {
+			t.Errorf("Expected stage %s, got %s", StageNormalizing, stage)
+		}
+		reported = append(reported, fraction)
+	})
+
+	w := &progressWriter{report: func(seconds float64) {
+		reportProgress(ctx, StageNormalizing, seconds/50)
+	}}
+
+	// Lines may be split across writes
+	w.Write([]byte("frame=0\nout_time_us=25000000\nout_time=00:00:25.000000\nprogress=contin"))
+	w.Write([]byte("ue\nout_time_ms=75000000\nout_time_us=N/A\n"))
+
+	if len(reported) != 2 {
+		t.Fatalf("Expected 2 reports, got %v", reported)
+	}
+	if reported[0] != 0.5 {
+		t.Errorf("Expected 0.5, got %f", reported[0])
+	}
+	// Clamped past the expected output duration
+	if reported[1] != 1 {
+		t.Errorf("Expected 1, got %f", reported[1])
+	}
+}
@@ -4,6 +4,10 @@ package audio
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
 	"os"
 	"time"
 
@@ -18,49 +22,291 @@ func NewQueue(redisAddr string) *asynq.Client {
 }
 
 type QueueManager struct {
-	client *asynq.Client
+	client    *asynq.Client
+	inspector *asynq.Inspector
 }
 
 func NewQueueManager(redisAddr string) *QueueManager {
+	opt := asynq.RedisClientOpt{
+		Addr:     redisAddr,
+		Password: os.Getenv("REDIS_PASSWORD"),
+	}
 	return &QueueManager{
-		client: asynq.NewClient(asynq.RedisClientOpt{
-			Addr:     redisAddr,
-			Password: os.Getenv("REDIS_PASSWORD"),
-		}),
+		client:    asynq.NewClient(opt),
+		inspector: asynq.NewInspector(opt),
 	}
 }
 
 // Shutdown gracefully closes the queue connection
 func (qm *QueueManager) Shutdown() error {
+	if err := qm.inspector.Close(); err != nil {
+		log.Printf("[WARN] Failed to close queue inspector: %v", err)
+	}
 	return qm.client.Close()
 }
 
+// taskQueue picks the queue for a task based on its schedule, processing mode
+// and user tier
+func taskQueue(task ProcessTask) string {
+	if task.OffPeak || task.ProcessAfter != nil {
+		return QueueOffPeak // Not urgent; never competes with interactive jobs
+	}
+	if task.ProcessingMode == ModeFast || task.FastMode {
+		return QueueFast // Fast processing gets its own queue
+	}
+	if task.IsPremium {
+		return QueuePremium
+	}
+	return QueueStandard
+}
+
+// Time budgets of a job. Rendering gets time per segment of audio, so long
+// files aren't cut off partway; a job adds time to hash and download the
+// original and upload the output. Both are capped so a stuck task still ends.
+const (
+	renderBaseTimeout    = 5 * time.Minute
+	renderSegmentTimeout = 2 * time.Minute // Per segmentLength of audio in precise mode; fast mode takes half
+	maxRenderTimeout     = 6 * time.Hour
+	transferTimeout      = 40 * time.Minute
+	maxJobTimeout        = maxRenderTimeout + transferTimeout
+
+	// asynq's own deadline is later, so the job fails itself with a timeout
+	taskTimeoutGrace = 5 * time.Minute
+)
+
+// renderTimeout is how long rendering durationSeconds of audio may take. An
+// unknown duration gets the cap.
+func renderTimeout(durationSeconds float64, mode ProcessingMode) time.Duration {
+	if durationSeconds <= 0 {
+		return maxRenderTimeout
+	}
+	perSegment := renderSegmentTimeout
+	if mode == ModeFast {
+		perSegment /= 2
+	}
+	segments := time.Duration(math.Ceil(durationSeconds / segmentLength))
+	return min(renderBaseTimeout+segments*perSegment, maxRenderTimeout)
+}
+
+// jobTimeout is how long a whole job on durationSeconds of audio may run
+func jobTimeout(durationSeconds int, mode ProcessingMode) time.Duration {
+	return renderTimeout(float64(durationSeconds), mode) + transferTimeout
+}
+
+// EnqueueProcessing queues a task for its job. The job ID is the asynq task
+// ID, so submitting the same job twice queues it once; the duplicate is not
+// an error.
 func (qm *QueueManager) EnqueueProcessing(ctx context.Context, task ProcessTask) error {
 	payload, err := json.Marshal(task)
 	if err != nil {
 		return err
 	}
 
-	// Determine queue based on processing mode and user tier
-	queueName := QueueStandard
-	if task.ProcessingMode == ModeFast || task.FastMode {
-		queueName = QueueFast // Fast processing gets its own queue
-	} else if task.IsPremium {
-		queueName = QueuePremium
-	}
+	queueName := taskQueue(task)
 
-	// Fast mode gets shorter timeout
-	timeout := 30 * time.Minute
-	if task.ProcessingMode == ModeFast || task.FastMode {
-		timeout = 10 * time.Minute
+	// Long files get longer, fast mode shorter
+	mode := task.ProcessingMode
+	if task.FastMode {
+		mode = ModeFast
 	}
+	timeout := jobTimeout(task.DurationSeconds, mode) + taskTimeoutGrace
 
-	t := asynq.NewTask(TypeAudioProcess, payload)
-	_, err = qm.client.EnqueueContext(ctx, t,
+	opts := []asynq.Option{
+		asynq.TaskID(task.JobID),
 		asynq.Queue(queueName),
 		asynq.Timeout(timeout),
-		asynq.Retention(24*time.Hour),
+		asynq.Retention(24 * time.Hour),
 		asynq.MaxRetry(3),
-	)
+	}
+	if task.ProcessAfter != nil {
+		opts = append(opts, asynq.ProcessAt(*task.ProcessAfter))
+	}
+
+	t := asynq.NewTask(TypeAudioProcess, payload)
+	_, err = qm.client.EnqueueContext(ctx, t, opts...)
+	if errors.Is(err, asynq.ErrTaskIDConflict) {
+		log.Printf("[INFO] Job %s is already queued, ignoring duplicate submission", task.JobID)
+		return nil
+	}
 	return err
 }
+
+// RequeueProcessing queues a job whose earlier task has finished, e.g. a
+// failed job retried by the user. The finished task still holds the job ID
+// until asynq's retention expires, so it is removed first.
+func (qm *QueueManager) RequeueProcessing(ctx context.Context, task ProcessTask) error {
+	queueName := taskQueue(task)
+	if err := qm.inspector.DeleteTask(queueName, task.JobID); err != nil &&
+		!errors.Is(err, asynq.ErrTaskNotFound) && !errors.Is(err, asynq.ErrQueueNotFound) {
+		return fmt.Errorf("failed to remove previous task for job %s: %w", task.JobID, err)
+	}
+	return qm.EnqueueProcessing(ctx, task)
+}
+
+// ProcessingQueues are the queues audio processing tasks are routed to
+var ProcessingQueues = []string{QueueFast, QueuePremium, QueueStandard, QueueOffPeak}
+
+// ArchivedTask is a processing task asynq gave up on
+type ArchivedTask struct {
+	Info *asynq.TaskInfo
+	Task ProcessTask // Decoded payload; zero if it could not be decoded
+}
+
+// ArchivedTasks lists up to limit archived tasks per processing queue
+func (qm *QueueManager) ArchivedTasks(limit int) ([]ArchivedTask, error) {
+	var archived []ArchivedTask
+	for _, queue := range ProcessingQueues {
+		infos, err := qm.inspector.ListArchivedTasks(queue, asynq.PageSize(limit))
+		if errors.Is(err, asynq.ErrQueueNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list archived tasks in %s: %w", queue, err)
+		}
+
+		for _, info := range infos {
+			entry := ArchivedTask{Info: info}
+			if info.Type == TypeAudioProcess {
+				_ = json.Unmarshal(info.Payload, &entry.Task)
+			}
+			archived = append(archived, entry)
+		}
+	}
+	return archived, nil
+}
+
+// RunArchivedTask moves an archived task back to pending
+func (qm *QueueManager) RunArchivedTask(queue, taskID string) error {
+	return qm.inspector.RunTask(queue, taskID)
+}
+
+// QueueEstimate is where a job's task stands in its queue. The wait is a
+// range, going by how fast the queue has moved today and over the last two
+// days; both ends are zero when it has no recent throughput.
+type QueueEstimate struct {
+	Queue    string
+	Position int // 1 for the next task to run, 0 once it is running
+	WaitMin  time.Duration
+	WaitMax  time.Duration
+}
+
+// Pending tasks are scanned page by page when looking for a job's position,
+// up to a limit so a very long queue can't make status polls expensive
+const (
+	queuePositionPageSize  = 100
+	queuePositionScanLimit = 1000
+)
+
+// QueuePosition finds the task for a job in the processing queues and
+// estimates how long it will wait from the recent throughput of that queue
+// alone. It returns nil if the task has already finished or isn't queued.
+func (qm *QueueManager) QueuePosition(jobID string) (*QueueEstimate, error) {
+	for _, queue := range ProcessingQueues {
+		info, err := qm.inspector.GetTaskInfo(queue, jobID)
+		if errors.Is(err, asynq.ErrTaskNotFound) || errors.Is(err, asynq.ErrQueueNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up task %s in %s: %w", jobID, queue, err)
+		}
+
+		estimate := &QueueEstimate{Queue: queue}
+		switch info.State {
+		case asynq.TaskStateActive:
+			return estimate, nil
+		case asynq.TaskStatePending:
+			position, err := qm.pendingPosition(queue, jobID)
+			if err != nil {
+				return nil, err
+			}
+			estimate.Position = position
+		case asynq.TaskStateScheduled, asynq.TaskStateRetry:
+			// Joins the back of the queue once it becomes due
+			qinfo, err := qm.inspector.GetQueueInfo(queue)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get queue info for %s: %w", queue, err)
+			}
+			estimate.Position = qinfo.Pending + 1
+		default:
+			return nil, nil
+		}
+
+		estimate.WaitMin, estimate.WaitMax = waitRange(estimate.Position, qm.throughput(queue))
+		if due := time.Until(info.NextProcessAt); due > 0 {
+			estimate.WaitMin = max(estimate.WaitMin, due)
+			estimate.WaitMax = max(estimate.WaitMax, due)
+		}
+		return estimate, nil
+	}
+	return nil, nil
+}
+
+// pendingPosition returns the 1-based position of a pending task, or the
+// scan limit if it is further back than that
+func (qm *QueueManager) pendingPosition(queue, taskID string) (int, error) {
+	for page := 1; (page-1)*queuePositionPageSize < queuePositionScanLimit; page++ {
+		infos, err := qm.inspector.ListPendingTasks(queue, asynq.Page(page), asynq.PageSize(queuePositionPageSize))
+		if err != nil {
+			return 0, fmt.Errorf("failed to list pending tasks in %s: %w", queue, err)
+		}
+		for i, info := range infos {
+			if info.ID == taskID {
+				return (page-1)*queuePositionPageSize + i + 1, nil
+			}
+		}
+		if len(infos) < queuePositionPageSize {
+			return 1, nil // Dequeued while we were looking
+		}
+	}
+	return queuePositionScanLimit, nil
+}
+
+// throughput is the queue's processed tasks per second today so far and
+// over today and yesterday (asynq keeps daily counts per queue). A rate is 0
+// if nothing was processed in its window.
+func (qm *QueueManager) throughput(queue string) []float64 {
+	stats, err := qm.inspector.History(queue, 2)
+	if err != nil {
+		log.Printf("[WARN] Failed to get queue history for %s: %v", queue, err)
+		return nil
+	}
+
+	now := time.Now().UTC()
+	window := now.Sub(now.Truncate(24 * time.Hour))
+	var rates []float64
+	processed := 0
+	for i, day := range stats {
+		processed += day.Processed
+		if i > 0 {
+			window += 24 * time.Hour
+		}
+		if window > 0 {
+			rates = append(rates, float64(processed)/window.Seconds())
+		}
+	}
+	return rates
+}
+
+// waitRange is how long the task at position waits at the fastest and
+// slowest of the given rates in tasks per second, ignoring zero rates
+func waitRange(position int, rates []float64) (time.Duration, time.Duration) {
+	var fastest, slowest float64
+	for _, rate := range rates {
+		if rate <= 0 {
+			continue
+		}
+		if fastest == 0 || rate > fastest {
+			fastest = rate
+		}
+		if slowest == 0 || rate < slowest {
+			slowest = rate
+		}
+	}
+	if fastest == 0 {
+		return 0, 0
+	}
+	wait := func(rate float64) time.Duration {
+		return time.Duration(float64(position) / rate * float64(time.Second))
+	}
+	return wait(fastest), wait(slowest)
+}
@@ -0,0 +1,52 @@
+package audio
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderTimeoutScalesWithDuration(t *testing.T) {
+	short := renderTimeout(4*60, ModePrecise)
+	if short != renderBaseTimeout+renderSegmentTimeout {
+		t.Errorf("4 minutes: expected one segment's budget, got %v", short)
+	}
+	if fast := renderTimeout(4*60, ModeFast); fast >= short {
+		t.Errorf("fast mode should get less time than precise, got %v and %v", fast, short)
+	}
+
+	// Eight hours is 96 segments, more than the old fixed 20 minutes by far
+	long := renderTimeout(8*60*60, ModePrecise)
+	if expected := renderBaseTimeout + 96*renderSegmentTimeout; long != expected {
+		t.Errorf("8 hours: expected %v, got %v", expected, long)
+	}
+
+	if got := renderTimeout(100*60*60, ModePrecise); got != maxRenderTimeout {
+		t.Errorf("100 hours: expected the cap %v, got %v", maxRenderTimeout, got)
+	}
+	if got := renderTimeout(0, ModeFast); got != maxRenderTimeout {
+		t.Errorf("unknown duration: expected the cap %v, got %v", maxRenderTimeout, got)
+	}
+
+	if got := jobTimeout(8*60*60, ModePrecise); got != long+transferTimeout {
+		t.Errorf("job timeout should add transfer time to the render budget, got %v", got)
+	}
+	if userSlotLease <= maxJobTimeout {
+		t.Errorf("slot lease %v should outlast the longest job %v", userSlotLease, maxJobTimeout)
+	}
+}
+
+func TestWaitRange(t *testing.T) {
+	// Ten tasks ahead, at one a minute today and one every two minutes
+	// over two days
+	low, high := waitRange(10, []float64{1.0 / 60, 1.0 / 120})
+	if low != 10*time.Minute || high != 20*time.Minute {
+		t.Errorf("expected 10m to 20m, got %v to %v", low, high)
+	}
+
+	if low, high := waitRange(10, []float64{0, 1.0 / 60}); low != 10*time.Minute || high != 10*time.Minute {
+		t.Errorf("a window with nothing processed should be ignored, got %v to %v", low, high)
+	}
+	if low, high := waitRange(10, nil); low != 0 || high != 0 {
+		t.Errorf("no throughput: expected no estimate, got %v to %v", low, high)
+	}
+}
@@ -0,0 +1,456 @@
+package audio
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// Content-aware sampling settings for fast mode
+const (
+	// MaxSampleConfidenceLU is the widest acceptable 95% confidence half-width
+	// (in LU) of a sampled estimate before falling back to full analysis
+	MaxSampleConfidenceLU = 1.0
+
+	scanSampleRate   = 8000 // Decimated mono rate for the energy scan
+	scanBlockSeconds = 3.0  // Block length, matches EBU R128 short-term loudness
+	scanAbsoluteGate = -70.0
+	scanRelativeGate = -10.0 // Integrated loudness relative gate (LU)
+	scanLRAGate      = -20.0 // Loudness range relative gate (LU)
+)
+
+// ErrSamplingInsufficient means a sampled estimate would not be reliable enough
+// (file too short, samples covering most of it, or confidence interval too wide)
+// and the whole file should be measured instead
+var ErrSamplingInsufficient = errors.New("sampled estimate not reliable enough")
+
+// energyScan holds per-block levels from a cheap low-rate decode
+type energyScan struct {
+	BlockLevels []float64 // dBFS mean-square level per scanBlockSeconds block
+	Duration    float64
+}
+
+// stratifiedWindow is a sample window and the seconds of audio its stratum represents
+type stratifiedWindow struct {
+	segment
+	Weight float64
+}
+
+// estimateLoudnessFromSamples estimates loudness from windows chosen by a
+// stratified scan of the file's energy distribution. The scan level is
+// calibrated against loudnorm measurements of the windows (a ratio estimator),
+// and the spread of the calibration offsets gives the confidence interval.
+func estimateLoudnessFromSamples(ctx context.Context, inputFile string) (*LoudnessInfo, error) {
+	// Check disk space before processing
+	if err := checkDiskSpace(); err != nil {
+		return nil, fmt.Errorf("insufficient disk space: %w", err)
+	}
+
+	duration, err := getDuration(ctx, inputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Adaptive sampling strategy based on duration
+	var sampleLength float64
+	var strata int
+
+	switch {
+	case duration <= 60: // Short files (<1 min)
+		return nil, fmt.Errorf("%w: file is only %.0fs", ErrSamplingInsufficient, duration)
+	case duration <= 180: // Medium files (1-3 min)
+		sampleLength, strata = 20.0, 3
+	case duration <= 600: // Long files (3-10 min)
+		sampleLength, strata = 30.0, 5
+	case duration <= 3600: // Very long files (10-60 min)
+		sampleLength, strata = 30.0, 7
+	default: // Multi-hour mixes
+		sampleLength, strata = 30.0, 9
+	}
+
+	// If we're sampling more than 60% of the file, just analyze the whole thing
+	coveragePercent := (sampleLength * float64(strata) / duration) * 100
+	if coveragePercent > 60 {
+		return nil, fmt.Errorf("%w: samples would cover %.0f%% of the file", ErrSamplingInsufficient, coveragePercent)
+	}
+
+	scan, err := scanEnergy(ctx, inputFile, duration)
+	if err != nil {
+		return nil, fmt.Errorf("energy scan failed: %w", err)
+	}
+
+	windows := chooseStratifiedWindows(scan, strata, sampleLength)
+	if len(windows) < 2 {
+		return nil, fmt.Errorf("%w: not enough non-silent audio to sample", ErrSamplingInsufficient)
+	}
+
+	if debugMode {
+		log.Printf("[DEBUG] Content-aware analysis: %d stratified samples from %.1fs file (%.1f%% coverage)",
+			len(windows), duration, coveragePercent)
+	}
+
+	segments := make([]segment, len(windows))
+	for i, w := range windows {
+		segments[i] = w.segment
+	}
+
+	results, err := performMultiSampleAnalysis(ctx, inputFile, segments)
+	if err != nil {
+		return nil, err
+	}
+
+	return estimateStratifiedLoudness(scan, windows, results)
+}
+
+// estimateStratifiedLoudness combines the window measurements with the scan.
+// Each window's offset between its loudnorm loudness and its scan level is
+// weighted by its stratum; the file's gated scan level plus the mean offset is
+// the estimate, and the offsets' weighted standard error bounds its accuracy.
+func estimateStratifiedLoudness(scan *energyScan, windows []stratifiedWindow, results []*LoudnessInfo) (*LoudnessInfo, error) {
+	fileLevel, gate := scan.gatedLevel()
+
+	var offsets, weights []float64
+	var measurements []loudnessMeasurement
+	for i, info := range results {
+		if info == nil {
+			continue
+		}
+		windowLevel, ok := scan.levelBetween(windows[i].Start, windows[i].End, gate)
+		if !ok {
+			continue
+		}
+		offsets = append(offsets, info.InputI-windowLevel)
+		weights = append(weights, windows[i].Weight)
+		measurements = append(measurements, loudnessMeasurement{info: info, weight: windows[i].Weight})
+	}
+
+	if len(offsets) < 2 {
+		return nil, fmt.Errorf("%w: only %d usable samples", ErrSamplingInsufficient, len(offsets))
+	}
+
+	meanOffset, halfWidth := weightedMeanCI95(offsets, weights)
+	if halfWidth > MaxSampleConfidenceLU {
+		return nil, fmt.Errorf("%w: ±%.2f LU exceeds ±%.1f LU", ErrSamplingInsufficient, halfWidth, MaxSampleConfidenceLU)
+	}
+
+	// Peaks come from the measured windows; the loudest strata are always sampled
+	merged, err := mergeLoudnessMeasurements(measurements)
+	if err != nil {
+		return nil, err
+	}
+
+	integrated := fileLevel + meanOffset
+	info := &LoudnessInfo{
+		InputI:       integrated,
+		InputTP:      merged.InputTP,
+		InputLRA:     scan.loudnessRange(),
+		InputThresh:  integrated - 10,
+		Confidence95: halfWidth,
+	}
+
+	log.Printf("[INFO] Content-aware analysis: %.1f LUFS ±%.2f LU (95%%), LRA: %.1f, Peak: %.1f dB (%d samples)",
+		info.InputI, info.Confidence95, info.InputLRA, info.InputTP, len(offsets))
+
+	return info, nil
+}
+
+// scanEnergy decodes the file as low-rate mono PCM and computes the mean-square
+// level of each block. This is far cheaper than loudnorm, which upsamples for
+// true peak and runs the full EBU R128 meter.
+func scanEnergy(ctx context.Context, inputFile string, duration float64) (*energyScan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	if err := acquireFFmpegSlot(ctx, "energy scan"); err != nil {
+		return nil, err
+	}
+	defer releaseFFmpegSlot()
+
+	cmd := commandContext(ctx,
+		"ffmpeg",
+		"-v", "error",
+		"-i", inputFile,
+		"-vn",
+		"-ac", "1",
+		"-ar", fmt.Sprintf("%d", scanSampleRate),
+		"-f", "s16le", "-")
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	// The scan is the first half of the fast analysis stage
+	var reader io.Reader = stdout
+	if total := duration * scanSampleRate * 2; total > 0 {
+		reader = &countingReader{r: stdout, onRead: func(n int64) {
+			reportProgress(ctx, StageAnalyzing, 0.5*float64(n)/total)
+		}}
+	}
+
+	levels, readErr := readBlockLevels(bufio.NewReaderSize(reader, 64*1024), int(scanBlockSeconds*scanSampleRate))
+	waitErr := cmd.Wait()
+
+	if err := contextError(ctx, "energy scan"); err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	if waitErr != nil {
+		return nil, waitErr
+	}
+
+	return &energyScan{BlockLevels: levels, Duration: duration}, nil
+}
+
+// countingReader reports the running byte count every 1MB read
+type countingReader struct {
+	r       io.Reader
+	n, last int64
+	onRead  func(n int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.n-c.last >= 1<<20 {
+		c.last = c.n
+		c.onRead(c.n)
+	}
+	return n, err
+}
+
+// readBlockLevels reads 16-bit mono PCM and returns the dBFS level of each full block
+func readBlockLevels(r io.Reader, blockSamples int) ([]float64, error) {
+	var levels []float64
+	var sum float64
+	var n int
+
+	buf := make([]byte, 2*4096)
+	for {
+		read, err := io.ReadFull(r, buf)
+		for i := 0; i+1 < read; i += 2 {
+			v := float64(int16(binary.LittleEndian.Uint16(buf[i:]))) / 32768.0
+			sum += v * v
+			n++
+			if n == blockSamples {
+				levels = append(levels, meanSquareToDB(sum/float64(n)))
+				sum, n = 0, 0
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scan output: %w", err)
+		}
+	}
+
+	// Keep a trailing partial block if it is at least half a block
+	if n >= blockSamples/2 {
+		levels = append(levels, meanSquareToDB(sum/float64(n)))
+	}
+	return levels, nil
+}
+
+func meanSquareToDB(ms float64) float64 {
+	if ms <= 0 {
+		return -math.MaxFloat64
+	}
+	return 10 * math.Log10(ms)
+}
+
+// gatedLevel returns the gated mean level of the scan (absolute gate, then a
+// relative gate below the ungated mean, as in EBU R128) and the gate it used
+func (s *energyScan) gatedLevel() (float64, float64) {
+	var sum float64
+	var n int
+	for _, l := range s.BlockLevels {
+		if l > scanAbsoluteGate {
+			sum += math.Pow(10, l/10)
+			n++
+		}
+	}
+	if n == 0 {
+		return scanAbsoluteGate, scanAbsoluteGate
+	}
+
+	gate := 10*math.Log10(sum/float64(n)) + scanRelativeGate
+	level, _ := s.levelOfBlocks(0, len(s.BlockLevels), gate)
+	return level, gate
+}
+
+// levelBetween returns the gated level of the blocks covering [start, end)
+func (s *energyScan) levelBetween(start, end, gate float64) (float64, bool) {
+	first := int(start / scanBlockSeconds)
+	last := int(math.Ceil(end / scanBlockSeconds))
+	return s.levelOfBlocks(first, last, gate)
+}
+
+func (s *energyScan) levelOfBlocks(first, last int, gate float64) (float64, bool) {
+	if first < 0 {
+		first = 0
+	}
+	if last > len(s.BlockLevels) {
+		last = len(s.BlockLevels)
+	}
+
+	var sum float64
+	var n int
+	for _, l := range s.BlockLevels[first:last] {
+		if l > gate {
+			sum += math.Pow(10, l/10)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return 10 * math.Log10(sum/float64(n)), true
+}
+
+// loudnessRange returns the 10th-95th percentile spread of block levels above
+// the LRA relative gate. Blocks match short-term loudness windows, and the
+// missing K-weighting is a near-constant offset that cancels in the spread.
+func (s *energyScan) loudnessRange() float64 {
+	var sum float64
+	var n int
+	for _, l := range s.BlockLevels {
+		if l > scanAbsoluteGate {
+			sum += math.Pow(10, l/10)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+
+	gate := 10*math.Log10(sum/float64(n)) + scanLRAGate
+	var gated []float64
+	for _, l := range s.BlockLevels {
+		if l > gate {
+			gated = append(gated, l)
+		}
+	}
+	sort.Float64s(gated)
+	return percentile(gated, 0.95) - percentile(gated, 0.10)
+}
+
+// chooseStratifiedWindows splits non-silent blocks into equal-count loudness
+// strata and picks one window per stratum around its median-level block,
+// avoiding overlap with windows already chosen. Each window is weighted by the
+// audio its stratum represents.
+func chooseStratifiedWindows(scan *energyScan, strata int, windowLength float64) []stratifiedWindow {
+	type block struct {
+		index int
+		level float64
+	}
+
+	var blocks []block
+	for i, l := range scan.BlockLevels {
+		if l > scanAbsoluteGate {
+			blocks = append(blocks, block{index: i, level: l})
+		}
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+	if strata > len(blocks) {
+		strata = len(blocks)
+	}
+
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].level < blocks[j].level })
+
+	var windows []stratifiedWindow
+	overlaps := func(start, end float64) bool {
+		for _, w := range windows {
+			if start < w.End && w.Start < end {
+				return true
+			}
+		}
+		return false
+	}
+
+	for k := 0; k < strata; k++ {
+		lo := k * len(blocks) / strata
+		hi := (k + 1) * len(blocks) / strata
+		stratum := append([]block{}, blocks[lo:hi]...)
+		median := stratum[len(stratum)/2].level
+
+		// Closest to the stratum median first
+		sort.SliceStable(stratum, func(i, j int) bool {
+			return math.Abs(stratum[i].level-median) < math.Abs(stratum[j].level-median)
+		})
+
+		var chosen *segment
+		for _, b := range stratum {
+			center := (float64(b.index) + 0.5) * scanBlockSeconds
+			start := math.Max(0, math.Min(center-windowLength/2, scan.Duration-windowLength))
+			end := math.Min(start+windowLength, scan.Duration)
+			if !overlaps(start, end) {
+				chosen = &segment{Start: start, End: end}
+				break
+			}
+		}
+		if chosen == nil {
+			continue
+		}
+
+		chosen.Index = len(windows)
+		windows = append(windows, stratifiedWindow{
+			segment: *chosen,
+			Weight:  float64(len(stratum)) * scanBlockSeconds,
+		})
+	}
+
+	return windows
+}
+
+// weightedMeanCI95 returns the weighted mean of values and the half-width of
+// its 95% confidence interval, using Student's t for the small sample count
+func weightedMeanCI95(values, weights []float64) (float64, float64) {
+	var totalWeight float64
+	for _, w := range weights {
+		totalWeight += w
+	}
+
+	var mean float64
+	for i, v := range values {
+		mean += v * weights[i] / totalWeight
+	}
+
+	n := float64(len(values))
+	var variance, sumSquaredWeights float64
+	for i, v := range values {
+		w := weights[i] / totalWeight
+		variance += w * (v - mean) * (v - mean)
+		sumSquaredWeights += w * w
+	}
+	variance *= n / (n - 1)
+
+	standardError := math.Sqrt(variance * sumSquaredWeights)
+	return mean, tCritical95(len(values)-1) * standardError
+}
+
+// tCritical95 returns the two-sided 95% Student's t critical value
+func tCritical95(df int) float64 {
+	table := []float64{12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228}
+	if df < 1 {
+		return math.Inf(1)
+	}
+	if df <= len(table) {
+		return table[df-1]
+	}
+	return 1.96
+}
@@ -0,0 +1,106 @@
+package audio
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestChooseStratifiedWindows(t *testing.T) {
+	// 10 minutes of 3s blocks: quiet intro, loud body, silent tail
+	scan := &energyScan{Duration: 600}
+	for i := 0; i < 200; i++ {
+		switch {
+		case i < 40:
+			scan.BlockLevels = append(scan.BlockLevels, -30)
+		case i < 190:
+			scan.BlockLevels = append(scan.BlockLevels, -12+float64(i%5))
+		default:
+			scan.BlockLevels = append(scan.BlockLevels, -90)
+		}
+	}
+
+	windows := chooseStratifiedWindows(scan, 5, 30)
+	if len(windows) != 5 {
+		t.Fatalf("Expected 5 windows, got %d", len(windows))
+	}
+
+	var totalWeight float64
+	var sampledQuiet bool
+	for i, w := range windows {
+		totalWeight += w.Weight
+		if w.Start < 0 || w.End > scan.Duration {
+			t.Errorf("Window %d [%f, %f] outside file", i, w.Start, w.End)
+		}
+		if w.Start >= 570 {
+			t.Errorf("Window %d at %f sampled the silent tail", i, w.Start)
+		}
+		if w.End <= 120 {
+			sampledQuiet = true
+		}
+		for j := 0; j < i; j++ {
+			if w.Start < windows[j].End && windows[j].Start < w.End {
+				t.Errorf("Windows %d and %d overlap", j, i)
+			}
+		}
+	}
+
+	// The quiet stratum must be represented
+	if !sampledQuiet {
+		t.Error("Expected a window in the quiet intro")
+	}
+
+	// Weights cover all non-silent audio
+	if math.Abs(totalWeight-190*scanBlockSeconds) > 0.01 {
+		t.Errorf("Expected total weight %f, got %f", 190*scanBlockSeconds, totalWeight)
+	}
+}
+
+func TestWeightedMeanCI95(t *testing.T) {
+	mean, halfWidth := weightedMeanCI95([]float64{1, 1, 1}, []float64{1, 2, 3})
+	if mean != 1 || halfWidth != 0 {
+		t.Errorf("Expected mean 1 ±0, got %f ±%f", mean, halfWidth)
+	}
+
+	_, narrow := weightedMeanCI95([]float64{0.9, 1.0, 1.1, 1.0, 0.95}, []float64{1, 1, 1, 1, 1})
+	_, wide := weightedMeanCI95([]float64{-2, 3, 0, 4, -3}, []float64{1, 1, 1, 1, 1})
+	if narrow > MaxSampleConfidenceLU {
+		t.Errorf("Expected consistent offsets within ±%.1f LU, got ±%f", MaxSampleConfidenceLU, narrow)
+	}
+	if wide <= MaxSampleConfidenceLU {
+		t.Errorf("Expected scattered offsets wider than ±%.1f LU, got ±%f", MaxSampleConfidenceLU, wide)
+	}
+}
+
+func TestEstimateStratifiedLoudnessLowConfidence(t *testing.T) {
+	scan := &energyScan{Duration: 90, BlockLevels: make([]float64, 30)}
+	for i := range scan.BlockLevels {
+		scan.BlockLevels[i] = -15
+	}
+	windows := []stratifiedWindow{
+		{segment: segment{Index: 0, Start: 0, End: 30}, Weight: 30},
+		{segment: segment{Index: 1, Start: 30, End: 60}, Weight: 30},
+		{segment: segment{Index: 2, Start: 60, End: 90}, Weight: 30},
+	}
+
+	// Windows with identical scan levels but very different loudness
+	results := []*LoudnessInfo{{InputI: -20}, {InputI: -8}, {InputI: -14}}
+	if _, err := estimateStratifiedLoudness(scan, windows, results); !errors.Is(err, ErrSamplingInsufficient) {
+		t.Errorf("Expected ErrSamplingInsufficient, got %v", err)
+	}
+
+	results = []*LoudnessInfo{{InputI: -12, InputTP: -2}, {InputI: -12.1, InputTP: -1}, {InputI: -11.9, InputTP: -3}}
+	info, err := estimateStratifiedLoudness(scan, windows, results)
+	if err != nil {
+		t.Fatalf("Expected estimate, got %v", err)
+	}
+	if math.Abs(info.InputI-(-12)) > 0.1 {
+		t.Errorf("Expected InputI -12, got %f", info.InputI)
+	}
+	if info.InputTP != -1 {
+		t.Errorf("Expected InputTP -1, got %f", info.InputTP)
+	}
+	if info.Confidence95 <= 0 || info.Confidence95 > MaxSampleConfidenceLU {
+		t.Errorf("Expected confidence within (0, %.1f], got %f", MaxSampleConfidenceLU, info.Confidence95)
+	}
+}
@@ -0,0 +1,272 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrUserAtCapacity is returned when a user already has as many jobs running
+// as their tier allows. The task goes back on its queue without using up a
+// retry.
+var ErrUserAtCapacity = errors.New("user is at their concurrent job limit")
+
+// SchedulingConfig controls how the worker shares its capacity. Queues are
+// picked in proportion to their weights rather than strictly by priority, and
+// each user can only run a limited number of jobs at once so one large batch
+// can't occupy every worker slot.
+//
+// Off-peak jobs wait for a nightly window (hours in UTC, end exclusive) and
+// count against the monthly allowance at a reduced rate.
+type SchedulingConfig struct {
+	QueueWeights map[string]int
+	UserJobLimit map[int]int // Subscription tier -> concurrent jobs per user
+	OffPeakStart int
+	OffPeakEnd   int
+	OffPeakRate  float64 // Fraction of the audio duration charged
+}
+
+// DefaultSchedulingConfig keeps the old 50/30/20 split between the processing
+// queues, now as weights
+func DefaultSchedulingConfig() SchedulingConfig {
+	return SchedulingConfig{
+		QueueWeights: map[string]int{
+			QueueFast:          5,
+			QueuePremium:       3,
+			QueueStandard:      2,
+			QueueOffPeak:       1,
+			QueueNotifications: 1,
+		},
+		UserJobLimit: map[int]int{
+			1: 1, // Free
+			2: 2, // Premium
+			3: 4, // Professional
+		},
+		OffPeakStart: 1,
+		OffPeakEnd:   7,
+		OffPeakRate:  0.5,
+	}
+}
+
+// LoadSchedulingConfig applies overrides from the environment to the defaults:
+//
+//	QUEUE_WEIGHTS=fast:5,premium:3,standard:2
+//	USER_JOB_LIMITS=1:1,2:2,3:4   (tier:jobs)
+//	OFF_PEAK_HOURS=1-7            (UTC, may wrap midnight, e.g. 22-6)
+//	OFF_PEAK_RATE=0.5
+func LoadSchedulingConfig() SchedulingConfig {
+	cfg := DefaultSchedulingConfig()
+
+	if v := os.Getenv("QUEUE_WEIGHTS"); v != "" {
+		weights, err := parseWeights(v)
+		if err != nil {
+			log.Printf("[WARN] Ignoring QUEUE_WEIGHTS: %v", err)
+		}
+		for queue, weight := range weights {
+			cfg.QueueWeights[queue] = weight
+		}
+	}
+
+	if v := os.Getenv("USER_JOB_LIMITS"); v != "" {
+		limits, err := parseWeights(v)
+		if err != nil {
+			log.Printf("[WARN] Ignoring USER_JOB_LIMITS: %v", err)
+		}
+		for tier, limit := range limits {
+			t, err := strconv.Atoi(tier)
+			if err != nil {
+				log.Printf("[WARN] Ignoring USER_JOB_LIMITS entry for tier %q", tier)
+				continue
+			}
+			cfg.UserJobLimit[t] = limit
+		}
+	}
+
+	if v := os.Getenv("OFF_PEAK_HOURS"); v != "" {
+		start, end, err := parseHourRange(v)
+		if err != nil {
+			log.Printf("[WARN] Ignoring OFF_PEAK_HOURS: %v", err)
+		} else {
+			cfg.OffPeakStart, cfg.OffPeakEnd = start, end
+		}
+	}
+
+	if v := os.Getenv("OFF_PEAK_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			log.Printf("[WARN] Ignoring OFF_PEAK_RATE %q, expected a fraction between 0 and 1", v)
+		} else {
+			cfg.OffPeakRate = rate
+		}
+	}
+
+	return cfg
+}
+
+// parseHourRange parses "start-end" with hours from 0 to 23
+func parseHourRange(s string) (int, int, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %q, expected start-end", s)
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil || start < 0 || start > 23 {
+		return 0, 0, fmt.Errorf("invalid start hour in %q", s)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(to))
+	if err != nil || end < 0 || end > 23 || end == start {
+		return 0, 0, fmt.Errorf("invalid end hour in %q", s)
+	}
+	return start, end, nil
+}
+
+// parseWeights parses "name:n,name:n" into a map of positive integers
+func parseWeights(s string) (map[string]int, error) {
+	parsed := make(map[string]int)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid entry %q, expected name:value", entry)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid value in %q, expected a positive integer", entry)
+		}
+		parsed[strings.TrimSpace(name)] = n
+	}
+	return parsed, nil
+}
+
+// jobLimit returns how many jobs a user of the given tier may run at once
+func (c SchedulingConfig) jobLimit(tier int) int {
+	if limit, ok := c.UserJobLimit[tier]; ok {
+		return limit
+	}
+	return c.UserJobLimit[1]
+}
+
+// InOffPeak reports whether t falls in the off-peak window
+func (c SchedulingConfig) InOffPeak(t time.Time) bool {
+	hour := t.UTC().Hour()
+	if c.OffPeakStart < c.OffPeakEnd {
+		return hour >= c.OffPeakStart && hour < c.OffPeakEnd
+	}
+	return hour >= c.OffPeakStart || hour < c.OffPeakEnd // Wraps midnight
+}
+
+// NextOffPeak returns t if it is off-peak, otherwise the start of the next
+// off-peak window
+func (c SchedulingConfig) NextOffPeak(t time.Time) time.Time {
+	if c.InOffPeak(t) {
+		return t
+	}
+	u := t.UTC()
+	start := time.Date(u.Year(), u.Month(), u.Day(), c.OffPeakStart, 0, 0, 0, time.UTC)
+	if !start.After(u) {
+		start = start.AddDate(0, 0, 1)
+	}
+	return start
+}
+
+// Schedule settles when a task may start. Off-peak tasks are moved into the
+// next off-peak window, and start times that have already passed are dropped
+// so the task runs straight away.
+func (c SchedulingConfig) Schedule(task *ProcessTask, now time.Time) {
+	if task.OffPeak {
+		after := now
+		if task.ProcessAfter != nil && task.ProcessAfter.After(now) {
+			after = *task.ProcessAfter
+		}
+		next := c.NextOffPeak(after)
+		task.ProcessAfter = &next
+	}
+	if task.ProcessAfter != nil && !task.ProcessAfter.After(now) {
+		task.ProcessAfter = nil
+	}
+}
+
+// ChargedSeconds is how much of the monthly processing allowance a job with
+// the given audio duration uses
+func (c SchedulingConfig) ChargedSeconds(seconds int, offPeak bool) int {
+	if !offPeak {
+		return seconds
+	}
+	return int(math.Ceil(float64(seconds) * c.OffPeakRate))
+}
+
+// Slots are leased slightly longer than the job timeout so a crashed worker
+// can't hold one forever
+const userSlotLease = maxJobTimeout + 5*time.Minute
+
+// A job at the user's limit is requeued after userSlotRetryDelay, without
+// counting as a failed attempt. It waits as long as it takes; slots held by
+// crashed workers come free when their lease expires.
+const userSlotRetryDelay = 15 * time.Second
+
+// acquireUserSlotScript takes a slot in the user's set of running jobs if
+// one is free. Members are job IDs scored by lease expiry, so expired leases
+// are dropped first and re-acquiring for the same job only renews it.
+var acquireUserSlotScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZSCORE', KEYS[1], ARGV[4]) or redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[3]) then
+	redis.call('ZADD', KEYS[1], ARGV[2], ARGV[4])
+	redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+func userSlotsKey(userID string) string {
+	return fmt.Sprintf("user-jobs:%s", userID)
+}
+
+// acquireUserSlot reserves one of the user's concurrent job slots for jobID
+// and returns a func that frees it. It returns ErrUserAtCapacity when every
+// slot is taken. Without Redis, or if Redis fails, jobs are not limited.
+func (p *Processor) acquireUserSlot(ctx context.Context, userID, jobID string) (func(), error) {
+	noop := func() {}
+	if p.redisClient == nil || userID == "" {
+		return noop, nil
+	}
+
+	tier := 1
+	if user, err := p.metadataStorage.GetUser(ctx, userID); err == nil {
+		tier = user.SubscriptionTier
+	} else {
+		log.Printf("[WARN] Failed to look up user %s for job limit, using free tier: %v", userID, err)
+	}
+	limit := p.scheduling.jobLimit(tier)
+
+	key := userSlotsKey(userID)
+	now := time.Now()
+	acquired, err := acquireUserSlotScript.Run(ctx, p.redisClient, []string{key},
+		now.UnixMilli(), now.Add(userSlotLease).UnixMilli(), limit, jobID).Int()
+	if err != nil {
+		log.Printf("[WARN] Failed to check job limit for user %s, not limiting: %v", userID, err)
+		return noop, nil
+	}
+	if acquired == 0 {
+		return nil, fmt.Errorf("job %s waiting for a slot (limit %d): %w", jobID, limit, ErrUserAtCapacity)
+	}
+
+	return func() {
+		// The task context may already be done
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := p.redisClient.ZRem(ctx, key, jobID).Err(); err != nil {
+			log.Printf("[WARN] Failed to release job slot for user %s: %v", userID, err)
+		}
+	}, nil
+}
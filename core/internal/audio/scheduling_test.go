@@ -0,0 +1,120 @@
+package audio
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadSchedulingConfig(t *testing.T) {
+	t.Setenv("QUEUE_WEIGHTS", "fast:2, standard:4")
+	t.Setenv("USER_JOB_LIMITS", "1:2,3:8")
+
+	cfg := LoadSchedulingConfig()
+
+	expectedWeights := map[string]int{
+		QueueFast:          2,
+		QueuePremium:       3,
+		QueueStandard:      4,
+		QueueNotifications: 1,
+	}
+	for queue, expected := range expectedWeights {
+		if got := cfg.QueueWeights[queue]; got != expected {
+			t.Errorf("weight for %s: expected %d, got %d", queue, expected, got)
+		}
+	}
+
+	expectedLimits := map[int]int{
+		1: 2,
+		2: 2,
+		3: 8,
+		9: 2, // Unknown tiers get the free tier's limit
+	}
+	for tier, expected := range expectedLimits {
+		if got := cfg.jobLimit(tier); got != expected {
+			t.Errorf("job limit for tier %d: expected %d, got %d", tier, expected, got)
+		}
+	}
+}
+
+func TestParseWeightsRejectsInvalidEntries(t *testing.T) {
+	for _, input := range []string{"fast", "fast:0", "fast:-1", "fast:x"} {
+		if _, err := parseWeights(input); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestSchedulingOffPeak(t *testing.T) {
+	cfg := DefaultSchedulingConfig() // 01:00-07:00 UTC
+	day := func(hour, minute int) time.Time {
+		return time.Date(2025, 3, 10, hour, minute, 0, 0, time.UTC)
+	}
+
+	testCases := []struct {
+		name     string
+		task     ProcessTask
+		now      time.Time
+		expected *time.Time
+	}{
+		{
+			name:     "Off-peak before the window waits for it",
+			task:     ProcessTask{OffPeak: true},
+			now:      day(0, 30),
+			expected: ptrTime(day(1, 0)),
+		},
+		{
+			name:     "Off-peak after the window waits for the next night",
+			task:     ProcessTask{OffPeak: true},
+			now:      day(12, 0),
+			expected: ptrTime(day(1, 0).AddDate(0, 0, 1)),
+		},
+		{
+			name:     "Off-peak inside the window runs now",
+			task:     ProcessTask{OffPeak: true},
+			now:      day(3, 0),
+			expected: nil,
+		},
+		{
+			name:     "Off-peak with a start time in the window keeps it",
+			task:     ProcessTask{OffPeak: true, ProcessAfter: ptrTime(day(4, 15))},
+			now:      day(0, 0),
+			expected: ptrTime(day(4, 15)),
+		},
+		{
+			name:     "Deferred start time is kept",
+			task:     ProcessTask{ProcessAfter: ptrTime(day(18, 0))},
+			now:      day(12, 0),
+			expected: ptrTime(day(18, 0)),
+		},
+		{
+			name:     "Past start time is dropped",
+			task:     ProcessTask{ProcessAfter: ptrTime(day(9, 0))},
+			now:      day(12, 0),
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			task := tc.task
+			cfg.Schedule(&task, tc.now)
+			switch {
+			case tc.expected == nil && task.ProcessAfter != nil:
+				t.Errorf("expected no start time, got %v", *task.ProcessAfter)
+			case tc.expected != nil && (task.ProcessAfter == nil || !task.ProcessAfter.Equal(*tc.expected)):
+				t.Errorf("expected start time %v, got %v", *tc.expected, task.ProcessAfter)
+			}
+		})
+	}
+
+	if got := cfg.ChargedSeconds(601, true); got != 301 {
+		t.Errorf("expected off-peak charge of 301s, got %d", got)
+	}
+	if got := cfg.ChargedSeconds(601, false); got != 601 {
+		t.Errorf("expected full charge of 601s, got %d", got)
+	}
+}
+
+func ptrTime(t time.Time) *time.Time {
+	return &t
+}
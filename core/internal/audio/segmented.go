@@ -0,0 +1,413 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Segmented processing settings for multi-hour files
+const (
+	// SegmentedMinDuration is the length (in seconds) from which files are
+	// streamed and processed in chunks instead of downloaded and rendered whole
+	SegmentedMinDuration = 60 * 60.0
+
+	segmentLength  = 5 * 60.0 // Seconds of audio per analysis/render chunk
+	segmentPreroll = 2.0      // Seconds decoded before each chunk to settle limiter and afftdn state
+)
+
+// segment is a half-open [Start, End) range on the source timeline, in seconds
+type segment struct {
+	Index int
+	Start float64
+	End   float64
+}
+
+// planSegments splits [start, end) into chunks of at most length seconds
+func planSegments(start, end, length float64) []segment {
+	var segments []segment
+	for i := 0; start+float64(i)*length < end; i++ {
+		segStart := start + float64(i)*length
+		segEnd := math.Min(segStart+length, end)
+		segments = append(segments, segment{Index: i, Start: segStart, End: segEnd})
+	}
+	return segments
+}
+
+// ProcessAudioSegmented analyzes and renders a long file in parallel chunks.
+// The input may be a local path or a URL that ffmpeg can seek in (e.g. a
+// presigned storage URL), so the source never has to be fully downloaded.
+// Rendered chunks are trimmed on sample boundaries and streamed in order into a
+// single encoder, so the output is gapless and temp disk use stays bounded by
+// the number of chunks in flight.
+func ProcessAudioSegmented(ctx context.Context, input, outputFile string, targetLUFS float64, options OutputOptions, mode ProcessingMode, silenceInfo *SilenceInfo, noiseReduction bool, duration float64) error {
+	contentStart, contentEnd := 0.0, duration
+	if silenceInfo.NeedsTrimming() {
+		contentStart, contentEnd = silenceInfo.TrimStart, silenceInfo.TrimEnd
+	}
+
+	var loudnessInfo *LoudnessInfo
+	var err error
+
+	switch mode {
+	case ModeFast:
+		log.Printf("[INFO] Fast mode: Using content-aware sampling analysis (segmented)")
+		loudnessInfo, err = estimateLoudnessFromSamples(ctx, input)
+		if errors.Is(err, ErrSamplingInsufficient) {
+			// The input may be a URL, so fall back to the chunked full analysis
+			log.Printf("[INFO] %v, using chunked full analysis", err)
+			loudnessInfo, err = AnalyzeLoudnessSegmented(ctx, input, planSegments(contentStart, contentEnd, segmentLength))
+		}
+		if err != nil {
+			return fmt.Errorf("adaptive analysis failed: %w", err)
+		}
+
+	case ModePrecise:
+		log.Printf("[INFO] Precise mode: Using parallel chunked analysis")
+		loudnessInfo, err = AnalyzeLoudnessSegmented(ctx, input, planSegments(contentStart, contentEnd, segmentLength))
+		if err != nil {
+			return fmt.Errorf("segmented analysis failed: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("unknown processing mode: %s", mode)
+	}
+
+	log.Printf("[INFO] Analysis complete: %.1f LUFS, LRA: %.1f, Peak: %.1f dB",
+		loudnessInfo.InputI, loudnessInfo.InputLRA, loudnessInfo.InputTP)
+
+	// Trimming is done by the chunk plan, not by an atrim stage in the chain
+	filters, err := buildNormalizationFilters(targetLUFS, loudnessInfo, options, nil, noiseReduction)
+	if err != nil {
+		return err
+	}
+
+	channels, err := probeChannels(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to probe channel layout: %w", err)
+	}
+	if hasOption(options.ExtraOptions, "-ac", "2") {
+		channels = 2
+	}
+
+	return renderSegmented(ctx, input, outputFile, filters, options, channels, contentStart, contentEnd)
+}
+
+// AnalyzeLoudnessSegmented measures each segment in parallel and merges the
+// results in the energy domain, weighted by segment length
+func AnalyzeLoudnessSegmented(ctx context.Context, input string, segments []segment) (*LoudnessInfo, error) {
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("no segments to analyze")
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	measurements := make([]loudnessMeasurement, len(segments))
+	errs := make(chan error, len(segments))
+	var wg sync.WaitGroup
+
+	total := segments[len(segments)-1].End - segments[0].Start
+	var analyzed float64
+	var progressMu sync.Mutex
+
+	for _, seg := range segments {
+		wg.Add(1)
+		go func(seg segment) {
+			defer wg.Done()
+
+			select {
+			case ffmpegSemaphore <- struct{}{}:
+				defer func() { <-ffmpegSemaphore }()
+			case <-ctx.Done():
+				return
+			}
+
+			cmd := commandContext(ctx,
+				"ffmpeg",
+				"-ss", formatSeconds(seg.Start),
+				"-t", formatSeconds(seg.End-seg.Start),
+				"-i", input,
+				"-af", "loudnorm=print_format=json:I=-16:TP=-1.5:LRA=11",
+				"-f", "null", "-")
+
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				errs <- fmt.Errorf("segment %d (%.0fs) analysis failed: %w", seg.Index, seg.Start, err)
+				cancel()
+				return
+			}
+
+			info, err := parseLoudnormOutput(output)
+			if err != nil {
+				errs <- fmt.Errorf("segment %d: %w", seg.Index, err)
+				cancel()
+				return
+			}
+
+			if debugMode {
+				log.Printf("[DEBUG] Segment %d: %.1f LUFS at %.0fs", seg.Index, info.InputI, seg.Start)
+			}
+
+			// Each goroutine owns its own index, no lock needed
+			measurements[seg.Index] = loudnessMeasurement{info: info, weight: seg.End - seg.Start}
+
+			progressMu.Lock()
+			analyzed += seg.End - seg.Start
+			reportProgress(ctx, StageAnalyzing, analyzed/total)
+			progressMu.Unlock()
+		}(seg)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	if err := contextError(parent, "segmented analysis"); err != nil {
+		return nil, err
+	}
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	info, err := mergeLoudnessMeasurements(measurements)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[INFO] Segmented analysis: %.1f LUFS, Peak: %.1f dB (%d segments)",
+		info.InputI, info.InputTP, len(segments))
+
+	return info, nil
+}
+
+// renderSegmented renders chunks in parallel to raw float PCM and feeds them to
+// one encoder in order. Chunk boundaries are fixed on the output sample grid so
+// consecutive chunks tile exactly.
+func renderSegmented(ctx context.Context, input, outputFile string, filters []string, options OutputOptions, channels int, start, end float64) error {
+	sampleRate := outputSampleRate(options)
+	segments := planSegments(start, end, segmentLength)
+	if len(segments) == 0 {
+		return fmt.Errorf("nothing to render")
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tempDir, err := os.MkdirTemp("/tmp/levelmix", "levelmix_segments_*")
+	if err != nil {
+		return fmt.Errorf("failed to create segment directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Single encoder reading the concatenated PCM stream from stdin
+	encodeArgs := appendEncoderArgs([]string{
+		"-f", "f32le",
+		"-ar", strconv.Itoa(sampleRate),
+		"-ac", strconv.Itoa(channels),
+		"-i", "pipe:0",
+	}, outputFile, options)
+
+	encoder := commandContext(ctx, "ffmpeg", encodeArgs...)
+	encoderIn, err := encoder.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open encoder stdin: %w", err)
+	}
+	var encoderOut strings.Builder
+	encoder.Stderr = &encoderOut
+	if err := encoder.Start(); err != nil {
+		return fmt.Errorf("failed to start encoder: %w", err)
+	}
+
+	// Limit rendered-but-unwritten chunks to bound temp disk usage
+	window := make(chan struct{}, cap(ffmpegSemaphore)+1)
+	results := make([]chan string, len(segments))
+	for i := range results {
+		results[i] = make(chan string, 1)
+	}
+
+	var renderErr error
+	var errOnce sync.Once
+	fail := func(err error) {
+		errOnce.Do(func() {
+			renderErr = err
+			cancel()
+		})
+	}
+
+	// Launcher and render goroutines must finish before the temp dir is removed
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, seg := range segments {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			wg.Add(1)
+			go func(seg segment) {
+				defer wg.Done()
+				select {
+				case ffmpegSemaphore <- struct{}{}:
+					defer func() { <-ffmpegSemaphore }()
+				case <-ctx.Done():
+					return
+				}
+
+				path := filepath.Join(tempDir, fmt.Sprintf("segment_%05d.f32", seg.Index))
+				if err := renderSegment(ctx, input, path, filters, sampleRate, channels, seg); err != nil {
+					fail(err)
+					return
+				}
+				results[seg.Index] <- path
+			}(seg)
+		}
+	}()
+
+	// Write chunks to the encoder strictly in order
+	for i := range segments {
+		var path string
+		select {
+		case path = <-results[i]:
+		case <-ctx.Done():
+		}
+		if path == "" {
+			break
+		}
+
+		if err := copyFileTo(encoderIn, path); err != nil {
+			fail(fmt.Errorf("failed to stream segment %d to encoder: %w", i, err))
+			break
+		}
+		os.Remove(path)
+		<-window
+		reportProgress(ctx, StageNormalizing, float64(i+1)/float64(len(segments)))
+	}
+
+	encoderIn.Close()
+	encodeErr := encoder.Wait()
+
+	// Stop any chunks still rendering after a failure, then wait for them
+	cancel()
+	wg.Wait()
+
+	if err := contextError(parent, "segmented render"); err != nil {
+		return err
+	}
+	if renderErr != nil {
+		return renderErr
+	}
+	if encodeErr != nil {
+		log.Printf("[ERROR] FFmpeg encoder output: %s", truncateString(encoderOut.String(), 2000))
+		return fmt.Errorf("encoding failed: %w", encodeErr)
+	}
+
+	log.Printf("[INFO] Segmented normalization complete (%d segments)", len(segments))
+	return nil
+}
+
+// renderSegment renders one chunk with a short preroll so stateful filters
+// (limiter, noise reduction) are settled when the kept range starts. Timestamps
+// are kept absolute with -copyts and the chunk is cut by pts in output samples,
+// so the result is sample-accurate regardless of where the input seek lands.
+func renderSegment(ctx context.Context, input, outputPath string, filters []string, sampleRate, channels int, seg segment) error {
+	seekTo := math.Max(seg.Start-segmentPreroll, 0)
+	startPTS := int64(math.Round(seg.Start * float64(sampleRate)))
+	endPTS := int64(math.Round(seg.End * float64(sampleRate)))
+
+	chain := append(append([]string{}, filters...),
+		fmt.Sprintf("atrim=start_pts=%d:end_pts=%d", startPTS, endPTS))
+
+	args := []string{
+		"-ss", formatSeconds(seekTo),
+		"-copyts",
+		"-i", input,
+		"-af", strings.Join(chain, ","),
+		"-ac", strconv.Itoa(channels),
+		"-ar", strconv.Itoa(sampleRate),
+		"-c:a", "pcm_f32le",
+		"-f", "f32le",
+		"-y", outputPath,
+	}
+
+	start := time.Now()
+	cmd := commandContext(ctx, "ffmpeg", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if err := contextError(ctx, "segment render"); err != nil {
+			return err
+		}
+		log.Printf("[ERROR] Segment %d FFmpeg output: %s", seg.Index, truncateString(string(output), 2000))
+		return fmt.Errorf("segment %d render failed: %w", seg.Index, err)
+	}
+
+	if debugMode {
+		log.Printf("[DEBUG] Segment %d rendered (%.0fs-%.0fs) in %.1fs", seg.Index, seg.Start, seg.End, time.Since(start).Seconds())
+	}
+	return nil
+}
+
+// probeChannels returns the channel count of the first audio stream
+func probeChannels(ctx context.Context, input string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	cmd := commandContext(ctx,
+		"ffprobe",
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=channels",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		input)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, err
+	}
+
+	channels, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil || channels < 1 {
+		return 0, fmt.Errorf("invalid channel count %q", strings.TrimSpace(string(output)))
+	}
+	return channels, nil
+}
+
+// copyFileTo streams a file into w
+func copyFileTo(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// hasOption reports whether args contains flag followed by value
+func hasOption(args []string, flag, value string) bool {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag && args[i+1] == value {
+			return true
+		}
+	}
+	return false
+}
+
+func formatSeconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', 3, 64)
+}
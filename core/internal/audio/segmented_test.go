@@ -0,0 +1,48 @@
+package audio
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPlanSegments(t *testing.T) {
+	segments := planSegments(1.5, 1000.0, 300.0)
+
+	if len(segments) != 4 {
+		t.Fatalf("Expected 4 segments, got %d", len(segments))
+	}
+	if segments[0].Start != 1.5 {
+		t.Errorf("Expected first segment to start at 1.5, got %f", segments[0].Start)
+	}
+	if segments[len(segments)-1].End != 1000.0 {
+		t.Errorf("Expected last segment to end at 1000.0, got %f", segments[len(segments)-1].End)
+	}
+
+	// Segments must tile the range with no gaps or overlaps
+	for i := 1; i < len(segments); i++ {
+		if segments[i].Start != segments[i-1].End {
+			t.Errorf("Gap between segment %d (end %f) and %d (start %f)", i-1, segments[i-1].End, i, segments[i].Start)
+		}
+	}
+}
+
+func TestMergeLoudnessMeasurements(t *testing.T) {
+	info, err := mergeLoudnessMeasurements([]loudnessMeasurement{
+		{info: &LoudnessInfo{InputI: -10, InputTP: -1.0, InputLRA: 4}, weight: 300},
+		{info: &LoudnessInfo{InputI: -10, InputTP: -0.5, InputLRA: 6}, weight: 100},
+	})
+	if err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+
+	// Equal loudness in every part must merge to the same loudness
+	if math.Abs(info.InputI-(-10)) > 0.01 {
+		t.Errorf("Expected InputI -10, got %f", info.InputI)
+	}
+	if info.InputTP != -0.5 {
+		t.Errorf("Expected InputTP to be the max peak -0.5, got %f", info.InputTP)
+	}
+	if math.Abs(info.InputLRA-4.5) > 0.01 {
+		t.Errorf("Expected duration-weighted InputLRA 4.5, got %f", info.InputLRA)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os/exec"
 	"strconv"
 	"strings"
 	"time"
@@ -19,25 +18,28 @@ type SilenceInfo struct {
 }
 
 // DetectSilence finds silence at start/end of audio and returns trim points
-func DetectSilence(inputFile string) (*SilenceInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+func DetectSilence(ctx context.Context, inputFile string) (*SilenceInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
 	defer cancel()
 
 	// Get total duration first
 	duration, err := getDurationForSilence(ctx, inputFile)
 	if err != nil {
+		if err := contextError(ctx, "silence detection"); err != nil {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to get duration: %w", err)
 	}
 
 	// Run silence detection
-	cmd := exec.CommandContext(ctx, "ffmpeg",
+	cmd := commandContext(ctx, "ffmpeg",
 		"-i", inputFile,
 		"-af", "silencedetect=noise=-70dB:d=0.5",
 		"-f", "null", "-")
 
 	output, err := cmd.CombinedOutput()
-	if err != nil && ctx.Err() == context.DeadlineExceeded {
-		return nil, fmt.Errorf("silence detection timed out")
+	if err := contextError(ctx, "silence detection"); err != nil {
+		return nil, err
 	}
 	// FFmpeg returns non-zero for null output, that's OK
 
@@ -57,7 +59,7 @@ func DetectSilence(inputFile string) (*SilenceInfo, error) {
 }
 
 func getDurationForSilence(ctx context.Context, inputFile string) (float64, error) {
-	cmd := exec.CommandContext(ctx, "ffprobe",
+	cmd := commandContext(ctx, "ffprobe",
 		"-v", "error",
 		"-show_entries", "format=duration",
 		"-of", "default=noprint_wrappers=1:nokey=1",
@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/simonlewi/levelmix/core/internal/handlers"
+	"github.com/simonlewi/levelmix/pkg/email"
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+const (
+	minPasswordLength = 8
+	maxNameLength     = 100
+
+	verificationDuration = 7 * 24 * time.Hour
+)
+
+// Handler serves login, registration and logout
+type Handler struct {
+	metadata    storage.MetadataStorage
+	sessions    *Sessions
+	email       email.EmailService
+	defaultTier int
+}
+
+// NewHandler creates the handler. New accounts get DEFAULT_TIER (default 1,
+// free), since there are no payments to upgrade them.
+func NewHandler(metadata storage.MetadataStorage, sessions *Sessions, emailService email.EmailService) *Handler {
+	tier := 1
+	if v := os.Getenv("DEFAULT_TIER"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			tier = parsed
+		} else {
+			log.Printf("Ignoring invalid DEFAULT_TIER %q", v)
+		}
+	}
+	return &Handler{metadata: metadata, sessions: sessions, email: emailService, defaultTier: tier}
+}
+
+func (h *Handler) ShowLogin(c *gin.Context) {
+	if _, exists := c.Get("user"); exists {
+		c.Redirect(http.StatusSeeOther, "/dashboard")
+		return
+	}
+	c.HTML(http.StatusOK, "login.html", handlers.GetTemplateData(c, gin.H{
+		"CurrentPage":    "login",
+		"PageTitle":      "Sign In",
+		"error":          c.Query("error"),
+		"password_reset": c.Query("password_reset") == "true",
+		"next":           c.Query("next"),
+	}))
+}
+
+func (h *Handler) HandleLogin(c *gin.Context) {
+	emailAddr := strings.TrimSpace(c.PostForm("email"))
+	password := c.PostForm("password")
+	if emailAddr == "" || password == "" {
+		h.loginError(c, "missing_fields")
+		return
+	}
+
+	user, err := h.metadata.GetUserByEmail(c.Request.Context(), emailAddr)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("HandleLogin: Failed to look up user: %v", err)
+			h.loginError(c, "server_error")
+			return
+		}
+		h.loginError(c, "invalid_credentials")
+		return
+	}
+	if user.PasswordHash == nil {
+		h.loginError(c, "use_oauth")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(*user.PasswordHash), []byte(password)); err != nil {
+		h.loginError(c, "invalid_credentials")
+		return
+	}
+
+	now := time.Now()
+	user.LastLoginAt = &now
+	if err := h.metadata.UpdateUser(c.Request.Context(), user); err != nil {
+		log.Printf("HandleLogin: Failed to record login for user %s: %v", user.ID, err)
+	}
+
+	h.sessions.Start(c, user.ID)
+	c.Redirect(http.StatusSeeOther, safeNext(c.Query("next"), "/dashboard"))
+}
+
+func (h *Handler) ShowRegister(c *gin.Context) {
+	if _, exists := c.Get("user"); exists {
+		c.Redirect(http.StatusSeeOther, "/dashboard")
+		return
+	}
+	c.HTML(http.StatusOK, "register.html", handlers.GetTemplateData(c, gin.H{
+		"CurrentPage": "register",
+		"PageTitle":   "Create Account",
+		"error":       c.Query("error"),
+	}))
+}
+
+func (h *Handler) HandleRegister(c *gin.Context) {
+	ctx := c.Request.Context()
+	name := strings.TrimSpace(c.PostForm("name"))
+	emailAddr := strings.TrimSpace(c.PostForm("email"))
+	password := c.PostForm("password")
+
+	switch {
+	case emailAddr == "" || password == "":
+		h.registerError(c, "missing_fields")
+		return
+	case len(name) > maxNameLength:
+		h.registerError(c, "name_too_long")
+		return
+	case len(password) < minPasswordLength:
+		h.registerError(c, "password_short")
+		return
+	case password != c.PostForm("confirm_password"):
+		h.registerError(c, "password_mismatch")
+		return
+	}
+
+	if _, err := h.metadata.GetUserByEmail(ctx, emailAddr); err == nil {
+		h.registerError(c, "email_exists")
+		return
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("HandleRegister: Failed to look up email: %v", err)
+		h.registerError(c, "server_error")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("HandleRegister: Failed to hash password: %v", err)
+		h.registerError(c, "server_error")
+		return
+	}
+	passwordHash := string(hash)
+
+	now := time.Now()
+	user := &storage.User{
+		ID:               newToken(),
+		Email:            emailAddr,
+		PasswordHash:     &passwordHash,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		LastLoginAt:      &now,
+		AuthProvider:     "email",
+		SubscriptionTier: h.defaultTier,
+	}
+	if name != "" {
+		user.Name = &name
+	}
+	if c.PostForm("marketing_consent") != "" {
+		user.MarketingConsent = true
+		user.MarketingConsentAt = &now
+	}
+
+	if err := h.metadata.CreateUser(ctx, user); err != nil {
+		// Registered by a concurrent request since the check above
+		if errors.Is(err, storage.ErrConflict) {
+			h.registerError(c, "email_exists")
+			return
+		}
+		log.Printf("HandleRegister: Failed to create user: %v", err)
+		h.registerError(c, "server_error")
+		return
+	}
+	if err := h.metadata.CreateUserStats(ctx, &storage.UserUploadStats{UserID: user.ID}); err != nil {
+		log.Printf("HandleRegister: Failed to create stats for user %s: %v", user.ID, err)
+	}
+
+	if err := h.email.SendWelcome(ctx, user.Email); err != nil {
+		log.Printf("HandleRegister: Failed to send welcome email to %s: %v", user.Email, err)
+	}
+	token := newToken()
+	if err := h.metadata.StoreVerificationToken(ctx, user.ID, token, now.Add(verificationDuration)); err != nil {
+		log.Printf("HandleRegister: Failed to store verification token for user %s: %v", user.ID, err)
+	} else if err := h.email.SendEmailVerification(ctx, user.Email, token); err != nil {
+		log.Printf("HandleRegister: Failed to send verification email to %s: %v", user.Email, err)
+	}
+
+	h.sessions.Start(c, user.ID)
+	c.Redirect(http.StatusSeeOther, "/upload")
+}
+
+func (h *Handler) HandleLogout(c *gin.Context) {
+	h.sessions.End(c)
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+func (h *Handler) loginError(c *gin.Context, code string) {
+	target := "/login?error=" + code
+	if next := c.Query("next"); next != "" {
+		target += "&next=" + url.QueryEscape(next)
+	}
+	c.Redirect(http.StatusSeeOther, target)
+}
+
+func (h *Handler) registerError(c *gin.Context, code string) {
+	c.Redirect(http.StatusSeeOther, "/register?error="+code)
+}
+
+// safeNext returns next if it is a local path, so the login page can't be
+// used to redirect elsewhere
+func safeNext(next, fallback string) string {
+	if next == "" || !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return fallback
+	}
+	return next
+}
+
+func newToken() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		log.Fatalf("Failed to generate random token: %v", err)
+	}
+	return hex.EncodeToString(bytes)
+}
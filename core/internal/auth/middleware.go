@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// Middleware loads the logged-in user into the request context
+type Middleware struct {
+	metadata storage.MetadataStorage
+	sessions *Sessions
+}
+
+func NewMiddleware(metadata storage.MetadataStorage, sessions *Sessions) *Middleware {
+	return &Middleware{metadata: metadata, sessions: sessions}
+}
+
+// TemplateContext sets "user" (*storage.User) and "userID" for a valid
+// session, and corrects the template login state set from the user_id cookie
+func (m *Middleware) TemplateContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("IsLoggedIn", false)
+		c.Set("UserID", "")
+
+		if userID, ok := m.sessions.UserID(c); ok {
+			user, err := m.metadata.GetUser(c.Request.Context(), userID)
+			if err != nil {
+				if !errors.Is(err, storage.ErrNotFound) {
+					log.Printf("TemplateContext: Failed to load user %s: %v", userID, err)
+				}
+				m.sessions.End(c)
+			} else {
+				c.Set("user", user)
+				c.Set("userID", user.ID)
+				c.Set("IsLoggedIn", true)
+				c.Set("UserID", user.ID)
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireAuth rejects requests without a logged-in user: API calls get a
+// 401, pages redirect to the login page
+func (m *Middleware) RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get("user"); exists {
+			c.Next()
+			return
+		}
+
+		if strings.HasPrefix(c.Request.URL.Path, "/api/") || c.Request.Method != http.MethodGet {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Please log in to continue"})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+		c.Abort()
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/simonlewi/levelmix/core/internal/handlers"
+	"github.com/simonlewi/levelmix/pkg/email"
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// PasswordRecoveryHandler emails password reset links. Reset tokens are
+// signed rather than stored, and bound to the password they replace, so each
+// one works only until the password is changed.
+type PasswordRecoveryHandler struct {
+	metadata storage.MetadataStorage
+	sessions *Sessions
+	email    email.EmailService
+}
+
+func NewPasswordRecoveryHandler(metadata storage.MetadataStorage, sessions *Sessions, emailService email.EmailService) *PasswordRecoveryHandler {
+	return &PasswordRecoveryHandler{metadata: metadata, sessions: sessions, email: emailService}
+}
+
+func (h *PasswordRecoveryHandler) ShowForgotPassword(c *gin.Context) {
+	c.HTML(http.StatusOK, "forgot-password.html", handlers.GetTemplateData(c, gin.H{
+		"CurrentPage": "forgot-password",
+		"PageTitle":   "Forgot Password",
+		"error":       c.Query("error"),
+		"success":     c.Query("success") == "true",
+	}))
+}
+
+// HandleForgotPassword reports success whether or not the account exists, so
+// the form can't be used to find registered emails
+func (h *PasswordRecoveryHandler) HandleForgotPassword(c *gin.Context) {
+	emailAddr := strings.TrimSpace(c.PostForm("email"))
+	if emailAddr == "" {
+		c.Redirect(http.StatusSeeOther, "/forgot-password?error=email_required")
+		return
+	}
+
+	user, err := h.metadata.GetUserByEmail(c.Request.Context(), emailAddr)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("HandleForgotPassword: Failed to look up user: %v", err)
+			c.Redirect(http.StatusSeeOther, "/forgot-password?error=server_error")
+			return
+		}
+	} else if user.PasswordHash != nil {
+		token := h.sessions.ResetToken(user.ID, *user.PasswordHash)
+		if err := h.email.SendPasswordReset(c.Request.Context(), user.Email, token); err != nil {
+			log.Printf("HandleForgotPassword: Failed to send reset email to %s: %v", user.Email, err)
+			c.Redirect(http.StatusSeeOther, "/forgot-password?error=server_error")
+			return
+		}
+	}
+
+	c.Redirect(http.StatusSeeOther, "/forgot-password?success=true")
+}
+
+func (h *PasswordRecoveryHandler) ShowResetPassword(c *gin.Context) {
+	token := c.Query("token")
+	if _, err := h.checkToken(c, token); err != nil {
+		c.Redirect(http.StatusSeeOther, "/login?error=invalid_token")
+		return
+	}
+	c.HTML(http.StatusOK, "reset-password.html", handlers.GetTemplateData(c, gin.H{
+		"CurrentPage": "reset-password",
+		"PageTitle":   "Reset Password",
+		"token":       token,
+		"error":       c.Query("error"),
+	}))
+}
+
+func (h *PasswordRecoveryHandler) HandleResetPassword(c *gin.Context) {
+	token := c.PostForm("token")
+	password := c.PostForm("password")
+
+	user, err := h.checkToken(c, token)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login?error=invalid_token")
+		return
+	}
+
+	retry := "/reset-password?token=" + token + "&error="
+	switch {
+	case password == "":
+		c.Redirect(http.StatusSeeOther, retry+"missing_fields")
+		return
+	case len(password) < minPasswordLength:
+		c.Redirect(http.StatusSeeOther, retry+"password_short")
+		return
+	case password != c.PostForm("confirm_password"):
+		c.Redirect(http.StatusSeeOther, retry+"password_mismatch")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("HandleResetPassword: Failed to hash password: %v", err)
+		c.Redirect(http.StatusSeeOther, retry+"server_error")
+		return
+	}
+	passwordHash := string(hash)
+	user.PasswordHash = &passwordHash
+	if err := h.metadata.UpdateUser(c.Request.Context(), user); err != nil {
+		log.Printf("HandleResetPassword: Failed to update password for user %s: %v", user.ID, err)
+		c.Redirect(http.StatusSeeOther, retry+"server_error")
+		return
+	}
+
+	if err := h.email.SendPasswordChanged(c.Request.Context(), user.Email); err != nil {
+		log.Printf("HandleResetPassword: Failed to send confirmation to %s: %v", user.Email, err)
+	}
+	h.sessions.End(c)
+	c.Redirect(http.StatusSeeOther, "/login?password_reset=true")
+}
+
+// checkToken returns the user a valid reset token belongs to
+func (h *PasswordRecoveryHandler) checkToken(c *gin.Context, token string) (*storage.User, error) {
+	userID, err := h.sessions.ResetUserID(token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := h.metadata.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.PasswordHash == nil {
+		return nil, errInvalidToken
+	}
+	if _, err := h.sessions.CheckResetToken(token, *user.PasswordHash); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,157 @@
+// Package auth is the open-source email and password login: signed session
+// cookies, registration, password recovery and soft email verification. It
+// needs nothing beyond storage.MetadataStorage.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionCookie = "session_token"
+	// userIDCookie is only a hint for handlers.TemplateContext; the signed
+	// session cookie is what authenticates requests
+	userIDCookie = "user_id"
+
+	sessionDuration = 30 * 24 * time.Hour
+	resetDuration   = time.Hour
+)
+
+var errInvalidToken = errors.New("invalid or expired token")
+
+// Sessions signs and checks session cookies and password reset tokens
+type Sessions struct {
+	secret []byte
+}
+
+// NewSessions uses SESSION_SECRET to sign sessions. Without one a random
+// secret is used, so everyone is logged out when the server restarts.
+func NewSessions() *Sessions {
+	secret := []byte(os.Getenv("SESSION_SECRET"))
+	if len(secret) == 0 {
+		log.Println("WARNING: SESSION_SECRET not set - using a random secret, sessions end on restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Failed to generate session secret: %v", err)
+		}
+	}
+	return &Sessions{secret: secret}
+}
+
+// Start logs the user in on this browser
+func (s *Sessions) Start(c *gin.Context, userID string) {
+	expires := time.Now().Add(sessionDuration)
+	token := s.sign("session", userID, expires, "")
+
+	maxAge := int(sessionDuration.Seconds())
+	secure := isSecure(c)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, token, maxAge, "/", "", secure, true)
+	c.SetCookie(userIDCookie, userID, maxAge, "/", "", secure, true)
+}
+
+// End logs the browser out
+func (s *Sessions) End(c *gin.Context) {
+	secure := isSecure(c)
+	c.SetCookie(sessionCookie, "", -1, "/", "", secure, true)
+	c.SetCookie(userIDCookie, "", -1, "/", "", secure, true)
+}
+
+// UserID returns the user of the request's session, if it has a valid one
+func (s *Sessions) UserID(c *gin.Context) (string, bool) {
+	token, err := c.Cookie(sessionCookie)
+	if err != nil || token == "" {
+		return "", false
+	}
+	userID, err := s.verify("session", token, "")
+	if err != nil {
+		return "", false
+	}
+	return userID, true
+}
+
+// ResetToken returns a password reset token for the user. It is bound to the
+// current password hash, so it stops working once the password changes.
+func (s *Sessions) ResetToken(userID, passwordHash string) string {
+	return s.sign("reset", userID, time.Now().Add(resetDuration), passwordHash)
+}
+
+// ResetUserID returns the user a reset token was issued to. Check the token
+// with CheckResetToken once the user's password hash is known.
+func (s *Sessions) ResetUserID(token string) (string, error) {
+	payload, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", errInvalidToken
+	}
+	userID, _, ok := strings.Cut(string(data), "|")
+	if !ok || userID == "" {
+		return "", errInvalidToken
+	}
+	return userID, nil
+}
+
+// CheckResetToken verifies a reset token against the user's current
+// password hash
+func (s *Sessions) CheckResetToken(token, passwordHash string) (string, error) {
+	return s.verify("reset", token, passwordHash)
+}
+
+// sign returns base64(userID|expiry).base64(mac), where the MAC also covers
+// the token's purpose and binding
+func (s *Sessions) sign(purpose, userID string, expires time.Time, binding string) string {
+	payload := userID + "|" + strconv.FormatInt(expires.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.mac(purpose, payload, binding))
+}
+
+func (s *Sessions) verify(purpose, token, binding string) (string, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.mac(purpose, string(payload), binding)) {
+		return "", errInvalidToken
+	}
+
+	userID, expiry, ok := strings.Cut(string(payload), "|")
+	if !ok {
+		return "", errInvalidToken
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		return "", errInvalidToken
+	}
+	return userID, nil
+}
+
+func (s *Sessions) mac(purpose, payload, binding string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(h, "%s\n%s\n%s", purpose, payload, binding)
+	return h.Sum(nil)
+}
+
+func isSecure(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
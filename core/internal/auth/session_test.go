@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionTokens(t *testing.T) {
+	s := &Sessions{secret: []byte("test-secret")}
+
+	token := s.sign("session", "user1", time.Now().Add(time.Hour), "")
+	if userID, err := s.verify("session", token, ""); err != nil || userID != "user1" {
+		t.Fatalf("verify = %q, %v; want user1", userID, err)
+	}
+
+	if _, err := s.verify("reset", token, ""); err == nil {
+		t.Error("session token accepted as a reset token")
+	}
+
+	other := &Sessions{secret: []byte("other-secret")}
+	if _, err := other.verify("session", token, ""); err == nil {
+		t.Error("token accepted with a different secret")
+	}
+
+	_, sig, _ := strings.Cut(token, ".")
+	payload, _, _ := strings.Cut(s.sign("session", "admin", time.Now().Add(time.Hour), ""), ".")
+	forged := payload + "." + sig
+	if _, err := s.verify("session", forged, ""); err == nil {
+		t.Error("tampered token accepted")
+	}
+
+	expired := s.sign("session", "user1", time.Now().Add(-time.Second), "")
+	if _, err := s.verify("session", expired, ""); err == nil {
+		t.Error("expired token accepted")
+	}
+}
+
+func TestResetTokenEndsWithPasswordChange(t *testing.T) {
+	s := &Sessions{secret: []byte("test-secret")}
+	token := s.ResetToken("user1", "old-hash")
+
+	if userID, err := s.ResetUserID(token); err != nil || userID != "user1" {
+		t.Fatalf("ResetUserID = %q, %v; want user1", userID, err)
+	}
+	if _, err := s.CheckResetToken(token, "old-hash"); err != nil {
+		t.Errorf("CheckResetToken with the current hash: %v", err)
+	}
+	if _, err := s.CheckResetToken(token, "new-hash"); err == nil {
+		t.Error("reset token still valid after the password changed")
+	}
+}
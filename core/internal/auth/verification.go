@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/simonlewi/levelmix/core/internal/handlers"
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// VerificationHandler serves the links in the verification email: one
+// confirms the address, the other removes an account the owner of the
+// address didn't create
+type VerificationHandler struct {
+	metadata storage.MetadataStorage
+}
+
+func NewVerificationHandler(metadata storage.MetadataStorage) *VerificationHandler {
+	return &VerificationHandler{metadata: metadata}
+}
+
+func (h *VerificationHandler) ShowVerify(c *gin.Context) {
+	ctx := c.Request.Context()
+	token := c.Query("token")
+	data := gin.H{"CurrentPage": "verify-email", "PageTitle": "Verify Email"}
+
+	userID, err := h.metadata.GetUserIDByVerificationToken(ctx, token)
+	if err != nil {
+		data["error"] = tokenError("ShowVerify", err)
+		c.HTML(http.StatusOK, "verify-email.html", handlers.GetTemplateData(c, data))
+		return
+	}
+
+	if err := h.metadata.SetEmailVerified(ctx, userID); err != nil {
+		log.Printf("ShowVerify: Failed to verify user %s: %v", userID, err)
+		data["error"] = "server_error"
+		c.HTML(http.StatusOK, "verify-email.html", handlers.GetTemplateData(c, data))
+		return
+	}
+	if err := h.metadata.DeleteVerificationToken(ctx, token); err != nil {
+		log.Printf("ShowVerify: Failed to delete token of user %s: %v", userID, err)
+	}
+
+	data["success"] = true
+	c.HTML(http.StatusOK, "verify-email.html", handlers.GetTemplateData(c, data))
+}
+
+func (h *VerificationHandler) ShowRejectConfirm(c *gin.Context) {
+	token := c.Query("token")
+	data := gin.H{"CurrentPage": "verify-email-reject", "PageTitle": "Not Your Account?", "token": token}
+
+	if _, err := h.unverifiedUser(c, token); err != nil {
+		data["error"] = tokenError("ShowRejectConfirm", err)
+	}
+	c.HTML(http.StatusOK, "verify-email-reject.html", handlers.GetTemplateData(c, data))
+}
+
+// HandleReject deletes the account, but only while its email is unverified
+func (h *VerificationHandler) HandleReject(c *gin.Context) {
+	token := c.PostForm("token")
+
+	user, err := h.unverifiedUser(c, token)
+	if err != nil {
+		c.HTML(http.StatusOK, "verify-email-reject.html", handlers.GetTemplateData(c, gin.H{
+			"CurrentPage": "verify-email-reject",
+			"PageTitle":   "Not Your Account?",
+			"error":       tokenError("HandleReject", err),
+		}))
+		return
+	}
+
+	if err := h.metadata.DeleteUser(c.Request.Context(), user.ID); err != nil {
+		log.Printf("HandleReject: Failed to delete user %s: %v", user.ID, err)
+		c.HTML(http.StatusOK, "verify-email-reject.html", handlers.GetTemplateData(c, gin.H{
+			"CurrentPage": "verify-email-reject",
+			"PageTitle":   "Not Your Account?",
+			"error":       "server_error",
+		}))
+		return
+	}
+
+	log.Printf("HandleReject: Deleted unverified account %s at the email owner's request", user.ID)
+	c.HTML(http.StatusOK, "verify-email-rejected.html", handlers.GetTemplateData(c, gin.H{
+		"CurrentPage": "verify-email-rejected",
+		"PageTitle":   "Account Removed",
+	}))
+}
+
+func (h *VerificationHandler) unverifiedUser(c *gin.Context, token string) (*storage.User, error) {
+	userID, err := h.metadata.GetUserIDByVerificationToken(c.Request.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	user, err := h.metadata.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.EmailVerified {
+		return nil, errInvalidToken
+	}
+	return user, nil
+}
+
+// tokenError picks the page error for a failed token lookup: a missing,
+// expired or used token is invalid, anything else is our fault
+func tokenError(caller string, err error) string {
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrExpired) || errors.Is(err, errInvalidToken) {
+		return "invalid_token"
+	}
+	log.Printf("%s: Failed to look up verification token: %v", caller, err)
+	return "server_error"
+}
@@ -95,6 +95,9 @@ func (h *AccountHandler) HandleDeleteAccount(c *gin.Context) {
 			// Delete original and processed files from S3
 			h.audioStorage.Delete(c.Request.Context(), "uploads/"+job.AudioFileID)
 			h.audioStorage.Delete(c.Request.Context(), "processed/"+job.AudioFileID)
+			if job.OutputS3Key != "" {
+				h.audioStorage.Delete(c.Request.Context(), job.OutputS3Key)
+			}
 		}
 	}
 
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simonlewi/levelmix/core/internal/audio"
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// How many archived tasks are listed per queue
+const archivedTasksLimit = 200
+
+// AdminHandler serves operator pages for the processing queues. Requeued
+// jobs reserve processing time the way user retries do.
+type AdminHandler struct {
+	uploads *UploadHandler
+	queue   *audio.QueueManager
+}
+
+func NewAdminHandler(uploads *UploadHandler, q *audio.QueueManager) *AdminHandler {
+	return &AdminHandler{uploads: uploads, queue: q}
+}
+
+// RequireAdmin only lets through users whose email is listed in ADMIN_EMAILS
+// (comma-separated). It must run after the auth middleware.
+func RequireAdmin() gin.HandlerFunc {
+	admins := make(map[string]bool)
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			admins[email] = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		if userInterface, exists := c.Get("user"); exists {
+			if user, ok := userInterface.(*storage.User); ok && admins[strings.ToLower(user.Email)] {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatus(http.StatusNotFound)
+	}
+}
+
+// ShowArchivedTasks lists processing tasks that failed permanently or ran
+// out of retries
+func (h *AdminHandler) ShowArchivedTasks(c *gin.Context) {
+	tasks, err := h.queue.ArchivedTasks(archivedTasksLimit)
+	if err != nil {
+		log.Printf("ShowArchivedTasks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list archived tasks"})
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin-queue.html", GetTemplateData(c, gin.H{
+		"CurrentPage": "admin-queue",
+		"PageTitle":   "Archived tasks",
+		"tasks":       tasks,
+		"requeued":    c.Query("requeued"),
+		"skipped":     c.Query("skipped"),
+	}))
+}
+
+// RequeueArchivedTasks puts the selected archived tasks (form values
+// "queue:taskID"), or every archived task when all=true, back on their
+// queues. Each task's job is moved back to queued first so the worker
+// accepts it. Failed jobs gave their processing time back, so they reserve
+// it again; those whose user no longer has enough are skipped.
+func (h *AdminHandler) RequeueArchivedTasks(c *gin.Context) {
+	ctx := c.Request.Context()
+	metadata := h.uploads.metadata
+
+	selected := c.PostFormArray("task")
+	if c.PostForm("all") == "true" {
+		tasks, err := h.queue.ArchivedTasks(archivedTasksLimit)
+		if err != nil {
+			log.Printf("RequeueArchivedTasks: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list archived tasks"})
+			return
+		}
+		selected = selected[:0]
+		for _, t := range tasks {
+			selected = append(selected, t.Info.Queue+":"+t.Info.ID)
+		}
+	}
+
+	requeued, skipped := 0, 0
+	for _, entry := range selected {
+		queue, taskID, ok := strings.Cut(entry, ":")
+		if !ok {
+			continue
+		}
+
+		// Job IDs are the task IDs of processing tasks
+		job, err := metadata.GetJob(ctx, taskID)
+		reserved := false
+		if err == nil && job.Status != storage.JobQueued {
+			wasFailed := job.Status == storage.JobFailed
+			previousError, previousCategory := job.ErrorMessage, job.ErrorCategory
+			job.ErrorMessage = nil
+			job.ErrorCategory = nil
+			job.CompletedAt = nil
+			if err := storage.TransitionJob(ctx, metadata, job, storage.JobQueued, "requeued by admin"); err != nil {
+				// Cancelled and completed jobs stay archived
+				log.Printf("RequeueArchivedTasks: Not requeueing job %s: %v", taskID, err)
+				continue
+			}
+
+			if wasFailed {
+				offPeak := job.Spec != nil && job.Spec.OffPeak
+				if message := h.uploads.reserveRetry(ctx, job, offPeak); message != "" {
+					log.Printf("RequeueArchivedTasks: Skipping job %s: %s", taskID, message)
+					job.ErrorMessage, job.ErrorCategory = previousError, previousCategory
+					if err := storage.TransitionJob(ctx, metadata, job, storage.JobFailed, "not enough processing time to requeue"); err != nil {
+						log.Printf("RequeueArchivedTasks: Failed to restore failed status for %s: %v", taskID, err)
+					}
+					skipped++
+					continue
+				}
+				reserved = true
+			}
+
+			resetProgress(ctx, h.uploads.redisClient, job.AudioFileID)
+			if err := metadata.UpdateStatus(ctx, job.AudioFileID, storage.StatusQueued); err != nil {
+				log.Printf("RequeueArchivedTasks: Failed to update file status for %s: %v", job.AudioFileID, err)
+			}
+		}
+
+		if err := h.queue.RunArchivedTask(queue, taskID); err != nil {
+			log.Printf("RequeueArchivedTasks: Failed to run task %s in %s: %v", taskID, queue, err)
+			if reserved {
+				h.uploads.releaseProcessingTime(ctx, job.ID)
+			}
+			continue
+		}
+		requeued++
+	}
+
+	log.Printf("RequeueArchivedTasks: Requeued %d of %d archived tasks, skipped %d without enough processing time", requeued, len(selected), skipped)
+	location := "/admin/queue/archived?requeued=" + strconv.Itoa(requeued)
+	if skipped > 0 {
+		location += "&skipped=" + strconv.Itoa(skipped)
+	}
+	c.Redirect(http.StatusSeeOther, location)
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/simonlewi/levelmix/core/internal/audio"
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// errAlreadyConfirmed means a concurrent confirm created the file first
+var errAlreadyConfirmed = errors.New("This upload is already being confirmed. Please wait a moment.")
+
+// uploadOptions are the processing options sent with an upload
+type uploadOptions struct {
+	targetLUFS     float64
+	processingMode audio.ProcessingMode
+	sampleRate     int
+	processAfter   *time.Time
+	offPeak        bool
+	noiseReduction bool
+	preset         string
+	sha256         string // The client's digest of the upload, checked if set
+}
+
+// parseUploadOptions reads the processing options of an upload in format
+// through get, e.g. c.PostForm, and checks them against the user's tier. The
+// error is a message for the user.
+func (h *UploadHandler) parseUploadOptions(get func(string) string, user *storage.User, format string) (uploadOptions, error) {
+	isPremium := user.SubscriptionTier > 1
+
+	targetLUFS, err := h.parseTargetLUFS(get("target_lufs"))
+	if err != nil {
+		return uploadOptions{}, err
+	}
+
+	// Only Premium/Pro users can use custom values
+	if h.isCustomLUFS(targetLUFS) && !isPremium {
+		return uploadOptions{}, errors.New("Custom LUFS targets are only available for Premium and Professional users")
+	}
+
+	modeStr := get("processing_mode")
+	if modeStr == "" {
+		modeStr = "fast"
+	}
+	processingMode, err := audio.ValidateProcessingMode(modeStr)
+	if err != nil {
+		return uploadOptions{}, errors.New("Invalid processing mode selected")
+	}
+
+	// Empty keeps the default
+	sampleRate, err := h.parseSampleRate(get("sample_rate"), isPremium, format)
+	if err != nil {
+		return uploadOptions{}, err
+	}
+
+	// Optional start time for deferred processing
+	processAfter, err := parseProcessAfter(get("process_after"))
+	if err != nil {
+		return uploadOptions{}, err
+	}
+
+	digest := strings.ToLower(get("sha256"))
+	if digest != "" {
+		if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+			return uploadOptions{}, errors.New("Invalid SHA-256 checksum")
+		}
+	}
+
+	return uploadOptions{
+		targetLUFS:     targetLUFS,
+		processingMode: processingMode,
+		sampleRate:     sampleRate,
+		processAfter:   processAfter,
+		offPeak:        get("off_peak") == "true",
+		noiseReduction: get("noise_reduction") == "true",
+		preset:         get("preset"),
+		sha256:         digest,
+	}, nil
+}
+
+// confirmUpload starts processing the upload stored at fileID's upload key,
+// returning the job's ID. The error is errAlreadyConfirmed if the file
+// already has a job, or a message for the user.
+func (h *UploadHandler) confirmUpload(ctx context.Context, user *storage.User, fileID, filename string, opts uploadOptions) (string, error) {
+	fileFormat := getFileExtension(filename)
+
+	// Verify file actually exists in S3
+	info, err := h.storage.GetObjectInfo(ctx, h.storage.GetUploadKey(fileID, fileFormat))
+	if err != nil {
+		// A concurrent confirm may have filed the upload under its hash already
+		if _, getErr := h.metadata.GetAudioFile(ctx, fileID); getErr == nil {
+			return "", errAlreadyConfirmed
+		}
+
+		log.Printf("confirmUpload: Failed to verify S3 upload for %s: %v", fileID, err)
+		return "", errors.New("Upload verification failed. The file was not found in storage. If you selected this file from cloud storage (Google Drive, Dropbox, OneDrive, etc.), please download it to your device first and try again.")
+	}
+
+	log.Printf("confirmUpload: Verified S3 upload: %s (%d bytes)", fileID, info.Size)
+
+	userID := user.ID
+	return h.queueUpload(ctx, user, &storage.AudioFile{
+		ID:               fileID,
+		UserID:           &userID,
+		OriginalFilename: filename,
+		FileSize:         info.Size,
+		Format:           fileFormat,
+		Status:           storage.StatusUploaded,
+		LUFSTarget:       opts.targetLUFS,
+		CreatedAt:        time.Now(),
+	}, opts)
+}
+
+// queueUpload starts processing file, whose original has been stored at its
+// upload key. If file.ContentHash was computed while storing it, the
+// original is moved to its content key, so identical uploads share one
+// object, and output the user already has for the same content and options
+// is reused; otherwise the worker does both as it downloads the original,
+// so confirming stays cheap however large the file. Unless reused, processing time is reserved and a
+// job queued. It returns the job's ID, or an error whose message is for the
+// user.
+func (h *UploadHandler) queueUpload(ctx context.Context, user *storage.User, file *storage.AudioFile, opts uploadOptions) (string, error) {
+	// The original stays at its upload key, where it is measured, until it
+	// is moved to its content key when the file is recorded
+	hash := file.ContentHash
+	file.ContentHash = ""
+	if hash != "" && opts.sha256 != "" && opts.sha256 != hash {
+		log.Printf("queueUpload: Upload %s has SHA-256 %s, client sent %s", file.ID, hash, opts.sha256)
+		h.storage.Delete(ctx, h.storage.GetUploadKey(file.ID, file.Format))
+		return "", errors.New("The uploaded file doesn't match its checksum and may have been corrupted. Please upload it again.")
+	}
+
+	task := audio.ProcessTask{
+		JobID:          generateID(),
+		FileID:         file.ID,
+		TargetLUFS:     opts.targetLUFS,
+		Preset:         opts.preset,
+		UserID:         user.ID,
+		IsPremium:      user.SubscriptionTier > 1,
+		ProcessingMode: opts.processingMode,
+		NoiseReduction: opts.noiseReduction,
+		SampleRate:     opts.sampleRate,
+		ProcessAfter:   opts.processAfter,
+		OffPeak:        opts.offPeak,
+		SHA256:         opts.sha256,
+	}
+	h.scheduling.Schedule(&task, time.Now())
+	spec := task.Spec()
+
+	// Without a hash the worker looks for output to reuse
+	if hash != "" {
+		job := &storage.ProcessingJob{
+			ID:          generateID(),
+			AudioFileID: file.ID,
+			UserID:      user.ID,
+			TargetLUFS:  &spec.TargetLUFS,
+			Spec:        &spec,
+			CreatedAt:   time.Now(),
+		}
+		if earlier, earlierFile := audio.ReuseOutput(ctx, h.storage, h.metadata, job, hash, spec); earlier != nil {
+			return h.reuseOutput(ctx, file, hash, earlierFile, earlier, job)
+		}
+	}
+
+	// Reserve the file's processing time before anything is queued. An
+	// upload that is over the limit or can't be measured is dropped; one that
+	// failed to reserve for another reason is kept for confirming again.
+	if err := h.reserveProcessingTime(ctx, user, task.JobID, file, task.OffPeak); err != nil {
+		if !errors.Is(err, errUsageUnavailable) {
+			h.deleteOriginal(ctx, file)
+		}
+		return "", err
+	}
+	task.DurationSeconds = fileDuration(file)
+
+	if err := h.saveAudioFile(ctx, file, hash); err != nil {
+		h.releaseProcessingTime(ctx, task.JobID)
+		return "", err
+	}
+
+	job := &storage.ProcessingJob{
+		ID:          task.JobID,
+		AudioFileID: file.ID,
+		UserID:      user.ID,
+		Status:      storage.JobQueued,
+		TargetLUFS:  &task.TargetLUFS,
+		Spec:        &spec,
+		CreatedAt:   time.Now(),
+	}
+
+	log.Printf("queueUpload: Creating job record for file %s with jobID %s and UserID '%s'", file.ID, job.ID, job.UserID)
+
+	if err := h.metadata.CreateJob(ctx, job); err != nil {
+		log.Printf("queueUpload: Failed to create job record for %s: %v", file.ID, err)
+		h.releaseProcessingTime(ctx, job.ID)
+		h.cleanup(ctx, file)
+		return "", errors.New("Failed to create processing job")
+	}
+
+	log.Printf("queueUpload: Enqueueing processing task for job %s", job.ID)
+	if err := h.queue.EnqueueProcessing(ctx, task); err != nil {
+		log.Printf("queueUpload: Failed to queue processing task for job %s: %v", job.ID, err)
+		h.releaseProcessingTime(ctx, job.ID)
+		h.cleanup(ctx, file)
+		return "", errors.New("Failed to queue processing")
+	}
+
+	h.countUpload(ctx, user.ID)
+	return job.ID, nil
+}
+
+// reuseOutput records file, with content hash hash, and job, which
+// completed at once with a copy of the output of earlier, a job on
+// earlierFile. Nothing is processed, so nothing is charged.
+func (h *UploadHandler) reuseOutput(ctx context.Context, file *storage.AudioFile, hash string, earlierFile *storage.AudioFile, earlier, job *storage.ProcessingJob) (string, error) {
+	file.Status = storage.StatusCompleted
+	file.DurationSeconds = earlierFile.DurationSeconds
+
+	if err := h.saveAudioFile(ctx, file, hash); err != nil {
+		h.storage.Delete(ctx, job.OutputS3Key)
+		return "", err
+	}
+
+	job.Status = storage.JobCompleted
+	job.StartedAt = job.CompletedAt
+	if err := h.metadata.CreateJob(ctx, job); err != nil {
+		log.Printf("reuseOutput: Failed to create job record for %s: %v", file.ID, err)
+		h.storage.Delete(ctx, job.OutputS3Key)
+		h.cleanup(ctx, file)
+		return "", errors.New("Failed to create processing job")
+	}
+
+	if h.redisClient != nil {
+		if err := audio.PublishProgress(ctx, h.redisClient, file.ID, 100, storage.StatusCompleted, 0); err != nil {
+			log.Printf("reuseOutput: Failed to publish completion of %s: %v", file.ID, err)
+		}
+	}
+
+	log.Printf("reuseOutput: File %s has the same content and options as file %s, reusing output of job %s",
+		file.ID, earlierFile.ID, earlier.ID)
+	h.countUpload(ctx, *file.UserID)
+	return job.ID, nil
+}
+
+// saveAudioFile records file. An original whose content hash is known is
+// moved to its content key along with it; others are left to the worker.
+// The error is errAlreadyConfirmed if a concurrent confirm saved it first,
+// or a message for the user.
+func (h *UploadHandler) saveAudioFile(ctx context.Context, file *storage.AudioFile, hash string) error {
+	create := func() error { return h.metadata.CreateAudioFile(ctx, file) }
+	var err error
+	if hash == "" {
+		err = create()
+	} else {
+		err = storage.StoreOriginal(ctx, h.storage, h.metadata, file, hash, create)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			return errAlreadyConfirmed
+		}
+		log.Printf("saveAudioFile: Failed to store %s: %v", file.ID, err)
+		h.deleteOriginal(ctx, file)
+		return errors.New("Failed to save file metadata")
+	}
+	return nil
+}
+
+// deleteOriginal deletes file's original unless another file shares it
+func (h *UploadHandler) deleteOriginal(ctx context.Context, file *storage.AudioFile) {
+	if _, err := storage.DeleteOriginal(ctx, h.storage, h.metadata, file); err != nil {
+		log.Printf("deleteOriginal: Failed to delete original of %s: %v", file.ID, err)
+	}
+}
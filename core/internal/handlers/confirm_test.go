@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// queueTestUpload stores data as the original of a new file and queues it
+// with the default options
+func queueTestUpload(t *testing.T, env *testEnv, user *storage.User, data []byte) (*storage.AudioFile, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	file := &storage.AudioFile{
+		ID:               generateID(),
+		UserID:           &user.ID,
+		OriginalFilename: "mix.wav",
+		FileSize:         int64(len(data)),
+		Format:           "wav",
+		Status:           storage.StatusUploaded,
+	}
+	if err := env.storage.Upload(ctx, file.ID, bytes.NewReader(data), file.Format); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	file.ContentHash = hex.EncodeToString(sum[:])
+
+	opts, err := env.handler.parseUploadOptions(func(string) string { return "" }, user, file.Format)
+	if err != nil {
+		t.Fatalf("parseUploadOptions: %v", err)
+	}
+	jobID, err := env.handler.queueUpload(ctx, user, file, opts)
+	if err != nil {
+		t.Fatalf("queueUpload: %v", err)
+	}
+	return file, jobID
+}
+
+func TestReusedOutputOutlivesEarlierFile(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	user := env.createUser(t, 3)
+	data := testAudio(4096)
+
+	// The first upload is processed
+	first, firstJobID := queueTestUpload(t, env, user, data)
+	job, err := env.metadata.GetJob(ctx, firstJobID)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if err := env.storage.UploadProcessed(ctx, first.ID, job.ID, strings.NewReader("rendered"), "wav"); err != nil {
+		t.Fatalf("UploadProcessed: %v", err)
+	}
+	job.Status = storage.JobCompleted
+	job.OutputFormat = "wav"
+	job.OutputS3Key = env.storage.GetJobProcessedKey(first.ID, job.ID, "wav")
+	if err := env.metadata.UpdateJob(ctx, job); err != nil {
+		t.Fatalf("UpdateJob: %v", err)
+	}
+
+	// The same content with the same options reuses it without processing
+	second, secondJobID := queueTestUpload(t, env, user, data)
+	if tasks := env.queue.queued(); len(tasks) != 1 {
+		t.Fatalf("expected only the first upload to be queued, got %d tasks", len(tasks))
+	}
+	reused, err := env.metadata.GetJob(ctx, secondJobID)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if reused.Status != storage.JobCompleted || reused.ParentJobID == nil || *reused.ParentJobID != job.ID {
+		t.Fatalf("expected a completed job reusing %s, got %+v", job.ID, reused)
+	}
+	if reused.OutputS3Key == job.OutputS3Key {
+		t.Fatalf("reused job shares the output key %s", job.OutputS3Key)
+	}
+
+	// Deleting the first file's output leaves the second's
+	if err := env.storage.Delete(ctx, job.OutputS3Key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	r, err := env.storage.Download(ctx, reused.OutputS3Key)
+	if err != nil {
+		t.Fatalf("output of %s is gone with the first file's: %v", second.ID, err)
+	}
+	defer r.Close()
+	if got, _ := io.ReadAll(r); string(got) != "rendered" {
+		t.Errorf("reused output = %q, want %q", got, "rendered")
+	}
+}
+
+func TestQueueUploadKeepsOriginalWhenUsageUnavailable(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	user := env.createUser(t, 3)
+	data := testAudio(4096)
+	duration := 60
+
+	file := &storage.AudioFile{
+		ID:               generateID(),
+		UserID:           &user.ID,
+		OriginalFilename: "mix.wav",
+		FileSize:         int64(len(data)),
+		Format:           "wav",
+		Status:           storage.StatusUploaded,
+		DurationSeconds:  &duration,
+	}
+	if err := env.storage.Upload(ctx, file.ID, bytes.NewReader(data), file.Format); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	opts, err := env.handler.parseUploadOptions(func(string) string { return "" }, user, file.Format)
+	if err != nil {
+		t.Fatalf("parseUploadOptions: %v", err)
+	}
+
+	// The usage ledger can't be reached, which is worth trying again
+	env.metadata.Close()
+	if _, err := env.handler.queueUpload(ctx, user, file, opts); !errors.Is(err, errUsageUnavailable) {
+		t.Fatalf("queueUpload = %v, want errUsageUnavailable", err)
+	}
+	if _, err := env.storage.GetObjectInfo(ctx, env.storage.GetUploadKey(file.ID, file.Format)); err != nil {
+		t.Fatalf("original was deleted: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"time"
@@ -92,10 +93,15 @@ func (h *CookieHandler) GetLatestConsent(c *gin.Context) {
 	}
 
 	record, err := h.metadata.GetLatestConsent(c.Request.Context(), userID)
-	if err != nil {
+	if errors.Is(err, storage.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "No consent record found"})
 		return
 	}
+	if err != nil {
+		log.Printf("GetLatestConsent: Failed to get consent for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve consent"})
+		return
+	}
 
 	consent := CookieConsent{
 		Essential:  record.Essential,
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"time"
@@ -29,36 +30,25 @@ func (h *DashboardHandler) ShowDashboard(c *gin.Context) {
 
 	user := userInterface.(*storage.User)
 
-	// Get user stats
+	// Get user stats; users without any haven't uploaded yet
 	stats, err := h.metadata.GetUserStats(c.Request.Context(), user.ID)
 	if err != nil {
-		// Create default stats if not found
-		stats = &storage.UserUploadStats{
-			UserID:                     user.ID,
-			ProcessingTimeThisMonth:    0,
-			MonthResetAt:               getMonthStart(time.Now()),
-			TotalUploads:               0,
-			TotalProcessingTimeSeconds: 0,
-		}
-		// Attempt to create the default stats in DB
-		if err := h.metadata.UpdateUserStats(c.Request.Context(), stats); err != nil {
-			log.Printf("Dashboard: Failed to create initial user stats for %s: %v", user.ID, err)
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Dashboard: Failed to load user stats for %s: %v", user.ID, err)
 		}
+		stats = &storage.UserUploadStats{UserID: user.ID}
 	}
 
-	// Ensure monthly stats are up-to-date before displaying
-	now := time.Now()
-	currentMonthStart := getMonthStart(now)
-
-	if stats.MonthResetAt.Before(currentMonthStart) {
-		log.Printf("Dashboard: Monthly reset triggered for user %s. Old reset: %v, New reset: %v",
-			user.ID, stats.MonthResetAt, currentMonthStart)
-		stats.ProcessingTimeThisMonth = 0
-		stats.MonthResetAt = currentMonthStart
-		// Update in DB if reset occurred
-		if err := h.metadata.UpdateUserStats(c.Request.Context(), stats); err != nil {
-			log.Printf("Dashboard: Failed to update user stats on monthly reset: %v", err)
-		}
+	// Processing time this month and overall, from the usage ledger
+	monthUsage, err := h.metadata.GetUsage(c.Request.Context(), user.ID, storage.UsagePeriod(time.Now()))
+	if err != nil {
+		log.Printf("Dashboard: Failed to load usage for %s: %v", user.ID, err)
+		monthUsage = &storage.Usage{}
+	}
+	totalUsage, err := h.metadata.GetUsage(c.Request.Context(), user.ID, "")
+	if err != nil {
+		log.Printf("Dashboard: Failed to load total usage for %s: %v", user.ID, err)
+		totalUsage = &storage.Usage{}
 	}
 
 	// Get recent jobs
@@ -76,12 +66,23 @@ func (h *DashboardHandler) ShowDashboard(c *gin.Context) {
 		}
 		if err == nil {
 			jobData["file"] = audioFile
+			jobData["canReprocess"] = job.Status == storage.JobCompleted && canReprocess(audioFile)
 		}
 		// Add dereferenced TargetLUFS for template
 		if job.TargetLUFS != nil {
 			jobData["targetLUFS"] = *job.TargetLUFS
 			jobData["hasTargetLUFS"] = true
 		}
+		// Deferred jobs show when they will start
+		if job.Status == storage.JobQueued && job.Spec != nil {
+			if job.Spec.ProcessAfter != nil && job.Spec.ProcessAfter.After(time.Now()) {
+				jobData["scheduledFor"] = job.Spec.ProcessAfter.UTC()
+			}
+			jobData["offPeak"] = job.Spec.OffPeak
+		}
+		if events, err := h.metadata.GetJobEvents(c.Request.Context(), job.ID); err == nil {
+			jobData["events"] = events
+		}
 		jobsWithFiles = append(jobsWithFiles, jobData)
 	}
 
@@ -92,7 +93,7 @@ func (h *DashboardHandler) ShowDashboard(c *gin.Context) {
 	// Calculate processing time remaining
 	processingTimeRemaining := processingTimeLimit
 	if processingTimeLimit > 0 {
-		processingTimeRemaining = processingTimeLimit - stats.ProcessingTimeThisMonth
+		processingTimeRemaining = processingTimeLimit - monthUsage.Seconds
 		if processingTimeRemaining < 0 {
 			processingTimeRemaining = 0
 		}
@@ -101,7 +102,7 @@ func (h *DashboardHandler) ShowDashboard(c *gin.Context) {
 	// Calculate processing time percentage for progress bar
 	processingTimePercent := 0
 	if processingTimeLimit > 0 {
-		processingTimePercent = int((float64(stats.ProcessingTimeThisMonth) / float64(processingTimeLimit)) * 100)
+		processingTimePercent = int((float64(monthUsage.Seconds) / float64(processingTimeLimit)) * 100)
 		if processingTimePercent > 100 {
 			processingTimePercent = 100
 		}
@@ -116,9 +117,9 @@ func (h *DashboardHandler) ShowDashboard(c *gin.Context) {
 		"tierName":                       tierName,
 		"processingTimeLimit":            processingTimeLimit,
 		"processingTimeRemaining":        formatDuration(processingTimeRemaining),
-		"processingTimeUsed":             formatDuration(stats.ProcessingTimeThisMonth),
+		"processingTimeUsed":             formatDuration(monthUsage.Seconds),
 		"processingTimeTotal":            formatDurationAsHours(processingTimeLimit),
-		"processingTime":                 formatDuration(stats.TotalProcessingTimeSeconds),
+		"processingTime":                 formatDuration(totalUsage.AudioSeconds),
 		"processingTimeRemainingSeconds": processingTimeRemaining,
 		"processingTimePercent":          processingTimePercent,
 	}))
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -56,24 +57,28 @@ func (h *DownloadHandler) HandleDownload(c *gin.Context) {
 	fileID := c.Param("id")
 
 	audioFile, err := h.metadata.GetAudioFile(c.Request.Context(), fileID)
-	if err != nil {
-		log.Printf("Failed to get audio file %s: %v", fileID, err)
+	if errors.Is(err, storage.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
-
-	if audioFile.Status != "completed" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File not ready"})
+	if err != nil {
+		log.Printf("Failed to get audio file %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve file information"})
 		return
 	}
 
-	// Get job information to determine output format
-	job, err := h.metadata.GetJobByFileID(c.Request.Context(), fileID)
+	// Get job information to determine output format; ?job= picks one of
+	// several results when the file was re-processed
+	job, err := h.downloadJob(c, fileID)
 	if err != nil {
 		log.Printf("Failed to get job for file %s: %v", fileID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve processing information"})
 		return
 	}
+	if job == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File not ready"})
+		return
+	}
 
 	// Determine output format with proper fallback
 	outputFormat := "mp3" // Default fallback
@@ -110,7 +115,10 @@ func (h *DownloadHandler) HandleDownload(c *gin.Context) {
 	log.Printf("Initiating download for file %s, format: %s, filename: %s", fileID, outputFormat, downloadFilename)
 
 	// Try presigned URL first (best performance)
-	processedKey := h.storage.GetProcessedKey(fileID, outputFormat)
+	processedKey := job.OutputS3Key
+	if processedKey == "" {
+		processedKey = h.storage.GetProcessedKey(fileID, outputFormat)
+	}
 
 	presignedURL, err := h.storage.GetPresignedDownloadURL(c.Request.Context(), processedKey, downloadFilename, contentType, 1*time.Hour)
 	if err == nil {
@@ -121,14 +129,41 @@ func (h *DownloadHandler) HandleDownload(c *gin.Context) {
 	log.Printf("Presigned URL generation failed, falling back to direct download: %v", err)
 
 	// Fallback to direct download with proper headers
-	h.directDownload(c, fileID, downloadFilename, contentType, outputFormat)
+	h.directDownload(c, fileID, processedKey, downloadFilename, contentType)
 }
 
-func (h *DownloadHandler) directDownload(c *gin.Context, fileID, filename, contentType, outputFormat string) {
-	log.Printf("Using direct download for file %s", fileID)
+// downloadJob returns the job named by the job query parameter, or the
+// file's most recently created completed job. It returns nil if that job
+// has no output yet.
+func (h *DownloadHandler) downloadJob(c *gin.Context, fileID string) (*storage.ProcessingJob, error) {
+	if jobID := c.Query("job"); jobID != "" {
+		job, err := h.metadata.GetJob(c.Request.Context(), jobID)
+		if err != nil {
+			return nil, err
+		}
+		if job.AudioFileID != fileID {
+			return nil, fmt.Errorf("job %s does not belong to file %s: %w", jobID, fileID, storage.ErrNotFound)
+		}
+		if job.Status != storage.JobCompleted {
+			return nil, nil
+		}
+		return job, nil
+	}
+
+	jobs, err := h.metadata.GetJobsByFileID(c.Request.Context(), fileID)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if job.Status == storage.JobCompleted {
+			return job, nil
+		}
+	}
+	return nil, nil
+}
 
-	// Get the correct S3 key
-	processedKey := h.storage.GetProcessedKey(fileID, outputFormat)
+func (h *DownloadHandler) directDownload(c *gin.Context, fileID, processedKey, filename, contentType string) {
+	log.Printf("Using direct download for file %s", fileID)
 
 	// Get file reader
 	reader, err := h.storage.Download(c.Request.Context(), processedKey)
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/simonlewi/levelmix/core/internal/audio"
+	"github.com/simonlewi/levelmix/pkg/storage"
+	"github.com/simonlewi/levelmix/pkg/storage/localfs"
+	"github.com/simonlewi/levelmix/pkg/storage/sqlite"
+)
+
+func TestMain(m *testing.M) {
+	// Jobs of unlimited tiers are queued without measuring the file, which
+	// needs ffprobe
+	os.Setenv("TIER_PROCESSING_HOURS", "3:-1")
+	os.Exit(m.Run())
+}
+
+// testUserHeader names the user a test request is made as
+const testUserHeader = "X-Test-User"
+
+// testEnv is an UploadHandler backed by SQLite and local file storage, with
+// routes mounted on an httptest server
+type testEnv struct {
+	handler  *UploadHandler
+	queue    *fakeQueue
+	metadata *sqlite.Storage
+	storage  *localfs.Storage
+	router   *gin.Engine
+	server   *httptest.Server
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	metadata, err := sqlite.NewStorage(filepath.Join(t.TempDir(), "levelmix.db"))
+	if err != nil {
+		t.Fatalf("sqlite.NewStorage: %v", err)
+	}
+	t.Cleanup(func() { metadata.Close() })
+	if _, err := metadata.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	r := gin.New()
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	files, err := localfs.NewStorage(t.TempDir(), srv.URL+"/files", []byte("test-secret"))
+	if err != nil {
+		t.Fatalf("localfs.NewStorage: %v", err)
+	}
+	r.GET("/files/*key", files.Handler())
+	r.PUT("/files/*key", files.Handler())
+
+	// Stands in for RequireAuth: requests run as the user named in the header
+	r.Use(func(c *gin.Context) {
+		if id := c.GetHeader(testUserHeader); id != "" {
+			if user, err := metadata.GetUser(c.Request.Context(), id); err == nil {
+				c.Set("user", user)
+			}
+		}
+		c.Next()
+	})
+
+	queue := &fakeQueue{}
+	handler := NewUploadHandler(files, metadata, nil, "")
+	handler.queue = queue
+
+	return &testEnv{
+		handler:  handler,
+		queue:    queue,
+		metadata: metadata,
+		storage:  files,
+		router:   r,
+		server:   srv,
+	}
+}
+
+// createUser adds a user of the given tier
+func (e *testEnv) createUser(t *testing.T, tier int) *storage.User {
+	t.Helper()
+	user := &storage.User{
+		ID:               uuid.New().String(),
+		Email:            uuid.New().String() + "@example.com",
+		AuthProvider:     "email",
+		SubscriptionTier: tier,
+	}
+	if err := e.metadata.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+// do sends a request to the test server as user, if set
+func (e *testEnv) do(t *testing.T, method, path string, user *storage.User, body io.Reader, header http.Header) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, e.server.URL+path, body)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if user != nil {
+		req.Header.Set(testUserHeader, user.ID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// fakeQueue records the tasks it is given
+type fakeQueue struct {
+	mu    sync.Mutex
+	tasks []audio.ProcessTask
+}
+
+func (q *fakeQueue) EnqueueProcessing(ctx context.Context, task audio.ProcessTask) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.tasks = append(q.tasks, task)
+	return nil
+}
+
+func (q *fakeQueue) RequeueProcessing(ctx context.Context, task audio.ProcessTask) error {
+	return q.EnqueueProcessing(ctx, task)
+}
+
+func (q *fakeQueue) QueuePosition(jobID string) (*audio.QueueEstimate, error) {
+	return &audio.QueueEstimate{}, nil
+}
+
+func (q *fakeQueue) queued() []audio.ProcessTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]audio.ProcessTask(nil), q.tasks...)
+}
+
+// newFakeRedis starts a server speaking just enough RESP for the progress
+// hash and pub/sub: hashes are always empty and subscriptions never receive
+// messages
+func newFakeRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeRedis(conn)
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{Addr: ln.Addr().String(), DisableIdentity: true})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func serveFakeRedis(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "HELLO":
+			reply = "-ERR unknown command 'HELLO'\r\n"
+		case "PING":
+			reply = "+PONG\r\n"
+		case "CLIENT", "SELECT":
+			reply = "+OK\r\n"
+		case "HGETALL":
+			reply = "*0\r\n"
+		case "SUBSCRIBE":
+			for _, ch := range args[1:] {
+				reply += fmt.Sprintf("*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(ch), ch)
+			}
+		default:
+			reply = ":1\r\n"
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readRESPCommand reads one command sent as an array of bulk strings
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("bad array length %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("bad bulk length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
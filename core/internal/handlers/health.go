@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"os"
 	"time"
@@ -72,7 +73,7 @@ func (h *HealthHandler) HealthCheck(c *gin.Context) {
 
 func (h *HealthHandler) checkDatabase(ctx context.Context) error {
 	_, err := h.metadata.GetUser(ctx, "health-check-non-existent-user")
-	if err != nil && err.Error() != "sql: no rows in result set" {
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
 		return err
 	}
 	return nil
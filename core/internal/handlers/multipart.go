@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// multipartPartSize is the part size of resumable uploads: a failed part
+// costs at most this much to send again
+const multipartPartSize = 8 << 20
+
+// multipartInfo is the info object of a resumable upload, stored next to it
+// when it starts: whose it is and the size it was checked against
+type multipartInfo struct {
+	UserID   string `json:"user_id"`
+	UploadID string `json:"upload_id"` // The storage's multipart upload
+	Format   string `json:"format"`
+	Size     int64  `json:"size"`
+	PartSize int64  `json:"part_size"`
+}
+
+// partCount is how many parts the declared size takes
+func (m *multipartInfo) partCount() int64 {
+	return (m.Size + m.PartSize - 1) / m.PartSize
+}
+
+// StartMultipartUpload starts a resumable upload, sent in parts straight to
+// storage. The client asks for a URL per part, can list the parts stored so
+// far to resume after a failure, and finishes with CompleteMultipartUpload.
+// Only the user who started it can do any of these.
+func (h *UploadHandler) StartMultipartUpload(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required. Please log in to upload files."})
+		return
+	}
+
+	currentUser, ok := userInterface.(*storage.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session. Please log in again."})
+		return
+	}
+
+	filename := c.PostForm("filename")
+	filesize, err := strconv.ParseInt(c.PostForm("filesize"), 10, 64)
+	if filename == "" || err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Filename and file size are required"})
+		return
+	}
+
+	if err := h.checkUploadLimits(c, currentUser); err != nil {
+		log.Printf("StartMultipartUpload: Upload limit check failed: %v", err)
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.validateFile(&multipart.FileHeader{Filename: filename, Size: filesize}, currentUser.SubscriptionTier); err != nil {
+		log.Printf("StartMultipartUpload: File validation failed: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fileFormat := getFileExtension(filename)
+	if fileFormat == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not determine audio format from file extension"})
+		return
+	}
+
+	// Parts must fit within the storage's part count
+	partSize := int64(multipartPartSize)
+	if minSize := (filesize + storage.MaxParts - 1) / storage.MaxParts; minSize > partSize {
+		partSize = minSize
+	}
+
+	fileID := generateID()
+	key := h.storage.GetUploadKey(fileID, fileFormat)
+	uploadID, err := h.storage.CreateMultipartUpload(c.Request.Context(), key, getContentTypeFromFormat(fileFormat))
+	if err != nil {
+		log.Printf("StartMultipartUpload: Failed to start upload of %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start upload"})
+		return
+	}
+
+	info := &multipartInfo{
+		UserID:   currentUser.ID,
+		UploadID: uploadID,
+		Format:   fileFormat,
+		Size:     filesize,
+		PartSize: partSize,
+	}
+	if err := h.saveMultipartInfo(c.Request.Context(), fileID, info); err != nil {
+		log.Printf("StartMultipartUpload: Failed to save upload %s of %s: %v", uploadID, fileID, err)
+		h.abortMultipart(c, key, uploadID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start upload"})
+		return
+	}
+
+	log.Printf("StartMultipartUpload: User %s started upload %s of file %s (%d bytes)", currentUser.ID, uploadID, fileID, filesize)
+
+	c.JSON(http.StatusOK, gin.H{
+		"file_id":    fileID,
+		"upload_id":  uploadID,
+		"part_size":  partSize,
+		"part_count": info.partCount(),
+	})
+}
+
+// GetMultipartPartURL returns the URL to PUT one part of a resumable upload
+// to. Sending a part again replaces it.
+func (h *UploadHandler) GetMultipartPartURL(c *gin.Context) {
+	key, info, ok := h.multipartKey(c, c.Query("file_id"), c.Query("filename"), c.Query("upload_id"))
+	if !ok {
+		return
+	}
+
+	partNumber, err := strconv.Atoi(c.Query("part"))
+	if err != nil || partNumber < 1 || int64(partNumber) > info.partCount() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid part number"})
+		return
+	}
+
+	url, err := h.storage.GetPresignedPartURL(c.Request.Context(), key, info.UploadID, partNumber, info.PartSize, 15*time.Minute)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found. It may have expired, please start again."})
+		return
+	}
+	if err != nil {
+		log.Printf("GetMultipartPartURL: Failed to sign part %d of %s: %v", partNumber, key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate upload URL"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"upload_url": url, "method": "PUT"})
+}
+
+// ListMultipartParts lists the parts of a resumable upload stored so far, so
+// a client resuming it sends only the rest
+func (h *UploadHandler) ListMultipartParts(c *gin.Context) {
+	key, info, ok := h.multipartKey(c, c.Query("file_id"), c.Query("filename"), c.Query("upload_id"))
+	if !ok {
+		return
+	}
+
+	parts, err := h.storage.ListParts(c.Request.Context(), key, info.UploadID)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found. It may have expired, please start again."})
+		return
+	}
+	if err != nil {
+		log.Printf("ListMultipartParts: Failed to list parts of %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list uploaded parts"})
+		return
+	}
+
+	stored := make([]gin.H, 0, len(parts))
+	for _, part := range parts {
+		stored = append(stored, gin.H{"part_number": part.PartNumber, "size": part.Size})
+	}
+	c.JSON(http.StatusOK, gin.H{"parts": stored})
+}
+
+// CompleteMultipartUpload joins the stored parts of a resumable upload into
+// the file, then confirms it exactly as ConfirmUpload does, taking the same
+// form fields plus upload_id. Completing again after a lost response just
+// confirms.
+func (h *UploadHandler) CompleteMultipartUpload(c *gin.Context) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		h.returnError(c, "Authentication required. Please log in to upload files.")
+		return
+	}
+
+	currentUser, ok := userInterface.(*storage.User)
+	if !ok {
+		h.returnError(c, "Invalid user session. Please log in again.")
+		return
+	}
+
+	fileID := c.PostForm("file_id")
+	filename := c.PostForm("filename")
+	if !validFileID(fileID) || getFileExtension(filename) == "" {
+		h.returnError(c, "Missing file ID or filename")
+		return
+	}
+	key := h.storage.GetUploadKey(fileID, getFileExtension(filename))
+	uploadID := c.PostForm("upload_id")
+	ctx := c.Request.Context()
+
+	info, err := h.loadMultipartInfo(ctx, currentUser, fileID, getFileExtension(filename), uploadID)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("CompleteMultipartUpload: Failed to read upload %s of %s: %v", uploadID, fileID, err)
+		}
+		h.returnError(c, "Upload not found. It may have expired, please start again.")
+		return
+	}
+
+	parts, err := h.storage.ListParts(ctx, key, uploadID)
+	if errors.Is(err, storage.ErrNotFound) {
+		// Already completed: ConfirmUpload finds the file or says it's missing
+		h.ConfirmUpload(c)
+		return
+	}
+	if err != nil {
+		log.Printf("CompleteMultipartUpload: Failed to list parts of %s: %v", key, err)
+		h.returnError(c, "Failed to complete upload. Please try again.")
+		return
+	}
+
+	// Part sizes aren't checked as they are sent, so check the total, and
+	// that the parts fit the size the upload was started with
+	var size int64
+	for _, part := range parts {
+		size += part.Size
+	}
+	err = h.validateFile(&multipart.FileHeader{Filename: filename, Size: size}, currentUser.SubscriptionTier)
+	if err == nil && (int64(len(parts)) > info.partCount() || size > info.Size) {
+		err = fmt.Errorf("The upload is larger than the %d bytes it was started with. Please start again.", info.Size)
+	}
+	if err != nil {
+		log.Printf("CompleteMultipartUpload: File validation failed for %s: %v", fileID, err)
+		h.abortMultipart(c, key, uploadID)
+		h.returnError(c, err.Error())
+		return
+	}
+
+	if err := h.storage.CompleteMultipartUpload(ctx, key, uploadID, parts); err != nil {
+		log.Printf("CompleteMultipartUpload: Failed to complete upload %s of %s: %v", uploadID, fileID, err)
+		h.returnError(c, "Failed to complete upload. Please try again.")
+		return
+	}
+
+	log.Printf("CompleteMultipartUpload: Completed upload of %s from %d parts (%d bytes)", fileID, len(parts), size)
+	h.ConfirmUpload(c)
+}
+
+// AbortMultipartUpload discards a resumable upload the client gave up on
+func (h *UploadHandler) AbortMultipartUpload(c *gin.Context) {
+	key, info, ok := h.multipartKey(c, c.PostForm("file_id"), c.PostForm("filename"), c.PostForm("upload_id"))
+	if !ok {
+		return
+	}
+	h.abortMultipart(c, key, info.UploadID)
+	c.JSON(http.StatusOK, gin.H{"status": "aborted"})
+}
+
+func (h *UploadHandler) abortMultipart(c *gin.Context, key, uploadID string) {
+	if err := h.storage.AbortMultipartUpload(c.Request.Context(), key, uploadID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("abortMultipart: Failed to abort upload %s of %s: %v", uploadID, key, err)
+	}
+}
+
+// multipartKey is the key a resumable upload of filename is stored at, and
+// its info, answering the request itself if the parameters are invalid or
+// the upload isn't the user's
+func (h *UploadHandler) multipartKey(c *gin.Context, fileID, filename, uploadID string) (string, *multipartInfo, bool) {
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required. Please log in to upload files."})
+		return "", nil, false
+	}
+	currentUser, ok := userInterface.(*storage.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session. Please log in again."})
+		return "", nil, false
+	}
+
+	fileFormat := getFileExtension(filename)
+	if !validFileID(fileID) || fileFormat == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file ID or filename"})
+		return "", nil, false
+	}
+
+	info, err := h.loadMultipartInfo(c.Request.Context(), currentUser, fileID, fileFormat, uploadID)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found. It may have expired, please start again."})
+		return "", nil, false
+	}
+	if err != nil {
+		log.Printf("multipartKey: Failed to read upload %s of %s: %v", uploadID, fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read upload"})
+		return "", nil, false
+	}
+	return h.storage.GetUploadKey(fileID, fileFormat), info, true
+}
+
+// loadMultipartInfo reads the info of upload uploadID of fileID. Uploads of
+// other users, or of another format, are ErrNotFound.
+func (h *UploadHandler) loadMultipartInfo(ctx context.Context, user *storage.User, fileID, format, uploadID string) (*multipartInfo, error) {
+	r, err := h.storage.Download(ctx, h.multipartInfoKey(fileID))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var info multipartInfo
+	if err := json.NewDecoder(r).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode upload of %s: %w", fileID, err)
+	}
+	if info.UserID != user.ID || info.UploadID != uploadID || info.Format != format || info.PartSize <= 0 {
+		return nil, fmt.Errorf("upload %s of %s: %w", uploadID, fileID, storage.ErrNotFound)
+	}
+	return &info, nil
+}
+
+func (h *UploadHandler) saveMultipartInfo(ctx context.Context, fileID string, info *multipartInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return h.storage.Upload(ctx, "multipart/"+fileID, bytes.NewReader(data), "info")
+}
+
+// multipartInfoKey is the key of the info object of fileID's upload
+func (h *UploadHandler) multipartInfoKey(fileID string) string {
+	return h.storage.GetUploadKey("multipart/"+fileID, "info")
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// newMultipartEnv mounts the resumable upload routes on a test environment
+func newMultipartEnv(t *testing.T) *testEnv {
+	t.Helper()
+	env := newTestEnv(t)
+	env.router.POST("/api/multipart-upload", env.handler.StartMultipartUpload)
+	env.router.GET("/api/multipart-upload/part-url", env.handler.GetMultipartPartURL)
+	env.router.GET("/api/multipart-upload/parts", env.handler.ListMultipartParts)
+	env.router.POST("/api/multipart-upload/complete", env.handler.CompleteMultipartUpload)
+	env.router.POST("/api/multipart-upload/abort", env.handler.AbortMultipartUpload)
+	return env
+}
+
+// multipartStart is the response starting an upload
+type multipartStart struct {
+	FileID    string `json:"file_id"`
+	UploadID  string `json:"upload_id"`
+	PartSize  int64  `json:"part_size"`
+	PartCount int64  `json:"part_count"`
+}
+
+func startMultipart(t *testing.T, env *testEnv, user *storage.User, size int) multipartStart {
+	t.Helper()
+	form := url.Values{"filename": {"mix.wav"}, "filesize": {strconv.Itoa(size)}}
+	resp := env.do(t, http.MethodPost, "/api/multipart-upload", user, strings.NewReader(form.Encode()),
+		http.Header{"Content-Type": {"application/x-www-form-urlencoded"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("start: expected 200, got %d", resp.StatusCode)
+	}
+	var start multipartStart
+	if err := json.NewDecoder(resp.Body).Decode(&start); err != nil {
+		t.Fatalf("decode start: %v", err)
+	}
+	return start
+}
+
+// multipartQuery is the query string of requests about the upload
+func multipartQuery(start multipartStart, kv ...string) string {
+	q := url.Values{"file_id": {start.FileID}, "upload_id": {start.UploadID}, "filename": {"mix.wav"}}
+	for i := 0; i+1 < len(kv); i += 2 {
+		q.Set(kv[i], kv[i+1])
+	}
+	return q.Encode()
+}
+
+// putPart sends part partNumber through the URL the server signs for it
+func putPart(t *testing.T, env *testEnv, user *storage.User, start multipartStart, partNumber int, data []byte) {
+	t.Helper()
+	resp := env.do(t, http.MethodGet, "/api/multipart-upload/part-url?"+multipartQuery(start, "part", strconv.Itoa(partNumber)), user, nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("part-url: expected 200, got %d", resp.StatusCode)
+	}
+	var signed struct {
+		UploadURL string `json:"upload_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+		t.Fatalf("decode part-url: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPut, signed.UploadURL, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	put, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT part: %v", err)
+	}
+	put.Body.Close()
+	if put.StatusCode/100 != 2 {
+		t.Fatalf("PUT part: expected 2xx, got %d", put.StatusCode)
+	}
+}
+
+func completeMultipart(t *testing.T, env *testEnv, user *storage.User, start multipartStart) string {
+	t.Helper()
+	resp := env.do(t, http.MethodPost, "/api/multipart-upload/complete", user, strings.NewReader(multipartQuery(start)),
+		http.Header{"Content-Type": {"application/x-www-form-urlencoded"}})
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestMultipartUploadOnlyForItsUser(t *testing.T) {
+	env := newMultipartEnv(t)
+	owner, other := env.createUser(t, 3), env.createUser(t, 3)
+	start := startMultipart(t, env, owner, 4096)
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/api/multipart-upload/part-url?" + multipartQuery(start, "part", "1")},
+		{http.MethodGet, "/api/multipart-upload/parts?" + multipartQuery(start)},
+	} {
+		if resp := env.do(t, req.method, req.path, other, nil, nil); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s %s as another user: expected 404, got %d", req.method, req.path, resp.StatusCode)
+		}
+	}
+	resp := env.do(t, http.MethodPost, "/api/multipart-upload/abort", other, strings.NewReader(multipartQuery(start)),
+		http.Header{"Content-Type": {"application/x-www-form-urlencoded"}})
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("abort as another user: expected 404, got %d", resp.StatusCode)
+	}
+
+	// The owner's upload is untouched and completes; another user can't
+	// complete it first
+	putPart(t, env, owner, start, 1, testAudio(4096))
+	if body := completeMultipart(t, env, other, start); !strings.Contains(body, "Upload not found") {
+		t.Fatalf("complete as another user: expected upload not found, got %q", body)
+	}
+	if tasks := env.queue.queued(); len(tasks) != 0 {
+		t.Fatalf("another user's complete queued %d tasks", len(tasks))
+	}
+	if body := completeMultipart(t, env, owner, start); strings.Contains(body, "Upload Failed") {
+		t.Fatalf("complete: %q", body)
+	}
+	checkOriginal(t, env, start.FileID, testAudio(4096))
+
+	// A wrong upload ID doesn't reach the owner's upload either
+	wrong := start
+	wrong.UploadID = "0123456789abcdef0123456789abcdef"
+	if resp := env.do(t, http.MethodGet, "/api/multipart-upload/parts?"+multipartQuery(wrong), owner, nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("parts of another upload ID: expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestMultipartCompleteRetried(t *testing.T) {
+	env := newMultipartEnv(t)
+	user := env.createUser(t, 3)
+	data := testAudio(4096)
+	start := startMultipart(t, env, user, len(data))
+	putPart(t, env, user, start, 1, data)
+
+	// Completing again after a lost response returns the same job
+	first := completeMultipart(t, env, user, start)
+	second := completeMultipart(t, env, user, start)
+	if strings.Contains(first, "Upload Failed") || first != second {
+		t.Fatalf("expected the same processing state twice, got %q then %q", first, second)
+	}
+	if tasks := env.queue.queued(); len(tasks) != 1 || tasks[0].FileID != start.FileID {
+		t.Fatalf("expected one task for %s, got %+v", start.FileID, tasks)
+	}
+	checkOriginal(t, env, start.FileID, data)
+}
+
+func TestMultipartRejectsPartsBeyondDeclaredSize(t *testing.T) {
+	env := newMultipartEnv(t)
+	user := env.createUser(t, 3)
+	start := startMultipart(t, env, user, 4096)
+	if start.PartCount != 1 {
+		t.Fatalf("expected 1 part, got %d", start.PartCount)
+	}
+
+	// No URL for a part past the declared size
+	resp := env.do(t, http.MethodGet, "/api/multipart-upload/part-url?"+multipartQuery(start, "part", "2"), user, nil, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("part-url past the declared size: expected 400, got %d", resp.StatusCode)
+	}
+
+	// Parts stored anyway aren't joined
+	putPart(t, env, user, start, 1, testAudio(4096))
+	key := env.storage.GetUploadKey(start.FileID, "wav")
+	if _, err := env.storage.UploadPart(context.Background(), key, start.UploadID, 2, bytes.NewReader(testAudio(4096)), 4096); err != nil {
+		t.Fatalf("UploadPart: %v", err)
+	}
+	if body := completeMultipart(t, env, user, start); !strings.Contains(body, "larger than the 4096 bytes") {
+		t.Fatalf("complete with an extra part: expected a size error, got %q", body)
+	}
+	if tasks := env.queue.queued(); len(tasks) != 0 {
+		t.Fatalf("oversized upload queued %d tasks", len(tasks))
+	}
+	if _, err := env.storage.GetObjectInfo(context.Background(), key); err == nil {
+		t.Error("oversized upload was joined")
+	}
+}
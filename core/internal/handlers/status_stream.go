@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/simonlewi/levelmix/core/internal/audio"
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// How often a comment is sent on an idle stream to keep proxies from closing it
+const streamKeepAlive = 15 * time.Second
+
+// StreamStatus streams a file's progress as server-sent events, backed by the
+// Redis pub/sub channel the worker publishes to. Each event carries the update
+// sequence number as its ID; a reconnecting client sends it back in
+// Last-Event-ID and only receives state newer than that. Clients that don't
+// accept text/event-stream, or servers without Redis, get the polling JSON.
+func (h *UploadHandler) StreamStatus(c *gin.Context) {
+	fileID := c.Param("id")
+	if fileID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File ID required"})
+		return
+	}
+
+	if h.redisClient == nil || !strings.Contains(c.GetHeader("Accept"), "text/event-stream") {
+		h.GetStatus(c)
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Subscribe before reading the snapshot so no update falls in between
+	sub := h.redisClient.Subscribe(ctx, audio.ProgressChannel(fileID))
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		log.Printf("StreamStatus: progress subscription failed for %s, falling back to polling: %v", fileID, err)
+		h.GetStatus(c)
+		return
+	}
+
+	snapshot, code := h.buildStatus(ctx, fileID)
+	if code != http.StatusOK {
+		c.JSON(code, snapshot)
+		return
+	}
+
+	// The stream may outlive the server's write timeout; if the deadline can't
+	// be cleared the client just reconnects with Last-Event-ID
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable proxy buffering
+	c.Status(http.StatusOK)
+
+	lastSeq, _ := strconv.ParseInt(c.GetHeader("Last-Event-ID"), 10, 64)
+	fmt.Fprintf(c.Writer, "retry: 3000\n\n")
+
+	// Current state, unless the client has already seen it
+	seq, _ := snapshot["seq"].(int64)
+	if seq == 0 || seq > lastSeq {
+		writeStatusEvent(c, seq, snapshot)
+		lastSeq = seq
+	}
+	if status, _ := snapshot["status"].(string); isTerminalStatus(status) {
+		return
+	}
+
+	messages := sub.Channel()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-keepAlive.C:
+			fmt.Fprintf(c.Writer, ": keep-alive\n\n")
+			c.Writer.Flush()
+
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			var event audio.ProgressEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil || event.Seq <= lastSeq {
+				continue
+			}
+			lastSeq = event.Seq
+
+			// Final states carry the full status, e.g. duration and error message
+			if isTerminalStatus(event.Status) {
+				final, _ := h.buildStatus(context.WithoutCancel(ctx), fileID)
+				final["status"] = event.Status
+				final["progress"] = event.Progress
+				writeStatusEvent(c, event.Seq, final)
+				return
+			}
+
+			data := gin.H{
+				"status":   event.Status,
+				"progress": event.Progress,
+				"fileID":   fileID,
+			}
+			if event.ETASeconds > 0 {
+				data["etaSeconds"] = event.ETASeconds
+			}
+			writeStatusEvent(c, event.Seq, data)
+		}
+	}
+}
+
+// writeStatusEvent writes one "status" event and flushes it to the client
+func writeStatusEvent(c *gin.Context, seq int64, data gin.H) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	if seq > 0 {
+		fmt.Fprintf(c.Writer, "id: %d\n", seq)
+	}
+	fmt.Fprintf(c.Writer, "event: status\ndata: %s\n\n", payload)
+	c.Writer.Flush()
+}
+
+// isTerminalStatus reports whether a job or file status is final, after
+// which the stream closes
+func isTerminalStatus(status string) bool {
+	return storage.JobStatus(status).IsTerminal()
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+func TestStreamStatusClosesForFinishedJob(t *testing.T) {
+	env := newTestEnv(t)
+	env.handler.redisClient = newFakeRedis(t)
+	env.router.GET("/status/:id/stream", env.handler.StreamStatus)
+
+	ctx := context.Background()
+	user := env.createUser(t, 1)
+	file := &storage.AudioFile{
+		ID:               strings.ReplaceAll(uuid.New().String(), "-", ""),
+		UserID:           &user.ID,
+		OriginalFilename: "song.mp3",
+		FileSize:         1024,
+		Format:           "mp3",
+		Status:           string(storage.JobCompleted),
+		LUFSTarget:       -14,
+	}
+	if err := env.metadata.CreateAudioFile(ctx, file); err != nil {
+		t.Fatalf("CreateAudioFile: %v", err)
+	}
+	job := &storage.ProcessingJob{
+		ID:          uuid.New().String(),
+		AudioFileID: file.ID,
+		UserID:      user.ID,
+		Status:      storage.JobCompleted,
+	}
+	if err := env.metadata.CreateJob(ctx, job); err != nil {
+		t.Fatalf("CreateJob: %v", err)
+	}
+
+	// The progress hash has expired, so the status comes from the database
+	// and the stream has to end after the first event
+	done := make(chan string, 1)
+	go func() {
+		resp := env.do(t, http.MethodGet, "/status/"+file.ID+"/stream", nil, nil,
+			http.Header{"Accept": {"text/event-stream"}})
+		body, _ := io.ReadAll(resp.Body)
+		done <- string(body)
+	}()
+
+	select {
+	case body := <-done:
+		if !strings.Contains(body, "event: status") || !strings.Contains(body, `"status":"completed"`) {
+			t.Errorf("expected a completed status event, got %q", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream stayed open for a finished job")
+	}
+}
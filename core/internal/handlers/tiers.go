@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TierLimits are the allowances of one subscription tier
+type TierLimits struct {
+	Name              string
+	ProcessingSeconds int      // Monthly processing time, -1 for unlimited
+	MaxUploadBytes    int64    // Largest accepted upload
+	Formats           []string // Accepted upload extensions, without the dot
+}
+
+// DefaultTierLimits are the limits of the hosted plans
+func DefaultTierLimits() map[int]TierLimits {
+	return map[int]TierLimits{
+		1: {Name: "Free", ProcessingSeconds: 7200, MaxUploadBytes: 300 << 20, Formats: []string{"mp3"}},
+		2: {Name: "Premium", ProcessingSeconds: 36000, MaxUploadBytes: 5 << 30, Formats: []string{"mp3", "wav", "flac"}},
+		3: {Name: "Professional", ProcessingSeconds: 144000, MaxUploadBytes: 5 << 30, Formats: []string{"mp3", "wav", "flac"}},
+	}
+}
+
+// LoadTierLimits applies overrides from the environment to the defaults,
+// each a comma-separated list of tier:value entries:
+//
+//	TIER_NAMES=1:Free,2:Premium,3:Professional
+//	TIER_PROCESSING_HOURS=1:2,2:10,3:40   (-1 for unlimited)
+//	TIER_MAX_UPLOAD_MB=1:300,2:5120,3:5120
+//	TIER_UPLOAD_FORMATS=1:mp3,2:mp3|wav|flac,3:mp3|wav|flac
+func LoadTierLimits() map[int]TierLimits {
+	limits := DefaultTierLimits()
+
+	apply := func(env string, set func(t *TierLimits, value string) error) {
+		v := os.Getenv(env)
+		if v == "" {
+			return
+		}
+		values, err := parseTierValues(v)
+		if err != nil {
+			log.Printf("Ignoring %s: %v", env, err)
+			return
+		}
+		for tier, value := range values {
+			t := limits[tier]
+			if err := set(&t, value); err != nil {
+				log.Printf("Ignoring %s entry for tier %d: %v", env, tier, err)
+				continue
+			}
+			limits[tier] = t
+		}
+	}
+
+	apply("TIER_NAMES", func(t *TierLimits, value string) error {
+		t.Name = value
+		return nil
+	})
+	apply("TIER_PROCESSING_HOURS", func(t *TierLimits, value string) error {
+		hours, err := strconv.ParseFloat(value, 64)
+		if err != nil || (hours < 0 && hours != -1) {
+			return fmt.Errorf("invalid hours %q", value)
+		}
+		if hours == -1 {
+			t.ProcessingSeconds = -1
+		} else {
+			t.ProcessingSeconds = int(hours * 3600)
+		}
+		return nil
+	})
+	apply("TIER_MAX_UPLOAD_MB", func(t *TierLimits, value string) error {
+		mb, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || mb <= 0 {
+			return fmt.Errorf("invalid size %q", value)
+		}
+		t.MaxUploadBytes = mb << 20
+		return nil
+	})
+	apply("TIER_UPLOAD_FORMATS", func(t *TierLimits, value string) error {
+		var formats []string
+		for _, f := range strings.Split(value, "|") {
+			if f = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(f), ".")); f != "" {
+				formats = append(formats, f)
+			}
+		}
+		if len(formats) == 0 {
+			return fmt.Errorf("no formats in %q", value)
+		}
+		t.Formats = formats
+		return nil
+	})
+
+	// A tier added only through some of the variables gets the free tier's
+	// limits for the rest
+	free := limits[1]
+	for tier, t := range limits {
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("Tier %d", tier)
+		}
+		if t.ProcessingSeconds == 0 {
+			t.ProcessingSeconds = free.ProcessingSeconds
+		}
+		if t.MaxUploadBytes == 0 {
+			t.MaxUploadBytes = free.MaxUploadBytes
+		}
+		if len(t.Formats) == 0 {
+			t.Formats = free.Formats
+		}
+		limits[tier] = t
+	}
+	return limits
+}
+
+// parseTierValues parses "tier:value,tier:value"
+func parseTierValues(s string) (map[int]string, error) {
+	values := make(map[int]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		tier, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid entry %q, expected tier:value", entry)
+		}
+		t, err := strconv.Atoi(strings.TrimSpace(tier))
+		if err != nil || t < 1 {
+			return nil, fmt.Errorf("invalid tier in %q", entry)
+		}
+		values[t] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
+
+// tierLimits is loaded on first use, after the server has read its .env
+var tierLimits = sync.OnceValue(LoadTierLimits)
+
+// limitsFor returns the limits of tier, or the free tier's for unknown tiers
+func limitsFor(tier int) TierLimits {
+	limits := tierLimits()
+	if l, ok := limits[tier]; ok {
+		return l
+	}
+	return limits[1]
+}
+
+// UploadLimitsText describes what tier may upload, e.g. "MP3 files up to 300MB"
+func UploadLimitsText(tier int) string {
+	limits := limitsFor(tier)
+	formats := strings.ToUpper(strings.Join(limits.Formats, ", "))
+	if i := strings.LastIndex(formats, ", "); i >= 0 {
+		formats = formats[:i] + " and " + formats[i+2:]
+	}
+	return fmt.Sprintf("%s files up to %s", formats, formatSize(limits.MaxUploadBytes))
+}
+
+// OffPeakText describes what off-peak processing is charged, e.g. "uses half
+// the processing time"
+func (h *UploadHandler) OffPeakText() string {
+	switch rate := h.scheduling.OffPeakRate; {
+	case rate == 0:
+		return "uses no processing time"
+	case rate == 0.5:
+		return "uses half the processing time"
+	case rate == 1:
+		return "uses the full processing time"
+	default:
+		return fmt.Sprintf("uses %d%% of the processing time", int(math.Round(rate*100)))
+	}
+}
+
+// acceptsFormat reports whether tier may upload files with extension ext
+func (t TierLimits) acceptsFormat(ext string) bool {
+	return slices.Contains(t.Formats, strings.ToLower(strings.TrimPrefix(ext, ".")))
+}
@@ -0,0 +1,686 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+const tusVersion = "1.0.0"
+
+// statusChecksumMismatch is the tus checksum extension's response to a chunk
+// that doesn't match its Upload-Checksum
+const statusChecksumMismatch = 460
+
+// Checksum algorithms accepted in Upload-Checksum
+var tusChecksums = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+	"sha256": sha256.New,
+}
+
+// TusHandler serves the tus 1.0 resumable upload protocol with the creation,
+// termination and checksum extensions, for clients such as Uppy and
+// tus-go-client. The upload ID is the file ID, so its status is at
+// /status/<id> once the upload is finished.
+//
+// Uploads are written to a multipart upload in AudioStorage, so any backend
+// works and any server can take the next request. Chunks are cut into parts
+// of a fixed size; the bytes of a part that isn't full yet wait in a tail
+// object until the next PATCH. The upload's state is a JSON info object next
+// to it, which records how many parts and tail bytes are current. Only one
+// PATCH writes to an upload at a time, held in Redis across servers, or
+// within the process without it. Once every byte has arrived the parts are
+// joined and the file is confirmed as ConfirmUpload does, with the
+// processing options taken from Upload-Metadata (filename is required).
+type TusHandler struct {
+	uploads *UploadHandler
+
+	mu      sync.Mutex
+	writing map[string]bool // Uploads with a PATCH running, without Redis
+}
+
+// tusUpload is the info object of an upload
+type tusUpload struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"user_id"`
+	Filename  string            `json:"filename"`
+	Length    int64             `json:"length"`
+	PartSize  int64             `json:"part_size"`
+	UploadID  string            `json:"upload_id"` // The storage's multipart upload
+	Metadata  map[string]string `json:"metadata"`
+	Parts     int               `json:"parts"`     // Parts stored
+	TailSize  int64             `json:"tail_size"` // Bytes of the tail that follow them
+	Assembled bool              `json:"assembled"` // Parts joined into the upload key
+	JobID     string            `json:"job_id,omitempty"`
+}
+
+func NewTusHandler(uploads *UploadHandler) *TusHandler {
+	return &TusHandler{uploads: uploads, writing: make(map[string]bool)}
+}
+
+// Options advertises the protocol version and extensions
+func (h *TusHandler) Options(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", "creation,termination,checksum")
+	c.Header("Tus-Checksum-Algorithm", "sha1,md5,sha256")
+	c.Status(http.StatusNoContent)
+}
+
+// Create starts an upload of Upload-Length bytes
+func (h *TusHandler) Create(c *gin.Context) {
+	user, ok := h.begin(c)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.String(http.StatusBadRequest, "Upload-Length is required; deferred lengths are not supported")
+		return
+	}
+	metadata, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid Upload-Metadata")
+		return
+	}
+
+	filename := metadata["filename"]
+	if filename == "" {
+		c.String(http.StatusBadRequest, "A filename is required in Upload-Metadata")
+		return
+	}
+	if err := h.uploads.checkUploadLimits(c, user); err != nil {
+		log.Printf("TusHandler: Upload limit check failed: %v", err)
+		c.String(http.StatusForbidden, err.Error())
+		return
+	}
+	if err := h.uploads.validateFile(&multipart.FileHeader{Filename: filename, Size: length}, user.SubscriptionTier); err != nil {
+		status := http.StatusBadRequest
+		if length > limitsFor(user.SubscriptionTier).MaxUploadBytes {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.String(status, err.Error())
+		return
+	}
+	fileFormat := getFileExtension(filename)
+	if fileFormat == "" {
+		c.String(http.StatusBadRequest, "Could not determine audio format from file extension")
+		return
+	}
+	// Options are checked again once the upload is finished, but a mistake
+	// shouldn't cost the whole upload first
+	if _, err := h.uploads.parseUploadOptions(metadataGetter(metadata), user, fileFormat); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Parts must fit within the storage's part count
+	partSize := int64(multipartPartSize)
+	if minSize := (length + storage.MaxParts - 1) / storage.MaxParts; minSize > partSize {
+		partSize = minSize
+	}
+
+	ctx := c.Request.Context()
+	upload := &tusUpload{
+		ID:       generateID(),
+		UserID:   user.ID,
+		Filename: filename,
+		Length:   length,
+		PartSize: partSize,
+		Metadata: metadata,
+	}
+	upload.UploadID, err = h.uploads.storage.CreateMultipartUpload(ctx, h.objectKey(upload), getContentTypeFromFormat(fileFormat))
+	if err != nil {
+		log.Printf("TusHandler: Failed to start upload of %s: %v", upload.ID, err)
+		c.String(http.StatusInternalServerError, "Failed to start upload")
+		return
+	}
+	if err := h.save(ctx, upload); err != nil {
+		log.Printf("TusHandler: Failed to save upload %s: %v", upload.ID, err)
+		h.uploads.storage.AbortMultipartUpload(ctx, h.objectKey(upload), upload.UploadID)
+		c.String(http.StatusInternalServerError, "Failed to start upload")
+		return
+	}
+
+	log.Printf("TusHandler: User %s started upload %s of %s (%d bytes)", user.ID, upload.ID, filename, length)
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+upload.ID)
+	c.Status(http.StatusCreated)
+}
+
+// Head reports how much of an upload has arrived
+func (h *TusHandler) Head(c *gin.Context) {
+	user, ok := h.begin(c)
+	if !ok {
+		return
+	}
+	upload, ok := h.load(c, user)
+	if !ok {
+		return
+	}
+
+	offset, err := h.offset(c.Request.Context(), upload)
+	if err != nil {
+		h.storageError(c, upload, err)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+// Patch appends a chunk at Upload-Offset. A chunk with an Upload-Checksum is
+// stored only if it matches; one without is kept up to where it broke off.
+// The chunk that completes the upload also confirms it.
+func (h *TusHandler) Patch(c *gin.Context) {
+	user, ok := h.begin(c)
+	if !ok {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.String(http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+
+	id := c.Param("id")
+	if !validFileID(id) {
+		c.String(http.StatusNotFound, "Upload not found")
+		return
+	}
+	ctx := c.Request.Context()
+
+	unlock, ok, err := h.lock(ctx, id)
+	if err != nil {
+		log.Printf("TusHandler: Failed to lock upload %s: %v", id, err)
+		c.String(http.StatusServiceUnavailable, "Failed to lock upload. Please try again.")
+		return
+	}
+	if !ok {
+		c.String(http.StatusLocked, "Another request is writing to this upload")
+		return
+	}
+	defer unlock()
+
+	upload, ok := h.load(c, user)
+	if !ok {
+		return
+	}
+
+	offset, err := h.offset(ctx, upload)
+	if err != nil {
+		h.storageError(c, upload, err)
+		return
+	}
+	if c.GetHeader("Upload-Offset") != strconv.FormatInt(offset, 10) {
+		c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		c.String(http.StatusConflict, "Upload-Offset doesn't match the %d bytes received", offset)
+		return
+	}
+	// A complete upload is only finished, e.g. when retrying a failed finish
+	if offset < upload.Length {
+		if c.Request.ContentLength > upload.Length-offset {
+			c.String(http.StatusBadRequest, "The chunk runs past Upload-Length")
+			return
+		}
+		body := io.LimitReader(c.Request.Body, upload.Length-offset)
+
+		if header := c.GetHeader("Upload-Checksum"); header != "" {
+			verified, status, err := verifyChunk(body, header)
+			if err != nil {
+				c.String(status, err.Error())
+				return
+			}
+			defer verified.Close()
+			body = verified
+		}
+
+		offset, err = h.write(ctx, upload, body)
+		if err != nil {
+			c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+			log.Printf("TusHandler: Failed to write upload %s at %d: %v", upload.ID, offset, err)
+			c.String(http.StatusInternalServerError, "Failed to store the chunk")
+			return
+		}
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+
+	if offset == upload.Length {
+		if status, err := h.finish(ctx, user, upload); err != nil {
+			c.String(status, err.Error())
+			return
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Terminate discards an upload. Processing of a finished upload isn't
+// affected; cancel its job for that.
+func (h *TusHandler) Terminate(c *gin.Context) {
+	user, ok := h.begin(c)
+	if !ok {
+		return
+	}
+	upload, ok := h.load(c, user)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	if !upload.Assembled {
+		if err := h.uploads.storage.AbortMultipartUpload(ctx, h.objectKey(upload), upload.UploadID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("TusHandler: Failed to abort upload %s: %v", upload.ID, err)
+			c.String(http.StatusInternalServerError, "Failed to terminate upload")
+			return
+		}
+	}
+	h.uploads.storage.Delete(ctx, h.stateKey(upload.ID, "part"))
+	h.uploads.storage.Delete(ctx, h.stateKey(upload.ID, "info"))
+
+	log.Printf("TusHandler: Upload %s terminated", upload.ID)
+	c.Status(http.StatusNoContent)
+}
+
+// begin checks the protocol version and the user of a request
+func (h *TusHandler) begin(c *gin.Context) (*storage.User, bool) {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.String(http.StatusPreconditionFailed, "Unsupported tus version")
+		return nil, false
+	}
+
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.String(http.StatusUnauthorized, "Authentication required. Please log in to upload files.")
+		return nil, false
+	}
+	user, ok := userInterface.(*storage.User)
+	if !ok {
+		c.String(http.StatusUnauthorized, "Invalid user session. Please log in again.")
+		return nil, false
+	}
+	return user, true
+}
+
+// load reads the upload in the URL, which only its user can see
+func (h *TusHandler) load(c *gin.Context, user *storage.User) (*tusUpload, bool) {
+	id := c.Param("id")
+	if !validFileID(id) {
+		c.String(http.StatusNotFound, "Upload not found")
+		return nil, false
+	}
+
+	r, err := h.uploads.storage.Download(c.Request.Context(), h.stateKey(id, "info"))
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("TusHandler: Failed to read upload %s: %v", id, err)
+			c.String(http.StatusInternalServerError, "Failed to read upload")
+			return nil, false
+		}
+		c.String(http.StatusNotFound, "Upload not found")
+		return nil, false
+	}
+	defer r.Close()
+
+	var upload tusUpload
+	if err := json.NewDecoder(r).Decode(&upload); err != nil {
+		log.Printf("TusHandler: Failed to decode upload %s: %v", id, err)
+		c.String(http.StatusInternalServerError, "Failed to read upload")
+		return nil, false
+	}
+	if upload.UserID != user.ID {
+		c.String(http.StatusNotFound, "Upload not found")
+		return nil, false
+	}
+	return &upload, true
+}
+
+func (h *TusHandler) save(ctx context.Context, upload *tusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return h.uploads.storage.Upload(ctx, "tus/"+upload.ID, bytes.NewReader(data), "info")
+}
+
+// stateKey is the key of an upload's info or tail object
+func (h *TusHandler) stateKey(id, kind string) string {
+	return h.uploads.storage.GetUploadKey("tus/"+id, kind)
+}
+
+// objectKey is where the upload ends up, the upload key of its file
+func (h *TusHandler) objectKey(upload *tusUpload) string {
+	return h.uploads.storage.GetUploadKey(upload.ID, getFileExtension(upload.Filename))
+}
+
+// tusLockTTL outlasts the longest PATCH, which the server's read timeout
+// bounds, so a crashed server's lock expires
+const tusLockTTL = 20 * time.Minute
+
+// releaseLockScript deletes a lock only if it is still the caller's
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// lock claims the upload with id for one request and returns a func that
+// releases it, or false if another request holds it
+func (h *TusHandler) lock(ctx context.Context, id string) (func(), bool, error) {
+	rdb := h.uploads.redisClient
+	if rdb == nil {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.writing[id] {
+			return nil, false, nil
+		}
+		h.writing[id] = true
+		return func() {
+			h.mu.Lock()
+			delete(h.writing, id)
+			h.mu.Unlock()
+		}, true, nil
+	}
+
+	key := fmt.Sprintf("tus-lock:%s", id)
+	token := generateID()
+	ok, err := rdb.SetNX(ctx, key, token, tusLockTTL).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return func() {
+		// The request context may already be done
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := releaseLockScript.Run(ctx, rdb, []string{key}, token).Err(); err != nil {
+			log.Printf("TusHandler: Failed to unlock upload %s: %v", id, err)
+		}
+	}, true, nil
+}
+
+// offset is how many bytes of upload are stored: its parts and its tail
+func (h *TusHandler) offset(ctx context.Context, upload *tusUpload) (int64, error) {
+	if upload.Assembled {
+		return upload.Length, nil
+	}
+
+	stored, tailSize, err := h.progress(ctx, upload)
+	if errors.Is(err, storage.ErrNotFound) && h.completed(ctx, upload) {
+		// Joined by a finish that failed before saving the info object
+		upload.Assembled = true
+		return upload.Length, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return stored + tailSize, nil
+}
+
+// progress returns the size of upload's stored parts and of its current
+// tail. Parts are stored before the info object is saved, so one part more
+// than it records holds the tail already, which is then out of date.
+func (h *TusHandler) progress(ctx context.Context, upload *tusUpload) (int64, int64, error) {
+	parts, err := h.uploads.storage.ListParts(ctx, h.objectKey(upload), upload.UploadID)
+	if err != nil {
+		return 0, 0, err
+	}
+	var stored int64
+	for _, part := range parts {
+		stored += part.Size
+	}
+
+	switch len(parts) {
+	case upload.Parts:
+		return stored, upload.TailSize, nil
+	case upload.Parts + 1:
+		upload.Parts, upload.TailSize = len(parts), 0
+		return stored, 0, nil
+	default:
+		return 0, 0, fmt.Errorf("upload %s has %d parts, its info %d", upload.ID, len(parts), upload.Parts)
+	}
+}
+
+// completed reports whether the parts of upload, whose multipart upload is
+// gone, were joined: the object is at its key, or was confirmed and moved on
+func (h *TusHandler) completed(ctx context.Context, upload *tusUpload) bool {
+	if _, err := h.uploads.storage.GetObjectInfo(ctx, h.objectKey(upload)); err == nil {
+		return true
+	}
+	_, err := h.uploads.metadata.GetAudioFile(ctx, upload.ID)
+	return err == nil
+}
+
+// write appends body to upload, returning the new offset. It stores every
+// full part, and what is left over as the tail, even if body breaks off. The
+// info object is saved after each, so a retry never counts bytes twice.
+func (h *TusHandler) write(ctx context.Context, upload *tusUpload, body io.Reader) (int64, error) {
+	s := h.uploads.storage
+	key := h.objectKey(upload)
+	tailKey := h.stateKey(upload.ID, "part")
+
+	stored, tailSize, err := h.progress(ctx, upload)
+	if err != nil {
+		return 0, err
+	}
+
+	// The tail is the start of the next part. A failed save can leave the
+	// object longer than the info object records, but never shorter.
+	buf := make([]byte, upload.PartSize)
+	if tailSize > 0 {
+		tail, err := s.Download(ctx, tailKey)
+		if err != nil {
+			return stored + tailSize, err
+		}
+		_, err = io.ReadFull(tail, buf[:tailSize])
+		tail.Close()
+		if err != nil {
+			return stored + tailSize, fmt.Errorf("failed to read tail of upload %s: %w", upload.ID, err)
+		}
+	}
+
+	filled := tailSize
+	for partNumber := upload.Parts + 1; stored < upload.Length; partNumber++ {
+		want := min(upload.PartSize, upload.Length-stored)
+		n, readErr := io.ReadFull(body, buf[filled:want])
+		filled += int64(n)
+
+		if filled == want {
+			if _, err := s.UploadPart(ctx, key, upload.UploadID, partNumber, bytes.NewReader(buf[:filled]), filled); err != nil {
+				return stored + tailSize, err
+			}
+			stored += filled
+			filled, tailSize = 0, 0
+			upload.Parts, upload.TailSize = partNumber, 0
+			if err := h.save(ctx, upload); err != nil {
+				return stored, err
+			}
+			continue
+		}
+
+		// The chunk ended, or broke off, before the part was full. Keep what
+		// arrived even if the client is gone.
+		if filled > tailSize {
+			saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+			err := s.Upload(saveCtx, "tus/"+upload.ID, bytes.NewReader(buf[:filled]), "part")
+			if err == nil {
+				upload.TailSize = filled
+				err = h.save(saveCtx, upload)
+			}
+			cancel()
+			if err != nil {
+				return stored + tailSize, err
+			}
+			tailSize = filled
+		}
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return stored + tailSize, readErr
+		}
+		break
+	}
+	return stored + tailSize, nil
+}
+
+// finish joins the parts of a complete upload and confirms it. The error is
+// a message for the user, with the status to answer with.
+func (h *TusHandler) finish(ctx context.Context, user *storage.User, upload *tusUpload) (int, error) {
+	s := h.uploads.storage
+	key := h.objectKey(upload)
+
+	if !upload.Assembled {
+		parts, err := s.ListParts(ctx, key, upload.UploadID)
+		if err == nil {
+			err = s.CompleteMultipartUpload(ctx, key, upload.UploadID, parts)
+		}
+		if err != nil {
+			log.Printf("TusHandler: Failed to complete upload %s: %v", upload.ID, err)
+			return http.StatusInternalServerError, errors.New("Failed to complete upload. Please try again.")
+		}
+		upload.Assembled = true
+		if err := h.save(ctx, upload); err != nil {
+			log.Printf("TusHandler: Failed to save upload %s: %v", upload.ID, err)
+		}
+		// The tail of the last full part is no longer read
+		if err := s.Delete(ctx, h.stateKey(upload.ID, "part")); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("TusHandler: Failed to delete tail of upload %s: %v", upload.ID, err)
+		}
+	}
+	if upload.JobID != "" {
+		return http.StatusNoContent, nil
+	}
+
+	opts, err := h.uploads.parseUploadOptions(metadataGetter(upload.Metadata), user, getFileExtension(upload.Filename))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	jobID, err := h.uploads.confirmUpload(ctx, user, upload.ID, upload.Filename, opts)
+	if errors.Is(err, errAlreadyConfirmed) {
+		job, getErr := h.uploads.metadata.GetJobByFileID(ctx, upload.ID)
+		if getErr != nil {
+			return http.StatusConflict, err
+		}
+		jobID, err = job.ID, nil
+	}
+	if err != nil {
+		return http.StatusUnprocessableEntity, err
+	}
+
+	log.Printf("TusHandler: Upload %s finished, processing as job %s", upload.ID, jobID)
+	upload.JobID = jobID
+	if err := h.save(ctx, upload); err != nil {
+		log.Printf("TusHandler: Failed to save upload %s: %v", upload.ID, err)
+	}
+	return http.StatusNoContent, nil
+}
+
+// storageError answers a request whose upload couldn't be read from storage
+func (h *TusHandler) storageError(c *gin.Context, upload *tusUpload, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		// Aborted by the cleanup job
+		c.String(http.StatusGone, "Upload expired. Please upload the file again.")
+		return
+	}
+	log.Printf("TusHandler: Failed to read upload %s: %v", upload.ID, err)
+	c.String(http.StatusInternalServerError, "Failed to read upload")
+}
+
+// verifyChunk reads a chunk to a temporary file and checks it against an
+// Upload-Checksum header, so nothing is stored unless it matches. It returns
+// the file to read the chunk from, or the status to answer with.
+func verifyChunk(body io.Reader, header string) (io.ReadCloser, int, error) {
+	algorithm, encoded, _ := strings.Cut(header, " ")
+	newHash, ok := tusChecksums[algorithm]
+	if !ok {
+		return nil, http.StatusBadRequest, fmt.Errorf("Unsupported checksum algorithm %q", algorithm)
+	}
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("Invalid Upload-Checksum")
+	}
+
+	f, err := os.CreateTemp("", "tus-chunk-*")
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Failed to store the chunk")
+	}
+	chunk := &tempFile{f}
+
+	sum := newHash()
+	if _, err := io.Copy(io.MultiWriter(f, sum), body); err != nil {
+		chunk.Close()
+		// An incomplete chunk can't be checked, so none of it is kept
+		return nil, http.StatusBadRequest, errors.New("The chunk was incomplete")
+	}
+	if !bytes.Equal(sum.Sum(nil), expected) {
+		chunk.Close()
+		return nil, statusChecksumMismatch, errors.New("Checksum mismatch")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		chunk.Close()
+		return nil, http.StatusInternalServerError, errors.New("Failed to store the chunk")
+	}
+	return chunk, 0, nil
+}
+
+// tempFile is removed when closed
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated keys,
+// each with an optional base64 value
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// metadataGetter reads upload options from metadata, as parseUploadOptions
+// reads them from a form
+func metadataGetter(metadata map[string]string) func(string) string {
+	return func(key string) string {
+		return metadata[key]
+	}
+}
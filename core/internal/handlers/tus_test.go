@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// newTusEnv mounts a TusHandler on a test environment
+func newTusEnv(t *testing.T) (*testEnv, *TusHandler) {
+	t.Helper()
+	env := newTestEnv(t)
+	h := NewTusHandler(env.handler)
+	env.router.POST("/api/tus", h.Create)
+	env.router.HEAD("/api/tus/:id", h.Head)
+	env.router.PATCH("/api/tus/:id", h.Patch)
+	env.router.DELETE("/api/tus/:id", h.Terminate)
+	return env, h
+}
+
+func tusHeader(kv ...string) http.Header {
+	header := http.Header{"Tus-Resumable": {tusVersion}}
+	for i := 0; i+1 < len(kv); i += 2 {
+		header.Set(kv[i], kv[i+1])
+	}
+	return header
+}
+
+// createTusUpload starts an upload of length bytes, returning its path
+func createTusUpload(t *testing.T, env *testEnv, user *storage.User, filename string, length int) string {
+	t.Helper()
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte(filename))
+	resp := env.do(t, http.MethodPost, "/api/tus", user, nil,
+		tusHeader("Upload-Length", strconv.Itoa(length), "Upload-Metadata", metadata))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d", resp.StatusCode)
+	}
+	location := resp.Header.Get("Location")
+	if !strings.HasPrefix(location, "/api/tus/") {
+		t.Fatalf("unexpected Location %q", location)
+	}
+	return location
+}
+
+// patchTus sends chunk at offset, returning the response
+func patchTus(t *testing.T, env *testEnv, user *storage.User, path string, offset int, chunk []byte, kv ...string) *http.Response {
+	t.Helper()
+	header := tusHeader(append([]string{
+		"Upload-Offset", strconv.Itoa(offset),
+		"Content-Type", "application/offset+octet-stream",
+	}, kv...)...)
+	return env.do(t, http.MethodPatch, path, user, bytes.NewReader(chunk), header)
+}
+
+// testAudio is n bytes standing in for an audio file
+func testAudio(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+// flakyStorage fails chosen calls of the storage it wraps
+type flakyStorage struct {
+	storage.AudioStorage
+
+	mu         sync.Mutex
+	failJoined bool   // Fail saving tus info objects of joined uploads
+	failParts  bool   // Fail saving tus info objects after a part
+	failLookup string // Fail GetObjectInfo of this key once
+}
+
+func (s *flakyStorage) Upload(ctx context.Context, key string, reader io.Reader, format string) error {
+	if format != "info" {
+		return s.AudioStorage.Upload(ctx, key, reader, format)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	fail := s.failJoined && upload.Assembled || s.failParts && upload.Parts > 0 && upload.TailSize == 0 && !upload.Assembled
+	s.mu.Unlock()
+	if fail {
+		return errors.New("injected failure")
+	}
+	return s.AudioStorage.Upload(ctx, key, bytes.NewReader(data), format)
+}
+
+func (s *flakyStorage) set(f func(s *flakyStorage)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s)
+}
+
+func (s *flakyStorage) GetObjectInfo(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	s.mu.Lock()
+	fail := key != "" && key == s.failLookup
+	if fail {
+		s.failLookup = ""
+	}
+	s.mu.Unlock()
+	if fail {
+		return nil, errors.New("injected failure")
+	}
+	return s.AudioStorage.GetObjectInfo(ctx, key)
+}
+
+func TestTusRetriesFailedFinish(t *testing.T) {
+	for name, saveFails := range map[string]bool{"joined": false, "join unsaved": true} {
+		t.Run(name, func(t *testing.T) {
+			env, _ := newTusEnv(t)
+			flaky := &flakyStorage{AudioStorage: env.storage}
+			env.handler.storage = flaky
+			user := env.createUser(t, 3)
+
+			data := testAudio(4096)
+			path := createTusUpload(t, env, user, "song.wav", len(data))
+			id := strings.TrimPrefix(path, "/api/tus/")
+
+			// The parts are joined, but the confirm fails, and maybe saving
+			// that they were joined too
+			flaky.set(func(s *flakyStorage) {
+				s.failJoined = saveFails
+				s.failLookup = env.storage.GetUploadKey(id, "wav")
+			})
+			resp := patchTus(t, env, user, path, 0, data)
+			if resp.StatusCode != http.StatusUnprocessableEntity {
+				t.Fatalf("final patch: expected 422, got %d", resp.StatusCode)
+			}
+			flaky.set(func(s *flakyStorage) { s.failJoined = false })
+
+			// The multipart upload is gone, but the upload is complete, not expired
+			resp = env.do(t, http.MethodHead, path, user, nil, tusHeader())
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("Upload-Offset") != strconv.Itoa(len(data)) {
+				t.Fatalf("head: expected 200 at %d, got %d at %q", len(data), resp.StatusCode, resp.Header.Get("Upload-Offset"))
+			}
+
+			// Retrying at the end only finishes the upload
+			resp = patchTus(t, env, user, path, len(data), nil)
+			if resp.StatusCode != http.StatusNoContent {
+				t.Fatalf("retry: expected 204, got %d", resp.StatusCode)
+			}
+			if tasks := env.queue.queued(); len(tasks) != 1 || tasks[0].FileID != id {
+				t.Fatalf("expected one job for %s, got %+v", id, tasks)
+			}
+
+			// A second retry finds the job already there
+			resp = patchTus(t, env, user, path, len(data), nil)
+			if resp.StatusCode != http.StatusNoContent {
+				t.Fatalf("second retry: expected 204, got %d", resp.StatusCode)
+			}
+			if tasks := env.queue.queued(); len(tasks) != 1 {
+				t.Fatalf("expected the job to be queued once, got %d", len(tasks))
+			}
+		})
+	}
+}
+
+// checkOriginal checks that the file with id was stored with content data
+func checkOriginal(t *testing.T, env *testEnv, id string, data []byte) {
+	t.Helper()
+	file, err := env.metadata.GetAudioFile(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetAudioFile: %v", err)
+	}
+	r, err := env.storage.Download(context.Background(), storage.OriginalKey(env.storage, file))
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	defer r.Close()
+	stored, _ := io.ReadAll(r)
+	if !bytes.Equal(stored, data) {
+		t.Fatalf("stored original differs: %d bytes, expected %d", len(stored), len(data))
+	}
+}
+
+func headOffset(t *testing.T, env *testEnv, user *storage.User, path string) string {
+	t.Helper()
+	resp := env.do(t, http.MethodHead, path, user, nil, tusHeader())
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("head: expected 200, got %d", resp.StatusCode)
+	}
+	return resp.Header.Get("Upload-Offset")
+}
+
+func TestTusUploadResumesTail(t *testing.T) {
+	env, _ := newTusEnv(t)
+	user := env.createUser(t, 3)
+
+	// A full part and a short last one
+	data := testAudio(multipartPartSize + 4096)
+	path := createTusUpload(t, env, user, "song.wav", len(data))
+	id := strings.TrimPrefix(path, "/api/tus/")
+	if offset := headOffset(t, env, user, path); offset != "0" {
+		t.Fatalf("new upload at offset %s", offset)
+	}
+
+	// Less than a part waits in the tail
+	resp := patchTus(t, env, user, path, 0, data[:3000])
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != "3000" {
+		t.Fatalf("first patch: expected 204 at 3000, got %d at %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	if offset := headOffset(t, env, user, path); offset != "3000" {
+		t.Fatalf("head after first patch: offset %s", offset)
+	}
+
+	resp = patchTus(t, env, user, path, 1000, data[1000:2000])
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get("Upload-Offset") != "3000" {
+		t.Fatalf("patch at the wrong offset: expected 409 at 3000, got %d", resp.StatusCode)
+	}
+
+	// The rest, checked, completes the upload
+	sum := sha256.Sum256(data[3000:])
+	resp = patchTus(t, env, user, path, 3000, data[3000:],
+		"Upload-Checksum", "sha256 "+base64.StdEncoding.EncodeToString(sum[:]))
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != strconv.Itoa(len(data)) {
+		t.Fatalf("last patch: expected 204 at %d, got %d at %q", len(data), resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	if tasks := env.queue.queued(); len(tasks) != 1 || tasks[0].FileID != id {
+		t.Fatalf("expected one job for %s, got %+v", id, tasks)
+	}
+	checkOriginal(t, env, id, data)
+}
+
+func TestTusChecksumMismatch(t *testing.T) {
+	env, _ := newTusEnv(t)
+	user := env.createUser(t, 3)
+
+	data := testAudio(4096)
+	path := createTusUpload(t, env, user, "song.wav", len(data))
+
+	sum := sha256.Sum256([]byte("something else"))
+	resp := patchTus(t, env, user, path, 0, data,
+		"Upload-Checksum", "sha256 "+base64.StdEncoding.EncodeToString(sum[:]))
+	if resp.StatusCode != statusChecksumMismatch {
+		t.Fatalf("expected %d, got %d", statusChecksumMismatch, resp.StatusCode)
+	}
+	if offset := headOffset(t, env, user, path); offset != "0" {
+		t.Fatalf("mismatched chunk was kept: offset %s", offset)
+	}
+	if tasks := env.queue.queued(); len(tasks) != 0 {
+		t.Fatalf("expected nothing queued, got %d", len(tasks))
+	}
+}
+
+func TestTusIgnoresStaleTail(t *testing.T) {
+	env, _ := newTusEnv(t)
+	flaky := &flakyStorage{AudioStorage: env.storage}
+	env.handler.storage = flaky
+	user := env.createUser(t, 3)
+
+	data := testAudio(multipartPartSize + 4096)
+	path := createTusUpload(t, env, user, "song.wav", len(data))
+	id := strings.TrimPrefix(path, "/api/tus/")
+
+	if resp := patchTus(t, env, user, path, 0, data[:3000]); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("first patch: expected 204, got %d", resp.StatusCode)
+	}
+
+	// The part holding the tail is stored, but not recorded
+	flaky.set(func(s *flakyStorage) { s.failParts = true })
+	resp := patchTus(t, env, user, path, 3000, data[3000:multipartPartSize])
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("second patch: expected 500, got %d", resp.StatusCode)
+	}
+	flaky.set(func(s *flakyStorage) { s.failParts = false })
+
+	if offset := headOffset(t, env, user, path); offset != strconv.Itoa(multipartPartSize) {
+		t.Fatalf("expected offset %d, the tail counted once, got %s", multipartPartSize, offset)
+	}
+	resp = patchTus(t, env, user, path, multipartPartSize, data[multipartPartSize:])
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("last patch: expected 204, got %d", resp.StatusCode)
+	}
+	checkOriginal(t, env, id, data)
+}
+
+func TestTusTerminate(t *testing.T) {
+	env, _ := newTusEnv(t)
+	user := env.createUser(t, 3)
+	other := env.createUser(t, 3)
+
+	data := testAudio(4096)
+	path := createTusUpload(t, env, user, "song.wav", len(data))
+	if resp := patchTus(t, env, user, path, 0, data[:2000]); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("patch: expected 204, got %d", resp.StatusCode)
+	}
+
+	// Other users can't see or end it
+	if resp := env.do(t, http.MethodHead, path, other, nil, tusHeader()); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("head as another user: expected 404, got %d", resp.StatusCode)
+	}
+	if resp := env.do(t, http.MethodDelete, path, other, nil, tusHeader()); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("terminate as another user: expected 404, got %d", resp.StatusCode)
+	}
+
+	if resp := env.do(t, http.MethodDelete, path, user, nil, tusHeader()); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("terminate: expected 204, got %d", resp.StatusCode)
+	}
+	if resp := env.do(t, http.MethodHead, path, user, nil, tusHeader()); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("head after terminate: expected 404, got %d", resp.StatusCode)
+	}
+	if resp := patchTus(t, env, user, path, 2000, data[2000:]); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("patch after terminate: expected 404, got %d", resp.StatusCode)
+	}
+	if uploads, err := env.storage.ListMultipartUploads(context.Background()); err != nil || len(uploads) != 0 {
+		t.Fatalf("expected the multipart upload to be aborted, got %v, %v", uploads, err)
+	}
+}
+
+func TestTusPatchLocksUpload(t *testing.T) {
+	env, h := newTusEnv(t)
+	user := env.createUser(t, 3)
+
+	// IDs that can't be uploads are turned away before taking a lock
+	resp := patchTus(t, env, user, "/api/tus/not-an-upload", 0, []byte("data"))
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("invalid ID: expected 404, got %d", resp.StatusCode)
+	}
+
+	data := testAudio(4096)
+	path := createTusUpload(t, env, user, "song.wav", len(data))
+	id := strings.TrimPrefix(path, "/api/tus/")
+
+	unlock, ok, err := h.lock(context.Background(), id)
+	if err != nil || !ok {
+		t.Fatalf("lock: %v, %v", ok, err)
+	}
+	if resp := patchTus(t, env, user, path, 0, data); resp.StatusCode != http.StatusLocked {
+		t.Fatalf("patch while locked: expected 423, got %d", resp.StatusCode)
+	}
+	unlock()
+
+	if resp := patchTus(t, env, user, path, 0, data); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("patch after unlock: expected 204, got %d", resp.StatusCode)
+	}
+	if len(h.writing) != 0 {
+		t.Fatalf("expected no locks to be held, got %v", h.writing)
+	}
+}
@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"html"
+	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
@@ -12,6 +16,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -20,11 +25,22 @@ import (
 	"github.com/simonlewi/levelmix/pkg/storage"
 )
 
+// processingQueue is the part of audio.QueueManager uploads use
+type processingQueue interface {
+	EnqueueProcessing(ctx context.Context, task audio.ProcessTask) error
+	RequeueProcessing(ctx context.Context, task audio.ProcessTask) error
+	QueuePosition(jobID string) (*audio.QueueEstimate, error)
+}
+
 type UploadHandler struct {
 	storage     storage.AudioStorage
 	metadata    storage.MetadataStorage
-	queue       *audio.QueueManager
+	queue       processingQueue
 	redisClient *redis.Client
+	scheduling  audio.SchedulingConfig
+
+	mu       sync.Mutex
+	claiming map[string]bool // Files a job is being queued for, without Redis
 }
 
 func NewUploadHandler(s storage.AudioStorage, m storage.MetadataStorage, q *audio.QueueManager, redisURL string) *UploadHandler {
@@ -35,12 +51,18 @@ func NewUploadHandler(s storage.AudioStorage, m storage.MetadataStorage, q *audi
 			Password: os.Getenv("REDIS_PASSWORD"),
 		})
 	}
-	return &UploadHandler{
+	h := &UploadHandler{
 		storage:     s,
 		metadata:    m,
-		queue:       q,
 		redisClient: redisClient,
+		scheduling:  audio.LoadSchedulingConfig(),
+		claiming:    make(map[string]bool),
+	}
+	// A nil *QueueManager would make a non-nil interface
+	if q != nil {
+		h.queue = q
 	}
+	return h
 }
 
 func (h *UploadHandler) GetPresignedUploadURL(c *gin.Context) {
@@ -111,7 +133,9 @@ func (h *UploadHandler) GetPresignedUploadURL(c *gin.Context) {
 	key := h.storage.GetUploadKey(fileID, fileFormat)
 	contentType := getContentTypeFromFormat(fileFormat)
 
-	uploadURL, err := h.storage.GetPresignedUploadURL(c.Request.Context(), key, contentType, 15*time.Minute)
+	// The body is capped at the tier's limit, not just the declared size
+	maxSize := limitsFor(currentUser.SubscriptionTier).MaxUploadBytes
+	uploadURL, err := h.storage.GetPresignedUploadURL(c.Request.Context(), key, contentType, maxSize, 15*time.Minute)
 	if err != nil {
 		log.Printf("GetPresignedUploadURL: Failed to generate presigned URL: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate upload URL"})
@@ -133,7 +157,7 @@ func (h *UploadHandler) ConfirmUpload(c *gin.Context) {
 	fileID := c.PostForm("file_id")
 	originalFilename := c.PostForm("filename")
 
-	if fileID == "" || originalFilename == "" {
+	if !validFileID(fileID) || originalFilename == "" {
 		h.returnError(c, "Missing file ID or filename")
 		return
 	}
@@ -152,166 +176,66 @@ func (h *UploadHandler) ConfirmUpload(c *gin.Context) {
 	}
 
 	userIDFromContext := currentUser.ID
-	userTier := currentUser.SubscriptionTier
-	isPremium := currentUser.SubscriptionTier > 1
 
 	log.Printf("ConfirmUpload: User %s confirming upload for file %s", userIDFromContext, fileID)
 
-	// Get target LUFS
-	targetLUFS, err := h.parseTargetLUFS(c.PostForm("target_lufs"))
-	if err != nil {
-		log.Printf("ConfirmUpload: LUFS parsing failed: %v", err)
-		h.returnError(c, err.Error())
-		return
-	}
-
-	// Get processing mode
-	processingModeStr := c.PostForm("processing_mode")
-	if processingModeStr == "" {
-		processingModeStr = "fast"
-	}
-
-	processingMode, err := audio.ValidateProcessingMode(processingModeStr)
-	if err != nil {
-		log.Printf("ConfirmUpload: Invalid processing mode: %v", err)
-		h.returnError(c, "Invalid processing mode selected")
+	// A repeated confirm (double submit, client retry) returns the job the
+	// first one created instead of processing the file twice
+	if existing, err := h.metadata.GetAudioFile(c.Request.Context(), fileID); err == nil {
+		h.confirmExisting(c, existing, userIDFromContext)
 		return
 	}
 
-	log.Printf("ConfirmUpload: Processing mode selected: %s", processingMode)
-
-	// Validate custom LUFS usage - only Premium/Pro users can use custom values
-	if h.isCustomLUFS(targetLUFS) && userTier < 2 {
-		log.Printf("ConfirmUpload: Custom LUFS attempted by non-premium user (Tier: %d)", userTier)
-		h.returnError(c, "Custom LUFS targets are only available for Premium and Professional users")
-		return
-	}
-
-	// Verify file actually exists in S3
 	fileFormat := getFileExtension(originalFilename)
 	if fileFormat == "" {
 		h.returnError(c, "Could not determine audio format from file extension")
 		return
 	}
 
-	key := h.storage.GetUploadKey(fileID, fileFormat)
-
-	info, err := h.storage.GetObjectInfo(c.Request.Context(), key)
+	opts, err := h.parseUploadOptions(c.PostForm, currentUser, fileFormat)
 	if err != nil {
-		log.Printf("ConfirmUpload: Failed to verify S3 upload for %s: %v", fileID, err)
-		h.returnError(c, "Upload verification failed. The file was not found in storage. If you selected this file from cloud storage (Google Drive, Dropbox, OneDrive, etc.), please download it to your device first and try again.")
-		return
-	}
-
-	log.Printf("ConfirmUpload: Verified S3 upload: %s (%d bytes)", fileID, info.Size)
-
-	// Store metadata
-	audioFile := &storage.AudioFile{
-		ID:               fileID,
-		UserID:           &userIDFromContext,
-		OriginalFilename: originalFilename,
-		FileSize:         info.Size,
-		Format:           fileFormat,
-		Status:           "uploaded",
-		LUFSTarget:       targetLUFS,
-		CreatedAt:        time.Now(),
-	}
-
-	if err := h.metadata.CreateAudioFile(c.Request.Context(), audioFile); err != nil {
-		log.Printf("ConfirmUpload: Failed to save audio file metadata for %s: %v", fileID, err)
-		// Clean up S3 file since metadata creation failed
-		h.storage.Delete(c.Request.Context(), key)
-		h.returnError(c, "Failed to save file metadata")
+		log.Printf("ConfirmUpload: Invalid processing options: %v", err)
+		h.returnError(c, err.Error())
 		return
 	}
 
-	// Prepare job for queueing
-	jobID := generateID()
-
-	job := &storage.ProcessingJob{
-		ID:          jobID,
-		AudioFileID: fileID,
-		UserID:      userIDFromContext,
-		Status:      "queued",
-		TargetLUFS:  &targetLUFS,
-		CreatedAt:   time.Now(),
+	jobID, err := h.confirmUpload(c.Request.Context(), currentUser, fileID, originalFilename, opts)
+	if errors.Is(err, errAlreadyConfirmed) {
+		// A concurrent confirm for the same file got there first
+		if existing, getErr := h.metadata.GetAudioFile(c.Request.Context(), fileID); getErr == nil {
+			h.confirmExisting(c, existing, userIDFromContext)
+			return
+		}
 	}
-
-	log.Printf("ConfirmUpload: Creating job record for file %s with jobID %s and UserID '%s'", fileID, jobID, job.UserID)
-
-	if err := h.metadata.CreateJob(c.Request.Context(), job); err != nil {
-		log.Printf("ConfirmUpload: Failed to create job record for %s: %v", fileID, err)
-		h.cleanup(c, fileID, fileFormat)
-		h.returnError(c, "Failed to create processing job")
+	if err != nil {
+		h.returnError(c, err.Error())
 		return
 	}
 
-	noiseReduction := c.PostForm("noise_reduction") == "true"
-
-	task := audio.ProcessTask{
-		JobID:          jobID,
-		FileID:         fileID,
-		TargetLUFS:     targetLUFS,
-		UserID:         userIDFromContext,
-		IsPremium:      isPremium,
-		ProcessingMode: processingMode,
-		NoiseReduction: noiseReduction,
-	}
+	// Return processing state HTML
+	processingHTML := h.generateProcessingHTML(fileID, jobID)
+	c.Data(http.StatusOK, "text/html", []byte(processingHTML))
+}
 
-	log.Printf("ConfirmUpload: Enqueueing processing task for job %s", jobID)
-	if err := h.queue.EnqueueProcessing(c.Request.Context(), task); err != nil {
-		log.Printf("ConfirmUpload: Failed to queue processing task for job %s: %v", jobID, err)
-		h.cleanup(c, fileID, fileFormat)
-		h.returnError(c, "Failed to queue processing")
+// confirmExisting answers a confirm for a file that was already confirmed with
+// the processing state of its job
+func (h *UploadHandler) confirmExisting(c *gin.Context, file *storage.AudioFile, userID string) {
+	if file.UserID == nil || *file.UserID != userID {
+		log.Printf("ConfirmUpload: File %s already belongs to another user", file.ID)
+		h.returnError(c, "Upload verification failed. Please try uploading again.")
 		return
 	}
 
-	// Increment upload stats (same logic as HandleUpload)
-	stats, err := h.metadata.GetUserStats(c.Request.Context(), currentUser.ID)
+	job, err := h.metadata.GetJobByFileID(c.Request.Context(), file.ID)
 	if err != nil {
-		log.Printf("ConfirmUpload: Could not retrieve user stats for %s, creating new: %v", currentUser.ID, err)
-		stats = &storage.UserUploadStats{
-			UserID:                     currentUser.ID,
-			UploadsThisWeek:            0,
-			WeekResetAt:                time.Now(),
-			TotalUploads:               0,
-			TotalProcessingTimeSeconds: 0,
-			ProcessingTimeThisMonth:    0,
-			MonthResetAt:               getMonthStart(time.Now()),
-		}
-		if createErr := h.metadata.CreateUserStats(c.Request.Context(), stats); createErr != nil {
-			log.Printf("ConfirmUpload: Failed to create initial user stats for %s: %v", currentUser.ID, createErr)
-		}
-	}
-
-	stats.UploadsThisWeek++
-	stats.TotalUploads++
-
-	now := time.Now()
-	weekday := now.Weekday()
-	if weekday == time.Sunday {
-		weekday = 7
-	}
-	daysSinceMonday := weekday - time.Monday
-	currentWeekStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -int(daysSinceMonday))
-
-	if stats.WeekResetAt.Before(currentWeekStart) {
-		log.Printf("ConfirmUpload: Weekly reset triggered for user %s. Old reset: %v, New reset: %v", currentUser.ID, stats.WeekResetAt, currentWeekStart)
-		stats.UploadsThisWeek = 0
-		stats.WeekResetAt = currentWeekStart
-		if err := h.metadata.UpdateUserStats(c.Request.Context(), stats); err != nil {
-			log.Printf("ConfirmUpload: Failed to update user stats on weekly reset for %s: %v", currentUser.ID, err)
-		}
-	}
-
-	if err := h.metadata.UpdateUserStats(c.Request.Context(), stats); err != nil {
-		log.Printf("ConfirmUpload: Failed to update user stats with processing time for user %s: %v", currentUser.ID, err)
-	} else {
-		log.Printf("ConfirmUpload: User stats updated for %s. UploadsThisWeek: %d, TotalUploads: %d", currentUser.ID, stats.UploadsThisWeek, stats.TotalUploads)
+		// The first confirm is still creating the job
+		log.Printf("ConfirmUpload: Duplicate confirm for %s before its job was created", file.ID)
+		h.returnError(c, "This upload is already being confirmed. Please wait a moment.")
+		return
 	}
 
-	// Return processing state HTML
-	processingHTML := h.generateProcessingHTML(fileID, jobID)
+	log.Printf("ConfirmUpload: File %s already confirmed, returning job %s", file.ID, job.ID)
+	processingHTML := h.generateProcessingHTML(file.ID, job.ID)
 	c.Data(http.StatusOK, "text/html", []byte(processingHTML))
 }
 
@@ -338,7 +262,6 @@ func (h *UploadHandler) HandleUpload(c *gin.Context) {
 
 	userIDFromContext := currentUser.ID
 	userTier := currentUser.SubscriptionTier
-	isPremium := currentUser.SubscriptionTier > 1
 	log.Printf("UploadHandler: User ID from context: '%s' (Tier: %d)", userIDFromContext, currentUser.SubscriptionTier)
 
 	// Check upload limits
@@ -365,36 +288,13 @@ func (h *UploadHandler) HandleUpload(c *gin.Context) {
 	// Generate unique file ID
 	fileID := generateID()
 
-	// Get target LUFS
-	targetLUFS, err := h.parseTargetLUFS(c.PostForm("target_lufs"))
+	opts, err := h.parseUploadOptions(c.PostForm, currentUser, fileFormat)
 	if err != nil {
-		log.Printf("UploadHandler: LUFS parsing failed: %v", err)
+		log.Printf("UploadHandler: Invalid processing options: %v", err)
 		h.returnError(c, err.Error())
 		return
 	}
 
-	// Get processing mode (new feature)
-	processingModeStr := c.PostForm("processing_mode")
-	if processingModeStr == "" {
-		processingModeStr = "fast" // Default to fast mode
-	}
-
-	processingMode, err := audio.ValidateProcessingMode(processingModeStr)
-	if err != nil {
-		log.Printf("UploadHandler: Invalid processing mode: %v", err)
-		h.returnError(c, "Invalid processing mode selected")
-		return
-	}
-
-	log.Printf("UploadHandler: Processing mode selected: %s", processingMode)
-
-	// Validate custom LUFS usage - only Premium/Pro users can use custom values
-	if h.isCustomLUFS(targetLUFS) && userTier < 2 {
-		log.Printf("UploadHandler: Custom LUFS attempted by non-premium user (Tier: %d)", userTier)
-		h.returnError(c, "Custom LUFS targets are only available for Premium and Professional users")
-		return
-	}
-
 	// Open uploaded file
 	file, err := fileHeader.Open()
 	if err != nil {
@@ -404,121 +304,37 @@ func (h *UploadHandler) HandleUpload(c *gin.Context) {
 	}
 	defer file.Close()
 
-	// Upload to S3
+	// Upload to S3, hashing the content on the way
 	log.Printf("UploadHandler: Attempting to upload file %s (%s) to S3", fileID, fileFormat)
-	if err := h.storage.Upload(c.Request.Context(), fileID, file, fileFormat); err != nil {
+	hash := sha256.New()
+	if err := h.storage.Upload(c.Request.Context(), fileID, io.TeeReader(file, hash), fileFormat); err != nil {
 		log.Printf("UploadHandler: S3 upload failed for file %s: %v", fileID, err)
-		// Clean up metadata if S3 upload fails
-		h.metadata.DeleteAudioFile(c.Request.Context(), fileID)
 		// Need to pass full key to delete from S3
 		h.storage.Delete(c.Request.Context(), h.storage.GetUploadKey(fileID, fileFormat))
-		// Assuming DeleteJob exists and takes jobID
-		// h.metadata.DeleteJob(c.Request.Context(), jobID)
 		h.returnError(c, "Failed to store file")
 		return
 	}
 
-	// Store metadata
 	audioFile := &storage.AudioFile{
 		ID:               fileID,
 		UserID:           &userIDFromContext,
 		OriginalFilename: fileHeader.Filename,
 		FileSize:         fileHeader.Size,
 		Format:           fileFormat, // Use the determined fileFormat
-		Status:           "uploaded",
-		LUFSTarget:       targetLUFS,
+		Status:           storage.StatusUploaded,
+		LUFSTarget:       opts.targetLUFS,
+		ContentHash:      hex.EncodeToString(hash.Sum(nil)),
 		CreatedAt:        time.Now(),
 	}
 
-	if err := h.metadata.CreateAudioFile(c.Request.Context(), audioFile); err != nil {
-		log.Printf("UploadHandler: Failed to save audio file metadata for %s: %v", fileID, err)
-		// Ensure cleanup uses the correct key with format
-		h.storage.Delete(c.Request.Context(), h.storage.GetUploadKey(fileID, fileFormat))
-		h.returnError(c, "Failed to save file metadata")
-		return
-	}
-
-	// Prepare job for queueing
-	jobID := generateID()
-
-	job := &storage.ProcessingJob{
-		ID:          jobID,
-		AudioFileID: fileID,
-		UserID:      userIDFromContext,
-		Status:      "queued",
-		TargetLUFS:  &targetLUFS,
-		CreatedAt:   time.Now(),
-	}
-
-	log.Printf("UploadHandler: Creating job record for file %s with jobID %s and UserID '%s'", fileID, jobID, job.UserID)
-
-	if err := h.metadata.CreateJob(c.Request.Context(), job); err != nil {
-		log.Printf("UploadHandler: Failed to create job record for %s: %v", fileID, err)
-		h.cleanup(c, fileID, fileFormat) // Pass fileFormat to cleanup
-		h.returnError(c, "Failed to create processing job")
-		return
-	}
-
-	noiseReduction := c.PostForm("noise_reduction") == "true"
-
-	task := audio.ProcessTask{
-		JobID:          jobID,
-		FileID:         fileID,
-		TargetLUFS:     targetLUFS,
-		UserID:         userIDFromContext,
-		IsPremium:      isPremium,
-		ProcessingMode: processingMode,
-		NoiseReduction: noiseReduction,
-	}
-
-	log.Printf("UploadHandler: Enqueueing processing task for job %s", jobID)
-	if err := h.queue.EnqueueProcessing(c.Request.Context(), task); err != nil {
-		log.Printf("UploadHandler: Failed to queue processing task for job %s: %v", jobID, err)
-		h.cleanup(c, fileID, fileFormat) // Pass fileFormat to cleanup
-		h.returnError(c, "Failed to queue processing")
-		return
-	}
-
-	// Increment upload stats
-	stats, err := h.metadata.GetUserStats(c.Request.Context(), currentUser.ID)
+	jobID, err := h.queueUpload(c.Request.Context(), currentUser, audioFile, opts)
 	if err != nil {
-		log.Printf("UploadHandler: Could not retrieve user stats for %s, creating new: %v", currentUser.ID, err)
-		stats = &storage.UserUploadStats{
-			UserID:                     currentUser.ID,
-			UploadsThisWeek:            0,
-			WeekResetAt:                time.Now(),
-			TotalUploads:               0,
-			TotalProcessingTimeSeconds: 0,
-		}
-		if createErr := h.metadata.CreateUserStats(c.Request.Context(), stats); createErr != nil {
-			log.Printf("UploadHandler: Failed to create initial user stats for %s: %v", currentUser.ID, createErr)
+		// A form upload can't be confirmed again, so nothing is kept
+		if errors.Is(err, errUsageUnavailable) {
+			h.deleteOriginal(c.Request.Context(), audioFile)
 		}
-	}
-
-	stats.UploadsThisWeek++
-	stats.TotalUploads++
-
-	now := time.Now()
-	weekday := now.Weekday()
-	if weekday == time.Sunday {
-		weekday = 7
-	}
-	daysSinceMonday := weekday - time.Monday
-	currentWeekStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -int(daysSinceMonday))
-
-	if stats.WeekResetAt.Before(currentWeekStart) {
-		log.Printf("checkUploadLimits: Weekly reset triggered for user %s. Old reset: %v, New reset: %v", currentUser.ID, stats.WeekResetAt, currentWeekStart)
-		stats.UploadsThisWeek = 0
-		stats.WeekResetAt = currentWeekStart
-		if err := h.metadata.UpdateUserStats(c.Request.Context(), stats); err != nil {
-			log.Printf("checkUploadLimits: Failed to update user stats on weekly reset for %s: %v", currentUser.ID, err)
-		}
-	}
-
-	if err := h.metadata.UpdateUserStats(c.Request.Context(), stats); err != nil {
-		log.Printf("UploadHandler: Failed to update user stats with processing time for user %s: %v", currentUser.ID, err)
-	} else {
-		log.Printf("UploadHandler: User stats updated for %s. UploadsThisWeek: %d, TotalUploads: %d", currentUser.ID, stats.UploadsThisWeek, stats.TotalUploads)
+		h.returnError(c, err.Error())
+		return
 	}
 
 	// Return processing state HTML
@@ -533,10 +349,16 @@ func (h *UploadHandler) GetStatus(c *gin.Context) {
 		return
 	}
 
+	response, code := h.buildStatus(c.Request.Context(), fileID)
+	c.JSON(code, response)
+}
+
+// buildStatus returns the status JSON for a file and its HTTP status code. It
+// is shared by the polling endpoint and the event stream.
+func (h *UploadHandler) buildStatus(ctx context.Context, fileID string) (gin.H, int) {
 	// First check Redis for real-time progress
 	if h.redisClient != nil {
-		key := fmt.Sprintf("progress:%s", fileID)
-		result := h.redisClient.HGetAll(c.Request.Context(), key)
+		result := h.redisClient.HGetAll(ctx, audio.ProgressKey(fileID))
 
 		if data, err := result.Result(); err == nil && len(data) > 0 {
 			progress := 0
@@ -563,8 +385,22 @@ func (h *UploadHandler) GetStatus(c *gin.Context) {
 				"silenceTrimmed": silenceTrimmed,
 			}
 
+			// Estimated seconds left, reported while ffmpeg is running
+			if e, exists := data["eta_seconds"]; exists {
+				if eta, err := strconv.Atoi(e); err == nil && eta > 0 {
+					response["etaSeconds"] = eta
+				}
+			}
+
+			// Sequence number of the last update, used as the stream event ID
+			if sq, exists := data["seq"]; exists {
+				if seq, err := strconv.ParseInt(sq, 10, 64); err == nil {
+					response["seq"] = seq
+				}
+			}
+
 			// Get audio file metadata
-			if audioFile, err := h.metadata.GetAudioFile(c.Request.Context(), fileID); err == nil {
+			if audioFile, err := h.metadata.GetAudioFile(ctx, fileID); err == nil {
 				response["filename"] = audioFile.OriginalFilename
 				response["format"] = audioFile.Format
 				response["fileSize"] = audioFile.FileSize
@@ -573,31 +409,48 @@ func (h *UploadHandler) GetStatus(c *gin.Context) {
 				}
 			}
 
-			c.JSON(http.StatusOK, response)
-			return
+			if status == storage.StatusQueued {
+				if job, err := h.metadata.GetJobByFileID(ctx, fileID); err == nil && job.Status == storage.JobQueued {
+					h.addQueueEstimate(response, job)
+				}
+			}
+
+			if status == "failed" {
+				if job, err := h.metadata.GetJobByFileID(ctx, fileID); err == nil && job.ErrorMessage != nil {
+					response["error"] = *job.ErrorMessage
+					if job.ErrorCategory != nil {
+						response["errorCategory"] = *job.ErrorCategory
+					}
+				}
+			}
+
+			return response, http.StatusOK
 		}
 	}
 
 	// Fallback to database (existing logic)
-	audioFile, err := h.metadata.GetAudioFile(c.Request.Context(), fileID)
+	audioFile, err := h.metadata.GetAudioFile(ctx, fileID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return gin.H{"error": "File not found"}, http.StatusNotFound
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
-		return
+		// Pollers keep trying on 5xx but give up on 404
+		log.Printf("GetStatus: Failed to get audio file %s: %v", fileID, err)
+		return gin.H{"error": "Failed to get status"}, http.StatusInternalServerError
 	}
 
-	job, err := h.metadata.GetJobByFileID(c.Request.Context(), fileID)
+	job, err := h.metadata.GetJobByFileID(ctx, fileID)
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
+		return gin.H{
 			"status":   audioFile.Status,
 			"progress": 0,
 			"fileID":   fileID,
-		})
-		return
+		}, http.StatusOK
 	}
 
-	progress := getProgressFromStatus(job.Status)
+	progress := getProgressFromStatus(string(job.Status))
 	response := gin.H{
-		"status":   job.Status,
+		"status":   string(job.Status),
 		"progress": progress,
 		"fileID":   fileID,
 		"filename": audioFile.OriginalFilename,
@@ -610,11 +463,52 @@ func (h *UploadHandler) GetStatus(c *gin.Context) {
 		response["durationSeconds"] = *audioFile.DurationSeconds
 	}
 
-	if job.Status == "failed" && job.ErrorMessage != nil {
+	if job.Status == storage.JobFailed && job.ErrorMessage != nil {
 		response["error"] = *job.ErrorMessage
+		if job.ErrorCategory != nil {
+			response["errorCategory"] = *job.ErrorCategory
+		}
+	}
+
+	if job.Status == storage.JobQueued {
+		h.addQueueEstimate(response, job)
 	}
 
-	c.JSON(http.StatusOK, response)
+	return response, http.StatusOK
+}
+
+// addQueueEstimate adds a queued job's schedule, place in line and expected
+// wait to a status response
+func (h *UploadHandler) addQueueEstimate(response gin.H, job *storage.ProcessingJob) {
+	if job.Spec != nil {
+		if job.Spec.ProcessAfter != nil && job.Spec.ProcessAfter.After(time.Now()) {
+			response["scheduledFor"] = job.Spec.ProcessAfter.Format(time.RFC3339)
+		}
+		if job.Spec.OffPeak {
+			response["offPeak"] = true
+		}
+	}
+
+	if h.queue == nil {
+		return
+	}
+
+	estimate, err := h.queue.QueuePosition(job.ID)
+	if err != nil {
+		log.Printf("buildStatus: Failed to get queue position for job %s: %v", job.ID, err)
+		return
+	}
+	if estimate == nil || estimate.Position == 0 {
+		return
+	}
+
+	response["queuePosition"] = estimate.Position
+	if estimate.WaitMax > 0 {
+		// queueEtaSeconds is the cautious end of the range
+		response["queueEtaSeconds"] = int(estimate.WaitMax.Seconds())
+		response["queueEtaMinSeconds"] = int(estimate.WaitMin.Seconds())
+		response["queueEtaMaxSeconds"] = int(estimate.WaitMax.Seconds())
+	}
 }
 
 func (h *UploadHandler) RetryJob(c *gin.Context) {
@@ -625,68 +519,86 @@ func (h *UploadHandler) RetryJob(c *gin.Context) {
 	}
 
 	// Get the job to verify it exists and get its status
-	job, err := h.metadata.GetJobByFileID(c.Request.Context(), fileID)
-	if err != nil {
+	job, err := h.requestedJob(c, fileID)
+	if errors.Is(err, storage.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
 		return
 	}
+	if err != nil {
+		log.Printf("RetryJob: Failed to get job for file %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job"})
+		return
+	}
 
 	// Only allow retrying failed jobs
-	if job.Status != "failed" {
+	if job.Status != storage.JobFailed {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Can only retry failed jobs"})
 		return
 	}
+	release, ok, err := h.claimFile(c.Request.Context(), fileID)
+	if err != nil {
+		log.Printf("RetryJob: Failed to claim file %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "This file is already being processed"})
+		return
+	}
+	defer release()
 
-	// Get user to check premium status
-	user, err := h.metadata.GetUser(c.Request.Context(), job.UserID)
+	task, err := h.retryTask(c.Request.Context(), job)
 	if err != nil {
-		log.Printf("RetryJob: Failed to get user %s: %v", job.UserID, err)
+		log.Printf("RetryJob: Failed to rebuild task for job %s: %v", job.ID, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user information"})
 		return
 	}
 
-	// Determine if user is premium (tier 2 = Premium, tier 3 = Professional)
-	isPremium := user.SubscriptionTier >= 2
-
-	// Default to precise mode for retry (we don't store this in the job)
-	processingMode := audio.ModePrecise
+	// Move the job back to queued first, so concurrent retries can't both enqueue
+	previousError, previousCategory := job.ErrorMessage, job.ErrorCategory
+	job.ErrorMessage = nil
+	job.ErrorCategory = nil
+	job.CompletedAt = nil
 
-	// Get target LUFS (use default if not set)
-	targetLUFS := audio.DefaultLUFS
-	if job.TargetLUFS != nil {
-		targetLUFS = *job.TargetLUFS
+	if err := storage.TransitionJob(c.Request.Context(), h.metadata, job, storage.JobQueued, "retried by user"); err != nil {
+		if errors.Is(err, storage.ErrStatusConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Job status changed, please refresh and try again"})
+			return
+		}
+		log.Printf("RetryJob: Failed to update job status to queued for %s: %v", job.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update job status"})
+		return
 	}
 
-	// Create a new processing task
-	task := audio.ProcessTask{
-		JobID:          job.ID,
-		FileID:         fileID,
-		UserID:         job.UserID,
-		TargetLUFS:     targetLUFS,
-		IsPremium:      isPremium,
-		ProcessingMode: processingMode,
+	// The failed attempt gave its processing time back, so the retry needs
+	// it again. Reserving after winning the transition keeps a concurrent
+	// retry from releasing this one's reservation.
+	if message := h.reserveRetry(c.Request.Context(), job, task.OffPeak); message != "" {
+		job.ErrorMessage, job.ErrorCategory = previousError, previousCategory
+		if err := storage.TransitionJob(c.Request.Context(), h.metadata, job, storage.JobFailed, "not enough processing time to retry"); err != nil {
+			log.Printf("RetryJob: Failed to restore failed status for %s: %v", job.ID, err)
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": message})
+		return
 	}
 
+	h.resetProgress(c.Request.Context(), fileID)
+
 	log.Printf("RetryJob: Re-enqueueing processing task for job %s (file %s)", job.ID, fileID)
-	if err := h.queue.EnqueueProcessing(c.Request.Context(), task); err != nil {
+	if err := h.queue.RequeueProcessing(c.Request.Context(), task); err != nil {
 		log.Printf("RetryJob: Failed to re-queue processing task for job %s: %v", job.ID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job"})
-		return
-	}
 
-	// Update job status back to queued
-	job.Status = "queued"
-	job.ErrorMessage = nil
-	job.CompletedAt = nil
-
-	if err := h.metadata.UpdateJob(c.Request.Context(), job); err != nil {
-		log.Printf("RetryJob: Failed to update job status to queued for %s: %v", job.ID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update job status"})
+		h.releaseProcessingTime(c.Request.Context(), job.ID)
+		job.ErrorMessage, job.ErrorCategory = previousError, previousCategory
+		if err := storage.TransitionJob(c.Request.Context(), h.metadata, job, storage.JobFailed, "retry could not be queued"); err != nil {
+			log.Printf("RetryJob: Failed to restore failed status for %s: %v", job.ID, err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job"})
 		return
 	}
 
 	// Update audio file status
-	if err := h.metadata.UpdateStatus(c.Request.Context(), fileID, "queued"); err != nil {
+	if err := h.metadata.UpdateStatus(c.Request.Context(), fileID, storage.StatusQueued); err != nil {
 		log.Printf("RetryJob: Failed to update file status to queued for %s: %v", fileID, err)
 	}
 
@@ -698,6 +610,330 @@ func (h *UploadHandler) RetryJob(c *gin.Context) {
 	})
 }
 
+// reserveRetry reserves processing time for retrying job, returning a
+// message for the user if it can't
+func (h *UploadHandler) reserveRetry(ctx context.Context, job *storage.ProcessingJob, offPeak bool) string {
+	if job.UserID == "" {
+		return ""
+	}
+
+	user, err := h.metadata.GetUser(ctx, job.UserID)
+	if err != nil {
+		log.Printf("reserveRetry: Failed to get user %s of job %s: %v", job.UserID, job.ID, err)
+		return errUsageUnavailable.Error()
+	}
+	audioFile, err := h.metadata.GetAudioFile(ctx, job.AudioFileID)
+	if err != nil {
+		log.Printf("reserveRetry: Failed to get file %s of job %s: %v", job.AudioFileID, job.ID, err)
+		return "The original upload is no longer available. Please upload the file again."
+	}
+
+	if err := h.reserveProcessingTime(ctx, user, job.ID, audioFile, offPeak); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// retryTask rebuilds the task a job was submitted with. Jobs created before
+// specs were stored fall back to precise mode and the user's current tier.
+func (h *UploadHandler) retryTask(ctx context.Context, job *storage.ProcessingJob) (audio.ProcessTask, error) {
+	var task audio.ProcessTask
+	if job.Spec != nil {
+		// An off-peak job that missed its window waits for the next one
+		task = audio.TaskFromSpec(job, *job.Spec)
+		h.scheduling.Schedule(&task, time.Now())
+	} else {
+		user, err := h.metadata.GetUser(ctx, job.UserID)
+		if err != nil {
+			return audio.ProcessTask{}, fmt.Errorf("failed to get user %s: %w", job.UserID, err)
+		}
+
+		targetLUFS := audio.DefaultLUFS
+		if job.TargetLUFS != nil {
+			targetLUFS = *job.TargetLUFS
+		}
+
+		task = audio.TaskFromSpec(job, storage.JobSpec{
+			TargetLUFS:     targetLUFS,
+			ProcessingMode: string(audio.ModePrecise),
+			IsPremium:      user.SubscriptionTier >= 2, // tier 2 = Premium, tier 3 = Professional
+		})
+	}
+
+	// The task's timeouts scale with the file's length
+	if audioFile, err := h.metadata.GetAudioFile(ctx, job.AudioFileID); err == nil {
+		task.DurationSeconds = fileDuration(audioFile)
+	}
+	return task, nil
+}
+
+// ReprocessJob starts a new job for an already processed file, reusing the
+// original upload. Options not posted are copied from the completed job.
+func (h *UploadHandler) ReprocessJob(c *gin.Context) {
+	fileID := c.Param("id")
+	if fileID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File ID required"})
+		return
+	}
+
+	userInterface, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	currentUser, ok := userInterface.(*storage.User)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user session"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	original, err := h.requestedJob(c, fileID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("ReprocessJob: Failed to get job for file %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job"})
+		return
+	}
+	if err != nil || original.UserID != currentUser.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if original.Status != storage.JobCompleted {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Can only re-process completed jobs"})
+		return
+	}
+	release, ok, err := h.claimFile(ctx, fileID)
+	if err != nil {
+		log.Printf("ReprocessJob: Failed to claim file %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create processing job"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "This file is already being processed"})
+		return
+	}
+	defer release()
+
+	audioFile, err := h.metadata.GetAudioFile(ctx, fileID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if !canReprocess(audioFile) {
+		c.JSON(http.StatusGone, gin.H{"error": "The original upload is no longer kept. Please upload the file again."})
+		return
+	}
+	if _, err := h.storage.GetObjectInfo(ctx, storage.OriginalKey(h.storage, audioFile)); err != nil {
+		log.Printf("ReprocessJob: Original upload for %s not available: %v", fileID, err)
+		c.JSON(http.StatusGone, gin.H{"error": "The original upload is no longer available. Please upload the file again."})
+		return
+	}
+
+	base, err := h.retryTask(ctx, original)
+	if err != nil {
+		log.Printf("ReprocessJob: Failed to rebuild task for job %s: %v", original.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read original job"})
+		return
+	}
+	spec := base.Spec()
+
+	// Options are re-checked against the user's current tier
+	isPremium := currentUser.SubscriptionTier > 1
+	spec.IsPremium = isPremium
+
+	if v := c.PostForm("target_lufs"); v != "" {
+		targetLUFS, err := h.parseTargetLUFS(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		spec.TargetLUFS = targetLUFS
+	}
+	if h.isCustomLUFS(spec.TargetLUFS) && !isPremium {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Custom LUFS targets are only available for Premium and Professional users"})
+		return
+	}
+
+	if v := c.PostForm("processing_mode"); v != "" {
+		processingMode, err := audio.ValidateProcessingMode(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid processing mode selected"})
+			return
+		}
+		spec.ProcessingMode = string(processingMode)
+	}
+
+	if v := c.PostForm("sample_rate"); v != "" {
+		sampleRate, err := h.parseSampleRate(v, isPremium, audioFile.Format)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		spec.SampleRate = sampleRate
+	} else if !isPremium {
+		spec.SampleRate = 0
+	}
+
+	if v := c.PostForm("preset"); v != "" {
+		spec.Preset = v
+	}
+	if v := c.PostForm("noise_reduction"); v != "" {
+		spec.NoiseReduction = v == "true"
+	}
+
+	// Scheduling isn't inherited; the new job runs now unless asked otherwise
+	processAfter, err := parseProcessAfter(c.PostForm("process_after"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	schedule := audio.ProcessTask{ProcessAfter: processAfter, OffPeak: c.PostForm("off_peak") == "true"}
+	h.scheduling.Schedule(&schedule, time.Now())
+	spec.ProcessAfter, spec.OffPeak = schedule.ProcessAfter, schedule.OffPeak
+
+	targetLUFS := spec.TargetLUFS
+	job := &storage.ProcessingJob{
+		ID:          generateID(),
+		AudioFileID: fileID,
+		UserID:      currentUser.ID,
+		Status:      storage.JobQueued,
+		TargetLUFS:  &targetLUFS,
+		Spec:        &spec,
+		ParentJobID: &original.ID,
+		CreatedAt:   time.Now(),
+	}
+
+	if err := h.reserveProcessingTime(ctx, currentUser, job.ID, audioFile, spec.OffPeak); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.metadata.CreateJob(ctx, job); err != nil {
+		log.Printf("ReprocessJob: Failed to create job record for %s: %v", fileID, err)
+		h.releaseProcessingTime(ctx, job.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create processing job"})
+		return
+	}
+
+	h.resetProgress(ctx, fileID)
+
+	log.Printf("ReprocessJob: Enqueueing job %s re-processing job %s (file %s)", job.ID, original.ID, fileID)
+	task := audio.TaskFromSpec(job, spec)
+	task.DurationSeconds = fileDuration(audioFile)
+	if err := h.queue.EnqueueProcessing(ctx, task); err != nil {
+		log.Printf("ReprocessJob: Failed to queue processing task for job %s: %v", job.ID, err)
+		h.releaseProcessingTime(ctx, job.ID)
+		if err := storage.TransitionJob(ctx, h.metadata, job, storage.JobFailed, "could not be queued"); err != nil {
+			log.Printf("ReprocessJob: Failed to mark job %s failed: %v", job.ID, err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue processing"})
+		return
+	}
+
+	if err := h.metadata.UpdateStatus(ctx, fileID, storage.StatusQueued); err != nil {
+		log.Printf("ReprocessJob: Failed to update file status to queued for %s: %v", fileID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "queued",
+		"fileID": fileID,
+		"jobID":  job.ID,
+	})
+}
+
+// requestedJob returns the job named by the job_id parameter, or the file's
+// latest job when none is given
+func (h *UploadHandler) requestedJob(c *gin.Context, fileID string) (*storage.ProcessingJob, error) {
+	jobID := c.Query("job_id")
+	if jobID == "" {
+		jobID = c.PostForm("job_id")
+	}
+	if jobID == "" {
+		return h.metadata.GetJobByFileID(c.Request.Context(), fileID)
+	}
+
+	job, err := h.metadata.GetJob(c.Request.Context(), jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.AudioFileID != fileID {
+		return nil, fmt.Errorf("job %s does not belong to file %s: %w", jobID, fileID, storage.ErrNotFound)
+	}
+	return job, nil
+}
+
+// fileClaimTTL bounds how long a request that died keeps a file claimed
+const fileClaimTTL = time.Minute
+
+// claimFile reserves the file for one request queueing a job for it and
+// returns a func that releases it. It reports false if another request holds
+// the file or its latest job is still queued or running: progress and
+// cancellation are tracked per file, so only one job may be active. Hold the
+// claim until the new job is saved as queued.
+func (h *UploadHandler) claimFile(ctx context.Context, fileID string) (func(), bool, error) {
+	release, ok, err := h.lockFile(ctx, fileID)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if latest, err := h.metadata.GetJobByFileID(ctx, fileID); err == nil && !latest.Status.IsTerminal() {
+		release()
+		return nil, false, nil
+	}
+	return release, true, nil
+}
+
+// lockFile takes the lock claimFile holds, or reports false if it is taken
+func (h *UploadHandler) lockFile(ctx context.Context, fileID string) (func(), bool, error) {
+	rdb := h.redisClient
+	if rdb == nil {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.claiming[fileID] {
+			return nil, false, nil
+		}
+		h.claiming[fileID] = true
+		return func() {
+			h.mu.Lock()
+			delete(h.claiming, fileID)
+			h.mu.Unlock()
+		}, true, nil
+	}
+
+	key := fmt.Sprintf("file-claim:%s", fileID)
+	token := generateID()
+	ok, err := rdb.SetNX(ctx, key, token, fileClaimTTL).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return func() {
+		// The request context may already be done
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := releaseLockScript.Run(ctx, rdb, []string{key}, token).Err(); err != nil {
+			log.Printf("Failed to release claim on file %s: %v", fileID, err)
+		}
+	}, true, nil
+}
+
+// resetProgress clears what the file's previous job left in Redis before
+// another job for the file is queued
+func (h *UploadHandler) resetProgress(ctx context.Context, fileID string) {
+	resetProgress(ctx, h.redisClient, fileID)
+}
+
+func resetProgress(ctx context.Context, rdb *redis.Client, fileID string) {
+	if rdb == nil {
+		return
+	}
+
+	rdb.Del(ctx, fmt.Sprintf("cancel:%s", fileID))
+	rdb.HDel(ctx, audio.ProgressKey(fileID), "silence_trimmed")
+	if err := audio.PublishProgress(ctx, rdb, fileID, 0, storage.StatusQueued, 0); err != nil {
+		log.Printf("Failed to reset progress for %s: %v", fileID, err)
+	}
+}
+
 func (h *UploadHandler) CancelJob(c *gin.Context) {
 	fileID := c.Param("id")
 	if fileID == "" {
@@ -707,23 +943,46 @@ func (h *UploadHandler) CancelJob(c *gin.Context) {
 
 	// Get the job to verify it exists and get its status
 	job, err := h.metadata.GetJobByFileID(c.Request.Context(), fileID)
-	if err != nil {
+	if errors.Is(err, storage.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
 		return
 	}
+	if err != nil {
+		log.Printf("CancelJob: Failed to get job for file %s: %v", fileID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get job"})
+		return
+	}
 
 	// Don't allow cancelling completed or already failed jobs
-	if job.Status == "completed" {
+	if job.Status == storage.JobCompleted {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot cancel completed job"})
 		return
 	}
 
-	if job.Status == "failed" || job.Status == "cancelled" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Job already terminated"})
+	// Update job status in database; this fails if the worker or another
+	// request changed the job since it was read
+	cancelledMsg := "Job cancelled by user"
+	job.ErrorMessage = &cancelledMsg
+	now := time.Now()
+	job.CompletedAt = &now
+
+	if err := storage.TransitionJob(c.Request.Context(), h.metadata, job, storage.JobCancelled, "cancelled by user"); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvalidTransition):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Job already terminated"})
+		case errors.Is(err, storage.ErrStatusConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": "Job status changed, please refresh and try again"})
+		default:
+			log.Printf("Failed to update job status to cancelled for %s: %v", fileID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job"})
+		}
 		return
 	}
 
-	// Mark job as cancelled in Redis (for in-progress jobs)
+	// The job won't be charged; give back the time it reserved
+	h.releaseProcessingTime(c.Request.Context(), job.ID)
+
+	// Signal the worker to stop any in-progress processing
 	if h.redisClient != nil {
 		cancelKey := fmt.Sprintf("cancel:%s", fileID)
 		if err := h.redisClient.Set(c.Request.Context(), cancelKey, "true", 10*time.Minute).Err(); err != nil {
@@ -731,37 +990,29 @@ func (h *UploadHandler) CancelJob(c *gin.Context) {
 		}
 
 		// Update progress to indicate cancellation
-		progressKey := fmt.Sprintf("progress:%s", fileID)
-		h.redisClient.HMSet(c.Request.Context(), progressKey, map[string]interface{}{
-			"status":     "cancelled",
-			"progress":   0,
-			"updated_at": time.Now().Unix(),
-		})
-	}
-
-	// Update job status in database
-	cancelledMsg := "Job cancelled by user"
-	job.Status = "cancelled"
-	job.ErrorMessage = &cancelledMsg
-	now := time.Now()
-	job.CompletedAt = &now
-
-	if err := h.metadata.UpdateJob(c.Request.Context(), job); err != nil {
-		log.Printf("Failed to update job status to cancelled for %s: %v", fileID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job"})
-		return
+		if err := audio.PublishProgress(c.Request.Context(), h.redisClient, fileID, 0, storage.StatusCancelled, 0); err != nil {
+			log.Printf("Failed to publish cancellation for job %s: %v", fileID, err)
+		}
 	}
 
 	// Update audio file status
-	if err := h.metadata.UpdateStatus(c.Request.Context(), fileID, "cancelled"); err != nil {
+	if err := h.metadata.UpdateStatus(c.Request.Context(), fileID, storage.StatusCancelled); err != nil {
 		log.Printf("Failed to update file status to cancelled for %s: %v", fileID, err)
 	}
 
-	// Get audio file to determine format for cleanup
-	audioFile, err := h.metadata.GetAudioFile(c.Request.Context(), fileID)
-	if err == nil {
-		// Clean up uploaded file from S3
-		h.cleanup(c, fileID, audioFile.Format)
+	// Clean up what this job wrote. The file and its other jobs stay, and so
+	// does the original if an earlier job completed and it is kept for
+	// re-processing, or another file shares it.
+	if audioFile, err := h.metadata.GetAudioFile(c.Request.Context(), fileID); err == nil {
+		for _, format := range outputFormats(job, audioFile) {
+			outputKey := h.storage.GetJobProcessedKey(fileID, job.ID, format)
+			if err := h.storage.Delete(c.Request.Context(), outputKey); err != nil {
+				log.Printf("CancelJob: Failed to delete output %s: %v", outputKey, err)
+			}
+		}
+		if !storage.HasCompletedJob(c.Request.Context(), h.metadata, fileID) {
+			h.deleteOriginal(c.Request.Context(), audioFile)
+		}
 	}
 
 	log.Printf("Job %s cancelled by user", job.ID)
@@ -772,57 +1023,153 @@ func (h *UploadHandler) CancelJob(c *gin.Context) {
 	})
 }
 
-func (h *UploadHandler) checkUploadLimits(c *gin.Context, user *storage.User) error {
-	stats, err := h.metadata.GetUserStats(c.Request.Context(), user.ID)
-	if err != nil {
-		log.Printf("checkUploadLimits: No stats found for user %s, initializing default. Error: %v", user.ID, err)
-		stats = &storage.UserUploadStats{
-			UserID:                     user.ID,
-			ProcessingTimeThisMonth:    0,
-			MonthResetAt:               getMonthStart(time.Now()),
-			TotalUploads:               0,
-			TotalProcessingTimeSeconds: 0,
-			// Keep old fields during transition
-			UploadsThisWeek: 0,
-			WeekResetAt:     time.Now(),
-		}
-		if createErr := h.metadata.CreateUserStats(c.Request.Context(), stats); createErr != nil {
-			log.Printf("checkUploadLimits: Failed to create initial user stats for %s: %v", user.ID, createErr)
-		}
+// outputFormats are the formats job's output may be stored in. The worker
+// may still be uploading it, so they follow from the job's options the way
+// the worker chooses them; jobs without stored options could have either.
+func outputFormats(job *storage.ProcessingJob, audioFile *storage.AudioFile) []string {
+	if job.Spec != nil {
+		return []string{audio.OutputFormat(job.Spec.IsPremium, audioFile.Format)}
+	}
+	formats := []string{audio.OutputFormat(false, audioFile.Format)}
+	if premium := audio.OutputFormat(true, audioFile.Format); premium != formats[0] {
+		formats = append(formats, premium)
 	}
+	return formats
+}
 
-	// Use NEW monthly processing time limit
+// countUpload adds an upload to the user's stats. Processing time is
+// reserved in the usage ledger when a job is queued and charged when it
+// completes.
+func (h *UploadHandler) countUpload(ctx context.Context, userID string) {
+	stats, err := h.metadata.GetUserStats(ctx, userID)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		stats = &storage.UserUploadStats{UserID: userID}
+	case err != nil:
+		// Counting against zeroed stats would overwrite the real ones
+		log.Printf("countUpload: Failed to load user stats for %s, upload not counted: %v", userID, err)
+		return
+	}
+
+	now := time.Now()
+	stats.TotalUploads++
+	stats.LastUploadAt = &now
+	if err := h.metadata.UpdateUserStats(ctx, stats); err != nil {
+		log.Printf("countUpload: Failed to update user stats for %s: %v", userID, err)
+	}
+}
+
+func (h *UploadHandler) checkUploadLimits(c *gin.Context, user *storage.User) error {
 	processingTimeLimit := getProcessingTimeLimit(user.SubscriptionTier)
 	if processingTimeLimit == -1 {
 		log.Printf("checkUploadLimits: User %s has unlimited processing time.", user.ID)
 		return nil
 	}
 
-	// Check for MONTHLY reset (not weekly)
-	now := time.Now()
-	currentMonthStart := getMonthStart(now)
-
-	if stats.MonthResetAt.Before(currentMonthStart) {
-		log.Printf("checkUploadLimits: Monthly reset triggered for user %s. Old reset: %v, New reset: %v", user.ID, stats.MonthResetAt, currentMonthStart)
-		stats.ProcessingTimeThisMonth = 0
-		stats.MonthResetAt = currentMonthStart
-		if err := h.metadata.UpdateUserStats(c.Request.Context(), stats); err != nil {
-			log.Printf("checkUploadLimits: Failed to update user stats on monthly reset for %s: %v", user.ID, err)
-		}
+	// The ledger is kept per billing month, so there is nothing to reset
+	usage, err := h.metadata.GetUsage(c.Request.Context(), user.ID, storage.UsagePeriod(time.Now()))
+	if err != nil {
+		log.Printf("checkUploadLimits: Failed to load usage for %s: %v", user.ID, err)
+		return errUsageUnavailable
 	}
 
 	// Check if user has reached monthly processing time limit
-	if stats.ProcessingTimeThisMonth >= processingTimeLimit {
-		usedTime := formatDurationDecimal(stats.ProcessingTimeThisMonth)
+	if usage.Seconds >= processingTimeLimit {
+		usedTime := formatDurationDecimal(usage.Seconds)
 		limitTime := formatDurationDecimal(processingTimeLimit)
-		log.Printf("checkUploadLimits: User %s reached monthly processing time limit (%d/%d seconds).", user.ID, stats.ProcessingTimeThisMonth, processingTimeLimit)
+		log.Printf("checkUploadLimits: User %s reached monthly processing time limit (%d/%d seconds).", user.ID, usage.Seconds, processingTimeLimit)
 		return fmt.Errorf("You've reached your monthly processing time limit (%s / %s used). Resets on the 1st of next month. Upgrade your plan for more time.", usedTime, limitTime)
 	}
 
-	log.Printf("checkUploadLimits: User %s is within limits (%d/%d seconds).", user.ID, stats.ProcessingTimeThisMonth, processingTimeLimit)
+	log.Printf("checkUploadLimits: User %s is within limits (%d/%d seconds).", user.ID, usage.Seconds, processingTimeLimit)
 	return nil
 }
 
+// errUsageUnavailable means the usage ledger couldn't be read or written;
+// unlike running out of time it is worth trying again
+var errUsageUnavailable = errors.New("Could not check your processing time right now. Please try again in a moment.")
+
+// reserveProcessingTime holds what a job on file will be charged against the
+// user's monthly allowance until it finishes, so queued jobs can't add up to
+// more than is left. A file without a duration is measured first. The error
+// is a message for the user.
+func (h *UploadHandler) reserveProcessingTime(ctx context.Context, user *storage.User, jobID string, file *storage.AudioFile, offPeak bool) error {
+	limit := getProcessingTimeLimit(user.SubscriptionTier)
+
+	if file.DurationSeconds == nil || *file.DurationSeconds <= 0 {
+		duration, err := h.probeDuration(ctx, file)
+		if err != nil {
+			log.Printf("reserveProcessingTime: Failed to read duration of %s: %v", file.ID, err)
+			if limit == -1 {
+				// Nothing to enforce; the worker measures the file itself
+				return nil
+			}
+			return errors.New("Could not read the length of this audio file. Please check that it plays and try again.")
+		}
+		file.DurationSeconds = &duration
+	}
+
+	entry := &storage.UsageEntry{
+		UserID:       user.ID,
+		JobID:        jobID,
+		Seconds:      h.scheduling.ChargedSeconds(*file.DurationSeconds, offPeak),
+		AudioSeconds: *file.DurationSeconds,
+		Tier:         user.SubscriptionTier,
+	}
+	err := h.metadata.ReserveUsage(ctx, entry, limit)
+	if errors.Is(err, storage.ErrQuotaExceeded) {
+		log.Printf("reserveProcessingTime: User %s is out of processing time: %v", user.ID, err)
+
+		used := limit
+		if usage, err := h.metadata.GetUsage(ctx, user.ID, entry.Period); err == nil {
+			used = usage.Seconds
+		}
+		return quotaExceededError(entry.Seconds, used, limit)
+	}
+	if err != nil {
+		log.Printf("reserveProcessingTime: Failed to reserve processing time for job %s: %v", jobID, err)
+		return errUsageUnavailable
+	}
+
+	log.Printf("reserveProcessingTime: Reserved %ds for job %s of user %s", entry.Seconds, jobID, user.ID)
+	return nil
+}
+
+// quotaExceededError tells the user how far a job of needed seconds is over
+// what is left of their monthly limit
+func quotaExceededError(needed, used, limit int) error {
+	left := max(limit-used, 0)
+	return fmt.Errorf("This file needs %s of processing time, but only %s of your monthly %s is left (%s used or reserved by queued jobs). Resets on the 1st of next month. Upgrade your plan for more time.",
+		formatDuration(needed), formatDuration(left), formatDuration(limit), formatDuration(used))
+}
+
+// releaseProcessingTime returns a job's reservation when it won't be
+// processed
+func (h *UploadHandler) releaseProcessingTime(ctx context.Context, jobID string) {
+	if err := h.metadata.ReleaseUsage(ctx, jobID); err != nil {
+		log.Printf("releaseProcessingTime: Failed to release processing time of job %s: %v", jobID, err)
+	}
+}
+
+// probeDuration measures a file's original the way the worker does, so the
+// time reserved for it is the time it is charged
+func (h *UploadHandler) probeDuration(ctx context.Context, file *storage.AudioFile) (int, error) {
+	fileID := file.ID
+	url, err := h.storage.GetPresignedURL(ctx, storage.OriginalKey(h.storage, file), 10*time.Minute, file.Format)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get URL of upload %s: %w", fileID, err)
+	}
+
+	duration, err := audio.ProbeDuration(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+	if duration < 1 {
+		return 0, fmt.Errorf("upload %s has no audio (duration %.2fs)", fileID, duration)
+	}
+	return int(duration), nil
+}
+
 // returnError sends an inline error response that the frontend can handle
 func (h *UploadHandler) returnError(c *gin.Context, message string) {
 	errorHTML := fmt.Sprintf(`
@@ -894,23 +1241,65 @@ func (h *UploadHandler) parseTargetLUFS(lufsStr string) (float64, error) {
 	return parsed, nil
 }
 
-// cleanup removes uploaded file, processed file, and metadata on error
-func (h *UploadHandler) cleanup(ctx *gin.Context, fileID string, fileFormat string) {
-	// Try to delete the uploaded file (ignore errors)
-	uploadKey := h.storage.GetUploadKey(fileID, fileFormat)
-	if err := h.storage.Delete(ctx.Request.Context(), uploadKey); err != nil {
-		log.Printf("cleanup: Failed to delete uploaded file %s: %v", uploadKey, err)
+// parseSampleRate parses and validates the requested output sample rate.
+// Free users always receive MP3, so the rate is checked against that format.
+func (h *UploadHandler) parseSampleRate(rateStr string, isPremium bool, inputFormat string) (int, error) {
+	if rateStr == "" {
+		return 0, nil
 	}
 
-	// Try to delete the processed file if it exists (ignore errors)
-	processedKey := h.storage.GetProcessedKey(fileID, fileFormat)
-	if err := h.storage.Delete(ctx.Request.Context(), processedKey); err != nil {
-		log.Printf("cleanup: Failed to delete processed file %s: %v", processedKey, err)
+	parsed, err := strconv.Atoi(rateStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sample rate: %s", rateStr)
+	}
+
+	outputFormat := "mp3"
+	if isPremium {
+		outputFormat = strings.ToLower(inputFormat)
+	}
+
+	if err := audio.ValidateSampleRate(parsed, outputFormat); err != nil {
+		return 0, err
+	}
+
+	return parsed, nil
+}
+
+// Furthest ahead processing can be scheduled
+const maxProcessAfter = 7 * 24 * time.Hour
+
+// parseProcessAfter parses an RFC 3339 start time for deferred processing.
+// Empty means as soon as possible.
+func parseProcessAfter(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
 	}
 
-	// Try to delete metadata (ignore errors)
-	if err := h.metadata.DeleteAudioFile(ctx.Request.Context(), fileID); err != nil {
-		log.Printf("cleanup: Failed to delete metadata for file %s: %v", fileID, err)
+	after, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid processing time: %s", value)
+	}
+	if after.After(time.Now().Add(maxProcessAfter)) {
+		return nil, fmt.Errorf("processing can be scheduled at most %d days ahead", int(maxProcessAfter.Hours()/24))
+	}
+	return &after, nil
+}
+
+// cleanup removes the original unless another file shares it, the processed
+// file, and metadata on error
+func (h *UploadHandler) cleanup(ctx context.Context, file *storage.AudioFile) {
+	// Try to delete metadata first, so the file no longer counts as sharing
+	// its original (ignore errors)
+	if err := h.metadata.DeleteAudioFile(ctx, file.ID); err != nil {
+		log.Printf("cleanup: Failed to delete metadata for file %s: %v", file.ID, err)
+	}
+
+	h.deleteOriginal(ctx, file)
+
+	// Try to delete the processed file if it exists (ignore errors)
+	processedKey := h.storage.GetProcessedKey(file.ID, file.Format)
+	if err := h.storage.Delete(ctx, processedKey); err != nil {
+		log.Printf("cleanup: Failed to delete processed file %s: %v", processedKey, err)
 	}
 }
 
@@ -920,22 +1309,14 @@ func (h *UploadHandler) validateFile(fileHeader *multipart.FileHeader, userTier
 		return fmt.Errorf("no file provided")
 	}
 
-	// Dynamic file size limits based on user tier
-	var maxSize int64
-	switch userTier {
-	case 1: // Free tier
-		maxSize = int64(300 * 1024 * 1024) // 300MB
-	case 2, 3: // Premium/Pro tiers
-		maxSize = int64(5 * 1024 * 1024 * 1024) // 5GB (effectively unlimited for most use cases)
-	default:
-		maxSize = int64(300 * 1024 * 1024) // Default to free tier
-	}
+	limits := limitsFor(userTier)
+	upgrade := userTier == 1 && len(tierLimits()) > 1
 
-	if fileHeader.Size > maxSize {
-		if userTier == 1 {
-			return fmt.Errorf("file too large (max 300MB). Upgrade to Premium for larger files")
+	if fileHeader.Size > limits.MaxUploadBytes {
+		if upgrade {
+			return fmt.Errorf("file too large (max %s). Upgrade to %s for larger files", formatSize(limits.MaxUploadBytes), limitsFor(2).Name)
 		}
-		return fmt.Errorf("file too large (max %dGB)", maxSize/(1024*1024*1024))
+		return fmt.Errorf("file too large (max %s)", formatSize(limits.MaxUploadBytes))
 	}
 
 	// Check minimum file size (1KB to avoid empty files)
@@ -946,20 +1327,12 @@ func (h *UploadHandler) validateFile(fileHeader *multipart.FileHeader, userTier
 
 	// Check file extension based on user tier
 	ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
-
-	switch userTier {
-	case 1: // Free tier - MP3 only
-		if ext != ".mp3" {
-			return fmt.Errorf("Only MP3 files are supported. Upgrade to Premium for WAV and FLAC support")
-		}
-	case 2, 3: // Premium/Pro tiers - MP3, WAV, and FLAC
-		if ext != ".mp3" && ext != ".wav" && ext != ".flac" {
-			return fmt.Errorf("Only MP3, WAV, and FLAC files are supported")
-		}
-	default:
-		if ext != ".mp3" {
-			return fmt.Errorf("Only MP3 files are supported")
+	if !limits.acceptsFormat(ext) {
+		formats := strings.ToUpper(strings.Join(limits.Formats, ", "))
+		if upgrade {
+			return fmt.Errorf("Only %s files are supported. Upgrade to %s for more formats", formats, limitsFor(2).Name)
 		}
+		return fmt.Errorf("Only %s files are supported", formats)
 	}
 
 	// Check filename length
@@ -979,6 +1352,21 @@ func generateID() string {
 	return hex.EncodeToString(bytes)
 }
 
+// validFileID reports whether id could have come from generateID, so a
+// client can't make a file ID point at another key, such as an original
+// stored by hash
+func validFileID(id string) bool {
+	if id == "" || len(id) > 32 {
+		return false
+	}
+	for _, r := range id {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
 func getFileExtension(filename string) string {
 	return strings.TrimPrefix(filepath.Ext(filename), ".")
 }
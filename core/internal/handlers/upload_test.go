@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+func TestClaimFileOneRequestAtATime(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	user := env.createUser(t, 3)
+	file, jobID := queueTestUpload(t, env, user, testAudio(4096))
+
+	// A file with a queued job can't get another
+	if _, ok, err := env.handler.claimFile(ctx, file.ID); err != nil || ok {
+		t.Fatalf("claimFile with a queued job = %v, %v; want false, nil", ok, err)
+	}
+
+	job, err := env.metadata.GetJob(ctx, jobID)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if err := storage.TransitionJob(ctx, env.metadata, job, storage.JobFailed, "test"); err != nil {
+		t.Fatalf("TransitionJob: %v", err)
+	}
+
+	// Once it finished, one request at a time may queue the next
+	release, ok, err := env.handler.claimFile(ctx, file.ID)
+	if err != nil || !ok {
+		t.Fatalf("claimFile = %v, %v; want true, nil", ok, err)
+	}
+	if _, ok, err := env.handler.claimFile(ctx, file.ID); err != nil || ok {
+		t.Fatalf("claimFile while claimed = %v, %v; want false, nil", ok, err)
+	}
+	release()
+
+	release, ok, err = env.handler.claimFile(ctx, file.ID)
+	if err != nil || !ok {
+		t.Fatalf("claimFile after release = %v, %v; want true, nil", ok, err)
+	}
+	release()
+	if len(env.handler.claiming) != 0 {
+		t.Fatalf("expected no claims to be held, got %v", env.handler.claiming)
+	}
+}
@@ -2,40 +2,47 @@ package handlers
 
 import (
 	"fmt"
+	"os"
+	"strconv"
 	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
 )
 
 func getTierName(tier int) string {
-	switch tier {
-	case 1:
-		return "Free"
-	case 2:
-		return "Premium"
-	case 3:
-		return "Professional"
-	default:
-		return "Free"
+	return limitsFor(tier).Name
+}
+
+// uploadRetention is how long original uploads are kept for re-processing,
+// matching the storage lifecycle set by the cleanup job (RETENTION_DAYS)
+func uploadRetention() time.Duration {
+	days := 30
+	if v := os.Getenv("RETENTION_DAYS"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			days = parsed
+		}
 	}
+	return time.Duration(days) * 24 * time.Hour
 }
 
-// getMonthStart returns the start of the month (1st day at 00:00:00)
-func getMonthStart(t time.Time) time.Time {
-	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+// canReprocess reports whether a file's original upload is still within the
+// retention window. A day of margin is left for the lifecycle rule's timing.
+func canReprocess(file *storage.AudioFile) bool {
+	return time.Since(file.CreatedAt) < uploadRetention()-24*time.Hour
 }
 
 // getProcessingTimeLimit returns the monthly processing time limit in seconds
 // Returns -1 for unlimited
 func getProcessingTimeLimit(tier int) int {
-	switch tier {
-	case 1:
-		return 7200 // 2 hours per month for Free tier (7200 seconds after beta)
-	case 2:
-		return 36000 // 10 hours per month for Premium tier (€9/month)
-	case 3:
-		return 144000 // 40 hours per month for Professional tier (€24/month)
-	default:
-		return 7200 // Default to free tier (7200 seconds after beta)
+	return limitsFor(tier).ProcessingSeconds
+}
+
+// fileDuration is file's length in seconds, 0 if it hasn't been measured
+func fileDuration(file *storage.AudioFile) int {
+	if file.DurationSeconds == nil {
+		return 0
 	}
+	return *file.DurationSeconds
 }
 
 // formatDuration formats seconds into human-readable format
@@ -70,3 +77,11 @@ func formatDurationAsHours(seconds int) string {
 	hours := seconds / 3600
 	return fmt.Sprintf("%dh", hours)
 }
+
+// formatSize formats a byte count in MB, or GB from 1GB (e.g., "300MB", "5GB")
+func formatSize(bytes int64) string {
+	if bytes >= 1<<30 && bytes%(1<<30) == 0 {
+		return fmt.Sprintf("%dGB", bytes>>30)
+	}
+	return fmt.Sprintf("%dMB", bytes>>20)
+}
@@ -87,7 +87,7 @@ function updateJobUI(fileId, status, errorMessage = null) {
 
         // Update action to download button
         actionContainer.innerHTML = `
-            <a href="/download/${fileId}" class="btn-sand inline-flex items-center" style="text-decoration: none; padding: 0.5rem 1rem; font-size: 0.875rem;">
+            <a href="/download/${fileId}?job=${jobElement.dataset.jobId}" class="btn-sand inline-flex items-center" style="text-decoration: none; padding: 0.5rem 1rem; font-size: 0.875rem;">
                 <span class="material-symbols-outlined mr-1.5" style="font-size: 16px;">download</span>
                 Download
             </a>
@@ -172,6 +172,55 @@ async function retryJob(fileID) {
     }
 }
 
+// LUFS targets of the presets offered when re-processing
+const reprocessPresetTargets = {
+    'dj': -5,
+    'streaming': -14,
+    'podcast': -16,
+    'broadcast': -23
+};
+
+// Show or hide the re-process options under a completed job
+function toggleReprocessForm(jobId) {
+    const form = document.getElementById(`reprocess-${jobId}`);
+    if (form) {
+        form.classList.toggle('hidden');
+    }
+}
+
+// Process a completed file again with new settings, reusing the original upload
+async function reprocessJob(event) {
+    event.preventDefault();
+    const form = event.target;
+    const button = form.querySelector('button[type="submit"]');
+    const fileId = form.dataset.fileId;
+
+    const formData = new FormData(form);
+    formData.append('job_id', form.dataset.jobId);
+    formData.append('target_lufs', reprocessPresetTargets[formData.get('preset')].toString());
+
+    try {
+        button.disabled = true;
+
+        const response = await fetch(`/reprocess/${fileId}`, {
+            method: 'POST',
+            body: formData
+        });
+
+        if (!response.ok) {
+            const data = await response.json();
+            throw new Error(data.error || 'Failed to re-process file');
+        }
+
+        // The new job is listed at the top of the history
+        window.location.reload();
+    } catch (error) {
+        console.error('Error re-processing job:', error);
+        alert(`Failed to re-process file: ${error.message}`);
+        button.disabled = false;
+    }
+}
+
 // Open Stripe Billing Portal for subscription management
 async function openBillingPortal(event) {
     try {
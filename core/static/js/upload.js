@@ -1,9 +1,13 @@
 let selectedFile = null;
 let pollInterval = null;
+let statusStream = null;
 let selectedProcessingMode = 'fast';
 let selectedPreset = 'dj';
 let selectedLufsTarget = -7;
 let noiseReductionEnabled = false;
+let selectedSampleRate = '';
+let selectedSchedule = '';
+let processAfter = '';
 
 
 // Preset display names (keys match dropdown values, values are what shows in completion message)
@@ -292,72 +296,137 @@ document.addEventListener('DOMContentLoaded', function() {
     }
 });
 
+// Status updates: stream server-sent events, falling back to polling
+function startStatusUpdates(fileId) {
+    stopStatusUpdates();
+
+    if (!window.EventSource) {
+        startStatusPolling(fileId);
+        return;
+    }
+
+    const handleStatus = createStatusHandler(fileId);
+    statusStream = new EventSource(`/status/${fileId}/stream`);
+
+    statusStream.addEventListener('status', event => {
+        try {
+            handleStatus(JSON.parse(event.data));
+        } catch (error) {
+            console.error('Status stream error:', error);
+        }
+    });
+
+    // The browser reconnects on its own (resuming with Last-Event-ID); only fall
+    // back to polling if the stream can't be used at all
+    statusStream.onerror = () => {
+        if (statusStream && statusStream.readyState === EventSource.CLOSED) {
+            statusStream = null;
+            startStatusPolling(fileId);
+        }
+    };
+}
+
+function stopStatusUpdates() {
+    if (pollInterval) {
+        clearInterval(pollInterval);
+        pollInterval = null;
+    }
+    if (statusStream) {
+        statusStream.close();
+        statusStream = null;
+    }
+}
+
 // Status polling
 function startStatusPolling(fileId) {
     if (pollInterval) clearInterval(pollInterval);
 
+    const handleStatus = createStatusHandler(fileId);
+
+    pollInterval = setInterval(() => {
+        fetch(`/status/${fileId}`)
+            .then(response => response.json())
+            .then(handleStatus)
+            .catch(error => {
+                console.error('Status polling error:', error);
+            });
+    }, 1500);
+}
+
+// createStatusHandler returns a function that renders each status update,
+// shared by the event stream and polling
+function createStatusHandler(fileId) {
     const startTime = Date.now();
     progressSimulation.stageStartTime = startTime;
     let lastStatus = '';
     let fileSize = selectedFile ? selectedFile.size : null;
 
-    pollInterval = setInterval(() => {
-        const elapsedTime = (Date.now() - startTime) / 1000;
+    return data => {
+        if (data.status !== lastStatus) {
+            progressSimulation.stageStartTime = Date.now();
+            lastStatus = data.status;
+        }
 
-        fetch(`/status/${fileId}`)
-            .then(response => response.json())
-            .then(data => {
-                if (data.status !== lastStatus) {
-                    progressSimulation.stageStartTime = Date.now();
-                    lastStatus = data.status;
-                }
+        let finalProgress, finalMessage;
 
-                let finalProgress, finalMessage;
+        if (data.progress && data.progress > 0) {
+            finalProgress = Math.min(data.progress, 100);
+            finalMessage = getMessageForBackendProgress(data.status, data.progress, selectedProcessingMode, data.etaSeconds);
+        } else {
+            const stageElapsedTime = (Date.now() - progressSimulation.stageStartTime) / 1000;
+            const progressInfo = getDetailedProgress(data.status, data.progress || 0, fileSize, stageElapsedTime, selectedProcessingMode);
+            finalProgress = progressInfo.progress;
+            finalMessage = progressInfo.message;
+        }
 
-                if (data.progress && data.progress > 0) {
-                    finalProgress = Math.min(data.progress, 100);
-                    finalMessage = getMessageForBackendProgress(data.status, data.progress, selectedProcessingMode);
-                } else {
-                    const stageElapsedTime = (Date.now() - progressSimulation.stageStartTime) / 1000;
-                    const progressInfo = getDetailedProgress(data.status, data.progress || 0, fileSize, stageElapsedTime, selectedProcessingMode);
-                    finalProgress = progressInfo.progress;
-                    finalMessage = progressInfo.message;
-                }
+        if (data.status === 'queued' && data.scheduledFor) {
+            finalMessage = `Scheduled to start ${new Date(data.scheduledFor).toLocaleString()}${data.offPeak ? ' (off-peak)' : ''}. You can close this page.`;
+        } else if (data.status === 'queued' && data.queuePosition) {
+            finalMessage = formatQueuePosition(data.queuePosition, data.queueEtaMinSeconds, data.queueEtaMaxSeconds);
+        }
 
-                animateProgress(finalProgress, finalMessage);
+        animateProgress(finalProgress, finalMessage);
 
-                // Update status badge based on current status
-                updateStatusBadge(data.status, finalProgress);
+        // Update status badge based on current status
+        updateStatusBadge(data.status, finalProgress);
 
-                // Update file metadata from backend (duration and extension)
-                updateFileMetadataFromBackend(data);
+        // Update file metadata from backend (duration and extension)
+        updateFileMetadataFromBackend(data);
 
-                const cancelBtn = document.getElementById('cancel-btn');
-                if (cancelBtn && finalProgress >= 90) {
-                    cancelBtn.disabled = true;
-                }
+        const cancelBtn = document.getElementById('cancel-btn');
+        if (cancelBtn && finalProgress >= 90) {
+            cancelBtn.disabled = true;
+        }
 
-                if (data.status === 'completed') {
-                    clearInterval(pollInterval);
-                    setTimeout(() => showCompletedState(fileId, data), 1000);
-                } else if (data.status === 'failed') {
-                    clearInterval(pollInterval);
-                    showErrorState(data.error || 'Processing failed');
-                } else if (data.status === 'cancelled') {
-                    clearInterval(pollInterval);
-                    showCancelledState();
-                }
-            })
-            .catch(error => {
-                console.error('Status polling error:', error);
-            });
-    }, 1500);
+        if (data.status === 'completed') {
+            stopStatusUpdates();
+            setTimeout(() => showCompletedState(fileId, data), 1000);
+        } else if (data.status === 'failed') {
+            stopStatusUpdates();
+            showErrorState(data.error || 'Processing failed');
+        } else if (data.status === 'cancelled') {
+            stopStatusUpdates();
+            showCancelledState();
+        }
+    };
 }
 
-function getMessageForBackendProgress(status, progress, mode = 'precise') {
+function getMessageForBackendProgress(status, progress, mode = 'precise', etaSeconds = 0) {
     if (status === 'completed') return 'Processing complete!';
     if (status === 'failed') return 'Processing failed';
 
+    // Stages reported by the worker from ffmpeg's actual position
+    const stageMessages = {
+        'downloading': 'Preparing audio file...',
+        'fast_analyzing': 'Fast analyzing audio...',
+        'precise_analyzing': 'Analyzing audio characteristics...',
+        'normalizing': 'Normalizing audio...',
+        'uploading': 'Uploading normalized file...'
+    };
+    if (stageMessages[status]) {
+        return stageMessages[status] + formatEta(etaSeconds);
+    }
+
     if (status === 'processing') {
         if (progress <= 15) return mode === 'fast' ? 'Initializing fast processor...' : 'Initializing audio processor...';
         if (progress <= 35) return mode === 'fast' ? 'Fast analyzing audio...' : 'Analyzing audio characteristics...';
@@ -375,6 +444,35 @@ function getMessageForBackendProgress(status, progress, mode = 'precise') {
     return statusMessages[status] || 'Processing...';
 }
 
+function formatEta(etaSeconds) {
+    if (!etaSeconds || etaSeconds <= 0) return '';
+    if (etaSeconds < 60) return ` (about ${etaSeconds}s left)`;
+    return ` (about ${Math.ceil(etaSeconds / 60)} min left)`;
+}
+
+// Off-peak and later jobs are queued to start at a set time
+function selectSchedule(value) {
+    selectedSchedule = value;
+    document.getElementById('process-after').classList.toggle('hidden', value !== 'later');
+}
+
+// Place in the processing queue and the expected wait before it starts
+function formatQueuePosition(position, etaMinSeconds, etaMaxSeconds) {
+    let message = position === 1 ? 'Next in line for processing' : `Queued for processing (#${position} in line)`;
+    if (etaMaxSeconds > 0) {
+        const minMinutes = Math.ceil(etaMinSeconds / 60);
+        const maxMinutes = Math.ceil(etaMaxSeconds / 60);
+        if (etaMaxSeconds < 60) {
+            message += ', starting in under a minute';
+        } else if (minMinutes === maxMinutes) {
+            message += `, starting in about ${maxMinutes} min`;
+        } else {
+            message += `, starting in about ${minMinutes}-${maxMinutes} min`;
+        }
+    }
+    return message + '...';
+}
+
 // Completed state
 function showCompletedState(fileId, data) {
     animateProgress(100, 'Processing complete!');
@@ -554,7 +652,7 @@ function cancelProcessing() {
     .then(response => response.json())
     .then(data => {
         if (data.status === 'cancelled') {
-            if (pollInterval) clearInterval(pollInterval);
+            stopStatusUpdates();
             showCancelledState();
         } else {
             alert('Failed to cancel: ' + (data.error || 'Unknown error'));
@@ -601,11 +699,151 @@ function showCancelledState() {
 
 // Presigned URL upload flow
 async function uploadFileWithPresignedURL(file, preset, processingMode, lufsTarget) {
+    if (file.size > MULTIPART_THRESHOLD) {
+        await uploadFileInParts(file, preset, processingMode, lufsTarget);
+        return;
+    }
+
     const presignedData = await getPresignedUploadURL(file);
     await uploadToS3(file, presignedData.upload_url, presignedData.content_type);
     await confirmUploadAndProcess(presignedData.file_id, file.name, preset, processingMode, lufsTarget);
 }
 
+// Files larger than this are sent in parts, so a failure only costs the part
+// in flight and an upload of the same file later resumes where it stopped
+const MULTIPART_THRESHOLD = 64 * 1024 * 1024;
+const PART_ATTEMPTS = 3;
+
+async function uploadFileInParts(file, preset, processingMode, lufsTarget) {
+    const resumeKey = `levelmix-upload:${file.name}:${file.size}:${file.lastModified}`;
+    let upload = JSON.parse(localStorage.getItem(resumeKey) || 'null');
+    let storedParts = [];
+
+    if (upload) {
+        // Resume an earlier attempt if storage still has it
+        storedParts = await listUploadedParts(file, upload).catch(() => null);
+        if (storedParts === null) {
+            upload = null;
+        }
+    }
+    if (!upload) {
+        upload = await multipartRequest('/api/multipart-upload', {
+            filename: file.name,
+            filesize: file.size.toString()
+        });
+        storedParts = [];
+        localStorage.setItem(resumeKey, JSON.stringify(upload));
+    }
+
+    const partSize = upload.part_size;
+    const partCount = Math.ceil(file.size / partSize);
+    const partBlob = (n) => file.slice((n - 1) * partSize, Math.min(n * partSize, file.size));
+
+    // Parts cut short by the failure are sent again
+    const done = new Set(storedParts
+        .filter(part => part.size === partBlob(part.part_number).size)
+        .map(part => part.part_number));
+    let sentBytes = 0;
+    done.forEach(n => { sentBytes += partBlob(n).size; });
+
+    for (let n = 1; n <= partCount; n++) {
+        if (done.has(n)) {
+            continue;
+        }
+        const blob = partBlob(n);
+        await uploadPart(file, upload, n, blob, (loaded) => {
+            updateUploadProgress(Math.round(((sentBytes + loaded) / file.size) * 100));
+        });
+        sentBytes += blob.size;
+    }
+    updateUploadProgress(100);
+
+    await confirmUploadAndProcess(upload.file_id, file.name, preset, processingMode, lufsTarget, upload.upload_id);
+    localStorage.removeItem(resumeKey);
+}
+
+async function listUploadedParts(file, upload) {
+    const params = new URLSearchParams({
+        file_id: upload.file_id,
+        upload_id: upload.upload_id,
+        filename: file.name
+    });
+    const response = await fetch(`/api/multipart-upload/parts?${params.toString()}`, {
+        credentials: 'include',
+        headers: { 'Accept': 'application/json' }
+    });
+    if (!response.ok) {
+        throw new Error('Upload can no longer be resumed');
+    }
+    return (await response.json()).parts;
+}
+
+async function uploadPart(file, upload, partNumber, blob, onProgress) {
+    const params = new URLSearchParams({
+        file_id: upload.file_id,
+        upload_id: upload.upload_id,
+        filename: file.name,
+        part: partNumber.toString()
+    });
+
+    for (let attempt = 1; ; attempt++) {
+        try {
+            const response = await fetch(`/api/multipart-upload/part-url?${params.toString()}`, {
+                credentials: 'include',
+                headers: { 'Accept': 'application/json' }
+            });
+            if (!response.ok) {
+                const error = await response.json();
+                throw new Error(error.error || 'Failed to get upload URL');
+            }
+            const { upload_url } = await response.json();
+            await putPart(upload_url, blob, onProgress);
+            return;
+        } catch (error) {
+            if (attempt >= PART_ATTEMPTS) {
+                throw new Error('Upload interrupted. Select the same file again to resume where it stopped.');
+            }
+            onProgress(0);
+            await new Promise(resolve => setTimeout(resolve, attempt * 2000));
+        }
+    }
+}
+
+function putPart(url, blob, onProgress) {
+    return new Promise((resolve, reject) => {
+        const xhr = new XMLHttpRequest();
+        xhr.upload.addEventListener('progress', (event) => onProgress(event.loaded));
+        xhr.addEventListener('load', () => {
+            if (xhr.status >= 200 && xhr.status < 300) {
+                resolve();
+            } else {
+                reject(new Error(`Part upload failed with status ${xhr.status}`));
+            }
+        });
+        xhr.addEventListener('error', () => reject(new Error('Part upload failed')));
+        xhr.addEventListener('abort', () => reject(new Error('Upload cancelled')));
+        xhr.open('PUT', url);
+        xhr.send(blob);
+    });
+}
+
+async function multipartRequest(url, fields) {
+    const formData = new FormData();
+    Object.entries(fields).forEach(([name, value]) => formData.append(name, value));
+
+    const response = await fetch(url, {
+        method: 'POST',
+        credentials: 'include',
+        headers: { 'Accept': 'application/json' },
+        body: formData
+    });
+    if (!response.ok) {
+        const error = await response.json();
+        throw new Error(error.error || 'Failed to start upload');
+    }
+    return await response.json();
+}
+
 async function getPresignedUploadURL(file) {
     const params = new URLSearchParams({
         filename: file.name,
@@ -687,9 +925,13 @@ async function uploadToS3(file, uploadURL, contentType) {
     });
 }
 
-async function confirmUploadAndProcess(fileId, filename, preset, processingMode, lufsTarget) {
+// uploadId completes a multipart upload before confirming it
+async function confirmUploadAndProcess(fileId, filename, preset, processingMode, lufsTarget, uploadId) {
     const formData = new FormData();
     formData.append('file_id', fileId);
+    if (uploadId) {
+        formData.append('upload_id', uploadId);
+    }
     formData.append('filename', filename);
     formData.append('preset', preset);
     formData.append('processing_mode', processingMode);
@@ -701,7 +943,17 @@ async function confirmUploadAndProcess(fileId, filename, preset, processingMode,
 
     formData.append('noise_reduction', noiseReductionEnabled ? 'true' : 'false');
 
-    const response = await fetch('/api/confirm-upload', {
+    if (selectedSampleRate) {
+        formData.append('sample_rate', selectedSampleRate);
+    }
+
+    if (selectedSchedule === 'offpeak') {
+        formData.append('off_peak', 'true');
+    } else if (selectedSchedule === 'later' && processAfter) {
+        formData.append('process_after', new Date(processAfter).toISOString());
+    }
+
+    const response = await fetch(uploadId ? '/api/multipart-upload/complete' : '/api/confirm-upload', {
         method: 'POST',
         credentials: 'include',
         body: formData
@@ -731,7 +983,7 @@ async function confirmUploadAndProcess(fileId, filename, preset, processingMode,
     progressSimulation.currentProgress = 0;
     progressSimulation.targetProgress = 0;
     animateProgress(1, 'Upload complete, queuing for processing...');
-    startStatusPolling(extractedFileId);
+    startStatusUpdates(extractedFileId);
 }
 
 function populateFileInfo(filename) {
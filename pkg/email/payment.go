@@ -68,7 +68,7 @@ Questions? Reply to this email anytime.
 		Text:    text,
 	}
 
-	_, err := s.client.Emails.Send(request)
+	err := s.sender.Send(request)
 	return err
 }
 
@@ -139,7 +139,7 @@ Need help? Reply to this email.
 		Text:    text,
 	}
 
-	_, err := s.client.Emails.Send(request)
+	err := s.sender.Send(request)
 	return err
 }
 
@@ -201,7 +201,7 @@ You can reactivate anytime from your dashboard.
 		Text:    text,
 	}
 
-	_, err := s.client.Emails.Send(request)
+	err := s.sender.Send(request)
 	return err
 }
 
@@ -259,7 +259,7 @@ Questions? Reply to this email anytime.
 		Text:    text,
 	}
 
-	_, err := s.client.Emails.Send(request)
+	err := s.sender.Send(request)
 	return err
 }
 
@@ -348,7 +348,7 @@ Questions? Reply to this email.
 		Text:    text,
 	}
 
-	_, err := s.client.Emails.Send(request)
+	err := s.sender.Send(request)
 	return err
 }
 
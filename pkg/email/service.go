@@ -28,9 +28,10 @@ type EmailService interface {
 	SendTrialEnding(ctx context.Context, to, planName string, trialEndDate time.Time) error
 }
 
-// ResendService implements EmailService using Resend
+// ResendService implements EmailService using Resend. The same emails can be
+// delivered over SMTP instead, see NewSMTPService.
 type ResendService struct {
-	client    *resend.Client
+	sender    sender
 	fromEmail string
 	fromName  string
 	baseURL   string
@@ -69,7 +70,7 @@ func NewResendService() (EmailService, error) {
 	client := resend.NewClient(apiKey)
 
 	return &ResendService{
-		client:    client,
+		sender:    resendSender{client: client},
 		fromEmail: fromEmail,
 		fromName:  fromName,
 		baseURL:   baseURL,
@@ -155,7 +156,7 @@ This link expires in 1 hour. If you didn't request this, you can safely ignore t
 		Text:    text,
 	}
 
-	_, err := s.client.Emails.Send(request)
+	err := s.sender.Send(request)
 	if err != nil {
 		log.Printf("Failed to send password reset email to %s: %v", to, err)
 		return fmt.Errorf("failed to send email: %w", err)
@@ -233,7 +234,7 @@ Questions? Reply to this email anytime.
 		Text:    text,
 	}
 
-	_, err := s.client.Emails.Send(request)
+	err := s.sender.Send(request)
 	if err != nil {
 		log.Printf("Failed to send welcome email to %s: %v", to, err)
 		return fmt.Errorf("failed to send email: %w", err)
@@ -304,7 +305,7 @@ If you didn't create this account, remove it here: %s
 		Text:    text,
 	}
 
-	_, err := s.client.Emails.Send(request)
+	err := s.sender.Send(request)
 	if err != nil {
 		log.Printf("Failed to send verification email to %s: %v", to, err)
 		return fmt.Errorf("failed to send email: %w", err)
@@ -386,7 +387,7 @@ If you didn't request this deletion, contact our support team immediately.
 		Text:    text,
 	}
 
-	_, err := s.client.Emails.Send(request)
+	err := s.sender.Send(request)
 	if err != nil {
 		log.Printf("Failed to send account deletion email to %s: %v", to, err)
 		// Don't return error as account is already deleted
@@ -455,7 +456,7 @@ If you didn't make this change:
 		Text:    text,
 	}
 
-	_, err := s.client.Emails.Send(request)
+	err := s.sender.Send(request)
 	if err != nil {
 		log.Printf("Failed to send email change notification to %s: %v", oldEmail, err)
 		return fmt.Errorf("failed to send email: %w", err)
@@ -529,7 +530,7 @@ If you didn't make this change, contact our support team immediately.
 		Text:    text,
 	}
 
-	_, err := s.client.Emails.Send(request)
+	err := s.sender.Send(request)
 	if err != nil {
 		log.Printf("Failed to send email change confirmation to %s: %v", to, err)
 		return fmt.Errorf("failed to send email: %w", err)
@@ -607,7 +608,7 @@ If you didn't make this change:
 		Text:    text,
 	}
 
-	_, err := s.client.Emails.Send(request)
+	err := s.sender.Send(request)
 	if err != nil {
 		log.Printf("Failed to send password change confirmation to %s: %v", to, err)
 		return fmt.Errorf("failed to send email: %w", err)
@@ -0,0 +1,189 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/resendlabs/resend-go"
+)
+
+// sender delivers a rendered email
+type sender interface {
+	Send(request *resend.SendEmailRequest) error
+}
+
+type resendSender struct {
+	client *resend.Client
+}
+
+func (r resendSender) Send(request *resend.SendEmailRequest) error {
+	_, err := r.client.Emails.Send(request)
+	return err
+}
+
+// smtpSender delivers emails through an SMTP server, using STARTTLS when the
+// server offers it, or implicit TLS on port 465
+type smtpSender struct {
+	addr string
+	host string
+	auth smtp.Auth
+}
+
+// NewSMTPService creates an email service that sends the same emails as
+// NewResendService through an SMTP server, for self-hosted installs:
+//
+//	SMTP_HOST=smtp.example.com
+//	SMTP_PORT=587
+//	SMTP_USERNAME / SMTP_PASSWORD (optional)
+func NewSMTPService() (EmailService, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil, fmt.Errorf("SMTP_HOST environment variable not set")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	fromEmail := os.Getenv("EMAIL_FROM")
+	if fromEmail == "" {
+		return nil, fmt.Errorf("EMAIL_FROM environment variable not set")
+	}
+
+	fromName := os.Getenv("EMAIL_FROM_NAME")
+	if fromName == "" {
+		fromName = "LevelMix"
+	}
+
+	baseURL := os.Getenv("APP_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	return &ResendService{
+		sender:    smtpSender{addr: net.JoinHostPort(host, port), host: host, auth: auth},
+		fromEmail: fromEmail,
+		fromName:  fromName,
+		baseURL:   baseURL,
+	}, nil
+}
+
+func (s smtpSender) Send(request *resend.SendEmailRequest) error {
+	from, err := mail.ParseAddress(request.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", request.From, err)
+	}
+	message, err := buildMessage(request)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasSuffix(s.addr, ":465") {
+		return smtp.SendMail(s.addr, s.auth, from.Address, request.To, message)
+	}
+
+	conn, err := tls.Dial("tcp", s.addr, &tls.Config{ServerName: s.host})
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", s.addr, err)
+	}
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to %s: %w", s.addr, err)
+	}
+	defer client.Close()
+
+	if s.auth != nil {
+		if err := client.Auth(s.auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range request.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildMessage renders request as a multipart/alternative message with its
+// plain text and HTML bodies
+func buildMessage(request *resend.SendEmailRequest) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", request.Text},
+		{"text/html; charset=utf-8", request.Html},
+	} {
+		if part.content == "" {
+			continue
+		}
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to build email: %w", err)
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, fmt.Errorf("failed to build email: %w", err)
+		}
+		qp.Close()
+	}
+	if err := parts.Close(); err != nil {
+		return nil, fmt.Errorf("failed to build email: %w", err)
+	}
+
+	domain := "localhost"
+	if from, err := mail.ParseAddress(request.From); err == nil {
+		if _, d, ok := strings.Cut(from.Address, "@"); ok {
+			domain = d
+		}
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", request.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(request.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", request.Subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
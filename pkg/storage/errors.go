@@ -0,0 +1,19 @@
+package storage
+
+import "errors"
+
+// Implementations wrap these so callers can tell a missing record from a
+// failing backend with errors.Is, whatever the database or object store
+var (
+	// ErrNotFound means the record or object does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the write clashes with existing data, such as a
+	// duplicate email or a job whose status changed concurrently
+	ErrConflict = errors.New("conflict")
+	// ErrExpired means the record exists but is no longer valid, such as a
+	// verification token past its expiry
+	ErrExpired = errors.New("expired")
+	// ErrQuotaExceeded means the write would take a user past their limit,
+	// such as reserving more processing time than the month has left
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
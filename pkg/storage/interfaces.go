@@ -13,39 +13,108 @@ type ObjectInfo struct {
 	ContentType  string
 }
 
-// AudioStorage handles file storage operations
+// Multipart upload limits, the same as S3's
+const (
+	MinPartSize = 5 << 20
+	MaxParts    = 10000
+)
+
+// UploadPart is a part of a multipart upload
+type UploadPart struct {
+	PartNumber int
+	ETag       string
+	Size       int64
+}
+
+// MultipartUpload is a multipart upload that hasn't been completed or aborted
+type MultipartUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// AudioStorage handles file storage operations. Reading a missing object
+// returns an error wrapping ErrNotFound.
 type AudioStorage interface {
 	Upload(ctx context.Context, key string, reader io.Reader, format string) error
 	Download(ctx context.Context, key string) (io.ReadCloser, error)
 	Delete(ctx context.Context, key string) error
 	GetPresignedURL(ctx context.Context, key string, duration time.Duration, format string) (string, error)
 	GetUploadKey(fileID string, format string) string
+	// GetProcessedKey is where output was stored before each job had its own
+	// key; jobs without OutputS3Key still read from it
 	GetProcessedKey(fileID string, format string) string
+	// GetJobProcessedKey is the output key of one job, so a file can be
+	// processed several times without overwriting earlier results
+	GetJobProcessedKey(fileID, jobID string, format string) string
+	// GetContentKey is where an original is kept once confirmed, shared by
+	// every file with the same content; see OriginalKey
+	GetContentKey(hash string, format string) string
+	// Rename moves an object to dstKey, replacing any object already there
+	Rename(ctx context.Context, srcKey, dstKey string) error
+	// Copy copies an object to dstKey, replacing any object already there.
+	// The copy is a new object, with its own retention.
+	Copy(ctx context.Context, srcKey, dstKey string) error
 	GetObjectInfo(ctx context.Context, key string) (*ObjectInfo, error)
-	GetPresignedUploadURL(ctx context.Context, key string, contentType string, duration time.Duration) (string, error)
+	// GetPresignedUploadURL returns a URL to PUT the object at key to, with
+	// a body of at most maxSize bytes (0 for no cap)
+	GetPresignedUploadURL(ctx context.Context, key string, contentType string, maxSize int64, duration time.Duration) (string, error)
 	DownloadToFile(ctx context.Context, key string, localPath string) error
-	UploadProcessed(ctx context.Context, fileID string, reader io.Reader, format string) error
+	UploadProcessed(ctx context.Context, fileID, jobID string, reader io.Reader, format string) error // Writes to GetJobProcessedKey
 	GetPresignedDownloadURL(ctx context.Context, key string, downloadFilename string, contentType string, duration time.Duration) (string, error)
+
+	// Multipart uploads, for large uploads that resume after a failure rather
+	// than start over. The object appears at key only once completed. Parts
+	// are numbered from 1 and all but the last must be at least MinPartSize.
+	// Operations on an unknown or aborted upload return ErrNotFound.
+	CreateMultipartUpload(ctx context.Context, key string, contentType string) (uploadID string, err error)
+	GetPresignedPartURL(ctx context.Context, key, uploadID string, partNumber int, maxSize int64, duration time.Duration) (string, error) // maxSize as for GetPresignedUploadURL
+	// UploadPart stores a part sent through the server; size is its length
+	UploadPart(ctx context.Context, key, uploadID string, partNumber int, reader io.Reader, size int64) (UploadPart, error)
+	ListParts(ctx context.Context, key, uploadID string) ([]UploadPart, error) // Ordered by part number
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []UploadPart) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+	ListMultipartUploads(ctx context.Context) ([]MultipartUpload, error) // Incomplete uploads, oldest first
 }
 
-// MetadataStorage handles database operations
+// MetadataStorage handles database operations. Lookups and updates of a
+// missing record return an error wrapping ErrNotFound; lists of nothing are
+// empty rather than errors.
 type MetadataStorage interface {
 	// Audio file operations
 	CreateAudioFile(ctx context.Context, file *AudioFile) error
 	GetAudioFile(ctx context.Context, fileID string) (*AudioFile, error)
 	UpdateStatus(ctx context.Context, fileID string, status string) error
 	UpdateAudioFileDuration(ctx context.Context, fileID string, durationSeconds int) error
+	UpdateAudioFileHash(ctx context.Context, fileID string, hash string) error
 	DeleteAudioFile(ctx context.Context, fileID string) error
+	// GetAudioFilesByHash lists the files of every user with the given
+	// content hash, newest first
+	GetAudioFilesByHash(ctx context.Context, hash string) ([]*AudioFile, error)
+	// LockContent takes the lock on the original with the given content
+	// hash for ttl, held by token (ErrConflict while another holder's lock
+	// hasn't expired). Use storage.LockContent rather than calling it
+	// directly. UnlockContent releases it if token still holds it.
+	LockContent(ctx context.Context, hash, token string, ttl time.Duration) error
+	UnlockContent(ctx context.Context, hash, token string) error
 
 	// Job operations
 	CreateJob(ctx context.Context, job *ProcessingJob) error
 	GetJob(ctx context.Context, jobID string) (*ProcessingJob, error)
-	GetJobByFileID(ctx context.Context, fileID string) (*ProcessingJob, error)
+	GetJobByFileID(ctx context.Context, fileID string) (*ProcessingJob, error)    // Most recently created job for the file
+	GetJobsByFileID(ctx context.Context, fileID string) ([]*ProcessingJob, error) // Newest first
 	UpdateJobStatus(ctx context.Context, jobID, status string, errorMsg *string) error
 	UpdateJob(ctx context.Context, job *ProcessingJob) error
 
+	// Job lifecycle: use TransitionJob rather than calling CompareAndSwapJob
+	// directly. CompareAndSwapJob writes job only if its stored status is still
+	// expected (ErrStatusConflict otherwise) and appends a JobEvent in the same
+	// transaction. CreateJob records the job's initial event.
+	CompareAndSwapJob(ctx context.Context, job *ProcessingJob, expected JobStatus, reason string) error
+	GetJobEvents(ctx context.Context, jobID string) ([]*JobEvent, error)
+
 	// User operations
-	CreateUser(ctx context.Context, user *User) error
+	CreateUser(ctx context.Context, user *User) error // ErrConflict if the email is taken
 	GetUser(ctx context.Context, userID string) (*User, error)
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
 	UpdateUser(ctx context.Context, user *User) error
@@ -55,7 +124,7 @@ type MetadataStorage interface {
 	// Email verification operations (soft, non-gating)
 	SetEmailVerified(ctx context.Context, userID string) error
 	StoreVerificationToken(ctx context.Context, userID, token string, expiresAt time.Time) error
-	GetUserIDByVerificationToken(ctx context.Context, token string) (string, error) // rejects expired tokens
+	GetUserIDByVerificationToken(ctx context.Context, token string) (string, error) // ErrExpired for expired tokens
 	DeleteVerificationToken(ctx context.Context, token string) error
 	// Marketing consent operations (GDPR-compliant email marketing opt-in)
 	SetMarketingConsent(ctx context.Context, userID string, consent bool) error
@@ -66,6 +135,18 @@ type MetadataStorage interface {
 	GetUserStats(ctx context.Context, userID string) (*UserUploadStats, error)
 	UpdateUserStats(ctx context.Context, stats *UserUploadStats) error
 
+	// Usage ledger: ReserveUsage holds time for a queued job if the user's
+	// total for the period stays within limit (ErrQuotaExceeded otherwise; a
+	// negative limit is unlimited). RecordUsage charges a finished job, once
+	// per job (ErrConflict if it was already charged), and ReleaseUsage
+	// returns what a stopped job held; both release the job's reservations.
+	// GetUsage sums a user's entries for a billing period, or for all time
+	// if period is empty.
+	ReserveUsage(ctx context.Context, entry *UsageEntry, limit int) error
+	RecordUsage(ctx context.Context, entry *UsageEntry) error
+	ReleaseUsage(ctx context.Context, jobID string) error
+	GetUsage(ctx context.Context, userID, period string) (*Usage, error)
+
 	// User jobs
 	GetUserJobs(ctx context.Context, userID string, limit, offset int) ([]*ProcessingJob, error)
 
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// JobStatus is the lifecycle state of a ProcessingJob
+type JobStatus string
+
+// Job lifecycle states
+const (
+	JobQueued     JobStatus = StatusQueued
+	JobProcessing JobStatus = StatusProcessing
+	JobCompleted  JobStatus = StatusCompleted
+	JobFailed     JobStatus = StatusFailed
+	JobCancelled  JobStatus = StatusCancelled
+)
+
+// jobTransitions lists the states each state may move to
+var jobTransitions = map[JobStatus][]JobStatus{
+	// Enqueue failures fail a job before a worker picks it up
+	JobQueued: {JobProcessing, JobCancelled, JobFailed},
+	// Back to queued when a worker shuts down or an attempt will be retried
+	JobProcessing: {JobCompleted, JobFailed, JobCancelled, JobQueued},
+	// Retried by the user
+	JobFailed:    {JobQueued},
+	JobCompleted: {},
+	JobCancelled: {},
+}
+
+var (
+	// ErrInvalidTransition means the requested status change is not allowed
+	ErrInvalidTransition = errors.New("invalid job status transition")
+	// ErrStatusConflict means the job's stored status no longer matches the
+	// expected one because another writer changed it first. It is an
+	// ErrConflict.
+	ErrStatusConflict = fmt.Errorf("job status changed concurrently: %w", ErrConflict)
+)
+
+// TransitionError describes a rejected status change. It matches
+// ErrInvalidTransition with errors.Is.
+type TransitionError struct {
+	JobID string
+	From  JobStatus
+	To    JobStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("job %s cannot move from %s to %s", e.JobID, e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// CanTransitionTo reports whether a job may move from s to next
+func (s JobStatus) CanTransitionTo(next JobStatus) bool {
+	for _, allowed := range jobTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminal reports whether no further work will happen without user action
+func (s JobStatus) IsTerminal() bool {
+	return s == JobCompleted || s == JobFailed || s == JobCancelled
+}
+
+// JobEvent is one entry in a job's append-only status history
+type JobEvent struct {
+	ID         int64
+	JobID      string
+	FromStatus JobStatus // Empty for the event recorded when the job is created
+	ToStatus   JobStatus
+	Reason     string
+	CreatedAt  time.Time
+}
+
+// TransitionJob moves job to status "to" and stores it with compare-and-set:
+// the write only succeeds if the stored status is still the one job was loaded
+// with. Fields the caller set on job (error message, timestamps) are written
+// with it, and a JobEvent with reason is appended. On error job.Status is restored.
+func TransitionJob(ctx context.Context, store MetadataStorage, job *ProcessingJob, to JobStatus, reason string) error {
+	from := job.Status
+	if !from.CanTransitionTo(to) {
+		return &TransitionError{JobID: job.ID, From: from, To: to}
+	}
+
+	job.Status = to
+	if err := store.CompareAndSwapJob(ctx, job, from, reason); err != nil {
+		job.Status = from
+		return err
+	}
+	return nil
+}
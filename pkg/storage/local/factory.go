@@ -0,0 +1,84 @@
+// Package local builds the self-hosted storage backends from the
+// environment: audio on the local filesystem and metadata in SQLite.
+package local
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+	"github.com/simonlewi/levelmix/pkg/storage/localfs"
+	"github.com/simonlewi/levelmix/pkg/storage/sqlite"
+)
+
+// Factory implements storage.StorageFactory. It is configured with:
+//
+//	LOCAL_STORAGE_DIR=./data/files
+//	LOCAL_STORAGE_URL=http://localhost:8080/files   (where the server mounts the file handler)
+//	LOCAL_STORAGE_SECRET=...                         (signs file URLs; must match across server and worker)
+//	SQLITE_PATH=./data/levelmix.db
+type Factory struct {
+	dir     string
+	baseURL string
+	secret  string
+	dbPath  string
+}
+
+var _ storage.StorageFactory = (*Factory)(nil)
+
+func NewFactory() *Factory {
+	f := &Factory{
+		dir:     os.Getenv("LOCAL_STORAGE_DIR"),
+		baseURL: os.Getenv("LOCAL_STORAGE_URL"),
+		secret:  os.Getenv("LOCAL_STORAGE_SECRET"),
+		dbPath:  os.Getenv("SQLITE_PATH"),
+	}
+	if f.dir == "" {
+		f.dir = filepath.Join("data", "files")
+	}
+	if f.baseURL == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		f.baseURL = "http://localhost:" + port + "/files"
+	}
+	if f.dbPath == "" {
+		f.dbPath = filepath.Join("data", "levelmix.db")
+	}
+	return f
+}
+
+// CreateAudioStorage returns a *localfs.Storage; the server must also serve
+// its Handler at LOCAL_STORAGE_URL
+func (f *Factory) CreateAudioStorage() (storage.AudioStorage, error) {
+	if f.secret == "" {
+		return nil, fmt.Errorf("LOCAL_STORAGE_SECRET environment variable not set")
+	}
+	return localfs.NewStorage(f.dir, f.baseURL, []byte(f.secret))
+}
+
+// CreateMetadataStorage opens the database and applies any pending
+// migrations
+func (f *Factory) CreateMetadataStorage() (storage.MetadataStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(f.dbPath), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+	db, err := sqlite.NewStorage(f.dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := db.Migrate(context.Background())
+	for _, m := range applied {
+		log.Printf("Applied database migration %04d %s", m.Version, m.Name)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
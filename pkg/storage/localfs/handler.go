@@ -0,0 +1,166 @@
+package localfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// Operations a signed URL can allow
+const (
+	opGet = "get"
+	opPut = "put"
+)
+
+// signedURL builds a URL for the handler that allows op on key until it
+// expires. filename and contentType are optional for downloads (they set the
+// response headers) and contentType is required to match for uploads, whose
+// body maxSize caps unless it is 0.
+func (s *Storage) signedURL(key, op string, duration time.Duration, filename, contentType string, maxSize int64) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	if s.baseURL == "" {
+		return "", errors.New("localfs: no base URL configured for signed URLs")
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(duration).Unix(), 10)
+	query := url.Values{}
+	query.Set("op", op)
+	query.Set("expires", expires)
+	if filename != "" {
+		query.Set("filename", filename)
+	}
+	if contentType != "" {
+		query.Set("type", contentType)
+	}
+	maxBytes := ""
+	if maxSize > 0 {
+		maxBytes = strconv.FormatInt(maxSize, 10)
+		query.Set("max", maxBytes)
+	}
+	query.Set("sig", s.sign(key, op, expires, filename, contentType, maxBytes))
+
+	return fmt.Sprintf("%s/%s?%s", s.baseURL, escapeKey(key), query.Encode()), nil
+}
+
+func (s *Storage) sign(key, op, expires, filename, contentType, maxBytes string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(strings.Join([]string{op, key, expires, filename, contentType, maxBytes}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature and expiry of a request for op on key
+func (s *Storage) verify(c *gin.Context, key, op string) bool {
+	if c.Query("op") != op {
+		return false
+	}
+	expires := c.Query("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	expected := s.sign(key, op, expires, c.Query("filename"), c.Query("type"), c.Query("max"))
+	return hmac.Equal([]byte(expected), []byte(c.Query("sig")))
+}
+
+func escapeKey(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Handler serves signed URLs. Mount it on a wildcard route named key under
+// the storage's base URL:
+//
+//	r.GET("/files/*key", fs.Handler())
+//	r.HEAD("/files/*key", fs.Handler())
+//	r.PUT("/files/*key", fs.Handler())
+func (s *Storage) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		p, err := s.path(key)
+		if err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead:
+			if !s.verify(c, key, opGet) {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			if _, err := s.GetObjectInfo(c.Request.Context(), key); err != nil {
+				if !errors.Is(err, storage.ErrNotFound) {
+					log.Printf("localfs: Failed to stat %s: %v", key, err)
+				}
+				c.AbortWithStatus(http.StatusNotFound)
+				return
+			}
+			if filename := c.Query("filename"); filename != "" {
+				c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+			}
+			ct := c.Query("type")
+			if ct == "" {
+				ct = contentType(key)
+			}
+			c.Header("Content-Type", ct)
+			// ServeFile answers range requests, which the segmented pipeline relies on
+			http.ServeFile(c.Writer, c.Request, p)
+
+		case http.MethodPut:
+			if !s.verify(c, key, opPut) {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			if ct := c.Query("type"); ct != "" && c.ContentType() != ct {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			// A part URL outlives an upload that is completed or aborted
+			if strings.HasPrefix(key, multipartDir+"/") && !s.partUploadExists(key) {
+				c.AbortWithStatus(http.StatusNotFound)
+				return
+			}
+			body := c.Request.Body
+			if max := c.Query("max"); max != "" {
+				n, err := strconv.ParseInt(max, 10, 64)
+				if err != nil {
+					c.AbortWithStatus(http.StatusForbidden)
+					return
+				}
+				body = http.MaxBytesReader(c.Writer, body, n)
+			}
+			if err := s.write(c.Request.Context(), key, body); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+					return
+				}
+				log.Printf("localfs: Failed to store upload %s: %v", key, err)
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			c.Status(http.StatusOK)
+
+		default:
+			c.AbortWithStatus(http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package localfs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// Multipart uploads are kept under .multipart/<upload>/ as upload.json and
+// one <n>.part file per part. Parts are written through Handler with
+// ordinary signed PUT URLs, and completing an upload joins them into the
+// object.
+const multipartDir = ".multipart"
+
+// multipartUpload is the upload.json of a multipart upload
+type multipartUpload struct {
+	Key         string    `json:"key"`
+	ContentType string    `json:"content_type"`
+	Initiated   time.Time `json:"initiated"`
+}
+
+func (s *Storage) CreateMultipartUpload(ctx context.Context, key string, contentType string) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate upload ID: %w", err)
+	}
+	uploadID := hex.EncodeToString(b)
+
+	data, err := json.Marshal(multipartUpload{Key: key, ContentType: contentType, Initiated: time.Now()})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode upload %s: %w", uploadID, err)
+	}
+	if err := os.MkdirAll(s.uploadDir(uploadID), 0o750); err != nil {
+		return "", fmt.Errorf("failed to create upload %s: %w", uploadID, err)
+	}
+	if err := os.WriteFile(filepath.Join(s.uploadDir(uploadID), "upload.json"), data, 0o640); err != nil {
+		os.RemoveAll(s.uploadDir(uploadID))
+		return "", fmt.Errorf("failed to create upload %s: %w", uploadID, err)
+	}
+	return uploadID, nil
+}
+
+// GetPresignedPartURL returns a signed URL to PUT one part to. Uploading a
+// part again replaces it.
+func (s *Storage) GetPresignedPartURL(ctx context.Context, key, uploadID string, partNumber int, maxSize int64, duration time.Duration) (string, error) {
+	if partNumber < 1 || partNumber > storage.MaxParts {
+		return "", fmt.Errorf("part number %d out of range 1-%d", partNumber, storage.MaxParts)
+	}
+	if _, err := s.readUpload(key, uploadID); err != nil {
+		return "", err
+	}
+	return s.signedURL(partKey(uploadID, partNumber), opPut, duration, "", "", maxSize)
+}
+
+func (s *Storage) UploadPart(ctx context.Context, key, uploadID string, partNumber int, reader io.Reader, size int64) (storage.UploadPart, error) {
+	if partNumber < 1 || partNumber > storage.MaxParts {
+		return storage.UploadPart{}, fmt.Errorf("part number %d out of range 1-%d", partNumber, storage.MaxParts)
+	}
+	if _, err := s.readUpload(key, uploadID); err != nil {
+		return storage.UploadPart{}, err
+	}
+
+	// S3 rejects a body that doesn't match the declared length
+	counted := &countingReader{r: reader}
+	if err := s.write(ctx, partKey(uploadID, partNumber), io.LimitReader(counted, size+1)); err != nil {
+		return storage.UploadPart{}, err
+	}
+	p := filepath.Join(s.uploadDir(uploadID), partFile(partNumber))
+	if counted.n != size {
+		os.Remove(p)
+		return storage.UploadPart{}, fmt.Errorf("part %d of upload %s is %d bytes, expected %d", partNumber, uploadID, counted.n, size)
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return storage.UploadPart{}, fmt.Errorf("failed to stat part %d of upload %s: %w", partNumber, uploadID, err)
+	}
+	return storage.UploadPart{PartNumber: partNumber, ETag: etag(info), Size: info.Size()}, nil
+}
+
+func (s *Storage) ListParts(ctx context.Context, key, uploadID string) ([]storage.UploadPart, error) {
+	if _, err := s.readUpload(key, uploadID); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(s.uploadDir(uploadID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parts of upload %s: %w", uploadID, err)
+	}
+
+	parts := []storage.UploadPart{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".part")
+		n, err := strconv.Atoi(name)
+		if !ok || err != nil || entry.IsDir() {
+			continue // upload.json and temporary files of parts being written
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed since the directory was read
+		}
+		parts = append(parts, storage.UploadPart{PartNumber: n, ETag: etag(info), Size: info.Size()})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+// CompleteMultipartUpload joins parts, which must be in ascending order and
+// match what ListParts returned, into the object at key. Parts that were
+// uploaded but not listed are discarded.
+func (s *Storage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []storage.UploadPart) error {
+	if _, err := s.readUpload(key, uploadID); err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("upload %s: no parts to complete", uploadID)
+	}
+
+	stored, err := s.ListParts(ctx, key, uploadID)
+	if err != nil {
+		return err
+	}
+	byNumber := make(map[int]storage.UploadPart, len(stored))
+	for _, part := range stored {
+		byNumber[part.PartNumber] = part
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return fmt.Errorf("upload %s: parts out of order at part %d", uploadID, part.PartNumber)
+		}
+		got, ok := byNumber[part.PartNumber]
+		if !ok || got.ETag != part.ETag {
+			return fmt.Errorf("upload %s part %d: %w", uploadID, part.PartNumber, storage.ErrConflict)
+		}
+		if i < len(parts)-1 && got.Size < storage.MinPartSize {
+			return fmt.Errorf("upload %s: part %d is smaller than the minimum of %d bytes", uploadID, part.PartNumber, storage.MinPartSize)
+		}
+
+		f, err := os.Open(filepath.Join(s.uploadDir(uploadID), partFile(part.PartNumber)))
+		if err != nil {
+			return fmt.Errorf("failed to open part %d of upload %s: %w", part.PartNumber, uploadID, err)
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	if err := s.write(ctx, key, io.MultiReader(readers...)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.uploadDir(uploadID)); err != nil {
+		return fmt.Errorf("failed to remove parts of upload %s: %w", uploadID, err)
+	}
+	return nil
+}
+
+func (s *Storage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	if _, err := s.readUpload(key, uploadID); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.uploadDir(uploadID)); err != nil {
+		return fmt.Errorf("failed to remove upload %s: %w", uploadID, err)
+	}
+	return nil
+}
+
+func (s *Storage) ListMultipartUploads(ctx context.Context) ([]storage.MultipartUpload, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, multipartDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []storage.MultipartUpload{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+	}
+
+	uploads := []storage.MultipartUpload{}
+	for _, entry := range entries {
+		upload, err := s.loadUpload(entry.Name())
+		if err != nil {
+			continue // Completed or aborted since the directory was read
+		}
+		uploads = append(uploads, storage.MultipartUpload{
+			Key:       upload.Key,
+			UploadID:  entry.Name(),
+			Initiated: upload.Initiated,
+		})
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].Initiated.Before(uploads[j].Initiated) })
+	return uploads, nil
+}
+
+// readUpload loads an upload, checking that it is for key
+func (s *Storage) readUpload(key, uploadID string) (*multipartUpload, error) {
+	upload, err := s.loadUpload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Key != key {
+		return nil, fmt.Errorf("upload %s of %s: %w", uploadID, key, storage.ErrNotFound)
+	}
+	return upload, nil
+}
+
+func (s *Storage) loadUpload(uploadID string) (*multipartUpload, error) {
+	if !validUploadID(uploadID) {
+		return nil, fmt.Errorf("upload %s: %w", uploadID, storage.ErrNotFound)
+	}
+	data, err := os.ReadFile(filepath.Join(s.uploadDir(uploadID), "upload.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("upload %s: %w", uploadID, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload %s: %w", uploadID, err)
+	}
+
+	var upload multipartUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("failed to decode upload %s: %w", uploadID, err)
+	}
+	return &upload, nil
+}
+
+// partUploadExists reports whether key is a part of a multipart upload that
+// is still in progress, so Handler doesn't store parts of finished uploads
+func (s *Storage) partUploadExists(key string) bool {
+	dir, file := path.Split(key)
+	if path.Dir(path.Clean(dir)) != multipartDir || !strings.HasSuffix(file, ".part") {
+		return false
+	}
+	_, err := s.loadUpload(path.Base(dir))
+	return err == nil
+}
+
+func (s *Storage) uploadDir(uploadID string) string {
+	return filepath.Join(s.root, multipartDir, uploadID)
+}
+
+func partKey(uploadID string, partNumber int) string {
+	return path.Join(multipartDir, uploadID, partFile(partNumber))
+}
+
+func partFile(partNumber int) string {
+	return strconv.Itoa(partNumber) + ".part"
+}
+
+func validUploadID(uploadID string) bool {
+	b, err := hex.DecodeString(uploadID)
+	return err == nil && len(b) == 16
+}
+
+// etag identifies a version of a part. S3 uses the part's MD5; size and
+// modification time are enough to tell a replaced part without reading it.
+func etag(info fs.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.Size(), info.ModTime().UnixNano())
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,311 @@
+// Package localfs stores audio files on the local filesystem. Presigned URLs
+// point at an HMAC-signed handler (see Handler) instead of S3, so browsers
+// and ffmpeg can read and write objects the same way they do with S3.
+package localfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// Storage implements storage.AudioStorage on a directory. Keys use the same
+// layout as the S3 bucket: uploads/<file>.<ext> for uploads,
+// uploads/sha256/<hash>.<ext> for confirmed originals and
+// processed/<file>/<job>.<ext> for job output. Incomplete multipart uploads
+// are kept apart, see multipartDir.
+type Storage struct {
+	root    string
+	baseURL string // Public URL the signed handler is mounted at, e.g. https://example.com/files
+	secret  []byte
+}
+
+var _ storage.AudioStorage = (*Storage)(nil)
+
+// NewStorage creates the root directory if needed. baseURL is where Handler
+// is served; secret signs the URLs it accepts.
+func NewStorage(root, baseURL string, secret []byte) (*Storage, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("localfs: a signing secret is required")
+	}
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory %s: %w", root, err)
+	}
+	return &Storage{
+		root:    root,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		secret:  secret,
+	}, nil
+}
+
+func (s *Storage) GetUploadKey(fileID string, format string) string {
+	return fmt.Sprintf("uploads/%s.%s", fileID, format)
+}
+
+func (s *Storage) GetProcessedKey(fileID string, format string) string {
+	return fmt.Sprintf("processed/%s.%s", fileID, format)
+}
+
+func (s *Storage) GetJobProcessedKey(fileID, jobID string, format string) string {
+	return fmt.Sprintf("processed/%s/%s.%s", fileID, jobID, format)
+}
+
+func (s *Storage) GetContentKey(hash string, format string) string {
+	return fmt.Sprintf("uploads/sha256/%s.%s", hash, format)
+}
+
+// Upload stores the original upload for a file; key is the file ID, as with
+// the S3 implementation
+func (s *Storage) Upload(ctx context.Context, key string, reader io.Reader, format string) error {
+	return s.write(ctx, s.GetUploadKey(key, format), reader)
+}
+
+func (s *Storage) UploadProcessed(ctx context.Context, fileID, jobID string, reader io.Reader, format string) error {
+	return s.write(ctx, s.GetJobProcessedKey(fileID, jobID, format), reader)
+}
+
+func (s *Storage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("object %s: %w", key, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", key, err)
+	}
+	return f, nil
+}
+
+func (s *Storage) DownloadToFile(ctx context.Context, key string, localPath string) error {
+	src, err := s.Download(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", localPath, err)
+	}
+	if _, err := io.Copy(dst, contextReader{ctx, src}); err != nil {
+		dst.Close()
+		os.Remove(localPath)
+		return fmt.Errorf("failed to copy %s: %w", key, err)
+	}
+	return dst.Close()
+}
+
+// Delete removes an object. Deleting a missing object is not an error,
+// matching S3.
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// Rename moves an object with a single rename, so readers see either the old
+// object at dstKey or the new one
+func (s *Storage) Rename(ctx context.Context, srcKey, dstKey string) error {
+	src, err := s.path(srcKey)
+	if err != nil {
+		return err
+	}
+	dst, err := s.path(dstKey)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", dstKey, err)
+	}
+	err = os.Rename(src, dst)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("object %s: %w", srcKey, storage.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+// Copy writes a copy of an object to dstKey, replacing it at once as write
+// does
+func (s *Storage) Copy(ctx context.Context, srcKey, dstKey string) error {
+	src, err := s.Download(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return s.write(ctx, dstKey, src)
+}
+
+// DeleteOlderThan removes objects last modified before cutoff, and the
+// directories that leaves empty. It is the local counterpart of the S3
+// lifecycle rule set up by the cleanup job.
+func (s *Storage) DeleteOlderThan(ctx context.Context, cutoff time.Time) (int, error) {
+	deleted := 0
+	var dirs []string
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// Multipart uploads are aborted by age instead, see
+			// storage.AbortStaleMultipartUploads
+			if p == filepath.Join(s.root, multipartDir) {
+				return filepath.SkipDir
+			}
+			// Top-level directories (uploads/, processed/) are kept
+			if p != s.root && filepath.Dir(p) != s.root {
+				dirs = append(dirs, p)
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(cutoff) {
+			if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		return deleted, fmt.Errorf("failed to clean up %s: %w", s.root, err)
+	}
+
+	// Deepest first, so parents are empty by the time they are reached;
+	// directories that still hold files are left alone
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+	return deleted, nil
+}
+
+func (s *Storage) GetObjectInfo(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && info.IsDir() {
+		return nil, fmt.Errorf("object %s: %w", key, storage.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+	return &storage.ObjectInfo{
+		Size:         info.Size(),
+		LastModified: info.ModTime(),
+		ContentType:  contentType(key),
+	}, nil
+}
+
+func (s *Storage) GetPresignedURL(ctx context.Context, key string, duration time.Duration, format string) (string, error) {
+	return s.signedURL(key, opGet, duration, "", "", 0)
+}
+
+func (s *Storage) GetPresignedDownloadURL(ctx context.Context, key string, downloadFilename string, contentType string, duration time.Duration) (string, error) {
+	return s.signedURL(key, opGet, duration, downloadFilename, contentType, 0)
+}
+
+func (s *Storage) GetPresignedUploadURL(ctx context.Context, key string, contentType string, maxSize int64, duration time.Duration) (string, error) {
+	return s.signedURL(key, opPut, duration, "", contentType, maxSize)
+}
+
+// path maps a key to a file under the root, rejecting keys that would
+// escape it
+func (s *Storage) path(key string) (string, error) {
+	if key == "" || !filepath.IsLocal(filepath.FromSlash(key)) || path.Clean(key) != key {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// write stores an object atomically, so readers never see a partial file
+func (s *Storage) write(ctx context.Context, key string, reader io.Reader) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", key, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := io.Copy(tmp, contextReader{ctx, reader}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("failed to store %s: %w", key, err)
+	}
+	return nil
+}
+
+// Content types for the audio formats we accept; anything else goes by the
+// system MIME table
+var audioContentTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+	".aac":  "audio/aac",
+	".m4a":  "audio/mp4",
+	".ogg":  "audio/ogg",
+	".aiff": "audio/aiff",
+}
+
+func contentType(key string) string {
+	ext := strings.ToLower(path.Ext(key))
+	if ct, ok := audioContentTypes[ext]; ok {
+		return ct
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// contextReader stops a copy once its context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,207 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+	"github.com/simonlewi/levelmix/pkg/storage/storagetest"
+)
+
+func newTestStorage(t *testing.T) (*Storage, *httptest.Server) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	s, err := NewStorage(t.TempDir(), srv.URL+"/files", []byte("test-secret"))
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	r.GET("/files/*key", s.Handler())
+	r.PUT("/files/*key", s.Handler())
+	return s, srv
+}
+
+func TestStorageRoundTrip(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+
+	if err := s.Upload(ctx, "file1", strings.NewReader("original"), "wav"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if err := s.UploadProcessed(ctx, "file1", "job1", strings.NewReader("processed"), "wav"); err != nil {
+		t.Fatalf("UploadProcessed: %v", err)
+	}
+
+	info, err := s.GetObjectInfo(ctx, s.GetUploadKey("file1", "wav"))
+	if err != nil {
+		t.Fatalf("GetObjectInfo: %v", err)
+	}
+	if info.Size != int64(len("original")) || info.ContentType != "audio/wav" {
+		t.Errorf("unexpected object info: %+v", info)
+	}
+
+	local := filepath.Join(t.TempDir(), "out.wav")
+	if err := s.DownloadToFile(ctx, s.GetJobProcessedKey("file1", "job1", "wav"), local); err != nil {
+		t.Fatalf("DownloadToFile: %v", err)
+	}
+	if data, _ := os.ReadFile(local); string(data) != "processed" {
+		t.Errorf("expected processed output, got %q", data)
+	}
+
+	if err := s.Delete(ctx, s.GetUploadKey("file1", "wav")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := s.Delete(ctx, s.GetUploadKey("file1", "wav")); err != nil {
+		t.Errorf("deleting a missing object should succeed, got %v", err)
+	}
+	if _, err := s.GetObjectInfo(ctx, s.GetUploadKey("file1", "wav")); err == nil {
+		t.Error("expected an error for a deleted object")
+	}
+}
+
+func TestStorageRejectsEscapingKeys(t *testing.T) {
+	s, _ := newTestStorage(t)
+	for _, key := range []string{"", "../secret", "/etc/passwd", "uploads/../../x"} {
+		if _, err := s.Download(context.Background(), key); err == nil {
+			t.Errorf("expected key %q to be rejected", key)
+		}
+	}
+}
+
+func TestSignedURLs(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+	key := s.GetUploadKey("file2", "mp3")
+
+	uploadURL, err := s.GetPresignedUploadURL(ctx, key, "audio/mpeg", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("GetPresignedUploadURL: %v", err)
+	}
+
+	put := func(url, contentType string) int {
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader("mp3 data"))
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := put(uploadURL, "audio/wav"); code != http.StatusForbidden {
+		t.Errorf("upload with the wrong content type: expected 403, got %d", code)
+	}
+	if code := put(strings.Replace(uploadURL, "file2", "file3", 1), "audio/mpeg"); code != http.StatusForbidden {
+		t.Errorf("upload to another key: expected 403, got %d", code)
+	}
+	if code := put(uploadURL, "audio/mpeg"); code != http.StatusOK {
+		t.Fatalf("upload: expected 200, got %d", code)
+	}
+
+	downloadURL, err := s.GetPresignedDownloadURL(ctx, key, `my "song".mp3`, "audio/mpeg", time.Minute)
+	if err != nil {
+		t.Fatalf("GetPresignedDownloadURL: %v", err)
+	}
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "mp3 data" {
+		t.Errorf("download: got %d %q", resp.StatusCode, body)
+	}
+	// Quotes in the filename are escaped, not ending the parameter early
+	cd := resp.Header.Get("Content-Disposition")
+	if disposition, params, err := mime.ParseMediaType(cd); err != nil || disposition != "attachment" || params["filename"] != `my "song".mp3` {
+		t.Errorf("expected attachment filename, got %q", cd)
+	}
+
+	// Bodies over a URL's cap aren't stored
+	capped := s.GetUploadKey("file4", "mp3")
+	cappedURL, err := s.GetPresignedUploadURL(ctx, capped, "audio/mpeg", 4, time.Minute)
+	if err != nil {
+		t.Fatalf("GetPresignedUploadURL: %v", err)
+	}
+	if code := put(cappedURL, "audio/mpeg"); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload over the cap: expected 413, got %d", code)
+	}
+	if _, err := s.GetObjectInfo(ctx, capped); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("upload over the cap was stored: %v", err)
+	}
+	if code := put(strings.Replace(cappedURL, "max=4", "max=100", 1), "audio/mpeg"); code != http.StatusForbidden {
+		t.Errorf("upload with a raised cap: expected 403, got %d", code)
+	}
+
+	// A download URL can't be used to upload
+	if code := put(downloadURL, "audio/mpeg"); code != http.StatusForbidden {
+		t.Errorf("upload with a download URL: expected 403, got %d", code)
+	}
+
+	expiredURL, _ := s.GetPresignedURL(ctx, key, -time.Minute, "mp3")
+	if resp, err := http.Get(expiredURL); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("expired URL: expected 403, got %d", resp.StatusCode)
+		}
+	}
+}
+
+func TestDeleteOlderThan(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := context.Background()
+
+	if err := s.Upload(ctx, "old", strings.NewReader("old"), "mp3"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if err := s.UploadProcessed(ctx, "old", "job1", strings.NewReader("old"), "mp3"); err != nil {
+		t.Fatalf("UploadProcessed: %v", err)
+	}
+	if err := s.Upload(ctx, "new", strings.NewReader("new"), "mp3"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	past := time.Now().Add(-48 * time.Hour)
+	for _, key := range []string{s.GetUploadKey("old", "mp3"), s.GetJobProcessedKey("old", "job1", "mp3")} {
+		if err := os.Chtimes(filepath.Join(s.root, key), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := s.DeleteOlderThan(ctx, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteOlderThan: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("deleted %d objects, want 2", deleted)
+	}
+	if _, err := s.GetObjectInfo(ctx, s.GetUploadKey("new", "mp3")); err != nil {
+		t.Errorf("recent upload was removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.root, "processed", "old")); !os.IsNotExist(err) {
+		t.Errorf("empty output directory was kept: %v", err)
+	}
+}
+
+func TestAudioStorage(t *testing.T) {
+	storagetest.RunAudioStorageTests(t, func(t *testing.T) storage.AudioStorage {
+		s, _ := newTestStorage(t)
+		return s
+	})
+}
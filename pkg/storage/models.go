@@ -15,23 +15,40 @@ type AudioFile struct {
 	Status           string
 	LUFSTarget       float64
 	DurationSeconds  *int
+	ContentHash      string // Hex SHA-256 of the original; empty for files uploaded before hashing
 	CreatedAt        time.Time
 	UpdatedAt        time.Time
 }
 
 // ProcessingJob represents a background processing job
 type ProcessingJob struct {
-	ID           string
-	AudioFileID  string
-	UserID       string
-	Status       string
-	TargetLUFS   *float64
-	ErrorMessage *string
-	OutputS3Key  string
-	OutputFormat string
-	StartedAt    *time.Time
-	CompletedAt  *time.Time
-	CreatedAt    time.Time
+	ID            string
+	AudioFileID   string
+	UserID        string
+	Status        JobStatus
+	TargetLUFS    *float64
+	ErrorMessage  *string
+	ErrorCategory *string // Failure category of a failed job, e.g. "invalid_input"
+	OutputS3Key   string
+	OutputFormat  string
+	Spec          *JobSpec // Nil for jobs created before specs were stored
+	ParentJobID   *string  // Job this one re-processes, if any
+	StartedAt     *time.Time
+	CompletedAt   *time.Time
+	CreatedAt     time.Time
+}
+
+// JobSpec is the full set of options a job was submitted with. It is stored
+// with the job (as JSON) so retries and re-processing replay it exactly.
+type JobSpec struct {
+	TargetLUFS     float64    `json:"target_lufs"`
+	Preset         string     `json:"preset,omitempty"`
+	ProcessingMode string     `json:"processing_mode"`
+	NoiseReduction bool       `json:"noise_reduction"`
+	SampleRate     int        `json:"sample_rate,omitempty"`
+	IsPremium      bool       `json:"is_premium"` // Selects the premium output format and options
+	ProcessAfter   *time.Time `json:"process_after,omitempty"`
+	OffPeak        bool       `json:"off_peak,omitempty"` // Charged at the off-peak rate
 }
 
 type User struct {
@@ -92,19 +109,12 @@ func (u *User) GetGreeting() string {
 	return "Hi there"
 }
 
+// UserUploadStats counts uploads. Processing time is in the usage ledger,
+// see GetUsage.
 type UserUploadStats struct {
-	UserID                     string
-	TotalUploads               int
-	TotalProcessingTimeSeconds int
-	LastUploadAt               *time.Time
-
-	// OLD FIELDS - keep temporarily during migration
-	UploadsThisWeek int
-	WeekResetAt     time.Time
-
-	// NEW FIELDS - Primary tracking for usage limits
-	ProcessingTimeThisMonth int
-	MonthResetAt            time.Time
+	UserID       string
+	TotalUploads int
+	LastUploadAt *time.Time
 }
 
 // Job status constants
@@ -114,6 +124,7 @@ const (
 	StatusProcessing = "processing"
 	StatusCompleted  = "completed"
 	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
 )
 
 type CookieConsentRecord struct {
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AbortStaleMultipartUploads aborts multipart uploads started before cutoff,
+// so abandoned uploads don't keep their parts forever. It returns how many
+// were aborted; one failing doesn't stop the rest.
+func AbortStaleMultipartUploads(ctx context.Context, s AudioStorage, cutoff time.Time) (int, error) {
+	uploads, err := s.ListMultipartUploads(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list multipart uploads: %w", err)
+	}
+
+	aborted := 0
+	var errs []error
+	for _, upload := range uploads {
+		if !upload.Initiated.Before(cutoff) {
+			continue
+		}
+		if err := s.AbortMultipartUpload(ctx, upload.Key, upload.UploadID); err != nil && !errors.Is(err, ErrNotFound) {
+			errs = append(errs, fmt.Errorf("failed to abort upload %s of %s: %w", upload.UploadID, upload.Key, err))
+			continue
+		}
+		aborted++
+	}
+	return aborted, errors.Join(errs...)
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// contentLockTTL bounds how long a holder that died keeps a content lock
+	contentLockTTL = 5 * time.Minute
+	// contentLockPollInterval is how often a busy content lock is retried
+	contentLockPollInterval = 50 * time.Millisecond
+)
+
+// OriginalKey is where file's original upload is stored: under its content
+// hash once confirmed, or under the file ID for files uploaded before
+// uploads were hashed
+func OriginalKey(s AudioStorage, file *AudioFile) string {
+	if file.ContentHash != "" {
+		return s.GetContentKey(file.ContentHash, file.Format)
+	}
+	return s.GetUploadKey(file.ID, file.Format)
+}
+
+// LockContent takes the lock on the original with the given content hash,
+// waiting while someone else holds it, and returns the function releasing
+// it. Moving an original onto its content key together with recording the
+// file, and deleting it once no file shares it, happen under this lock.
+func LockContent(ctx context.Context, m MetadataStorage, hash string) (func(), error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate content lock token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+
+	for {
+		err := m.LockContent(ctx, hash, token, contentLockTTL)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrConflict) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for content lock %s: %w", hash, ctx.Err())
+		case <-time.After(contentLockPollInterval):
+		}
+	}
+
+	return func() {
+		if err := m.UnlockContent(context.WithoutCancel(ctx), hash, token); err != nil {
+			log.Printf("storage: Failed to release content lock %s: %v", hash, err)
+		}
+	}, nil
+}
+
+// StoreOriginal moves file's original from its upload key to the content
+// key of hash and calls record to save file with it, under the content
+// lock, so deleting another copy can't remove it in between. file's
+// ContentHash is set while record runs. If either fails the original is
+// left at, or put back to, its upload key and ContentHash cleared.
+func StoreOriginal(ctx context.Context, s AudioStorage, m MetadataStorage, file *AudioFile, hash string, record func() error) error {
+	unlock, err := LockContent(ctx, m, hash)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Moving over an existing copy keeps one object and restarts its
+	// retention, so every file sharing it keeps its original for the full
+	// period
+	uploadKey, contentKey := s.GetUploadKey(file.ID, file.Format), s.GetContentKey(hash, file.Format)
+	if err := s.Rename(ctx, uploadKey, contentKey); err != nil {
+		return fmt.Errorf("failed to move original of %s to %s: %w", file.ID, contentKey, err)
+	}
+
+	file.ContentHash = hash
+	if err := record(); err != nil {
+		file.ContentHash = ""
+		// Copy it back if other files share it
+		restore := s.Copy
+		if files, lookupErr := m.GetAudioFilesByHash(ctx, hash); lookupErr == nil && len(files) == 0 {
+			restore = s.Rename
+		}
+		if restoreErr := restore(ctx, contentKey, uploadKey); restoreErr != nil {
+			return fmt.Errorf("%w (and failed to put back original of %s: %v)", err, file.ID, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteOriginal deletes file's original upload unless another file shares
+// it. It reports whether the object was deleted.
+func DeleteOriginal(ctx context.Context, s AudioStorage, m MetadataStorage, file *AudioFile) (bool, error) {
+	if file.ContentHash != "" {
+		unlock, err := LockContent(ctx, m, file.ContentHash)
+		if err != nil {
+			return false, err
+		}
+		defer unlock()
+
+		files, err := m.GetAudioFilesByHash(ctx, file.ContentHash)
+		if err != nil {
+			return false, fmt.Errorf("failed to check who shares the original of %s: %w", file.ID, err)
+		}
+		for _, other := range files {
+			if other.ID != file.ID {
+				return false, nil
+			}
+		}
+	}
+
+	if err := s.Delete(ctx, OriginalKey(s, file)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// HasCompletedJob reports whether any job for the file completed, in which
+// case its original is kept for re-processing. Errors count as true so the
+// original is kept when in doubt.
+func HasCompletedJob(ctx context.Context, m MetadataStorage, fileID string) bool {
+	jobs, err := m.GetJobsByFileID(ctx, fileID)
+	if err != nil {
+		return true
+	}
+	for _, job := range jobs {
+		if job.Status == JobCompleted {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+const consentColumns = `id, user_id, essential, analytics, functional, consent_version, user_agent, ip_address, created_at`
+
+func (s *Storage) StoreCookieConsent(ctx context.Context, record storage.CookieConsentRecord) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO cookie_consents (`+consentColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.ID, record.UserID, record.Essential, record.Analytics, record.Functional,
+		record.ConsentVersion, record.UserAgent, record.IPAddress, now(record.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to store cookie consent %s: %w", record.ID, err)
+	}
+	return nil
+}
+
+func (s *Storage) GetLatestConsent(ctx context.Context, userID string) (*storage.CookieConsentRecord, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+consentColumns+` FROM cookie_consents
+		WHERE user_id = ?
+		ORDER BY created_at DESC, rowid DESC
+		LIMIT 1`, userID)
+	record, err := scanConsent(row)
+	if err != nil {
+		return nil, lookupErr(err, "consent of user "+userID)
+	}
+	return record, nil
+}
+
+// GetUserConsentHistory returns the user's consent records, newest first
+func (s *Storage) GetUserConsentHistory(ctx context.Context, userID string) ([]*storage.CookieConsentRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+consentColumns+` FROM cookie_consents
+		WHERE user_id = ?
+		ORDER BY created_at DESC, rowid DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query consent history of user %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var records []*storage.CookieConsentRecord
+	for rows.Next() {
+		record, err := scanConsent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read consent record: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s *Storage) DeleteUserConsentData(ctx context.Context, userID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM cookie_consents WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete consent data of user %s: %w", userID, err)
+	}
+	return nil
+}
+
+// DeleteOldConsents removes records created before cutoffTime
+func (s *Storage) DeleteOldConsents(ctx context.Context, cutoffTime time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM cookie_consents WHERE created_at < ?`, utc(cutoffTime)); err != nil {
+		return fmt.Errorf("failed to delete old consents: %w", err)
+	}
+	return nil
+}
+
+func scanConsent(row scanner) (*storage.CookieConsentRecord, error) {
+	var record storage.CookieConsentRecord
+	var userID sql.NullString
+	if err := row.Scan(&record.ID, &userID, &record.Essential, &record.Analytics, &record.Functional,
+		&record.ConsentVersion, &record.UserAgent, &record.IPAddress, &record.CreatedAt); err != nil {
+		return nil, err
+	}
+	record.UserID = stringPtr(userID)
+	return &record, nil
+}
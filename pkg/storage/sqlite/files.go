@@ -0,0 +1,134 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+const audioFileColumns = `id, user_id, original_filename, file_size, format, status, lufs_target, duration_seconds, content_hash, created_at, updated_at`
+
+func (s *Storage) CreateAudioFile(ctx context.Context, file *storage.AudioFile) error {
+	file.CreatedAt = now(file.CreatedAt)
+	file.UpdatedAt = now(file.UpdatedAt)
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO audio_files (`+audioFileColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		file.ID, file.UserID, file.OriginalFilename, file.FileSize, file.Format, file.Status,
+		file.LUFSTarget, file.DurationSeconds, sql.NullString{String: file.ContentHash, Valid: file.ContentHash != ""},
+		file.CreatedAt, file.UpdatedAt)
+	if err != nil {
+		return insertErr(err, "audio file "+file.ID)
+	}
+	return nil
+}
+
+func (s *Storage) GetAudioFile(ctx context.Context, fileID string) (*storage.AudioFile, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+audioFileColumns+` FROM audio_files WHERE id = ?`, fileID)
+	file, err := scanAudioFile(row)
+	if err != nil {
+		return nil, lookupErr(err, "audio file "+fileID)
+	}
+	return file, nil
+}
+
+func (s *Storage) UpdateStatus(ctx context.Context, fileID string, status string) error {
+	return s.updateAudioFile(ctx, fileID, `status = ?`, status)
+}
+
+func (s *Storage) UpdateAudioFileDuration(ctx context.Context, fileID string, durationSeconds int) error {
+	return s.updateAudioFile(ctx, fileID, `duration_seconds = ?`, durationSeconds)
+}
+
+func (s *Storage) UpdateAudioFileHash(ctx context.Context, fileID string, hash string) error {
+	return s.updateAudioFile(ctx, fileID, `content_hash = ?`, hash)
+}
+
+func (s *Storage) DeleteAudioFile(ctx context.Context, fileID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM audio_files WHERE id = ?`, fileID); err != nil {
+		return fmt.Errorf("failed to delete audio file %s: %w", fileID, err)
+	}
+	return nil
+}
+
+func (s *Storage) GetAudioFilesByHash(ctx context.Context, hash string) ([]*storage.AudioFile, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+audioFileColumns+` FROM audio_files
+		WHERE content_hash = ?
+		ORDER BY created_at DESC, rowid DESC`, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audio files with hash %s: %w", hash, err)
+	}
+	defer rows.Close()
+
+	var files []*storage.AudioFile
+	for rows.Next() {
+		file, err := scanAudioFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audio files with hash %s: %w", hash, err)
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// updateAudioFile sets one column of a file and bumps updated_at
+func (s *Storage) updateAudioFile(ctx context.Context, fileID, set string, value any) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE audio_files SET `+set+`, updated_at = ? WHERE id = ?`,
+		value, time.Now().UTC(), fileID)
+	if err != nil {
+		return fmt.Errorf("failed to update audio file %s: %w", fileID, err)
+	}
+	return requireRow(result, "audio file "+fileID)
+}
+
+func scanAudioFile(row scanner) (*storage.AudioFile, error) {
+	var file storage.AudioFile
+	var userID sql.NullString
+	var duration sql.NullInt64
+	var contentHash sql.NullString
+	if err := row.Scan(&file.ID, &userID, &file.OriginalFilename, &file.FileSize, &file.Format,
+		&file.Status, &file.LUFSTarget, &duration, &contentHash, &file.CreatedAt, &file.UpdatedAt); err != nil {
+		return nil, err
+	}
+	file.UserID = stringPtr(userID)
+	file.ContentHash = contentHash.String
+	if duration.Valid {
+		d := int(duration.Int64)
+		file.DurationSeconds = &d
+	}
+	return &file, nil
+}
+
+func (s *Storage) LockContent(ctx context.Context, hash, token string, ttl time.Duration) error {
+	now := time.Now().UTC()
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO content_locks (hash, token, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT(hash) DO UPDATE SET token = excluded.token, expires_at = excluded.expires_at
+		WHERE content_locks.expires_at <= ?`,
+		hash, token, now.Add(ttl), now)
+	if err != nil {
+		return fmt.Errorf("failed to lock content %s: %w", hash, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to lock content %s: %w", hash, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("content %s is locked: %w", hash, storage.ErrConflict)
+	}
+	return nil
+}
+
+func (s *Storage) UnlockContent(ctx context.Context, hash, token string) error {
+	if _, err := s.db.ExecContext(ctx,
+		`DELETE FROM content_locks WHERE hash = ? AND token = ?`, hash, token); err != nil {
+		return fmt.Errorf("failed to unlock content %s: %w", hash, err)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+const jobColumns = `id, audio_file_id, user_id, status, target_lufs, error_message, error_category,
+	output_s3_key, output_format, spec, parent_job_id, started_at, completed_at, created_at`
+
+// CreateJob stores a new job and records its initial event
+func (s *Storage) CreateJob(ctx context.Context, job *storage.ProcessingJob) error {
+	job.CreatedAt = now(job.CreatedAt)
+	spec, err := encodeSpec(job.Spec)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create job %s: %w", job.ID, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO processing_jobs (`+jobColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.AudioFileID, job.UserID, string(job.Status), job.TargetLUFS, job.ErrorMessage,
+		job.ErrorCategory, job.OutputS3Key, job.OutputFormat, spec, job.ParentJobID,
+		nullTime(job.StartedAt), nullTime(job.CompletedAt), job.CreatedAt); err != nil {
+		return insertErr(err, "job "+job.ID)
+	}
+	if err := insertJobEvent(ctx, tx, job.ID, "", job.Status, "created"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) GetJob(ctx context.Context, jobID string) (*storage.ProcessingJob, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM processing_jobs WHERE id = ?`, jobID)
+	job, err := scanJob(row)
+	if err != nil {
+		return nil, lookupErr(err, "job "+jobID)
+	}
+	return job, nil
+}
+
+func (s *Storage) GetJobByFileID(ctx context.Context, fileID string) (*storage.ProcessingJob, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT `+jobColumns+` FROM processing_jobs
+		WHERE audio_file_id = ?
+		ORDER BY created_at DESC, rowid DESC
+		LIMIT 1`, fileID)
+	job, err := scanJob(row)
+	if err != nil {
+		return nil, lookupErr(err, "job for file "+fileID)
+	}
+	return job, nil
+}
+
+func (s *Storage) GetJobsByFileID(ctx context.Context, fileID string) ([]*storage.ProcessingJob, error) {
+	return s.queryJobs(ctx, `
+		SELECT `+jobColumns+` FROM processing_jobs
+		WHERE audio_file_id = ?
+		ORDER BY created_at DESC, rowid DESC`, fileID)
+}
+
+func (s *Storage) GetUserJobs(ctx context.Context, userID string, limit, offset int) ([]*storage.ProcessingJob, error) {
+	return s.queryJobs(ctx, `
+		SELECT `+jobColumns+` FROM processing_jobs
+		WHERE user_id = ?
+		ORDER BY created_at DESC, rowid DESC
+		LIMIT ? OFFSET ?`, userID, limit, offset)
+}
+
+// UpdateJobStatus sets the status and error message without the
+// compare-and-swap check or a history event
+func (s *Storage) UpdateJobStatus(ctx context.Context, jobID, status string, errorMsg *string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE processing_jobs SET status = ?, error_message = ? WHERE id = ?`,
+		status, errorMsg, jobID)
+	if err != nil {
+		return fmt.Errorf("failed to update job %s: %w", jobID, err)
+	}
+	return requireRow(result, "job "+jobID)
+}
+
+// UpdateJob writes every field of the job unconditionally
+func (s *Storage) UpdateJob(ctx context.Context, job *storage.ProcessingJob) error {
+	result, err := s.updateJob(ctx, s.db, job, "")
+	if err != nil {
+		return err
+	}
+	return requireRow(result, "job "+job.ID)
+}
+
+func (s *Storage) CompareAndSwapJob(ctx context.Context, job *storage.ProcessingJob, expected storage.JobStatus, reason string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update job %s: %w", job.ID, err)
+	}
+	defer tx.Rollback()
+
+	result, err := s.updateJob(ctx, tx, job, expected)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update job %s: %w", job.ID, err)
+	}
+	if n == 0 {
+		var exists int
+		if err := tx.QueryRowContext(ctx, `SELECT 1 FROM processing_jobs WHERE id = ?`, job.ID).Scan(&exists); err != nil {
+			return lookupErr(err, "job "+job.ID)
+		}
+		return fmt.Errorf("job %s is no longer %s: %w", job.ID, expected, storage.ErrStatusConflict)
+	}
+
+	if err := insertJobEvent(ctx, tx, job.ID, expected, job.Status, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) GetJobEvents(ctx context.Context, jobID string) ([]*storage.JobEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, job_id, from_status, to_status, reason, created_at
+		FROM job_events WHERE job_id = ? ORDER BY id`, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get events for job %s: %w", jobID, err)
+	}
+	defer rows.Close()
+
+	var events []*storage.JobEvent
+	for rows.Next() {
+		var e storage.JobEvent
+		var from, to string
+		if err := rows.Scan(&e.ID, &e.JobID, &from, &to, &e.Reason, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read events for job %s: %w", jobID, err)
+		}
+		e.FromStatus, e.ToStatus = storage.JobStatus(from), storage.JobStatus(to)
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// updateJob writes all fields of job, only if its stored status is expected
+// when expected is not empty
+func (s *Storage) updateJob(ctx context.Context, db execer, job *storage.ProcessingJob, expected storage.JobStatus) (sql.Result, error) {
+	spec, err := encodeSpec(job.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE processing_jobs SET
+			status = ?, target_lufs = ?, error_message = ?, error_category = ?,
+			output_s3_key = ?, output_format = ?, spec = ?, parent_job_id = ?,
+			started_at = ?, completed_at = ?
+		WHERE id = ?`
+	args := []any{string(job.Status), job.TargetLUFS, job.ErrorMessage, job.ErrorCategory,
+		job.OutputS3Key, job.OutputFormat, spec, job.ParentJobID,
+		nullTime(job.StartedAt), nullTime(job.CompletedAt), job.ID}
+	if expected != "" {
+		query += ` AND status = ?`
+		args = append(args, string(expected))
+	}
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update job %s: %w", job.ID, err)
+	}
+	return result, nil
+}
+
+func insertJobEvent(ctx context.Context, db execer, jobID string, from, to storage.JobStatus, reason string) error {
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO job_events (job_id, from_status, to_status, reason, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		jobID, string(from), string(to), reason, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to record event for job %s: %w", jobID, err)
+	}
+	return nil
+}
+
+func (s *Storage) queryJobs(ctx context.Context, query string, args ...any) ([]*storage.ProcessingJob, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*storage.ProcessingJob
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func scanJob(row scanner) (*storage.ProcessingJob, error) {
+	var job storage.ProcessingJob
+	var status string
+	var targetLUFS sql.NullFloat64
+	var errorMessage, errorCategory, spec, parentJobID sql.NullString
+	var startedAt, completedAt sql.NullTime
+
+	if err := row.Scan(&job.ID, &job.AudioFileID, &job.UserID, &status, &targetLUFS, &errorMessage,
+		&errorCategory, &job.OutputS3Key, &job.OutputFormat, &spec, &parentJobID,
+		&startedAt, &completedAt, &job.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	job.Status = storage.JobStatus(status)
+	if targetLUFS.Valid {
+		job.TargetLUFS = &targetLUFS.Float64
+	}
+	job.ErrorMessage = stringPtr(errorMessage)
+	job.ErrorCategory = stringPtr(errorCategory)
+	job.ParentJobID = stringPtr(parentJobID)
+	job.StartedAt = timePtr(startedAt)
+	job.CompletedAt = timePtr(completedAt)
+
+	if spec.Valid && spec.String != "" {
+		job.Spec = &storage.JobSpec{}
+		if err := json.Unmarshal([]byte(spec.String), job.Spec); err != nil {
+			return nil, fmt.Errorf("failed to decode spec of job %s: %w", job.ID, err)
+		}
+	}
+	return &job, nil
+}
+
+func encodeSpec(spec *storage.JobSpec) (sql.NullString, error) {
+	if spec == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("failed to encode job spec: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
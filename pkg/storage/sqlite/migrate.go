@@ -0,0 +1,140 @@
+package sqlite
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is one versioned schema change, loaded from
+// migrations/NNNN_name.sql
+type Migration struct {
+	Version   int
+	Name      string
+	SQL       string
+	AppliedAt *time.Time // Set by MigrationStatus for applied migrations
+}
+
+// Migrations returns the embedded migrations in version order
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, label, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s is not named NNNN_name.sql", entry.Name())
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		sql, err := fs.ReadFile(migrationFiles, "migrations/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: label, SQL: string(sql)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+const createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at DATETIME NOT NULL
+)`
+
+// Migrate applies every migration that hasn't been applied yet, each in its
+// own transaction, and returns the ones it applied
+func (s *Storage) Migrate(ctx context.Context) ([]Migration, error) {
+	migrations, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, m := range migrations {
+		if m.AppliedAt != nil {
+			continue
+		}
+
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return applied, fmt.Errorf("failed to begin migration %s: %w", m.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("migration %s failed: %w", m.Name, err)
+		}
+		now := time.Now().UTC()
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+			m.Version, m.Name, now); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("failed to record migration %s: %w", m.Name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return applied, fmt.Errorf("failed to commit migration %s: %w", m.Name, err)
+		}
+
+		m.AppliedAt = &now
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// MigrationStatus returns every known migration with AppliedAt set for the
+// ones already applied
+func (s *Storage) MigrationStatus(ctx context.Context) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	for i := range migrations {
+		if at, ok := applied[migrations[i].Version]; ok {
+			migrations[i].AppliedAt = &at
+		}
+	}
+	return migrations, nil
+}
@@ -0,0 +1,99 @@
+-- Initial schema, matching the fields in pkg/storage/models.go
+
+CREATE TABLE users (
+    id                      TEXT PRIMARY KEY,
+    email                   TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    name                    TEXT,
+    password_hash           TEXT,
+    created_at              DATETIME NOT NULL,
+    updated_at              DATETIME NOT NULL,
+    last_login_at           DATETIME,
+    auth_provider           TEXT NOT NULL DEFAULT 'email',
+    auth_provider_id        TEXT,
+    subscription_tier       INTEGER NOT NULL DEFAULT 1,
+    subscription_expires_at DATETIME,
+    email_verified          INTEGER NOT NULL DEFAULT 0,
+    email_verified_at       DATETIME,
+    marketing_consent       INTEGER NOT NULL DEFAULT 0,
+    marketing_consent_at    DATETIME
+);
+
+CREATE TABLE audio_files (
+    id                TEXT PRIMARY KEY,
+    user_id           TEXT REFERENCES users(id) ON DELETE CASCADE,
+    original_filename TEXT NOT NULL,
+    file_size         INTEGER NOT NULL DEFAULT 0,
+    format            TEXT NOT NULL,
+    status            TEXT NOT NULL,
+    lufs_target       REAL NOT NULL DEFAULT 0,
+    duration_seconds  INTEGER,
+    created_at        DATETIME NOT NULL,
+    updated_at        DATETIME NOT NULL
+);
+
+CREATE INDEX idx_audio_files_user ON audio_files(user_id);
+
+CREATE TABLE processing_jobs (
+    id             TEXT PRIMARY KEY,
+    audio_file_id  TEXT NOT NULL REFERENCES audio_files(id) ON DELETE CASCADE,
+    user_id        TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status         TEXT NOT NULL,
+    target_lufs    REAL,
+    error_message  TEXT,
+    error_category TEXT,
+    output_s3_key  TEXT NOT NULL DEFAULT '',
+    output_format  TEXT NOT NULL DEFAULT '',
+    spec           TEXT, -- JSON encoded storage.JobSpec
+    parent_job_id  TEXT,
+    started_at     DATETIME,
+    completed_at   DATETIME,
+    created_at     DATETIME NOT NULL
+);
+
+CREATE INDEX idx_processing_jobs_file ON processing_jobs(audio_file_id, created_at);
+CREATE INDEX idx_processing_jobs_user ON processing_jobs(user_id, created_at);
+
+CREATE TABLE job_events (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    job_id      TEXT NOT NULL REFERENCES processing_jobs(id) ON DELETE CASCADE,
+    from_status TEXT NOT NULL DEFAULT '',
+    to_status   TEXT NOT NULL,
+    reason      TEXT NOT NULL DEFAULT '',
+    created_at  DATETIME NOT NULL
+);
+
+CREATE INDEX idx_job_events_job ON job_events(job_id, id);
+
+CREATE TABLE user_upload_stats (
+    user_id                       TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    total_uploads                 INTEGER NOT NULL DEFAULT 0,
+    total_processing_time_seconds INTEGER NOT NULL DEFAULT 0,
+    last_upload_at                DATETIME,
+    uploads_this_week             INTEGER NOT NULL DEFAULT 0,
+    week_reset_at                 DATETIME NOT NULL,
+    processing_time_this_month    INTEGER NOT NULL DEFAULT 0,
+    month_reset_at                DATETIME NOT NULL
+);
+
+CREATE TABLE email_verification_tokens (
+    token      TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_email_verification_tokens_user ON email_verification_tokens(user_id);
+
+CREATE TABLE cookie_consents (
+    id              TEXT PRIMARY KEY,
+    user_id         TEXT REFERENCES users(id) ON DELETE CASCADE,
+    essential       INTEGER NOT NULL DEFAULT 1,
+    analytics       INTEGER NOT NULL DEFAULT 0,
+    functional      INTEGER NOT NULL DEFAULT 0,
+    consent_version TEXT NOT NULL,
+    user_agent      TEXT NOT NULL DEFAULT '',
+    ip_address      TEXT NOT NULL DEFAULT '',
+    created_at      DATETIME NOT NULL
+);
+
+CREATE INDEX idx_cookie_consents_user ON cookie_consents(user_id, created_at);
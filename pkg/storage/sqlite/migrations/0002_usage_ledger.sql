@@ -0,0 +1,33 @@
+-- Processing time moves from counters in user_upload_stats, which concurrent
+-- jobs overwrote with read-modify-write, to an append-only ledger
+
+CREATE TABLE usage_entries (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id       TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- No foreign key: deleting a file and its jobs must not refund usage
+    job_id        TEXT,
+    seconds       INTEGER NOT NULL,
+    audio_seconds INTEGER NOT NULL DEFAULT 0,
+    tier          INTEGER NOT NULL,
+    period        TEXT NOT NULL,
+    created_at    DATETIME NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_usage_entries_job ON usage_entries(job_id);
+CREATE INDEX idx_usage_entries_user_period ON usage_entries(user_id, period);
+
+-- Carry the counters over as one entry per user without a job: this month's
+-- charged time in the month it was counted for, and the lifetime total as
+-- audio processed
+INSERT INTO usage_entries (user_id, job_id, seconds, audio_seconds, tier, period, created_at)
+SELECT s.user_id, NULL, s.processing_time_this_month, s.total_processing_time_seconds,
+       u.subscription_tier, strftime('%Y-%m', s.month_reset_at), s.month_reset_at
+FROM user_upload_stats s
+JOIN users u ON u.id = s.user_id
+WHERE s.processing_time_this_month > 0 OR s.total_processing_time_seconds > 0;
+
+ALTER TABLE user_upload_stats DROP COLUMN uploads_this_week;
+ALTER TABLE user_upload_stats DROP COLUMN week_reset_at;
+ALTER TABLE user_upload_stats DROP COLUMN processing_time_this_month;
+ALTER TABLE user_upload_stats DROP COLUMN month_reset_at;
+ALTER TABLE user_upload_stats DROP COLUMN total_processing_time_seconds;
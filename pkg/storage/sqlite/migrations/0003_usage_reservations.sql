@@ -0,0 +1,9 @@
+-- Processing time is reserved when a job is queued instead of charged after
+-- it finishes. A job's entries are its reservations, the releases that
+-- return them when it stops or finishes, and at most one charge.
+
+ALTER TABLE usage_entries ADD COLUMN kind TEXT NOT NULL DEFAULT 'charge';
+
+DROP INDEX idx_usage_entries_job;
+CREATE INDEX idx_usage_entries_job ON usage_entries(job_id);
+CREATE UNIQUE INDEX idx_usage_entries_job_charge ON usage_entries(job_id) WHERE kind = 'charge';
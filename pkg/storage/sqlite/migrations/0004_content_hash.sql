@@ -0,0 +1,6 @@
+-- Originals are stored once per content hash, and identical uploads with
+-- identical options reuse earlier output
+
+ALTER TABLE audio_files ADD COLUMN content_hash TEXT;
+
+CREATE INDEX idx_audio_files_content_hash ON audio_files(content_hash);
@@ -0,0 +1,9 @@
+-- Moving an original onto its content key and deleting it once no file
+-- shares it are serialized per content hash. A lock whose holder died is
+-- taken over once it expires.
+
+CREATE TABLE content_locks (
+    hash TEXT PRIMARY KEY,
+    token TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
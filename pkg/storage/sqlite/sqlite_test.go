@@ -0,0 +1,104 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+	"github.com/simonlewi/levelmix/pkg/storage/storagetest"
+)
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	s, err := NewStorage(filepath.Join(t.TempDir(), "levelmix.db"))
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	if _, err := s.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return s
+}
+
+func TestMetadataStorage(t *testing.T) {
+	storagetest.RunMetadataStorageTests(t, func(t *testing.T) storage.MetadataStorage {
+		return newTestStorage(t)
+	})
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	applied, err := s.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("second Migrate applied %d migrations, want 0", len(applied))
+	}
+
+	status, err := s.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	for _, m := range status {
+		if m.AppliedAt == nil {
+			t.Errorf("migration %s not applied", m.Name)
+		}
+	}
+}
+
+func TestUsageLedgerMigrationCarriesCounters(t *testing.T) {
+	s, err := NewStorage(filepath.Join(t.TempDir(), "levelmix.db"))
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	// Apply the initial schema by hand and fill the counters it had
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	if _, err := s.MigrationStatus(ctx); err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	if _, err := s.db.Exec(migrations[0].SQL); err != nil {
+		t.Fatalf("initial migration: %v", err)
+	}
+	if _, err := s.db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (1, 'initial', ?)`, time.Now()); err != nil {
+		t.Fatalf("record initial migration: %v", err)
+	}
+	if err := s.CreateUser(ctx, &storage.User{ID: "u1", Email: "u1@example.com", SubscriptionTier: 2}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	monthStart := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := s.db.Exec(`
+		INSERT INTO user_upload_stats (user_id, total_uploads, total_processing_time_seconds,
+			uploads_this_week, week_reset_at, processing_time_this_month, month_reset_at)
+		VALUES ('u1', 7, 5400, 2, ?, 1800, ?)`, monthStart, monthStart); err != nil {
+		t.Fatalf("insert stats: %v", err)
+	}
+
+	if _, err := s.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	usage, err := s.GetUsage(ctx, "u1", "2026-10")
+	if err != nil {
+		t.Fatalf("GetUsage: %v", err)
+	}
+	if usage.Seconds != 1800 || usage.AudioSeconds != 5400 || usage.Jobs != 0 {
+		t.Errorf("carried-over usage = %+v, want 1800s charged and 5400s processed", usage)
+	}
+	stats, err := s.GetUserStats(ctx, "u1")
+	if err != nil || stats.TotalUploads != 7 {
+		t.Errorf("GetUserStats after migration = %+v, %v", stats, err)
+	}
+}
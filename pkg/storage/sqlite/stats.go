@@ -0,0 +1,49 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+func (s *Storage) CreateUserStats(ctx context.Context, stats *storage.UserUploadStats) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO user_upload_stats (user_id, total_uploads, last_upload_at)
+		VALUES (?, ?, ?)`,
+		stats.UserID, stats.TotalUploads, nullTime(stats.LastUploadAt))
+	if err != nil {
+		return insertErr(err, "stats for user "+stats.UserID)
+	}
+	return nil
+}
+
+func (s *Storage) GetUserStats(ctx context.Context, userID string) (*storage.UserUploadStats, error) {
+	var stats storage.UserUploadStats
+	var lastUploadAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT user_id, total_uploads, last_upload_at
+		FROM user_upload_stats WHERE user_id = ?`, userID).
+		Scan(&stats.UserID, &stats.TotalUploads, &lastUploadAt)
+	if err != nil {
+		return nil, lookupErr(err, "stats for user "+userID)
+	}
+	stats.LastUploadAt = timePtr(lastUploadAt)
+	return &stats, nil
+}
+
+// UpdateUserStats writes the stats, creating the row if the user has none yet
+func (s *Storage) UpdateUserStats(ctx context.Context, stats *storage.UserUploadStats) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO user_upload_stats (user_id, total_uploads, last_upload_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			total_uploads = excluded.total_uploads,
+			last_upload_at = excluded.last_upload_at`,
+		stats.UserID, stats.TotalUploads, nullTime(stats.LastUploadAt))
+	if err != nil {
+		return fmt.Errorf("failed to update stats for user %s: %w", stats.UserID, err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Package sqlite implements storage.MetadataStorage on a local SQLite
+// database, for self-hosted and development setups. The schema is created by
+// embedded, versioned migrations; run them with Migrate or the migrate
+// command.
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// Storage implements storage.MetadataStorage
+type Storage struct {
+	db *sql.DB
+}
+
+var _ storage.MetadataStorage = (*Storage)(nil)
+
+// NewStorage opens (creating if needed) the database at path. Foreign keys
+// are enforced so deleting a user removes their data, and write
+// transactions take the lock up front so concurrent compare-and-swaps wait
+// for each other instead of failing.
+func NewStorage(path string) (*Storage, error) {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Set("_txlock", "immediate")
+	params.Set("_time_format", "sqlite")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	return &Storage{db: db}, nil
+}
+
+// Close closes the database
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// DB returns the underlying database, e.g. for health checks
+func (s *Storage) DB() *sql.DB {
+	return s.db
+}
+
+// Times are stored in UTC so they sort correctly as text
+
+func utc(t time.Time) time.Time {
+	return t.UTC()
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func stringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+// now returns t, or the current time if t is zero
+func now(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now().UTC()
+	}
+	return t.UTC()
+}
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// lookupErr wraps the error of a single-row lookup of what, turning
+// sql.ErrNoRows into storage.ErrNotFound
+func lookupErr(err error, what string) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: %w", what, storage.ErrNotFound)
+	}
+	return fmt.Errorf("failed to read %s: %w", what, err)
+}
+
+// insertErr wraps the error of creating what, turning a uniqueness
+// violation into storage.ErrConflict
+func insertErr(err error, what string) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) &&
+		(sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY) {
+		return fmt.Errorf("%s already exists: %w", what, storage.ErrConflict)
+	}
+	return fmt.Errorf("failed to create %s: %w", what, err)
+}
+
+// requireRow turns a write to what that matched nothing into
+// storage.ErrNotFound
+func requireRow(result sql.Result, what string) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", what, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%s: %w", what, storage.ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// ReserveUsage appends a reservation if the user's usage for the period
+// stays within limit. The transaction takes the write lock up front, so
+// concurrent reservations can't both fit into the same remaining time.
+func (s *Storage) ReserveUsage(ctx context.Context, entry *storage.UsageEntry, limit int) error {
+	entry.Kind = storage.UsageReserved
+	fillUsageEntry(entry)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to reserve usage for job %s: %w", entry.JobID, err)
+	}
+	defer tx.Rollback()
+
+	if limit >= 0 {
+		var used int
+		err := tx.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(seconds), 0) FROM usage_entries
+			WHERE user_id = ? AND period = ?`, entry.UserID, entry.Period).Scan(&used)
+		if err != nil {
+			return fmt.Errorf("failed to read usage of user %s: %w", entry.UserID, err)
+		}
+		if used+entry.Seconds > limit {
+			return fmt.Errorf("job %s needs %ds with %ds of %ds used: %w",
+				entry.JobID, entry.Seconds, used, limit, storage.ErrQuotaExceeded)
+		}
+	}
+
+	if err := insertUsage(ctx, tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordUsage charges a finished job, filling in CreatedAt and Period if
+// unset, and releases what it reserved in the same transaction
+func (s *Storage) RecordUsage(ctx context.Context, entry *storage.UsageEntry) error {
+	entry.Kind = storage.UsageCharged
+	fillUsageEntry(entry)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record usage of job %s: %w", entry.JobID, err)
+	}
+	defer tx.Rollback()
+
+	if err := releaseUsage(ctx, tx, entry.JobID, entry.CreatedAt); err != nil {
+		return err
+	}
+	if err := insertUsage(ctx, tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReleaseUsage returns whatever the job still holds. Releasing twice, or a
+// job that reserved nothing, appends nothing.
+func (s *Storage) ReleaseUsage(ctx context.Context, jobID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to release usage of job %s: %w", jobID, err)
+	}
+	defer tx.Rollback()
+
+	if err := releaseUsage(ctx, tx, jobID, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Storage) GetUsage(ctx context.Context, userID, period string) (*storage.Usage, error) {
+	usage := storage.Usage{Period: period}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(seconds), 0), COALESCE(SUM(audio_seconds), 0),
+		       COUNT(CASE WHEN kind = ? THEN job_id END)
+		FROM usage_entries
+		WHERE user_id = ? AND (? = '' OR period = ?)`, storage.UsageCharged, userID, period, period).
+		Scan(&usage.Seconds, &usage.AudioSeconds, &usage.Jobs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage of user %s: %w", userID, err)
+	}
+	return &usage, nil
+}
+
+func fillUsageEntry(entry *storage.UsageEntry) {
+	entry.CreatedAt = now(entry.CreatedAt)
+	if entry.Period == "" {
+		entry.Period = storage.UsagePeriod(entry.CreatedAt)
+	}
+}
+
+func insertUsage(ctx context.Context, tx *sql.Tx, entry *storage.UsageEntry) error {
+	jobID := sql.NullString{String: entry.JobID, Valid: entry.JobID != ""}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO usage_entries (user_id, job_id, kind, seconds, audio_seconds, tier, period, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.UserID, jobID, entry.Kind, entry.Seconds, entry.AudioSeconds, entry.Tier, entry.Period, entry.CreatedAt)
+	if err != nil {
+		return insertErr(err, fmt.Sprintf("%s usage of job %s", entry.Kind, entry.JobID))
+	}
+	if entry.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to record usage of job %s: %w", entry.JobID, err)
+	}
+	return nil
+}
+
+// releaseUsage appends a release for whatever the job's reservations still
+// hold, in the period each was reserved in, so that month's allowance gets
+// the time back
+func releaseUsage(ctx context.Context, tx *sql.Tx, jobID string, at time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO usage_entries (user_id, job_id, kind, seconds, audio_seconds, tier, period, created_at)
+		SELECT user_id, job_id, ?, -SUM(seconds), -SUM(audio_seconds), MAX(tier), period, ?
+		FROM usage_entries
+		WHERE job_id = ? AND kind IN (?, ?)
+		GROUP BY user_id, job_id, period
+		HAVING SUM(seconds) != 0 OR SUM(audio_seconds) != 0`,
+		storage.UsageReleased, at, jobID, storage.UsageReserved, storage.UsageReleased)
+	if err != nil {
+		return fmt.Errorf("failed to release usage of job %s: %w", jobID, err)
+	}
+	return nil
+}
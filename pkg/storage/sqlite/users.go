@@ -0,0 +1,192 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+const userColumns = `id, email, name, password_hash, created_at, updated_at, last_login_at,
+	auth_provider, auth_provider_id, subscription_tier, subscription_expires_at,
+	email_verified, email_verified_at, marketing_consent, marketing_consent_at`
+
+func (s *Storage) CreateUser(ctx context.Context, user *storage.User) error {
+	user.CreatedAt = now(user.CreatedAt)
+	user.UpdatedAt = now(user.UpdatedAt)
+	if user.AuthProvider == "" {
+		user.AuthProvider = "email"
+	}
+	if user.SubscriptionTier == 0 {
+		user.SubscriptionTier = 1
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO users (`+userColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.ID, user.Email, user.Name, user.PasswordHash, user.CreatedAt, user.UpdatedAt,
+		nullTime(user.LastLoginAt), user.AuthProvider, user.AuthProviderID, user.SubscriptionTier,
+		nullTime(user.SubscriptionExpiresAt), user.EmailVerified, nullTime(user.EmailVerifiedAt),
+		user.MarketingConsent, nullTime(user.MarketingConsentAt))
+	if err != nil {
+		return insertErr(err, "user "+user.ID)
+	}
+	return nil
+}
+
+func (s *Storage) GetUser(ctx context.Context, userID string) (*storage.User, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, userID)
+	user, err := scanUser(row)
+	if err != nil {
+		return nil, lookupErr(err, "user "+userID)
+	}
+	return user, nil
+}
+
+// GetUserByEmail matches the email case-insensitively
+func (s *Storage) GetUserByEmail(ctx context.Context, email string) (*storage.User, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email = ?`, email)
+	user, err := scanUser(row)
+	if err != nil {
+		return nil, lookupErr(err, "user with email "+email)
+	}
+	return user, nil
+}
+
+func (s *Storage) UpdateUser(ctx context.Context, user *storage.User) error {
+	user.UpdatedAt = time.Now().UTC()
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE users SET
+			email = ?, name = ?, password_hash = ?, updated_at = ?, last_login_at = ?,
+			auth_provider = ?, auth_provider_id = ?, subscription_tier = ?, subscription_expires_at = ?,
+			email_verified = ?, email_verified_at = ?, marketing_consent = ?, marketing_consent_at = ?
+		WHERE id = ?`,
+		user.Email, user.Name, user.PasswordHash, user.UpdatedAt, nullTime(user.LastLoginAt),
+		user.AuthProvider, user.AuthProviderID, user.SubscriptionTier, nullTime(user.SubscriptionExpiresAt),
+		user.EmailVerified, nullTime(user.EmailVerifiedAt), user.MarketingConsent, nullTime(user.MarketingConsentAt),
+		user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update user %s: %w", user.ID, err)
+	}
+	return requireRow(result, "user "+user.ID)
+}
+
+func (s *Storage) UpdateUserName(ctx context.Context, userID string, name string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE users SET name = ?, updated_at = ? WHERE id = ?`,
+		name, time.Now().UTC(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to update name of user %s: %w", userID, err)
+	}
+	return requireRow(result, "user "+userID)
+}
+
+// DeleteUser removes the user; their files, jobs, stats, tokens and consent
+// records go with them through ON DELETE CASCADE
+func (s *Storage) DeleteUser(ctx context.Context, userID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *Storage) SetEmailVerified(ctx context.Context, userID string) error {
+	t := time.Now().UTC()
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE users SET email_verified = 1, email_verified_at = ?, updated_at = ? WHERE id = ?`,
+		t, t, userID)
+	if err != nil {
+		return fmt.Errorf("failed to verify email of user %s: %w", userID, err)
+	}
+	return requireRow(result, "user "+userID)
+}
+
+func (s *Storage) StoreVerificationToken(ctx context.Context, userID, token string, expiresAt time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO email_verification_tokens (token, user_id, expires_at, created_at)
+		VALUES (?, ?, ?, ?)`,
+		token, userID, utc(expiresAt), time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to store verification token for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+// GetUserIDByVerificationToken returns storage.ErrExpired for a token past
+// its expiry
+func (s *Storage) GetUserIDByVerificationToken(ctx context.Context, token string) (string, error) {
+	var userID string
+	var expiresAt time.Time
+	err := s.db.QueryRowContext(ctx,
+		`SELECT user_id, expires_at FROM email_verification_tokens WHERE token = ?`, token).
+		Scan(&userID, &expiresAt)
+	if err != nil {
+		return "", lookupErr(err, "verification token")
+	}
+	if !time.Now().Before(expiresAt) {
+		return "", fmt.Errorf("verification token: %w", storage.ErrExpired)
+	}
+	return userID, nil
+}
+
+func (s *Storage) DeleteVerificationToken(ctx context.Context, token string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM email_verification_tokens WHERE token = ?`, token); err != nil {
+		return fmt.Errorf("failed to delete verification token: %w", err)
+	}
+	return nil
+}
+
+// SetMarketingConsent records the time consent was granted, and clears it
+// when consent is withdrawn
+func (s *Storage) SetMarketingConsent(ctx context.Context, userID string, consent bool) error {
+	t := time.Now().UTC()
+	consentAt := sql.NullTime{Time: t, Valid: consent}
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE users SET marketing_consent = ?, marketing_consent_at = ?, updated_at = ? WHERE id = ?`,
+		consent, consentAt, t, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set marketing consent of user %s: %w", userID, err)
+	}
+	return requireRow(result, "user "+userID)
+}
+
+func (s *Storage) GetMarketingConsentedUsers(ctx context.Context) ([]*storage.User, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE marketing_consent = 1 ORDER BY created_at, rowid`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query consented users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*storage.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read user: %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func scanUser(row scanner) (*storage.User, error) {
+	var user storage.User
+	var name, passwordHash, authProviderID sql.NullString
+	var lastLoginAt, expiresAt, verifiedAt, consentAt sql.NullTime
+
+	if err := row.Scan(&user.ID, &user.Email, &name, &passwordHash, &user.CreatedAt, &user.UpdatedAt,
+		&lastLoginAt, &user.AuthProvider, &authProviderID, &user.SubscriptionTier, &expiresAt,
+		&user.EmailVerified, &verifiedAt, &user.MarketingConsent, &consentAt); err != nil {
+		return nil, err
+	}
+
+	user.Name = stringPtr(name)
+	user.PasswordHash = stringPtr(passwordHash)
+	user.AuthProviderID = stringPtr(authProviderID)
+	user.LastLoginAt = timePtr(lastLoginAt)
+	user.SubscriptionExpiresAt = timePtr(expiresAt)
+	user.EmailVerifiedAt = timePtr(verifiedAt)
+	user.MarketingConsentAt = timePtr(consentAt)
+	return &user, nil
+}
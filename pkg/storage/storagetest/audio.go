@@ -0,0 +1,450 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// RunAudioStorageTests runs the suite against stores returned by newStore,
+// which is called once per subtest. Presigned URLs are fetched with
+// http.DefaultClient, so they must be reachable from the test.
+func RunAudioStorageTests(t *testing.T, newStore func(t *testing.T) storage.AudioStorage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.AudioStorage)
+	}{
+		{"RoundTrip", testAudioRoundTrip},
+		{"ProcessedKeys", testProcessedKeys},
+		{"Delete", testAudioDelete},
+		{"Rename", testRename},
+		{"Copy", testCopy},
+		{"MissingObjects", testMissingObjects},
+		{"PresignedURLs", testPresignedURLs},
+		{"PresignedURLExpiry", testPresignedURLExpiry},
+		{"MultipartUpload", testMultipartUpload},
+		{"AbortMultipartUpload", testAbortMultipartUpload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func upload(t *testing.T, s storage.AudioStorage, fileID, content string) string {
+	t.Helper()
+	if err := s.Upload(context.Background(), fileID, strings.NewReader(content), "mp3"); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	return s.GetUploadKey(fileID, "mp3")
+}
+
+func readObject(t *testing.T, s storage.AudioStorage, key string) string {
+	t.Helper()
+	r, err := s.Download(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Download %s: %v", key, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Download %s: %v", key, err)
+	}
+	return string(data)
+}
+
+func testAudioRoundTrip(t *testing.T, s storage.AudioStorage) {
+	ctx := context.Background()
+	fileID := newID("file")
+	key := upload(t, s, fileID, "original audio")
+
+	if got := readObject(t, s, key); got != "original audio" {
+		t.Errorf("Download = %q, want %q", got, "original audio")
+	}
+
+	info, err := s.GetObjectInfo(ctx, key)
+	if err != nil {
+		t.Fatalf("GetObjectInfo: %v", err)
+	}
+	if info.Size != int64(len("original audio")) {
+		t.Errorf("GetObjectInfo size = %d, want %d", info.Size, len("original audio"))
+	}
+
+	local := filepath.Join(t.TempDir(), "download.mp3")
+	if err := s.DownloadToFile(ctx, key, local); err != nil {
+		t.Fatalf("DownloadToFile: %v", err)
+	}
+	if data, err := os.ReadFile(local); err != nil || string(data) != "original audio" {
+		t.Errorf("DownloadToFile wrote %q, %v", data, err)
+	}
+
+	// Uploading again replaces the object
+	upload(t, s, fileID, "replaced")
+	if got := readObject(t, s, key); got != "replaced" {
+		t.Errorf("Download after re-upload = %q, want %q", got, "replaced")
+	}
+}
+
+func testProcessedKeys(t *testing.T, s storage.AudioStorage) {
+	ctx := context.Background()
+	fileID := newID("file")
+	uploadKey := upload(t, s, fileID, "original")
+
+	jobs := map[string]string{newID("job"): "first output", newID("job"): "second output"}
+	keys := map[string]bool{uploadKey: true, s.GetProcessedKey(fileID, "mp3"): true}
+	for jobID, content := range jobs {
+		if err := s.UploadProcessed(ctx, fileID, jobID, strings.NewReader(content), "mp3"); err != nil {
+			t.Fatalf("UploadProcessed: %v", err)
+		}
+		key := s.GetJobProcessedKey(fileID, jobID, "mp3")
+		if keys[key] {
+			t.Errorf("GetJobProcessedKey(%s) = %s, which another object already uses", jobID, key)
+		}
+		keys[key] = true
+	}
+
+	// Each job's output is kept, and the original is untouched
+	for jobID, content := range jobs {
+		if got := readObject(t, s, s.GetJobProcessedKey(fileID, jobID, "mp3")); got != content {
+			t.Errorf("output of %s = %q, want %q", jobID, got, content)
+		}
+	}
+	if got := readObject(t, s, uploadKey); got != "original" {
+		t.Errorf("original after processing = %q", got)
+	}
+}
+
+func testAudioDelete(t *testing.T, s storage.AudioStorage) {
+	ctx := context.Background()
+	key := upload(t, s, newID("file"), "audio")
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.GetObjectInfo(ctx, key); err == nil {
+		t.Error("GetObjectInfo succeeded after Delete")
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing object = %v, want nil", err)
+	}
+}
+
+func testRename(t *testing.T, s storage.AudioStorage) {
+	ctx := context.Background()
+	first := upload(t, s, newID("file"), "first copy")
+	second := upload(t, s, newID("file"), "second copy")
+	contentKey := s.GetContentKey(newID("sha256"), "mp3")
+	if contentKey == first || contentKey == s.GetUploadKey(newID("file"), "mp3") {
+		t.Errorf("GetContentKey = %s, which an upload key could use", contentKey)
+	}
+
+	if err := s.Rename(ctx, first, contentKey); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if got := readObject(t, s, contentKey); got != "first copy" {
+		t.Errorf("object after Rename = %q, want %q", got, "first copy")
+	}
+	if _, err := s.GetObjectInfo(ctx, first); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetObjectInfo of the renamed object = %v, want ErrNotFound", err)
+	}
+
+	// Renaming onto an existing object replaces it
+	if err := s.Rename(ctx, second, contentKey); err != nil {
+		t.Fatalf("Rename onto an existing object: %v", err)
+	}
+	if got := readObject(t, s, contentKey); got != "second copy" {
+		t.Errorf("object after second Rename = %q, want %q", got, "second copy")
+	}
+
+	if err := s.Rename(ctx, first, s.GetUploadKey(newID("file"), "mp3")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Rename of a missing object = %v, want ErrNotFound", err)
+	}
+}
+
+func testCopy(t *testing.T, s storage.AudioStorage) {
+	ctx := context.Background()
+	src := upload(t, s, newID("file"), "output")
+	dst := s.GetJobProcessedKey(newID("file"), newID("job"), "mp3")
+
+	if err := s.Copy(ctx, src, dst); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if got := readObject(t, s, dst); got != "output" {
+		t.Errorf("copy = %q, want %q", got, "output")
+	}
+
+	// The copy outlives its source
+	if err := s.Delete(ctx, src); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got := readObject(t, s, dst); got != "output" {
+		t.Errorf("copy after deleting the source = %q, want %q", got, "output")
+	}
+
+	if err := s.Copy(ctx, src, dst); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Copy of a missing object = %v, want ErrNotFound", err)
+	}
+}
+
+func testMissingObjects(t *testing.T, s storage.AudioStorage) {
+	ctx := context.Background()
+	key := s.GetUploadKey(newID("missing"), "mp3")
+
+	r, err := s.Download(ctx, key)
+	if err == nil {
+		r.Close()
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Download of a missing object = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetObjectInfo(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetObjectInfo of a missing object = %v, want ErrNotFound", err)
+	}
+	if err := s.DownloadToFile(ctx, key, filepath.Join(t.TempDir(), "x.mp3")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("DownloadToFile of a missing object = %v, want ErrNotFound", err)
+	}
+}
+
+func testPresignedURLs(t *testing.T, s storage.AudioStorage) {
+	ctx := context.Background()
+	fileID := newID("file")
+	key := s.GetUploadKey(fileID, "mp3")
+
+	uploadURL, err := s.GetPresignedUploadURL(ctx, key, "audio/mpeg", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("GetPresignedUploadURL: %v", err)
+	}
+	if code := httpPut(t, uploadURL, "audio/mpeg", "browser upload"); code != http.StatusOK {
+		t.Fatalf("PUT to upload URL: status %d", code)
+	}
+	if got := readObject(t, s, key); got != "browser upload" {
+		t.Errorf("object after presigned upload = %q", got)
+	}
+
+	url, err := s.GetPresignedURL(ctx, key, time.Minute, "mp3")
+	if err != nil {
+		t.Fatalf("GetPresignedURL: %v", err)
+	}
+	if code, body, _ := httpGet(t, url); code != http.StatusOK || body != "browser upload" {
+		t.Errorf("GET presigned URL = %d %q", code, body)
+	}
+
+	downloadURL, err := s.GetPresignedDownloadURL(ctx, key, "mix-normalized.mp3", "audio/mpeg", time.Minute)
+	if err != nil {
+		t.Fatalf("GetPresignedDownloadURL: %v", err)
+	}
+	code, _, header := httpGet(t, downloadURL)
+	if code != http.StatusOK {
+		t.Errorf("GET download URL: status %d", code)
+	}
+	if cd := header.Get("Content-Disposition"); !strings.Contains(cd, "mix-normalized.mp3") {
+		t.Errorf("Content-Disposition = %q, want the download filename", cd)
+	}
+}
+
+func testPresignedURLExpiry(t *testing.T, s storage.AudioStorage) {
+	ctx := context.Background()
+	key := upload(t, s, newID("file"), "audio")
+
+	url, err := s.GetPresignedURL(ctx, key, time.Second, "mp3")
+	if err != nil {
+		t.Fatalf("GetPresignedURL: %v", err)
+	}
+	uploadURL, err := s.GetPresignedUploadURL(ctx, key, "audio/mpeg", 0, time.Second)
+	if err != nil {
+		t.Fatalf("GetPresignedUploadURL: %v", err)
+	}
+
+	time.Sleep(2100 * time.Millisecond)
+
+	if code, _, _ := httpGet(t, url); code < 400 {
+		t.Errorf("GET expired URL: status %d, want an error status", code)
+	}
+	if code := httpPut(t, uploadURL, "audio/mpeg", "late upload"); code < 400 {
+		t.Errorf("PUT expired upload URL: status %d, want an error status", code)
+	}
+	if got := readObject(t, s, key); got != "audio" {
+		t.Errorf("expired upload URL changed the object to %q", got)
+	}
+}
+
+func testMultipartUpload(t *testing.T, s storage.AudioStorage) {
+	ctx := context.Background()
+	key := s.GetUploadKey(newID("file"), "mp3")
+
+	uploadID, err := s.CreateMultipartUpload(ctx, key, "audio/mpeg")
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload: %v", err)
+	}
+	if parts, err := s.ListParts(ctx, key, uploadID); err != nil || len(parts) != 0 {
+		t.Errorf("ListParts of a new upload = %v, %v; want none", parts, err)
+	}
+
+	first := strings.Repeat("a", storage.MinPartSize)
+	putPart(t, s, key, uploadID, 1, first)
+	putPart(t, s, key, uploadID, 2, "interrupted")
+	// Uploading a part again, as a resumed upload does, replaces it, whether
+	// through a presigned URL or the server
+	part, err := s.UploadPart(ctx, key, uploadID, 2, strings.NewReader("last part"), int64(len("last part")))
+	if err != nil {
+		t.Fatalf("UploadPart: %v", err)
+	}
+	if part.PartNumber != 2 || part.Size != int64(len("last part")) || part.ETag == "" {
+		t.Errorf("UploadPart = %+v, want part 2 with its size and ETag", part)
+	}
+	if _, err := s.UploadPart(ctx, key, uploadID, 3, strings.NewReader("short"), 10); err == nil {
+		t.Error("UploadPart accepted a part shorter than its declared size")
+	}
+
+	if _, err := s.GetObjectInfo(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetObjectInfo before completing = %v, want ErrNotFound", err)
+	}
+	if !hasUpload(t, s, uploadID) {
+		t.Errorf("ListMultipartUploads doesn't include %s", uploadID)
+	}
+
+	parts, err := s.ListParts(ctx, key, uploadID)
+	if err != nil {
+		t.Fatalf("ListParts: %v", err)
+	}
+	if len(parts) != 2 || parts[0].PartNumber != 1 || parts[1].PartNumber != 2 ||
+		parts[0].Size != int64(len(first)) || parts[1].Size != int64(len("last part")) {
+		t.Fatalf("ListParts = %+v, want parts 1 and 2 with their sizes", parts)
+	}
+	if parts[1].ETag != part.ETag {
+		t.Errorf("ListParts ETag of part 2 = %s, UploadPart returned %s", parts[1].ETag, part.ETag)
+	}
+
+	if err := s.CompleteMultipartUpload(ctx, key, uploadID, parts); err != nil {
+		t.Fatalf("CompleteMultipartUpload: %v", err)
+	}
+	if got := readObject(t, s, key); got != first+"last part" {
+		t.Errorf("completed object is %d bytes, want the %d bytes of both parts", len(got), len(first+"last part"))
+	}
+	if _, err := s.ListParts(ctx, key, uploadID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("ListParts of a completed upload = %v, want ErrNotFound", err)
+	}
+	if hasUpload(t, s, uploadID) {
+		t.Errorf("ListMultipartUploads still includes completed upload %s", uploadID)
+	}
+
+	// Only the last part may be smaller than MinPartSize
+	small, err := s.CreateMultipartUpload(ctx, key, "audio/mpeg")
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload: %v", err)
+	}
+	putPart(t, s, key, small, 1, "too small")
+	putPart(t, s, key, small, 2, "last part")
+	if parts, err := s.ListParts(ctx, key, small); err != nil {
+		t.Fatalf("ListParts: %v", err)
+	} else if err := s.CompleteMultipartUpload(ctx, key, small, parts); err == nil {
+		t.Error("CompleteMultipartUpload accepted a part smaller than MinPartSize")
+	}
+	s.AbortMultipartUpload(ctx, key, small)
+}
+
+func testAbortMultipartUpload(t *testing.T, s storage.AudioStorage) {
+	ctx := context.Background()
+	key := s.GetUploadKey(newID("file"), "mp3")
+
+	uploadID, err := s.CreateMultipartUpload(ctx, key, "audio/mpeg")
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload: %v", err)
+	}
+	putPart(t, s, key, uploadID, 1, "part")
+	partURL, err := s.GetPresignedPartURL(ctx, key, uploadID, 2, 0, time.Minute)
+	if err != nil {
+		t.Fatalf("GetPresignedPartURL: %v", err)
+	}
+
+	// Uploads newer than the cutoff are left alone
+	if _, err := storage.AbortStaleMultipartUploads(ctx, s, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("AbortStaleMultipartUploads: %v", err)
+	}
+	if !hasUpload(t, s, uploadID) {
+		t.Fatal("AbortStaleMultipartUploads aborted an upload newer than the cutoff")
+	}
+
+	if _, err := storage.AbortStaleMultipartUploads(ctx, s, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("AbortStaleMultipartUploads: %v", err)
+	}
+	if hasUpload(t, s, uploadID) {
+		t.Error("AbortStaleMultipartUploads left an upload older than the cutoff")
+	}
+	if _, err := s.ListParts(ctx, key, uploadID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("ListParts of an aborted upload = %v, want ErrNotFound", err)
+	}
+	if err := s.CompleteMultipartUpload(ctx, key, uploadID, []storage.UploadPart{{PartNumber: 1}}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("CompleteMultipartUpload of an aborted upload = %v, want ErrNotFound", err)
+	}
+	if err := s.AbortMultipartUpload(ctx, key, uploadID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("AbortMultipartUpload of an aborted upload = %v, want ErrNotFound", err)
+	}
+	if code := httpPut(t, partURL, "application/octet-stream", "late part"); code < 400 {
+		t.Errorf("PUT part of an aborted upload: status %d, want an error status", code)
+	}
+	if _, err := s.GetObjectInfo(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetObjectInfo after abort = %v, want ErrNotFound", err)
+	}
+}
+
+func putPart(t *testing.T, s storage.AudioStorage, key, uploadID string, partNumber int, content string) {
+	t.Helper()
+	url, err := s.GetPresignedPartURL(context.Background(), key, uploadID, partNumber, 0, time.Minute)
+	if err != nil {
+		t.Fatalf("GetPresignedPartURL: %v", err)
+	}
+	if code := httpPut(t, url, "application/octet-stream", content); code != http.StatusOK {
+		t.Fatalf("PUT part %d: status %d", partNumber, code)
+	}
+}
+
+func hasUpload(t *testing.T, s storage.AudioStorage, uploadID string) bool {
+	t.Helper()
+	uploads, err := s.ListMultipartUploads(context.Background())
+	if err != nil {
+		t.Fatalf("ListMultipartUploads: %v", err)
+	}
+	for _, upload := range uploads {
+		if upload.UploadID == uploadID {
+			return true
+		}
+	}
+	return false
+}
+
+func httpGet(t *testing.T, url string) (int, string, http.Header) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), resp.Header
+}
+
+func httpPut(t *testing.T, url, contentType, body string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("PUT %s: %v", url, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT %s: %v", url, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
@@ -0,0 +1,976 @@
+// Package storagetest is a conformance suite for storage backends. Each
+// implementation runs it from its own tests so every backend behaves the
+// same way.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/simonlewi/levelmix/pkg/storage"
+)
+
+// RunMetadataStorageTests runs the suite against stores returned by
+// newStore, which is called once per subtest and must return an empty store
+func RunMetadataStorageTests(t *testing.T, newStore func(t *testing.T) storage.MetadataStorage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.MetadataStorage)
+	}{
+		{"Users", testUsers},
+		{"DeleteUserCascades", testDeleteUserCascades},
+		{"AudioFiles", testAudioFiles},
+		{"AudioFilesByHash", testAudioFilesByHash},
+		{"ContentLocks", testContentLocks},
+		{"Jobs", testJobs},
+		{"UserJobsPagination", testUserJobsPagination},
+		{"CompareAndSwapJob", testCompareAndSwapJob},
+		{"ConcurrentTransitions", testConcurrentTransitions},
+		{"NotFound", testNotFound},
+		{"VerificationTokens", testVerificationTokens},
+		{"MarketingConsent", testMarketingConsent},
+		{"UserStats", testUserStats},
+		{"Usage", testUsage},
+		{"ConcurrentUsage", testConcurrentUsage},
+		{"UsageReservations", testUsageReservations},
+		{"ConcurrentReservations", testConcurrentReservations},
+		{"CookieConsent", testCookieConsent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+var idCounter atomic.Int64
+
+// newID returns an ID unique within the test binary
+func newID(prefix string) string {
+	return fmt.Sprintf("%s-%d", prefix, idCounter.Add(1))
+}
+
+func createUser(t *testing.T, s storage.MetadataStorage) *storage.User {
+	t.Helper()
+	id := newID("user")
+	user := &storage.User{
+		ID:               id,
+		Email:            id + "@example.com",
+		AuthProvider:     "email",
+		SubscriptionTier: 1,
+	}
+	if err := s.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+func createAudioFile(t *testing.T, s storage.MetadataStorage, userID string) *storage.AudioFile {
+	t.Helper()
+	file := &storage.AudioFile{
+		ID:               newID("file"),
+		UserID:           &userID,
+		OriginalFilename: "mix.wav",
+		FileSize:         1024,
+		Format:           "wav",
+		Status:           storage.StatusUploaded,
+		LUFSTarget:       -14,
+	}
+	if err := s.CreateAudioFile(context.Background(), file); err != nil {
+		t.Fatalf("CreateAudioFile: %v", err)
+	}
+	return file
+}
+
+func createJob(t *testing.T, s storage.MetadataStorage, file *storage.AudioFile, createdAt time.Time) *storage.ProcessingJob {
+	t.Helper()
+	job := &storage.ProcessingJob{
+		ID:          newID("job"),
+		AudioFileID: file.ID,
+		UserID:      *file.UserID,
+		Status:      storage.JobQueued,
+		CreatedAt:   createdAt,
+	}
+	if err := s.CreateJob(context.Background(), job); err != nil {
+		t.Fatalf("CreateJob: %v", err)
+	}
+	return job
+}
+
+func testUsers(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+
+	got, err := s.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.Email != user.Email || got.SubscriptionTier != 1 || got.Name != nil {
+		t.Errorf("GetUser = %+v, want %+v", got, user)
+	}
+
+	byEmail, err := s.GetUserByEmail(ctx, user.ID+"@EXAMPLE.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail with different case: %v", err)
+	}
+	if byEmail.ID != user.ID {
+		t.Errorf("GetUserByEmail returned %s, want %s", byEmail.ID, user.ID)
+	}
+
+	if err := s.CreateUser(ctx, &storage.User{ID: newID("user"), Email: user.Email}); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("CreateUser with a duplicate email = %v, want ErrConflict", err)
+	}
+
+	if err := s.UpdateUserName(ctx, user.ID, "Ada Lovelace"); err != nil {
+		t.Fatalf("UpdateUserName: %v", err)
+	}
+	got.SubscriptionTier = 2
+	expires := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	got.SubscriptionExpiresAt = &expires
+	got.Name = nil
+	if err := s.UpdateUser(ctx, got); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	got, err = s.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUser after update: %v", err)
+	}
+	if got.SubscriptionTier != 2 || got.SubscriptionExpiresAt == nil || !got.SubscriptionExpiresAt.Equal(expires) {
+		t.Errorf("UpdateUser not persisted: tier %d, expires %v", got.SubscriptionTier, got.SubscriptionExpiresAt)
+	}
+	if got.Name != nil {
+		t.Errorf("UpdateUser did not clear name, got %q", *got.Name)
+	}
+
+	if _, err := s.GetUser(ctx, "missing"); err == nil {
+		t.Error("GetUser of a missing user succeeded")
+	}
+	if _, err := s.GetUserByEmail(ctx, "missing@example.com"); err == nil {
+		t.Error("GetUserByEmail of a missing email succeeded")
+	}
+	if err := s.UpdateUserName(ctx, "missing", "x"); err == nil {
+		t.Error("UpdateUserName of a missing user succeeded")
+	}
+}
+
+func testDeleteUserCascades(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+	other := createUser(t, s)
+	file := createAudioFile(t, s, user.ID)
+	job := createJob(t, s, file, time.Time{})
+	if err := storage.TransitionJob(ctx, s, job, storage.JobProcessing, ""); err != nil {
+		t.Fatalf("TransitionJob: %v", err)
+	}
+	token := newID("token")
+
+	if err := s.CreateUserStats(ctx, &storage.UserUploadStats{UserID: user.ID}); err != nil {
+		t.Fatalf("CreateUserStats: %v", err)
+	}
+	if err := s.RecordUsage(ctx, &storage.UsageEntry{UserID: user.ID, JobID: job.ID, Seconds: 60, Tier: 1}); err != nil {
+		t.Fatalf("RecordUsage: %v", err)
+	}
+	if err := s.StoreVerificationToken(ctx, user.ID, token, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("StoreVerificationToken: %v", err)
+	}
+	if err := s.StoreCookieConsent(ctx, storage.CookieConsentRecord{
+		ID: newID("consent"), UserID: &user.ID, Essential: true, ConsentVersion: "1",
+	}); err != nil {
+		t.Fatalf("StoreCookieConsent: %v", err)
+	}
+
+	if err := s.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	if _, err := s.GetUser(ctx, user.ID); err == nil {
+		t.Error("user still exists after DeleteUser")
+	}
+	if _, err := s.GetAudioFile(ctx, file.ID); err == nil {
+		t.Error("audio file still exists after DeleteUser")
+	}
+	if _, err := s.GetJob(ctx, job.ID); err == nil {
+		t.Error("job still exists after DeleteUser")
+	}
+	if events, err := s.GetJobEvents(ctx, job.ID); err != nil || len(events) != 0 {
+		t.Errorf("job events after DeleteUser = %d events, %v", len(events), err)
+	}
+	if _, err := s.GetUserStats(ctx, user.ID); err == nil {
+		t.Error("stats still exist after DeleteUser")
+	}
+	if usage, err := s.GetUsage(ctx, user.ID, ""); err != nil || usage.Seconds != 0 {
+		t.Errorf("usage after DeleteUser = %+v, %v", usage, err)
+	}
+	if _, err := s.GetUserIDByVerificationToken(ctx, token); err == nil {
+		t.Error("verification token still valid after DeleteUser")
+	}
+	if history, err := s.GetUserConsentHistory(ctx, user.ID); err != nil || len(history) != 0 {
+		t.Errorf("consent history after DeleteUser = %d records, %v", len(history), err)
+	}
+	if _, err := s.GetUser(ctx, other.ID); err != nil {
+		t.Errorf("DeleteUser removed another user: %v", err)
+	}
+}
+
+func testAudioFiles(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+	file := createAudioFile(t, s, user.ID)
+
+	got, err := s.GetAudioFile(ctx, file.ID)
+	if err != nil {
+		t.Fatalf("GetAudioFile: %v", err)
+	}
+	if got.OriginalFilename != file.OriginalFilename || got.FileSize != file.FileSize ||
+		got.UserID == nil || *got.UserID != user.ID || got.DurationSeconds != nil {
+		t.Errorf("GetAudioFile = %+v, want %+v", got, file)
+	}
+
+	if err := s.UpdateStatus(ctx, file.ID, storage.StatusQueued); err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+	if err := s.UpdateAudioFileDuration(ctx, file.ID, 245); err != nil {
+		t.Fatalf("UpdateAudioFileDuration: %v", err)
+	}
+	got, err = s.GetAudioFile(ctx, file.ID)
+	if err != nil {
+		t.Fatalf("GetAudioFile after update: %v", err)
+	}
+	if got.Status != storage.StatusQueued || got.DurationSeconds == nil || *got.DurationSeconds != 245 {
+		t.Errorf("updates not persisted: status %s, duration %v", got.Status, got.DurationSeconds)
+	}
+
+	if err := s.DeleteAudioFile(ctx, file.ID); err != nil {
+		t.Fatalf("DeleteAudioFile: %v", err)
+	}
+	if _, err := s.GetAudioFile(ctx, file.ID); err == nil {
+		t.Error("GetAudioFile after delete succeeded")
+	}
+	if err := s.UpdateStatus(ctx, file.ID, storage.StatusFailed); err == nil {
+		t.Error("UpdateStatus of a missing file succeeded")
+	}
+}
+
+func testAudioFilesByHash(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user, other := createUser(t, s), createUser(t, s)
+	hash := newID("sha256")
+
+	withHash := func(userID string, createdAt time.Time) *storage.AudioFile {
+		t.Helper()
+		file := &storage.AudioFile{
+			ID: newID("file"), UserID: &userID, OriginalFilename: "mix.wav", Format: "wav",
+			Status: storage.StatusUploaded, ContentHash: hash, CreatedAt: createdAt,
+		}
+		if err := s.CreateAudioFile(ctx, file); err != nil {
+			t.Fatalf("CreateAudioFile: %v", err)
+		}
+		return file
+	}
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	older := withHash(user.ID, base)
+	newer := withHash(other.ID, base.Add(time.Minute))
+	createAudioFile(t, s, user.ID) // Not hashed
+
+	files, err := s.GetAudioFilesByHash(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetAudioFilesByHash: %v", err)
+	}
+	if len(files) != 2 || files[0].ID != newer.ID || files[1].ID != older.ID {
+		t.Fatalf("GetAudioFilesByHash returned %d files, want %s then %s", len(files), newer.ID, older.ID)
+	}
+	if files[0].ContentHash != hash {
+		t.Errorf("ContentHash = %q, want %q", files[0].ContentHash, hash)
+	}
+
+	if files, err := s.GetAudioFilesByHash(ctx, newID("sha256")); err != nil || len(files) != 0 {
+		t.Errorf("GetAudioFilesByHash of unknown content = %d files, %v; want none", len(files), err)
+	}
+	unhashed := createAudioFile(t, s, user.ID)
+	if got, err := s.GetAudioFile(ctx, unhashed.ID); err != nil || got.ContentHash != "" {
+		t.Errorf("unhashed file has ContentHash %q, %v", got.ContentHash, err)
+	}
+
+	// Hashed after it was created
+	if err := s.UpdateAudioFileHash(ctx, unhashed.ID, hash); err != nil {
+		t.Fatalf("UpdateAudioFileHash: %v", err)
+	}
+	if files, err := s.GetAudioFilesByHash(ctx, hash); err != nil || len(files) != 3 || files[0].ID != unhashed.ID {
+		t.Errorf("GetAudioFilesByHash after UpdateAudioFileHash = %d files, %v; want %s first of 3", len(files), err, unhashed.ID)
+	}
+	if err := s.UpdateAudioFileHash(ctx, newID("file"), hash); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("UpdateAudioFileHash of an unknown file = %v, want ErrNotFound", err)
+	}
+}
+
+func testContentLocks(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	hash := newID("sha256")
+
+	if err := s.LockContent(ctx, hash, "first", time.Hour); err != nil {
+		t.Fatalf("LockContent: %v", err)
+	}
+	if err := s.LockContent(ctx, hash, "second", time.Hour); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("LockContent of a held lock = %v, want ErrConflict", err)
+	}
+	if err := s.LockContent(ctx, newID("sha256"), "second", time.Hour); err != nil {
+		t.Errorf("LockContent of other content = %v, want nil", err)
+	}
+
+	// Only the holder releases it
+	if err := s.UnlockContent(ctx, hash, "second"); err != nil {
+		t.Fatalf("UnlockContent: %v", err)
+	}
+	if err := s.LockContent(ctx, hash, "second", time.Hour); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("LockContent after another token's unlock = %v, want ErrConflict", err)
+	}
+	if err := s.UnlockContent(ctx, hash, "first"); err != nil {
+		t.Fatalf("UnlockContent: %v", err)
+	}
+	if err := s.LockContent(ctx, hash, "second", -time.Second); err != nil {
+		t.Fatalf("LockContent after unlock = %v, want nil", err)
+	}
+
+	// An expired lock is taken over
+	if err := s.LockContent(ctx, hash, "third", time.Hour); err != nil {
+		t.Errorf("LockContent of an expired lock = %v, want nil", err)
+	}
+}
+
+func testJobs(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+	file := createAudioFile(t, s, user.ID)
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	first := createJob(t, s, file, base)
+	second := createJob(t, s, file, base.Add(time.Minute))
+
+	lufs := -9.5
+	second.TargetLUFS = &lufs
+	second.OutputFormat = "mp3"
+	second.ParentJobID = &first.ID
+	second.Spec = &storage.JobSpec{TargetLUFS: lufs, Preset: "club", ProcessingMode: "precise", SampleRate: 48000}
+	if err := s.UpdateJob(ctx, second); err != nil {
+		t.Fatalf("UpdateJob: %v", err)
+	}
+
+	got, err := s.GetJob(ctx, second.ID)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if got.TargetLUFS == nil || *got.TargetLUFS != lufs || got.OutputFormat != "mp3" ||
+		got.ParentJobID == nil || *got.ParentJobID != first.ID {
+		t.Errorf("GetJob = %+v, want %+v", got, second)
+	}
+	if got.Spec == nil || *got.Spec != *second.Spec {
+		t.Errorf("job spec = %+v, want %+v", got.Spec, second.Spec)
+	}
+	if !got.CreatedAt.Equal(second.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v", got.CreatedAt, second.CreatedAt)
+	}
+
+	latest, err := s.GetJobByFileID(ctx, file.ID)
+	if err != nil {
+		t.Fatalf("GetJobByFileID: %v", err)
+	}
+	if latest.ID != second.ID {
+		t.Errorf("GetJobByFileID = %s, want newest job %s", latest.ID, second.ID)
+	}
+
+	jobs, err := s.GetJobsByFileID(ctx, file.ID)
+	if err != nil {
+		t.Fatalf("GetJobsByFileID: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != second.ID || jobs[1].ID != first.ID {
+		t.Errorf("GetJobsByFileID returned %d jobs, want [%s %s] newest first", len(jobs), second.ID, first.ID)
+	}
+
+	page, err := s.GetUserJobs(ctx, user.ID, 1, 1)
+	if err != nil {
+		t.Fatalf("GetUserJobs: %v", err)
+	}
+	if len(page) != 1 || page[0].ID != first.ID {
+		t.Errorf("GetUserJobs(limit 1, offset 1) = %d jobs, want [%s]", len(page), first.ID)
+	}
+
+	msg := "decoder error"
+	if err := s.UpdateJobStatus(ctx, first.ID, storage.StatusFailed, &msg); err != nil {
+		t.Fatalf("UpdateJobStatus: %v", err)
+	}
+	got, err = s.GetJob(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("GetJob after UpdateJobStatus: %v", err)
+	}
+	if got.Status != storage.JobFailed || got.ErrorMessage == nil || *got.ErrorMessage != msg {
+		t.Errorf("UpdateJobStatus not persisted: %s %v", got.Status, got.ErrorMessage)
+	}
+
+	if _, err := s.GetJob(ctx, "missing"); err == nil {
+		t.Error("GetJob of a missing job succeeded")
+	}
+	if _, err := s.GetJobByFileID(ctx, "missing"); err == nil {
+		t.Error("GetJobByFileID of a file without jobs succeeded")
+	}
+}
+
+func testUserJobsPagination(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+	other := createUser(t, s)
+	file := createAudioFile(t, s, user.ID)
+	createJob(t, s, createAudioFile(t, s, other.ID), time.Now())
+
+	// Created out of order so insertion order can't pass for sorting
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	ids := make([]string, 5)
+	for _, i := range []int{2, 0, 4, 1, 3} {
+		ids[i] = createJob(t, s, file, base.Add(time.Duration(i)*time.Minute)).ID
+	}
+	newestFirst := []string{ids[4], ids[3], ids[2], ids[1], ids[0]}
+
+	var got []string
+	for offset := 0; ; offset += 2 {
+		page, err := s.GetUserJobs(ctx, user.ID, 2, offset)
+		if err != nil {
+			t.Fatalf("GetUserJobs(offset %d): %v", offset, err)
+		}
+		if len(page) > 2 {
+			t.Fatalf("GetUserJobs(limit 2) returned %d jobs", len(page))
+		}
+		for _, job := range page {
+			if job.UserID != user.ID {
+				t.Errorf("GetUserJobs returned job %s of user %s", job.ID, job.UserID)
+			}
+			got = append(got, job.ID)
+		}
+		if len(page) < 2 {
+			break
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(newestFirst) {
+		t.Errorf("GetUserJobs pages = %v, want %v newest first", got, newestFirst)
+	}
+
+	page, err := s.GetUserJobs(ctx, user.ID, 10, 10)
+	if err != nil || len(page) != 0 {
+		t.Errorf("GetUserJobs past the end = %d jobs, %v; want none", len(page), err)
+	}
+	page, err = s.GetUserJobs(ctx, newID("user"), 10, 0)
+	if err != nil || len(page) != 0 {
+		t.Errorf("GetUserJobs of a user without jobs = %d jobs, %v; want none", len(page), err)
+	}
+}
+
+func testCompareAndSwapJob(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+	file := createAudioFile(t, s, user.ID)
+	job := createJob(t, s, file, time.Time{})
+
+	if err := storage.TransitionJob(ctx, s, job, storage.JobProcessing, "worker started"); err != nil {
+		t.Fatalf("TransitionJob to processing: %v", err)
+	}
+
+	// A writer still holding the queued copy loses the race
+	stale := *job
+	stale.Status = storage.JobCancelled
+	err := s.CompareAndSwapJob(ctx, &stale, storage.JobQueued, "cancelled by user")
+	if !errors.Is(err, storage.ErrStatusConflict) || !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("CompareAndSwapJob with stale status = %v, want ErrStatusConflict", err)
+	}
+
+	if err := storage.TransitionJob(ctx, s, job, storage.JobCompleted, "done"); err != nil {
+		t.Fatalf("TransitionJob to completed: %v", err)
+	}
+
+	events, err := s.GetJobEvents(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("GetJobEvents: %v", err)
+	}
+	want := []storage.JobStatus{storage.JobQueued, storage.JobProcessing, storage.JobCompleted}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, e := range events {
+		if e.ToStatus != want[i] {
+			t.Errorf("event %d moved to %s, want %s", i, e.ToStatus, want[i])
+		}
+	}
+	if events[0].FromStatus != "" || events[1].FromStatus != storage.JobQueued || events[1].Reason != "worker started" {
+		t.Errorf("unexpected events: %+v %+v", events[0], events[1])
+	}
+
+	missing := &storage.ProcessingJob{ID: "missing", Status: storage.JobProcessing}
+	err = s.CompareAndSwapJob(ctx, missing, storage.JobQueued, "")
+	if !notFound(err) {
+		t.Errorf("CompareAndSwapJob of a missing job = %v, want a not-found error", err)
+	}
+}
+
+func testConcurrentTransitions(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+	file := createAudioFile(t, s, user.ID)
+	job := createJob(t, s, file, time.Time{})
+
+	// Several workers race to claim the same queued job; exactly one may win
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make([]error, workers)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claim := *job
+			errs[i] = storage.TransitionJob(ctx, s, &claim, storage.JobProcessing, fmt.Sprintf("worker %d", i))
+		}()
+	}
+	wg.Wait()
+
+	won := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, storage.ErrStatusConflict):
+			t.Errorf("losing TransitionJob = %v, want ErrStatusConflict", err)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d workers claimed the job, want 1", won)
+	}
+
+	events, err := s.GetJobEvents(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("GetJobEvents: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("got %d events, want queued and one processing", len(events))
+	}
+
+	// Independent writers don't block each other out
+	errs = make([]error, workers)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.CreateJob(ctx, &storage.ProcessingJob{
+				ID: newID("job"), AudioFileID: file.ID, UserID: user.ID, Status: storage.JobQueued,
+			})
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("concurrent CreateJob: %v", err)
+		}
+	}
+	if jobs, err := s.GetJobsByFileID(ctx, file.ID); err != nil || len(jobs) != workers+1 {
+		t.Errorf("GetJobsByFileID = %d jobs, %v; want %d", len(jobs), err, workers+1)
+	}
+}
+
+func notFound(err error) bool {
+	return errors.Is(err, storage.ErrNotFound)
+}
+
+func testNotFound(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	missing := newID("missing")
+
+	lookups := map[string]error{}
+	_, lookups["GetUser"] = s.GetUser(ctx, missing)
+	_, lookups["GetUserByEmail"] = s.GetUserByEmail(ctx, missing+"@example.com")
+	_, lookups["GetAudioFile"] = s.GetAudioFile(ctx, missing)
+	_, lookups["GetJob"] = s.GetJob(ctx, missing)
+	_, lookups["GetJobByFileID"] = s.GetJobByFileID(ctx, missing)
+	_, lookups["GetUserStats"] = s.GetUserStats(ctx, missing)
+	_, lookups["GetUserIDByVerificationToken"] = s.GetUserIDByVerificationToken(ctx, missing)
+	lookups["UpdateUser"] = s.UpdateUser(ctx, &storage.User{ID: missing})
+	lookups["UpdateUserName"] = s.UpdateUserName(ctx, missing, "x")
+	lookups["UpdateStatus"] = s.UpdateStatus(ctx, missing, storage.StatusFailed)
+	lookups["UpdateJob"] = s.UpdateJob(ctx, &storage.ProcessingJob{ID: missing})
+	lookups["UpdateJobStatus"] = s.UpdateJobStatus(ctx, missing, storage.StatusFailed, nil)
+	for name, err := range lookups {
+		if !notFound(err) {
+			t.Errorf("%s of a missing record = %v, want a not-found error", name, err)
+		}
+	}
+
+	// Lists of nothing are empty, not errors
+	if jobs, err := s.GetJobsByFileID(ctx, missing); err != nil || len(jobs) != 0 {
+		t.Errorf("GetJobsByFileID of a missing file = %d jobs, %v", len(jobs), err)
+	}
+	if events, err := s.GetJobEvents(ctx, missing); err != nil || len(events) != 0 {
+		t.Errorf("GetJobEvents of a missing job = %d events, %v", len(events), err)
+	}
+	if history, err := s.GetUserConsentHistory(ctx, missing); err != nil || len(history) != 0 {
+		t.Errorf("GetUserConsentHistory of a missing user = %d records, %v", len(history), err)
+	}
+}
+
+func testVerificationTokens(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+
+	token := newID("token")
+	if err := s.StoreVerificationToken(ctx, user.ID, token, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("StoreVerificationToken: %v", err)
+	}
+	userID, err := s.GetUserIDByVerificationToken(ctx, token)
+	if err != nil || userID != user.ID {
+		t.Fatalf("GetUserIDByVerificationToken = %q, %v; want %q", userID, err, user.ID)
+	}
+
+	expired := newID("token")
+	if err := s.StoreVerificationToken(ctx, user.ID, expired, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("StoreVerificationToken: %v", err)
+	}
+	if _, err := s.GetUserIDByVerificationToken(ctx, expired); !errors.Is(err, storage.ErrExpired) {
+		t.Errorf("GetUserIDByVerificationToken of an expired token = %v, want ErrExpired", err)
+	}
+
+	if err := s.SetEmailVerified(ctx, user.ID); err != nil {
+		t.Fatalf("SetEmailVerified: %v", err)
+	}
+	got, err := s.GetUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if !got.EmailVerified || got.EmailVerifiedAt == nil {
+		t.Errorf("SetEmailVerified not persisted: %v %v", got.EmailVerified, got.EmailVerifiedAt)
+	}
+
+	if err := s.DeleteVerificationToken(ctx, token); err != nil {
+		t.Fatalf("DeleteVerificationToken: %v", err)
+	}
+	if _, err := s.GetUserIDByVerificationToken(ctx, token); err == nil {
+		t.Error("GetUserIDByVerificationToken succeeded after delete")
+	}
+}
+
+func testMarketingConsent(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	consenting := createUser(t, s)
+	createUser(t, s)
+
+	if err := s.SetMarketingConsent(ctx, consenting.ID, true); err != nil {
+		t.Fatalf("SetMarketingConsent: %v", err)
+	}
+	users, err := s.GetMarketingConsentedUsers(ctx)
+	if err != nil {
+		t.Fatalf("GetMarketingConsentedUsers: %v", err)
+	}
+	if len(users) != 1 || users[0].ID != consenting.ID || users[0].MarketingConsentAt == nil {
+		t.Errorf("GetMarketingConsentedUsers = %d users, want only %s with a consent time", len(users), consenting.ID)
+	}
+
+	if err := s.SetMarketingConsent(ctx, consenting.ID, false); err != nil {
+		t.Fatalf("SetMarketingConsent(false): %v", err)
+	}
+	got, err := s.GetUser(ctx, consenting.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.MarketingConsent || got.MarketingConsentAt != nil {
+		t.Errorf("withdrawn consent still recorded: %v %v", got.MarketingConsent, got.MarketingConsentAt)
+	}
+}
+
+func testUserStats(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+
+	stats := &storage.UserUploadStats{UserID: user.ID}
+	if err := s.CreateUserStats(ctx, stats); err != nil {
+		t.Fatalf("CreateUserStats: %v", err)
+	}
+
+	uploaded := time.Now().Truncate(time.Second)
+	stats.TotalUploads = 3
+	stats.LastUploadAt = &uploaded
+	if err := s.UpdateUserStats(ctx, stats); err != nil {
+		t.Fatalf("UpdateUserStats: %v", err)
+	}
+
+	got, err := s.GetUserStats(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserStats: %v", err)
+	}
+	if got.TotalUploads != 3 || got.LastUploadAt == nil || !got.LastUploadAt.Equal(uploaded) {
+		t.Errorf("GetUserStats = %+v, want %+v", got, stats)
+	}
+
+	if _, err := s.GetUserStats(ctx, "missing"); err == nil {
+		t.Error("GetUserStats of a user without stats succeeded")
+	}
+}
+
+func testUsage(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+	other := createUser(t, s)
+	file := createAudioFile(t, s, user.ID)
+
+	october := time.Date(2026, 10, 31, 23, 0, 0, 0, time.UTC)
+	november := october.Add(2 * time.Hour)
+	record := func(userID string, at time.Time, seconds int) *storage.UsageEntry {
+		t.Helper()
+		entry := &storage.UsageEntry{
+			UserID: userID, JobID: newID("job"), Seconds: seconds, AudioSeconds: seconds * 2, Tier: 1, CreatedAt: at,
+		}
+		if err := s.RecordUsage(ctx, entry); err != nil {
+			t.Fatalf("RecordUsage: %v", err)
+		}
+		return entry
+	}
+	first := record(user.ID, october, 600)
+	record(user.ID, october, 300)
+	record(user.ID, november, 120)
+	record(other.ID, october, 999)
+
+	if first.Period != "2026-10" || first.ID == 0 {
+		t.Errorf("RecordUsage set period %q, ID %d; want 2026-10 and an ID", first.Period, first.ID)
+	}
+
+	usage, err := s.GetUsage(ctx, user.ID, "2026-10")
+	if err != nil {
+		t.Fatalf("GetUsage: %v", err)
+	}
+	if usage.Seconds != 900 || usage.AudioSeconds != 1800 || usage.Jobs != 2 {
+		t.Errorf("GetUsage(2026-10) = %+v, want 900s over 2 jobs", usage)
+	}
+	if usage, err := s.GetUsage(ctx, user.ID, "2026-11"); err != nil || usage.Seconds != 120 {
+		t.Errorf("GetUsage(2026-11) = %+v, %v; want 120s", usage, err)
+	}
+	if usage, err := s.GetUsage(ctx, user.ID, ""); err != nil || usage.Seconds != 1020 || usage.Jobs != 3 {
+		t.Errorf("GetUsage(all time) = %+v, %v; want 1020s over 3 jobs", usage, err)
+	}
+	if usage, err := s.GetUsage(ctx, user.ID, "2026-09"); err != nil || usage.Seconds != 0 || usage.Jobs != 0 {
+		t.Errorf("GetUsage of a period without usage = %+v, %v; want zero", usage, err)
+	}
+
+	// A job is charged once, however often completion is reported
+	again := &storage.UsageEntry{UserID: user.ID, JobID: first.JobID, Seconds: 600, Tier: 1}
+	if err := s.RecordUsage(ctx, again); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("RecordUsage of a recorded job = %v, want ErrConflict", err)
+	}
+
+	// Deleting the processed file doesn't refund its usage
+	job := createJob(t, s, file, time.Time{})
+	if err := s.RecordUsage(ctx, &storage.UsageEntry{UserID: user.ID, JobID: job.ID, Seconds: 60, Tier: 1}); err != nil {
+		t.Fatalf("RecordUsage: %v", err)
+	}
+	if err := s.DeleteAudioFile(ctx, file.ID); err != nil {
+		t.Fatalf("DeleteAudioFile: %v", err)
+	}
+	if usage, err := s.GetUsage(ctx, user.ID, ""); err != nil || usage.Seconds != 1080 {
+		t.Errorf("GetUsage after DeleteAudioFile = %+v, %v; want 1080s", usage, err)
+	}
+}
+
+func testConcurrentUsage(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+
+	const jobs = 20
+	var wg sync.WaitGroup
+	errs := make([]error, jobs)
+	for i := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.RecordUsage(ctx, &storage.UsageEntry{UserID: user.ID, JobID: newID("job"), Seconds: 30, Tier: 1})
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("concurrent RecordUsage: %v", err)
+		}
+	}
+
+	usage, err := s.GetUsage(ctx, user.ID, storage.UsagePeriod(time.Now()))
+	if err != nil {
+		t.Fatalf("GetUsage: %v", err)
+	}
+	if usage.Seconds != jobs*30 || usage.Jobs != jobs {
+		t.Errorf("GetUsage = %+v, want %ds over %d jobs", usage, jobs*30, jobs)
+	}
+}
+
+func testUsageReservations(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+
+	october := time.Date(2026, 10, 31, 23, 0, 0, 0, time.UTC)
+	reserve := func(jobID string, seconds, limit int) error {
+		t.Helper()
+		return s.ReserveUsage(ctx, &storage.UsageEntry{
+			UserID: user.ID, JobID: jobID, Seconds: seconds, AudioSeconds: seconds, Tier: 1, CreatedAt: october,
+		}, limit)
+	}
+	usedInOctober := func() *storage.Usage {
+		t.Helper()
+		usage, err := s.GetUsage(ctx, user.ID, "2026-10")
+		if err != nil {
+			t.Fatalf("GetUsage: %v", err)
+		}
+		return usage
+	}
+
+	first, second := newID("job"), newID("job")
+	if err := reserve(first, 600, 1000); err != nil {
+		t.Fatalf("ReserveUsage: %v", err)
+	}
+	if usage := usedInOctober(); usage.Seconds != 600 || usage.Jobs != 0 {
+		t.Errorf("usage with a reservation = %+v, want 600s over 0 charged jobs", usage)
+	}
+
+	if err := reserve(second, 500, 1000); !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Errorf("ReserveUsage past the limit = %v, want ErrQuotaExceeded", err)
+	}
+	if err := reserve(second, 400, 1000); err != nil {
+		t.Errorf("ReserveUsage up to the limit: %v", err)
+	}
+	if err := reserve(newID("job"), 100000, -1); err != nil {
+		t.Errorf("ReserveUsage without a limit: %v", err)
+	}
+
+	// A released job gives its time back once, and can reserve again
+	if err := s.ReleaseUsage(ctx, second); err != nil {
+		t.Fatalf("ReleaseUsage: %v", err)
+	}
+	if err := s.ReleaseUsage(ctx, second); err != nil {
+		t.Fatalf("second ReleaseUsage: %v", err)
+	}
+	if err := s.ReleaseUsage(ctx, newID("job")); err != nil {
+		t.Errorf("ReleaseUsage of a job without reservations: %v", err)
+	}
+	if usage := usedInOctober(); usage.Seconds != 100600 {
+		t.Errorf("usage after release = %ds, want 100600s", usage.Seconds)
+	}
+	if err := reserve(second, 400, -1); err != nil {
+		t.Errorf("ReserveUsage after release: %v", err)
+	}
+	if err := s.ReleaseUsage(ctx, second); err != nil {
+		t.Fatalf("ReleaseUsage: %v", err)
+	}
+
+	// Charging replaces the reservation; the time goes back to the month it
+	// was reserved in even when the job finishes in the next one
+	charge := &storage.UsageEntry{UserID: user.ID, JobID: first, Seconds: 450, AudioSeconds: 450, Tier: 1, CreatedAt: october.Add(2 * time.Hour)}
+	if err := s.RecordUsage(ctx, charge); err != nil {
+		t.Fatalf("RecordUsage: %v", err)
+	}
+	if usage := usedInOctober(); usage.Seconds != 100000 || usage.Jobs != 0 {
+		t.Errorf("October usage after charging = %+v, want 100000s over 0 charged jobs", usage)
+	}
+	if usage, err := s.GetUsage(ctx, user.ID, "2026-11"); err != nil || usage.Seconds != 450 || usage.Jobs != 1 {
+		t.Errorf("November usage after charging = %+v, %v; want 450s over 1 job", usage, err)
+	}
+	if err := s.ReleaseUsage(ctx, first); err != nil {
+		t.Fatalf("ReleaseUsage of a charged job: %v", err)
+	}
+	if usage, err := s.GetUsage(ctx, user.ID, ""); err != nil || usage.Seconds != 100450 {
+		t.Errorf("usage after releasing a charged job = %+v, %v; want the charge kept", usage, err)
+	}
+}
+
+func testConcurrentReservations(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+
+	// Twenty 30s jobs against 300s: exactly ten fit, however they interleave
+	const jobs, limit = 20, 300
+	var wg sync.WaitGroup
+	errs := make([]error, jobs)
+	for i := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.ReserveUsage(ctx, &storage.UsageEntry{UserID: user.ID, JobID: newID("job"), Seconds: 30, Tier: 1}, limit)
+		}()
+	}
+	wg.Wait()
+
+	reserved := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			reserved++
+		case !errors.Is(err, storage.ErrQuotaExceeded):
+			t.Errorf("concurrent ReserveUsage: %v", err)
+		}
+	}
+	if reserved != limit/30 {
+		t.Errorf("%d of %d concurrent reservations succeeded, want %d", reserved, jobs, limit/30)
+	}
+
+	usage, err := s.GetUsage(ctx, user.ID, storage.UsagePeriod(time.Now()))
+	if err != nil {
+		t.Fatalf("GetUsage: %v", err)
+	}
+	if usage.Seconds != limit {
+		t.Errorf("GetUsage = %ds, want %ds", usage.Seconds, limit)
+	}
+}
+
+func testCookieConsent(t *testing.T, s storage.MetadataStorage) {
+	ctx := context.Background()
+	user := createUser(t, s)
+
+	old := time.Now().Add(-400 * 24 * time.Hour).Truncate(time.Second)
+	recent := time.Now().Add(-time.Hour).Truncate(time.Second)
+	records := []storage.CookieConsentRecord{
+		{ID: newID("consent"), UserID: &user.ID, Essential: true, ConsentVersion: "1", CreatedAt: old},
+		{ID: newID("consent"), UserID: &user.ID, Essential: true, Analytics: true, ConsentVersion: "2", CreatedAt: recent},
+		{ID: newID("consent"), Essential: true, ConsentVersion: "2", CreatedAt: recent},
+	}
+	for _, r := range records {
+		if err := s.StoreCookieConsent(ctx, r); err != nil {
+			t.Fatalf("StoreCookieConsent: %v", err)
+		}
+	}
+
+	latest, err := s.GetLatestConsent(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetLatestConsent: %v", err)
+	}
+	if latest.ID != records[1].ID || !latest.Analytics || latest.ConsentVersion != "2" {
+		t.Errorf("GetLatestConsent = %+v, want %+v", latest, records[1])
+	}
+
+	history, err := s.GetUserConsentHistory(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserConsentHistory: %v", err)
+	}
+	if len(history) != 2 || history[0].ID != records[1].ID {
+		t.Errorf("GetUserConsentHistory returned %d records, want 2 newest first", len(history))
+	}
+
+	if err := s.DeleteOldConsents(ctx, time.Now().Add(-365*24*time.Hour)); err != nil {
+		t.Fatalf("DeleteOldConsents: %v", err)
+	}
+	history, err = s.GetUserConsentHistory(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserConsentHistory: %v", err)
+	}
+	if len(history) != 1 || history[0].ID != records[1].ID {
+		t.Errorf("DeleteOldConsents left %d records, want only the recent one", len(history))
+	}
+
+	if err := s.DeleteUserConsentData(ctx, user.ID); err != nil {
+		t.Fatalf("DeleteUserConsentData: %v", err)
+	}
+	if _, err := s.GetLatestConsent(ctx, user.ID); err == nil {
+		t.Error("GetLatestConsent succeeded after DeleteUserConsentData")
+	}
+}
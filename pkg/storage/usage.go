@@ -0,0 +1,44 @@
+package storage
+
+import "time"
+
+// UsageKind says what a ledger entry does to a job's usage
+type UsageKind string
+
+const (
+	// UsageReserved holds time for a queued job against the allowance
+	UsageReserved UsageKind = "reserve"
+	// UsageReleased returns what a job reserved, with negative seconds
+	UsageReleased UsageKind = "release"
+	// UsageCharged is what a finished job actually cost, at most once per job
+	UsageCharged UsageKind = "charge"
+)
+
+// UsageEntry is one line of the append-only usage ledger. Entries are only
+// ever inserted, so concurrent jobs can't overwrite each other's usage.
+type UsageEntry struct {
+	ID           int64
+	UserID       string
+	JobID        string
+	Kind         UsageKind
+	Seconds      int    // Counted against the monthly allowance
+	AudioSeconds int    // Audio processed; off-peak jobs are charged less than this
+	Tier         int    // The user's tier when the job ran
+	Period       string // Billing month, see UsagePeriod
+	CreatedAt    time.Time
+}
+
+// Usage is the sum of a user's ledger entries. Seconds includes time still
+// reserved by queued jobs; Jobs counts only charged ones.
+type Usage struct {
+	Period       string // Empty for all time
+	Seconds      int
+	AudioSeconds int
+	Jobs         int
+}
+
+// UsagePeriod returns the billing month t falls in, as "2006-01" in UTC.
+// Allowances reset when the period changes.
+func UsagePeriod(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}